@@ -8,172 +8,862 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+var outOfOrder = metrics.Counter("logingestor_ingest_out_of_order_logs_total", "Ingested logs older than a log their shard already held, e.g. backfills and late deliveries")
+
 // Log represents the log entry format
 type Log struct {
-	Level       string    `json:"level"`
-	Message     string    `json:"message"`
-	ResourceID  string    `json:"resourceId"`
-	Timestamp   time.Time `json:"timestamp"`
-	TraceID     string    `json:"traceId"`
-	SpanID      string    `json:"spanId"`
-	Commit      string    `json:"commit"`
-	Metadata    Metadata  `json:"metadata"`
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	ResourceID string         `json:"resourceId"`
+	Timestamp  time.Time      `json:"timestamp"`
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	Commit     string         `json:"commit"`
+	Metadata   Metadata       `json:"metadata"`
+	IngestedAt time.Time      `json:"ingestedAt,omitzero"` // set by the server on receipt
+	Custom     map[string]any `json:"-"`                   // declared custom fields, written at the top level
 }
 
-// Metadata represents the metadata field in the log entry
+// Metadata represents the metadata field in the log entry: parentResourceId
+// and any other JSON keys
 type Metadata struct {
-	ParentResourceID string `json:"parentResourceId"`
+	ParentResourceID string         `json:"parentResourceId"`
+	Fields           map[string]any `json:"-"` // keys other than parentResourceId, as decoded
 }
 
-// LogStorage stores logs and provides query functionality
+// LogStorage stores logs and provides query functionality. Logs are partitioned
+// into shards by time window, so queries with time bounds only touch the shards
+// they overlap and retention drops whole shards. Each window is split into stripes
+// by resourceId, each a shard with its own lock, so that concurrent ingests and
+// queries of the current window do not all queue on one lock.
 type LogStorage struct {
-	logs []Log
-	mu   sync.RWMutex
+	shards       []*shard // ordered by start, then stripe
+	window       time.Duration
+	stripes      int // shards per window
+	store        Storage
+	mu           sync.RWMutex // guards shards; each shard has its own lock
+	tail         tailHub
+	sinks        []*Sink        // output sinks the ingested logs are forwarded to
+	dedup        *dedupCache    // drops recently ingested duplicates; nil when off
+	cold         *coldTier      // past windows on disk; nil when everything stays in memory
+	volume       *volumeCounter // counts the ingested logs of each resource for anomaly detection; nil when off
+	patterns     *PatternMiner  // mines the templates of ingested messages; nil when off
+	deleted      *tombstones    // logs deleted through DELETE /logs
+	cache        *queryCache    // results of recent queries; nil when off
+	aggregations []*aggregation // continuous aggregations the ingested logs are added to
+	rollups      *rollups       // summaries of the evicted logs; nil when off
+	changes      atomic.Uint64  // sequence of the change stamps of shards, see touch
+
+	replayed int // logs loaded from store when opened
+}
+
+// NewLogStorage creates a new in-memory LogStorage instance with stripes shards
+// per window of the given length, one per core when stripes is 0
+func NewLogStorage(window time.Duration, stripes int) *LogStorage {
+	if stripes <= 0 {
+		stripes = min(runtime.GOMAXPROCS(0), 256)
+	}
+	return &LogStorage{window: window, stripes: stripes, deleted: &tombstones{keys: make(map[string]time.Time), legacy: make(map[string]time.Time)}}
+}
+
+// OpenLogStorage creates a LogStorage backed by store, loading the logs it already holds
+// but the deleted ones. Logs older than retention are not loaded; a zero retention loads
+// everything. With a cold tier, logs it already holds stay on disk.
+func OpenLogStorage(store Storage, cold *coldTier, deleted *tombstones, window, retention time.Duration, stripes int) (*LogStorage, error) {
+	ls := NewLogStorage(window, stripes)
+	ls.store, ls.cold, ls.deleted = store, cold, deleted
+
+	var cutoff time.Time
+	if retention > 0 {
+		cutoff = time.Now().Add(-retention)
+	}
+	err := store.Load(func(log Log) {
+		if log.Timestamp.Before(cutoff) || (cold != nil && cold.holds(log, window)) || deleted.has(log) {
+			return
+		}
+		ls.shardFor(log.Timestamp, ls.stripeOf(log)).appendLocked(log)
+		ls.replayed++
+	})
+	if cold != nil {
+		cold.loadKeys = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ls, nil
 }
 
-// NewLogStorage creates a new LogStorage instance
-func NewLogStorage() *LogStorage {
-	return &LogStorage{}
+// findShard returns the position of the stripe of the shard starting at start,
+// or where it belongs when there is none; the caller holds ls.mu
+func (ls *LogStorage) findShard(start time.Time, stripe int) (int, bool) {
+	i := sort.Search(len(ls.shards), func(i int) bool { return ls.shards[i].compare(start, stripe) >= 0 })
+	return i, i < len(ls.shards) && ls.shards[i].compare(start, stripe) == 0
 }
 
-// Ingest logs a new log entry
-func (ls *LogStorage) Ingest(log Log) {
+// windowShards returns the stripes of the window starting at start; the caller
+// holds ls.mu
+func (ls *LogStorage) windowShards(start time.Time) []*shard {
+	i, _ := ls.findShard(start, 0)
+	j := i
+	for j < len(ls.shards) && ls.shards[j].start.Equal(start) {
+		j++
+	}
+	return ls.shards[i:j]
+}
+
+// stripeOf returns the stripe of the shards a log goes to, from the FNV-1a hash
+// of its resourceId, so that the logs of a resource stay together
+func (ls *LogStorage) stripeOf(log Log) int {
+	if ls.stripes == 1 {
+		return 0
+	}
+	hash := uint32(2166136261)
+	for i := 0; i < len(log.ResourceID); i++ {
+		hash = (hash ^ uint32(log.ResourceID[i])) * 16777619
+	}
+	return int(hash % uint32(ls.stripes))
+}
+
+// shardFor returns the stripe of the shard whose window holds t, creating it when needed
+func (ls *LogStorage) shardFor(t time.Time, stripe int) *shard {
+	start := t.Truncate(ls.window)
+	ls.mu.RLock()
+	i, ok := ls.findShard(start, stripe)
+	if ok {
+		sh := ls.shards[i]
+		ls.mu.RUnlock()
+		return sh
+	}
+	ls.mu.RUnlock()
+
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
+	if i, ok = ls.findShard(start, stripe); ok {
+		return ls.shards[i]
+	}
+	sh := newShard(start, ls.window)
+	sh.stripe = stripe
+	ls.shards = slices.Insert(ls.shards, i, sh)
 
-	ls.logs = append(ls.logs, log)
+	return sh
 }
 
-// Query searches for logs based on provided filters
-func (ls *LogStorage) Query(filters map[string]string) []Log {
+// shardsBetween returns the shards that may hold logs within [from, to], oldest
+// first, the stripes of a window together. Cold tier windows are placeholders
+// to pass through openShard.
+func (ls *LogStorage) shardsBetween(from, to time.Time) []*shard {
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
 
-	var result []Log
+	var shards []*shard
+	for _, sh := range ls.shards {
+		if sh.overlaps(from, to) && (ls.cold == nil || ls.cold.segmentAt(sh.start) == nil) {
+			shards = append(shards, sh)
+		}
+	}
+	if ls.cold != nil {
+		for _, seg := range ls.cold.segments {
+			placeholder := &shard{start: seg.footer.Start, end: seg.footer.End, cold: true}
+			if placeholder.overlaps(from, to) {
+				shards = append(shards, placeholder)
+			}
+		}
+		slices.SortFunc(shards, func(a, b *shard) int { return a.start.Compare(b.start) })
+	}
+
+	return shards
+}
 
-	for _, log := range ls.logs {
-		if matchesFilters(log, filters) {
-			result = append(result, log)
+// Ingest logs a new log entry, persisting it first when a Storage is configured
+func (ls *LogStorage) Ingest(log Log) error {
+	return ls.IngestBatch([]Log{log})
+}
+
+// IngestBatch logs several entries with one Storage write, made before any lock
+// so concurrent ingests share a WAL fsync, and one lock per shard stripe; each
+// log goes to the shard of its window, and duplicates within dedup-window are skipped
+func (ls *LogStorage) IngestBatch(logs []Log) (err error) {
+	if ls.dedup != nil {
+		var keys []string
+		if logs, keys = ls.dedup.filter(logs); len(logs) > 0 && ls.store != nil {
+			// Logs that fail to be stored are retried, which must not count as duplicates
+			defer func() {
+				if err != nil {
+					ls.dedup.forget(keys)
+				}
+			}()
 		}
 	}
+	if len(logs) == 0 {
+		return nil
+	}
 
-	return result
+	if ls.store != nil {
+		if err := ls.store.Append(logs...); err != nil {
+			return err
+		}
+	}
+	for _, sink := range ls.sinks {
+		sink.Enqueue(logs)
+	}
+	if ls.volume != nil {
+		ls.volume.add(logs)
+	}
+	if ls.patterns != nil {
+		ls.patterns.add(logs)
+	}
+	for _, agg := range ls.aggregations {
+		agg.add(logs)
+	}
+
+	for _, group := range groupByShard(logs, ls.window, ls.stripeOf) {
+		for {
+			sh := ls.shardFor(group[0].Timestamp, ls.stripeOf(group[0]))
+			sh.mu.Lock()
+			if sh.detached {
+				// Moved to the cold tier meanwhile; shardFor now returns its successor
+				sh.mu.Unlock()
+				continue
+			}
+			if late := sh.appendLocked(group...); late > 0 {
+				outOfOrder.Add(int64(late))
+			}
+			ls.touch(sh)
+			sh.mu.Unlock()
+			break
+		}
+		ls.publish(group)
+	}
+
+	return nil
 }
 
-// matchesFilters checks if a log entry matches the provided filters
-func matchesFilters(log Log, filters map[string]string) bool {
-	for key, value := range filters {
-		switch key {
-		case "level":
-			if log.Level != value {
-				return false
+// groupByShard splits logs into the logs of each time window and stripe, in the
+// order of the first log of each group, keeping the order of the logs within a group
+func groupByShard(logs []Log, window time.Duration, stripeOf func(Log) int) [][]Log {
+	start, stripe := logs[0].Timestamp.Truncate(window), stripeOf(logs[0])
+	if !slices.ContainsFunc(logs, func(log Log) bool {
+		return !log.Timestamp.Truncate(window).Equal(start) || stripeOf(log) != stripe
+	}) {
+		return [][]Log{logs}
+	}
+
+	type shardKey struct {
+		start  int64
+		stripe int
+	}
+	var groups [][]Log
+	group := make(map[shardKey]int)
+	for _, log := range logs {
+		key := shardKey{log.Timestamp.Truncate(window).UnixNano(), stripeOf(log)}
+		i, ok := group[key]
+		if !ok {
+			i = len(groups)
+			group[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], log)
+	}
+	return groups
+}
+
+// Close closes the underlying Storage, if any
+func (ls *LogStorage) Close() error {
+	if ls.cold != nil {
+		ls.cold.close()
+	}
+	if ls.store == nil {
+		return nil
+	}
+
+	return ls.store.Close()
+}
+
+// Query searches for logs based on provided filters and returns the page selected
+// by opts, reporting whether more matching logs follow it. When ctx ends the scan
+// early, the logs found so far are returned with ctx's error.
+func (ls *LogStorage) Query(ctx context.Context, filters map[string]string, opts QueryOptions) (result []Log, more bool, err error) {
+	results, err := ls.Select(ctx, filters, opts)
+	results.Each(func(log Log) bool {
+		result = append(result, log)
+		return true
+	})
+
+	return result, results.More, err
+}
+
+// Results is a page of query results read from the shard logs captured at query
+// time. Stored entries are never modified in place: appends and rebuilds leave
+// earlier slices intact, so Results can be read after the locks are released.
+type Results struct {
+	chunks []resultChunk
+	n      int
+	More   bool // more matching logs follow the page
+}
+
+// resultChunk is the part of a page read from one slice of logs
+type resultChunk struct {
+	logs      []Log
+	positions []int
+}
+
+// Len returns the number of logs in the page
+func (r Results) Len() int { return r.n }
+
+// Each calls fn with every log of the page, in order, until fn returns false
+func (r Results) Each(fn func(Log) bool) {
+	for _, chunk := range r.chunks {
+		for _, pos := range chunk.positions {
+			if !fn(chunk.logs[pos]) {
+				return
 			}
-		case "message":
-			if !strings.Contains(log.Message, value) {
-				return false
+		}
+	}
+}
+
+// Select finds the page of logs selected by the filters and opts without copying
+// them, but for the stripes of a window merged by timestamp. Unsorted results are
+// ordered by time window, then by stripe and ingestion. The shards are
+// scanned until ctx is done or a cold tier file fails to read, in which case the
+// partial page comes with the error.
+func (ls *LogStorage) Select(ctx context.Context, filters map[string]string, opts QueryOptions) (Results, error) {
+	var results Results
+	skipped := 0
+
+	// visit adds the positions of logs that scan reports to the page and returns
+	// false once the page is full
+	visit := func(logs []Log, scan func(collect func(pos int) bool) bool) bool {
+		chunk := resultChunk{logs: logs}
+		complete := scan(func(pos int) bool {
+			if !opts.allows(logs[pos]) {
+				return true
 			}
-		case "resourceId":
-			if log.ResourceID != value {
-				return false
+			if skipped < opts.Offset {
+				skipped++
+				return true
 			}
-		case "timestamp":
-			timestamp, err := time.Parse(time.RFC3339, value)
-			if err != nil || log.Timestamp.Before(timestamp) || log.Timestamp.After(timestamp.Add(24*time.Hour)) {
+			if opts.Limit > 0 && results.n == opts.Limit {
+				results.More = true
 				return false
 			}
-		case "traceId":
-			if log.TraceID != value {
-				return false
+
+			chunk.positions = append(chunk.positions, pos)
+			results.n++
+			return true
+		})
+		if len(chunk.positions) > 0 {
+			results.chunks = append(results.chunks, chunk)
+		}
+		return complete
+	}
+
+	from, to := timeBounds(filters)
+	shards := ls.shardsBetween(from, to)
+	switch {
+	case len(opts.Sort) == 0:
+		for _, sh := range shards {
+			sh, err := ls.openShard(sh, filters)
+			if err != nil {
+				return results, err
 			}
-		case "spanId":
-			if log.SpanID != value {
-				return false
+			sh.mu.RLock()
+			more := visit(sh.logs, func(collect func(int) bool) bool { return sh.scan(ctx, filters, opts.Expr, collect) })
+			sh.mu.RUnlock()
+			if !more {
+				break
 			}
-		case "commit":
-			if log.Commit != value {
-				return false
+		}
+	case isTimestampSort(opts.Sort):
+		// Shard windows do not overlap, so walking them in order keeps the results
+		// sorted once the stripes of each window are merged
+		desc := opts.Sort[0].Desc
+		if desc {
+			slices.Reverse(shards)
+		}
+		for len(shards) > 0 {
+			n := 1
+			for n < len(shards) && shards[n].start.Equal(shards[0].start) {
+				n++
 			}
-		case "metadata.parentResourceId":
-			if log.Metadata.ParentResourceID != value {
-				return false
+			window := shards[:n]
+			shards = shards[n:]
+
+			var more bool
+			if len(window) > 1 {
+				// Enough of each stripe to fill the rest of the page
+				need := -1
+				if opts.Limit > 0 {
+					need = opts.Offset - skipped + opts.Limit - results.n + 1
+				}
+				logs := mergeByTime(ctx, window, filters, opts, desc, need)
+				more = visit(logs, func(collect func(int) bool) bool {
+					for pos := range logs {
+						if !collect(pos) {
+							return false
+						}
+					}
+					return ctx.Err() == nil
+				})
+			} else {
+				sh, err := ls.openShard(window[0], filters)
+				if err != nil {
+					return results, err
+				}
+				sh.mu.RLock()
+				more = visit(sh.logs, func(collect func(int) bool) bool { return sh.scanByTime(ctx, filters, opts.Expr, desc, collect) })
+				sh.mu.RUnlock()
+			}
+			if !more {
+				break
+			}
+		}
+	default:
+		var matches []Log
+		for _, sh := range shards {
+			sh, err := ls.openShard(sh, filters)
+			if err != nil {
+				return results, err
+			}
+			sh.mu.RLock()
+			sh.scan(ctx, filters, opts.Expr, func(pos int) bool {
+				matches = append(matches, sh.logs[pos])
+				return true
+			})
+			sh.mu.RUnlock()
+			if ctx.Err() != nil {
+				break
 			}
 		}
+
+		sortLogs(matches, opts.Sort)
+		visit(matches, func(collect func(int) bool) bool {
+			for pos := range matches {
+				if !collect(pos) {
+					return false
+				}
+			}
+			return true
+		})
 	}
 
-	return true
+	return results, ctx.Err()
 }
 
-func main() {
-	logStorage := NewLogStorage()
+// mergeByTime returns up to need logs (all of them when negative) of each of the
+// stripes of a window that match the filters and opts, in timestamp order
+func mergeByTime(ctx context.Context, stripes []*shard, filters map[string]string, opts QueryOptions, desc bool, need int) []Log {
+	var merged []Log
+	for _, sh := range stripes {
+		found := 0
+		sh.mu.RLock()
+		sh.scanByTime(ctx, filters, opts.Expr, desc, func(pos int) bool {
+			if log := sh.logs[pos]; opts.allows(log) {
+				merged = append(merged, log)
+				found++
+			}
+			return need < 0 || found < need
+		})
+		sh.mu.RUnlock()
+	}
 
-	http.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Ingest called")
-		if r.Method != http.MethodPost {
-			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-			return
+	slices.SortStableFunc(merged, func(a, b Log) int {
+		if desc {
+			return b.Timestamp.Compare(a.Timestamp)
 		}
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return merged
+}
 
-		body, err := ioutil.ReadAll(r.Body)
+// Count returns the number of logs matching the filters and opts, ignoring the
+// page options; no log is copied. When ctx or a cold tier read ends the count
+// early, the number counted so far is returned with the error.
+func (ls *LogStorage) Count(ctx context.Context, filters map[string]string, opts QueryOptions) (int, error) {
+	n := 0
+	from, to := timeBounds(filters)
+	for _, sh := range ls.shardsBetween(from, to) {
+		sh, err := ls.openShard(sh, filters)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
-			return
+			return n, err
+		}
+		sh.mu.RLock()
+		n += sh.count(ctx, filters, opts)
+		sh.mu.RUnlock()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return n, ctx.Err()
+}
+
+// BatchResult reports the outcome of one entry of a batch ingest request
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// BatchResponse is the response body of the /ingest/batch endpoint
+type BatchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// validateTimeFilters checks that the timestamp filters are RFC3339 and form a valid range
+func validateTimeFilters(filters map[string]string) error {
+	parsed := make(map[string]time.Time)
+	for _, key := range []string{"timestamp", "timestamp_from", "timestamp_to"} {
+		value, ok := filters[key]
+		if !ok {
+			continue
 		}
 
-		var log Log
-		err = json.Unmarshal(body, &log)
+		t, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
-			return
+			return fmt.Errorf("Invalid %s: expected RFC3339 time", key)
 		}
+		parsed[key] = t
+	}
 
-		logStorage.Ingest(log)
-		w.WriteHeader(http.StatusOK)
-	})
+	from, hasFrom := parsed["timestamp_from"]
+	to, hasTo := parsed["timestamp_to"]
+	if hasFrom && hasTo && to.Before(from) {
+		return errors.New("Invalid time range: timestamp_to is before timestamp_from")
+	}
 
-	http.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+	return nil
+}
 
-		fmt.Println("Query called")
-		if r.Method != http.MethodPost {
-			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-			return
+// matchesQuery checks if a log entry matches the filters and the optional LQL expression
+func matchesQuery(log Log, filters map[string]string, expr lqlNode) bool {
+	return matchesFilters(log, filters) && (expr == nil || expr.eval(log))
+}
+
+// matchesFilters checks if a log entry matches the provided filters
+func matchesFilters(log Log, filters map[string]string) bool {
+	for key, value := range filters {
+		if !matchesFilter(log, key, value, filters[messageModeKey]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesFilter checks if a log entry matches one filter; messageMode is the
+// message_mode of the query, used by the message filter
+func matchesFilter(log Log, key, value, messageMode string) bool {
+	switch key {
+	case "level":
+		return log.Level == value
+	case "message":
+		return matchesMessage(log.Message, value, messageMode)
+	case "resourceId":
+		return log.ResourceID == value
+	case "timestamp":
+		timestamp, err := time.Parse(time.RFC3339, value)
+		return err == nil && !log.Timestamp.Before(timestamp) && !log.Timestamp.After(timestamp.Add(24*time.Hour))
+	case "timestamp_from":
+		from, err := time.Parse(time.RFC3339, value)
+		return err == nil && !log.Timestamp.Before(from)
+	case "timestamp_to":
+		to, err := time.Parse(time.RFC3339, value)
+		return err == nil && !log.Timestamp.After(to)
+	case "traceId":
+		return log.TraceID == value
+	case "spanId":
+		return log.SpanID == value
+	case "commit":
+		return log.Commit == value
+	case "metadata.parentResourceId":
+		return log.Metadata.ParentResourceID == value
+	}
+	if field, ok := regexField(key); ok {
+		return matchesRegex(fieldValue(log, field), value)
+	}
+	if isMetadataPath(key) || customField(key) != nil {
+		return fieldValue(log, key) == value
+	}
+
+	return true
+}
+
+// openStorage opens the Storage engine selected by the configuration
+func openStorage(cfg Config) (Storage, error) {
+	switch cfg.StorageEngine {
+	case "file":
+		return NewFileStorage(filepath.Join(cfg.DataDir, "logs.ndjson"))
+	default:
+		return OpenWAL(filepath.Join(cfg.DataDir, "wal"), cfg.WALSegmentSize, cfg.WALSyncInterval)
+	}
+}
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(2)
+	}
+	logger = newLogger(cfg, os.Stdout, nil)
+	fields, _ := parseCustomFields(cfg.CustomFields) // checked by Config.Validate
+	declareCustomFields(fields)
+
+	start := time.Now()
+	tenants, err := OpenTenants(cfg)
+	if err != nil {
+		logger.Error("opening storage failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("storage opened", "tenants", len(tenants.All()), "duration", time.Since(start))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background workers stop on ctx and are waited for before storage is closed
+	var background sync.WaitGroup
+
+	if cfg.LogSelfIngest {
+		self := newSelfIngester()
+		logger = newLogger(cfg, os.Stdout, self)
+		background.Go(func() { self.Run(ctx, tenants.Default().storage) })
+	}
+
+	for _, tenant := range tenants.All() {
+		if tenant.policy.enabled() {
+			background.Go(func() { tenant.storage.RunRetention(ctx, tenant.policy, cfg.RetentionInterval) })
+		}
+		if tenant.storage.cold != nil {
+			background.Go(func() { tenant.storage.RunTiering(ctx, cfg.TierInterval) })
 		}
+		if tenant.storage.patterns != nil {
+			background.Go(func() { tenant.storage.patterns.Run(ctx, patternSaveInterval) })
+		}
+	}
+
+	server, err := NewServer(cfg, tenants)
+	if err != nil {
+		logger.Error("starting server failed", "error", err)
+		os.Exit(1)
+	}
+
+	if server.alerts != nil {
+		background.Go(func() { server.alerts.Run(ctx, cfg.AlertInterval) })
+	}
+	if server.anomalies != nil {
+		background.Go(func() { server.anomalies.Run(ctx) })
+	}
+	if server.exports != nil {
+		background.Go(func() { server.exports.Run(ctx) })
+	}
+	if server.quotas != nil {
+		background.Go(func() { server.quotas.Run(ctx, quotaSaveInterval) })
+	}
+	background.Go(func() { server.compaction.Run(ctx, cfg.CompactionInterval) })
+	if server.cluster != nil {
+		background.Go(func() { server.cluster.Run(ctx, server.catchUp) })
+	}
+
+	// Kafka, syslog and forward logs go to the default tenant
+	ingest := func(source string) func(logs []Log) error {
+		return func(logs []Log) error {
+			logs, err := server.admit(ctx, source, logs)
+			if err == nil {
+				_, err = server.store(ctx, tenants.Default(), logs)
+			}
+			return err
+		}
+	}
+
+	if cfg.KafkaProxyURL != "" {
+		consumer := NewKafkaConsumer(cfg, ingest("kafka"), server.validator, server.deadLetter)
+		background.Go(func() { consumer.Run(ctx) })
+	}
 
-		body, err := ioutil.ReadAll(r.Body)
+	if cfg.SyslogAddr != "" {
+		listener, err := ListenSyslog(cfg.SyslogAddr, ingest("syslog"), server.validator, server.deadLetter)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
-			return
+			logger.Error("starting syslog listener failed", "error", err)
+			os.Exit(1)
 		}
+		logger.Info("syslog listener is running (UDP and TCP)", "addr", cfg.SyslogAddr)
+		background.Go(func() { listener.Serve(ctx) })
+	}
 
-		var filters map[string]string
-		err = json.Unmarshal(body, &filters)
+	if cfg.ForwardAddr != "" {
+		listener, err := ListenForward(cfg.ForwardAddr, ingest("forward"), server.validator, server.deadLetter, cfg.MaxBodySize, cfg.MaxDecompressedSize)
 		if err != nil {
-			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
-			return
+			logger.Error("starting forward listener failed", "error", err)
+			os.Exit(1)
 		}
+		logger.Info("forward listener is running (TCP)", "addr", cfg.ForwardAddr)
+		background.Go(func() { listener.Serve(ctx) })
+	}
 
-		logs := logStorage.Query(filters)
+	if cfg.UDPAddr != "" {
+		listener, err := ListenUDP(cfg.UDPAddr, cfg.UDPQueueSize, ingest("udp"), server.validator, server.deadLetter)
+		if err != nil {
+			logger.Error("starting UDP listener failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("UDP listener is running", "addr", cfg.UDPAddr)
+		background.Go(func() { listener.Serve(ctx) })
+	}
 
-		response, err := json.Marshal(logs)
+	var certs *tlsReloader
+	if cfg.TLSCertFile != "" {
+		certs, err = newTLSReloader(cfg)
 		if err != nil {
-			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-			return
+			logger.Error("loading TLS certificates failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	// listen serves plain HTTP, or HTTPS with the certificates of the tls-* settings
+	listen := func(srv *http.Server) error {
+		if certs == nil {
+			return srv.ListenAndServe()
 		}
+		return srv.ListenAndServeTLS("", "")
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(response)
-	})
+	handler := server.Handler()
+	httpServer := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		HTTP2:             http2Config(cfg),
+		ConnState:         trackConns("http"),
+	}
+	if certs != nil {
+		httpServer.TLSConfig = certs.config("h2", "http/1.1")
+	} else if cfg.H2C {
+		httpServer.Protocols = new(http.Protocols)
+		httpServer.Protocols.SetHTTP1(true)
+		httpServer.Protocols.SetUnencryptedHTTP2(true)
+	}
+	// Live tails never finish on their own; end them so Shutdown can complete
+	httpServer.RegisterOnShutdown(tenants.EndSubscriptions)
 
-	fmt.Println("Hi Dyte , Log Ingestor is running on Port :3000...")
-	http.ListenAndServe(":3000", nil)
-}
+	serverErr := make(chan error, 3)
+	go func() {
+		logger.Info("Hi Dyte , Log Ingestor is running", "port", cfg.Port)
+		serverErr <- listen(httpServer)
+	}()
 
+	var unixServer *http.Server
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketMode)
+		if err != nil {
+			logger.Error("listening on the Unix socket failed", "error", err)
+			os.Exit(1)
+		}
+		// The same API and timeouts as the HTTP port, in plain HTTP (or h2c)
+		unixServer = &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			HTTP2:             http2Config(cfg),
+			ConnState:         trackConns("unix"),
+		}
+		if cfg.H2C {
+			unixServer.Protocols = new(http.Protocols)
+			unixServer.Protocols.SetHTTP1(true)
+			unixServer.Protocols.SetUnencryptedHTTP2(true)
+		}
+		unixServer.RegisterOnShutdown(tenants.EndSubscriptions)
+		go func() {
+			logger.Info("HTTP API is also served on the Unix socket", "path", cfg.UnixSocket)
+			serverErr <- unixServer.Serve(listener)
+		}()
+	}
 
+	var grpcServer *http.Server
+	if cfg.GRPCPort != 0 {
+		// Read and write timeouts would cut IngestStream streams, so only idle
+		// and header timeouts apply
+		grpcServer = &http.Server{
+			Addr:              net.JoinHostPort(cfg.BindAddress, strconv.Itoa(cfg.GRPCPort)),
+			Handler:           server.GRPCHandler(),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			HTTP2:             http2Config(cfg),
+			ConnState:         trackConns("grpc"),
+		}
+		if certs != nil {
+			grpcServer.TLSConfig = certs.config("h2")
+		} else {
+			// gRPC clients speak HTTP/2 with prior knowledge, without TLS
+			grpcServer.Protocols = new(http.Protocols)
+			grpcServer.Protocols.SetUnencryptedHTTP2(true)
+		}
+		go func() {
+			logger.Info("gRPC service is running", "port", cfg.GRPCPort)
+			serverErr <- listen(grpcServer)
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		logger.Error("server stopped", "error", err)
+		server.Close()
+		tenants.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown failed", "error", err)
+	}
+	if unixServer != nil {
+		if err := unixServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Unix socket shutdown failed", "error", err)
+		}
+	}
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("gRPC shutdown failed", "error", err)
+		}
+	}
+
+	background.Wait()
+	server.Close()
+
+	if err := tenants.Close(); err != nil {
+		logger.Error("flushing storage failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Log Ingestor stopped")
+}
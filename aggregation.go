@@ -0,0 +1,565 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Continuous aggregations updated on ingest and read through /aggregations/{name}
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Functions of an aggregation over the logs of each bucket and group
+const (
+	AggregateCount = "count" // matching logs
+	AggregateSum   = "sum"   // of the numeric values of field
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+)
+
+var aggregateFunctions = []string{AggregateCount, AggregateSum, AggregateAvg, AggregateMin, AggregateMax}
+
+// aggregationName is what names of aggregations may hold, as they appear in paths
+var aggregationName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// clusterAggregationsPath is where cluster nodes ask each other for the buckets
+// of an aggregation, by POST as every peer query
+const clusterAggregationsPath = "/cluster/aggregations/"
+
+var aggregatedLogs = metrics.CounterVec("logingestor_aggregated_logs_total", "Logs added to continuous aggregations", "aggregation")
+
+// AggregationRule is an entry of the aggregations-file. It counts the logs of
+// the tenant matching Query, a /query body of filters, min_level and "q", or
+// applies Function to the numeric values of Field, per Interval and GroupBy value.
+type AggregationRule struct {
+	Name      string                     `json:"name"`
+	Tenant    string                     `json:"tenant"`
+	Query     map[string]json.RawMessage `json:"query"`
+	Interval  string                     `json:"interval"`           // default 1m
+	GroupBy   string                     `json:"group_by,omitempty"` // an indexed field
+	Function  string                     `json:"function"`           // default count
+	Field     string                     `json:"field,omitempty"`    // metadata.<path> or a custom field, but for count
+	Retention string                     `json:"retention"`          // how long buckets are kept, default 24h
+}
+
+// aggregate is what a bucket holds of the logs of one group
+type aggregate struct {
+	count         int
+	sum, min, max float64
+}
+
+func (a *aggregate) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.count++
+	a.sum += v
+}
+
+func (a *aggregate) merge(b aggregate) {
+	if b.count == 0 {
+		return
+	}
+	if a.count == 0 || b.min < a.min {
+		a.min = b.min
+	}
+	if a.count == 0 || b.max > a.max {
+		a.max = b.max
+	}
+	a.count += b.count
+	a.sum += b.sum
+}
+
+// AggregationValue is the result of an aggregation over some logs: how many
+// there are and, but for count, the function of their values
+type AggregationValue struct {
+	Count int      `json:"count"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+// value returns the result of function over the aggregate
+func (a aggregate) value(function string) AggregationValue {
+	v := AggregationValue{Count: a.count}
+	if function == AggregateCount || a.count == 0 {
+		return v
+	}
+	var x float64
+	switch function {
+	case AggregateSum:
+		x = a.sum
+	case AggregateAvg:
+		x = a.sum / float64(a.count)
+	case AggregateMin:
+		x = a.min
+	case AggregateMax:
+		x = a.max
+	}
+	v.Value = &x
+	return v
+}
+
+// aggregateOf returns the aggregate a value of function was computed from, as
+// far as it can be told, for the values of cluster nodes to be merged
+func aggregateOf(function string, v AggregationValue) aggregate {
+	a := aggregate{count: v.Count}
+	if v.Value != nil {
+		a.sum, a.min, a.max = *v.Value, *v.Value, *v.Value
+		if function == AggregateAvg {
+			a.sum *= float64(v.Count)
+		}
+	}
+	return a
+}
+
+// AggregationBucket is the aggregation of the logs in [Time, Time+interval),
+// split by the values of group_by when the rule has one
+type AggregationBucket struct {
+	Time time.Time `json:"time"`
+	AggregationValue
+	Groups map[string]AggregationValue `json:"groups,omitempty"`
+}
+
+// AggregationResponse is the body of GET /aggregations/{name}
+type AggregationResponse struct {
+	Name     string              `json:"name"`
+	Function string              `json:"function"`
+	Field    string              `json:"field,omitempty"`
+	Interval string              `json:"interval"`
+	GroupBy  string              `json:"group_by,omitempty"`
+	Buckets  []AggregationBucket `json:"buckets"`
+}
+
+// AggregationList is the body of GET /aggregations
+type AggregationList struct {
+	Aggregations []AggregationRule `json:"aggregations"`
+}
+
+// aggregationBuckets holds the aggregates of each bucket start, in Unix
+// nanoseconds, by group value; the value is empty without group_by
+type aggregationBuckets map[int64]map[string]*aggregate
+
+func (ab aggregationBuckets) add(start int64, group string, a aggregate) {
+	if ab[start] == nil {
+		ab[start] = make(map[string]*aggregate)
+	}
+	if ab[start][group] == nil {
+		ab[start][group] = &aggregate{}
+	}
+	ab[start][group].merge(a)
+}
+
+// aggregation is a loaded rule with its parsed query and buckets
+type aggregation struct {
+	AggregationRule
+	tenant    *Tenant
+	req       QueryRequest
+	interval  time.Duration
+	retention time.Duration
+	serves    func(Log) bool // in cluster mode, the logs this node aggregates
+
+	mu      sync.Mutex
+	buckets aggregationBuckets
+}
+
+// Aggregations keeps the continuous aggregations of every tenant up to date as
+// logs are ingested
+type Aggregations struct {
+	rules []*aggregation
+}
+
+// LoadAggregations reads and checks the rules of the aggregations-file, fills
+// them from the logs stored within their retention, then makes the storage of
+// their tenant add every log it ingests to them. In cluster mode serves picks
+// the logs this node is the first replica of, so that each is aggregated once.
+func LoadAggregations(cfg Config, tenants *Tenants, levels *LevelRegistry, serves func(Log) bool) (*Aggregations, error) {
+	data, err := os.ReadFile(cfg.AggregationsFile)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AggregationRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.AggregationsFile, err)
+	}
+
+	a := &Aggregations{}
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("aggregation without a name")
+		}
+		if !aggregationName.MatchString(rule.Name) {
+			return nil, fmt.Errorf("aggregation %q: name may only hold letters, digits, '_', '.' and '-'", rule.Name)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("aggregation %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		agg, err := loadAggregation(cfg, tenants, levels, rule)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation %q: %v", rule.Name, err)
+		}
+		agg.serves = serves
+		a.rules = append(a.rules, agg)
+	}
+
+	start := time.Now()
+	for _, agg := range a.rules {
+		if err := agg.backfill(context.Background(), start); err != nil {
+			return nil, fmt.Errorf("aggregation %q: %v", agg.Name, err)
+		}
+		agg.tenant.storage.AddAggregation(agg)
+	}
+	if len(a.rules) > 0 {
+		logger.Info("aggregations loaded", "aggregations", len(a.rules), "duration", time.Since(start))
+	}
+	return a, nil
+}
+
+// loadAggregation checks one rule and fills in its defaults
+func loadAggregation(cfg Config, tenants *Tenants, levels *LevelRegistry, rule AggregationRule) (*aggregation, error) {
+	if rule.Tenant == "" {
+		rule.Tenant = DefaultTenant
+	}
+	tenant, ok := tenants.byID[rule.Tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", rule.Tenant)
+	}
+
+	req, err := buildRuleQuery(rule.Query, cfg.MaxPageSize, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Interval == "" {
+		rule.Interval = "1m"
+	}
+	interval, err := parseDuration(rule.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %q", rule.Interval)
+	}
+	if rule.Retention == "" {
+		rule.Retention = "24h"
+	}
+	retention, err := parseDuration(rule.Retention)
+	if err != nil || retention < interval {
+		return nil, fmt.Errorf("invalid retention %q: must be a duration of at least the interval", rule.Retention)
+	}
+	if int64(retention/interval) >= maxHistogramBuckets {
+		return nil, fmt.Errorf("retention %s holds more than %d intervals of %s", rule.Retention, maxHistogramBuckets, rule.Interval)
+	}
+
+	if rule.GroupBy != "" && !slices.Contains(indexedFields, rule.GroupBy) {
+		return nil, fmt.Errorf("invalid group_by %q: must be one of %s", rule.GroupBy, strings.Join(indexedFields, ", "))
+	}
+	if rule.Function == "" {
+		rule.Function = AggregateCount
+	}
+	switch {
+	case !slices.Contains(aggregateFunctions, rule.Function):
+		return nil, fmt.Errorf("unknown function %q, expected one of %s", rule.Function, strings.Join(aggregateFunctions, ", "))
+	case rule.Function == AggregateCount && rule.Field != "":
+		return nil, errors.New("count takes no field")
+	case rule.Function != AggregateCount && !isMetadataPath(rule.Field) && customField(rule.Field) == nil:
+		return nil, fmt.Errorf("%s needs a field, metadata.<path> or a custom field", rule.Function)
+	}
+
+	return &aggregation{AggregationRule: rule, tenant: tenant, req: req, interval: interval, retention: retention,
+		buckets: make(aggregationBuckets)}, nil
+}
+
+// cutoff returns the start of the oldest bucket kept at now
+func (agg *aggregation) cutoff(now time.Time) int64 {
+	return now.Add(-agg.retention).Truncate(agg.interval).UnixNano()
+}
+
+// value returns what a log adds to the aggregation, false when it adds nothing:
+// it does not match, or has no numeric value of the field
+func (agg *aggregation) value(log Log) (float64, bool) {
+	if !matchesQuery(log, agg.req.Filters, agg.req.Options.Expr) || (agg.serves != nil && !agg.serves(log)) {
+		return 0, false
+	}
+	if agg.Function == AggregateCount {
+		return 1, true
+	}
+	v, err := strconv.ParseFloat(fieldValue(log, agg.Field), 64)
+	return v, err == nil
+}
+
+// add aggregates ingested logs, leaving out those older than the retention
+func (agg *aggregation) add(logs []Log) {
+	cutoff := agg.cutoff(time.Now())
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+	n := 0
+	for _, log := range logs {
+		v, ok := agg.value(log)
+		start := log.Timestamp.Truncate(agg.interval).UnixNano()
+		if !ok || start < cutoff {
+			continue
+		}
+		if agg.buckets[start] == nil {
+			agg.prune(cutoff)
+		}
+		var group string
+		if agg.GroupBy != "" {
+			group = fieldValue(log, agg.GroupBy)
+		}
+		one := aggregate{}
+		one.add(v)
+		agg.buckets.add(start, group, one)
+		n++
+	}
+	if n > 0 {
+		aggregatedLogs.With(agg.Name).Add(int64(n))
+	}
+}
+
+// prune drops the buckets starting before cutoff; agg.mu is held
+func (agg *aggregation) prune(cutoff int64) {
+	for start := range agg.buckets {
+		if start < cutoff {
+			delete(agg.buckets, start)
+		}
+	}
+}
+
+// backfill aggregates the stored logs of the tenant within the retention
+func (agg *aggregation) backfill(ctx context.Context, now time.Time) error {
+	ls := agg.tenant.storage
+	from := time.Unix(0, agg.cutoff(now)).UTC()
+	filters := map[string]string{"timestamp_from": from.Format(time.RFC3339)}
+	for key, value := range agg.req.Filters {
+		filters[key] = value
+	}
+	for _, sh := range ls.shardsBetween(from, time.Time{}) {
+		sh, err := ls.openShard(sh, filters)
+		if err != nil {
+			return err
+		}
+		var matched []Log
+		sh.mu.RLock()
+		sh.scan(ctx, filters, agg.req.Options.Expr, func(pos int) bool {
+			matched = append(matched, sh.logs[pos])
+			return true
+		})
+		sh.mu.RUnlock()
+		agg.add(matched)
+	}
+	return ctx.Err()
+}
+
+// between returns the aggregates of the buckets from the bucket of from to the
+// bucket of to
+func (agg *aggregation) between(from, to time.Time) aggregationBuckets {
+	first, last := from.Truncate(agg.interval).UnixNano(), to.Truncate(agg.interval).UnixNano()
+	buckets := make(aggregationBuckets)
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+	for start, groups := range agg.buckets {
+		if start < first || start > last {
+			continue
+		}
+		for group, a := range groups {
+			buckets.add(start, group, *a)
+		}
+	}
+	return buckets
+}
+
+// list returns the buckets from the bucket of from to the bucket of to, empty
+// buckets included
+func (agg *aggregation) list(buckets aggregationBuckets, from, to time.Time) []AggregationBucket {
+	list := []AggregationBucket{}
+	for start := from.Truncate(agg.interval).UnixNano(); start <= to.Truncate(agg.interval).UnixNano(); start += int64(agg.interval) {
+		var total aggregate
+		bucket := AggregationBucket{Time: time.Unix(0, start).UTC()}
+		if agg.GroupBy != "" {
+			bucket.Groups = make(map[string]AggregationValue)
+		}
+		for group, a := range buckets[start] {
+			total.merge(*a)
+			if agg.GroupBy != "" {
+				bucket.Groups[group] = a.value(agg.Function)
+			}
+		}
+		bucket.AggregationValue = total.value(agg.Function)
+		list = append(list, bucket)
+	}
+	return list
+}
+
+// Get returns the aggregation of a tenant called name, nil if there is none
+func (a *Aggregations) Get(tenant *Tenant, name string) *aggregation {
+	for _, agg := range a.rules {
+		if agg.tenant == tenant && agg.Name == name {
+			return agg
+		}
+	}
+	return nil
+}
+
+// List returns the rules of a tenant's aggregations, defaults filled in
+func (a *Aggregations) List(tenant *Tenant) []AggregationRule {
+	rules := []AggregationRule{}
+	for _, agg := range a.rules {
+		if agg.tenant == tenant {
+			rules = append(rules, agg.AggregationRule)
+		}
+	}
+	return rules
+}
+
+// AddAggregation makes the storage add every log it ingests to agg;
+// aggregations are added before ingestion starts
+func (ls *LogStorage) AddAggregation(agg *aggregation) {
+	ls.aggregations = append(ls.aggregations, agg)
+}
+
+// handleAggregations lists the continuous aggregations of the request's tenant
+func (s *Server) handleAggregations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.aggregations == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "No aggregations are defined (aggregations-file is empty)", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, AggregationList{Aggregations: s.aggregations.List(s.tenant(r.Context()))})
+}
+
+// handleAggregation answers with the buckets of an aggregation between the from
+// and to URL parameters, by default its whole retention up to now. Keys limited
+// to some resources or levels cannot read aggregations, which cover them all.
+// In cluster mode every node adds the logs it aggregated, so every node must be up.
+func (s *Server) handleAggregation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !(r.Method == http.MethodPost && isForwarded(r.Context())) {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.aggregations == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "No aggregations are defined (aggregations-file is empty)", nil)
+		return
+	}
+	agg := s.aggregations.Get(s.tenant(r.Context()), r.PathValue("name"))
+	if agg == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Aggregation %q not found", r.PathValue("name")), nil)
+		return
+	}
+	if accessScope(r.Context()).restricted() {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "Aggregations cover every resource and level: this API key is limited to some of them", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	from, to := time.Unix(0, agg.cutoff(now)).UTC(), now
+	query := r.URL.Query()
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := query.Get(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				writeValidationError(w, fmt.Errorf("%s must be an RFC 3339 timestamp", name))
+				return
+			}
+		}
+	}
+	if to.Before(from) {
+		writeValidationError(w, errors.New("to must not be before from"))
+		return
+	}
+	if int64(to.Sub(from)/agg.interval) >= maxHistogramBuckets {
+		writeValidationError(w, fmt.Errorf("Invalid range: more than %d buckets of %s", maxHistogramBuckets, agg.Interval))
+		return
+	}
+	auditQuery(r.Context(), map[string]json.RawMessage{
+		"aggregation": json.RawMessage(strconv.Quote(agg.Name)),
+		"from":        json.RawMessage(strconv.Quote(from.Format(time.RFC3339))),
+		"to":          json.RawMessage(strconv.Quote(to.Format(time.RFC3339))),
+	})
+
+	var buckets aggregationBuckets
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		ctx, cancel := s.queryContext(r.Context())
+		defer cancel()
+		var err error
+		if buckets, err = s.clusterAggregation(ctx, r, agg, from, to); err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+	} else {
+		buckets = agg.between(from, to)
+	}
+
+	list := agg.list(buckets, from, to)
+	total := 0
+	for _, bucket := range list {
+		total += bucket.Count
+	}
+	auditResults(r.Context(), total)
+	writeJSON(w, http.StatusOK, AggregationResponse{Name: agg.Name, Function: agg.Function, Field: agg.Field,
+		Interval: agg.interval.String(), GroupBy: agg.GroupBy, Buckets: list})
+}
+
+// clusterAggregation merges the buckets every node aggregated between from and
+// to, which it passes on so that every node answers for the same range
+func (s *Server) clusterAggregation(ctx context.Context, r *http.Request, agg *aggregation, from, to time.Time) (aggregationBuckets, error) {
+	path := clusterAggregationsPath + url.PathEscape(agg.Name) + "?" + url.Values{
+		"from": {from.Format(time.RFC3339)},
+		"to":   {to.Format(time.RFC3339)},
+	}.Encode()
+	nodeBuckets := make([]aggregationBuckets, len(s.cluster.nodes))
+	err := s.cluster.eachNode(func(i int, node *clusterNode) error {
+		if node == s.cluster.self {
+			nodeBuckets[i] = agg.between(from, to)
+			return nil
+		}
+
+		resp, err := s.cluster.peerQuery(ctx, node, path, r, nil, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var response AggregationResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return peerError(node, err)
+		}
+		nodeBuckets[i] = make(aggregationBuckets)
+		for _, bucket := range response.Buckets {
+			if agg.GroupBy == "" {
+				nodeBuckets[i].add(bucket.Time.UnixNano(), "", aggregateOf(agg.Function, bucket.AggregationValue))
+			}
+			for group, v := range bucket.Groups {
+				nodeBuckets[i].add(bucket.Time.UnixNano(), group, aggregateOf(agg.Function, v))
+			}
+		}
+		return nil
+	})
+
+	buckets := make(aggregationBuckets)
+	for _, nb := range nodeBuckets {
+		for start, groups := range nb {
+			for group, a := range groups {
+				buckets.add(start, group, *a)
+			}
+		}
+	}
+	return buckets, err
+}
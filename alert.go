@@ -0,0 +1,370 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Alerting rules counting matching logs over a window and notifying webhook, Slack and email targets
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertSampleSize is how many of the most recent matching logs a firing alert carries
+const alertSampleSize = 5
+
+// alertNotifyTimeout bounds each webhook or Slack notification
+const alertNotifyTimeout = 10 * time.Second
+
+var (
+	alertsFired         = metrics.CounterVec("logingestor_alerts_fired_total", "Firing notifications sent per alerting rule", "rule")
+	alertNotifyFailures = metrics.CounterVec("logingestor_alert_notification_failures_total", "Alert notifications that could not be delivered", "type")
+	alertTargetTypes    = []string{"webhook", "slack", "email"}
+)
+
+// AlertRule is an entry of the alert-rules-file. It fires when at least MinCount
+// logs of the tenant matched Query, a /query body of filters, min_level and "q",
+// during the last Window.
+type AlertRule struct {
+	Name     string                     `json:"name"`
+	Tenant   string                     `json:"tenant"`
+	Query    map[string]json.RawMessage `json:"query"`
+	MinCount int                        `json:"min_count"` // default 1
+	Window   string                     `json:"window"`    // default 5m
+	Cooldown string                     `json:"cooldown"`  // least time between notifications, default the window
+	Targets  []AlertTarget              `json:"targets"`
+}
+
+// AlertTarget is where notifications of a rule go: a webhook or Slack incoming
+// webhook URL, or email recipients
+type AlertTarget struct {
+	Type string   `json:"type"`
+	URL  string   `json:"url"`
+	To   []string `json:"to"`
+}
+
+// Alert is the notification sent when a rule starts firing, repeatedly while it
+// keeps firing once the cooldown passed, and once more when it resolves
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Tenant   string    `json:"tenant"`
+	Status   string    `json:"status"` // firing or resolved
+	Count    int       `json:"count"`
+	MinCount int       `json:"min_count"`
+	Window   string    `json:"window"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Sample   []Log     `json:"sample,omitempty"` // most recent matching logs of a firing alert
+}
+
+// summary is the one-line text of an alert used by Slack and email
+func (a Alert) summary() string {
+	return fmt.Sprintf("[%s] %s: %d matching logs in the last %s (min_count %d), tenant %s",
+		strings.ToUpper(a.Status), a.Rule, a.Count, a.Window, a.MinCount, a.Tenant)
+}
+
+// AlertState is the current state of a rule, as listed by GET /alerts
+type AlertState struct {
+	Rule        string    `json:"rule"`
+	Firing      bool      `json:"firing"`
+	Count       int       `json:"count"`
+	EvaluatedAt time.Time `json:"evaluated_at,omitzero"`
+	NotifiedAt  time.Time `json:"notified_at,omitzero"`
+}
+
+// alertRule is a loaded rule with its parsed query and evaluation state
+type alertRule struct {
+	AlertRule
+	tenant   *Tenant
+	req      QueryRequest
+	window   time.Duration
+	cooldown time.Duration
+
+	state    AlertState
+	notified bool // a firing notification was sent since the rule started firing
+}
+
+// Alerter evaluates the alerting rules on a schedule and sends their notifications
+type Alerter struct {
+	rules  []*alertRule
+	client *http.Client
+
+	smtpAddr string
+	smtpFrom string
+	smtpAuth smtp.Auth // nil sends without authentication
+
+	mu sync.Mutex // guards the rule states
+}
+
+// LoadAlerter reads and checks the rules of the alert-rules-file
+func LoadAlerter(cfg Config, tenants *Tenants, levels *LevelRegistry) (*Alerter, error) {
+	data, err := os.ReadFile(cfg.AlertRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.AlertRulesFile, err)
+	}
+
+	a := &Alerter{
+		client:   &http.Client{Timeout: alertNotifyTimeout},
+		smtpAddr: cfg.AlertSMTPAddr,
+		smtpFrom: cfg.AlertSMTPFrom,
+	}
+	if cfg.AlertSMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.AlertSMTPAddr)
+		a.smtpAuth = smtp.PlainAuth("", cfg.AlertSMTPUsername, cfg.AlertSMTPPassword, host)
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("alert rule without a name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		loaded, err := a.load(cfg, tenants, levels, rule)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %v", rule.Name, err)
+		}
+		a.rules = append(a.rules, loaded)
+	}
+
+	return a, nil
+}
+
+// load checks one rule and fills in its defaults
+func (a *Alerter) load(cfg Config, tenants *Tenants, levels *LevelRegistry, rule AlertRule) (*alertRule, error) {
+	if rule.Tenant == "" {
+		rule.Tenant = DefaultTenant
+	}
+	tenant, ok := tenants.byID[rule.Tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", rule.Tenant)
+	}
+
+	req, err := buildRuleQuery(rule.Query, cfg.MaxPageSize, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.MinCount == 0 {
+		rule.MinCount = 1
+	}
+	if rule.MinCount < 0 {
+		return nil, errors.New("min_count must be positive")
+	}
+	if rule.Window == "" {
+		rule.Window = "5m"
+	}
+	window, err := parseDuration(rule.Window)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid window %q", rule.Window)
+	}
+	cooldown := window
+	if rule.Cooldown != "" {
+		if cooldown, err = parseDuration(rule.Cooldown); err != nil || cooldown < 0 {
+			return nil, fmt.Errorf("invalid cooldown %q", rule.Cooldown)
+		}
+	}
+
+	if len(rule.Targets) == 0 {
+		return nil, errors.New("no targets")
+	}
+	for _, target := range rule.Targets {
+		switch target.Type {
+		case "webhook", "slack":
+			if target.URL == "" {
+				return nil, fmt.Errorf("%s target without a url", target.Type)
+			}
+		case "email":
+			if len(target.To) == 0 {
+				return nil, errors.New("email target without recipients")
+			}
+			if a.smtpAddr == "" || a.smtpFrom == "" {
+				return nil, errors.New("email targets need alert-smtp-addr and alert-smtp-from")
+			}
+		default:
+			return nil, fmt.Errorf("unknown target type %q, expected one of %s", target.Type, strings.Join(alertTargetTypes, ", "))
+		}
+	}
+
+	return &alertRule{
+		AlertRule: rule,
+		tenant:    tenant,
+		req:       req,
+		window:    window,
+		cooldown:  cooldown,
+		state:     AlertState{Rule: rule.Name},
+	}, nil
+}
+
+// Run evaluates every rule each interval until ctx is done
+func (a *Alerter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, rule := range a.rules {
+				a.evaluate(rule, now)
+			}
+		}
+	}
+}
+
+// evaluate counts the logs matching a rule during its window and notifies its
+// targets when it starts firing, keeps firing past the cooldown, or resolves
+func (a *Alerter) evaluate(rule *alertRule, now time.Time) {
+	from := now.Add(-rule.window)
+	filters := maps.Clone(rule.req.Filters)
+	filters["timestamp_from"] = from.Format(time.RFC3339Nano)
+	filters["timestamp_to"] = now.Format(time.RFC3339Nano)
+	count, _ := rule.tenant.storage.Count(context.Background(), filters, rule.req.Options)
+
+	a.mu.Lock()
+	status := ""
+	firing := count >= rule.MinCount
+	switch {
+	case firing && (rule.state.NotifiedAt.IsZero() || now.Sub(rule.state.NotifiedAt) >= rule.cooldown):
+		status = "firing"
+		rule.state.NotifiedAt = now
+		rule.notified = true
+	case !firing && rule.state.Firing && rule.notified:
+		status = "resolved"
+		rule.notified = false
+	}
+	rule.state.Firing = firing
+	rule.state.Count = count
+	rule.state.EvaluatedAt = now
+	a.mu.Unlock()
+
+	if status == "" {
+		return
+	}
+
+	alert := Alert{
+		Rule:     rule.Name,
+		Tenant:   rule.tenant.ID,
+		Status:   status,
+		Count:    count,
+		MinCount: rule.MinCount,
+		Window:   rule.Window,
+		From:     from,
+		To:       now,
+	}
+	if firing {
+		alertsFired.With(rule.Name).Inc()
+		opts := rule.req.Options
+		opts.Limit = alertSampleSize
+		opts.Sort = []SortKey{{Field: "timestamp", Desc: true}}
+		alert.Sample, _, _ = rule.tenant.storage.Query(context.Background(), filters, opts)
+	}
+
+	for _, target := range rule.Targets {
+		if err := a.notify(target, alert); err != nil {
+			alertNotifyFailures.With(target.Type).Inc()
+			logger.Error("alert notification failed", "rule", rule.Name, "target", target.Type, "error", err)
+		}
+	}
+}
+
+// notify sends an alert to one target
+func (a *Alerter) notify(target AlertTarget, alert Alert) error {
+	switch target.Type {
+	case "webhook":
+		return postJSON(a.client, target.URL, alert)
+	case "slack":
+		return postJSON(a.client, target.URL, map[string]string{"text": alert.summary()})
+	case "email":
+		var msg bytes.Buffer
+		fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+			a.smtpFrom, strings.Join(target.To, ", "), alert.summary())
+		fmt.Fprintf(&msg, "%s\r\nFrom %s to %s.\r\n", alert.summary(), alert.From.Format(time.RFC3339), alert.To.Format(time.RFC3339))
+		for _, log := range alert.Sample {
+			line, _ := json.Marshal(log)
+			fmt.Fprintf(&msg, "\r\n%s", line)
+		}
+		return smtp.SendMail(a.smtpAddr, a.smtpAuth, a.smtpFrom, target.To, msg.Bytes())
+	}
+
+	return fmt.Errorf("unknown target type %q", target.Type)
+}
+
+// postJSON sends body as JSON to url, failing on a non-2xx response
+func postJSON(client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// States returns the state of every rule of a tenant, in file order
+func (a *Alerter) States(tenant *Tenant) []AlertState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	states := []AlertState{}
+	for _, rule := range a.rules {
+		if rule.tenant == tenant {
+			states = append(states, rule.state)
+		}
+	}
+
+	return states
+}
+
+// AlertList is the body of GET /alerts
+type AlertList struct {
+	Alerts []AlertState `json:"alerts"`
+}
+
+// handleAlerts lists the alerting rules of the request's tenant with their state
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.alerts == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Alerting is disabled (no alert-rules-file)", nil)
+		return
+	}
+
+	response, err := json.Marshal(AlertList{Alerts: s.alerts.States(s.tenant(r.Context()))})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
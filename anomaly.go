@@ -0,0 +1,385 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Anomaly detection on the log volume and error ratio of each resource against a learned baseline
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of anomalies
+const (
+	AnomalySpike      = "spike"       // far more logs than the baseline
+	AnomalyDrop       = "drop"        // far fewer logs than the baseline
+	AnomalySilence    = "silence"     // no logs at all from a resource that logs steadily
+	AnomalyErrorRatio = "error_ratio" // a far larger share of error logs than the baseline
+)
+
+var anomalyKinds = []string{AnomalySpike, AnomalyDrop, AnomalySilence, AnomalyErrorRatio}
+
+// Least volumes flagged, so that resources logging a handful of lines do not
+// raise anomalies on every burst
+const (
+	anomalyMinLogs   = 10 // logs of an interval for a spike, or of the baseline for a drop or silence
+	anomalyMinErrors = 5  // error logs of an interval for an error ratio anomaly
+)
+
+// anomalyMinRatioDeviation is the least standard deviation assumed of an error
+// ratio, as a steady ratio has none
+const anomalyMinRatioDeviation = 0.05
+
+var (
+	anomaliesDetected     = metrics.CounterVec("logingestor_anomalies_total", "Anomalies detected in the log volume of resources", "kind")
+	anomalyResources      = metrics.Gauge("logingestor_anomaly_resources", "Resources whose log volume has a baseline")
+	anomalyUntracked      = metrics.Counter("logingestor_anomaly_untracked_logs_total", "Logs of resources left without a baseline because anomaly-max-resources was reached")
+	anomalyNotifyFailures = metrics.Counter("logingestor_anomaly_notification_failures_total", "Anomaly notifications that could not be delivered")
+)
+
+// Anomaly is a deviation of the logs of a resource during one interval from
+// what its baseline expects
+type Anomaly struct {
+	ID         int64     `json:"id"`
+	Tenant     string    `json:"tenant"`
+	ResourceID string    `json:"resourceId"`
+	Kind       string    `json:"kind"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Count      int       `json:"count"`    // logs of the interval
+	Expected   float64   `json:"expected"` // baseline logs per interval
+	Errors     int       `json:"errors"`
+	ErrorRatio float64   `json:"error_ratio"`
+	// Baseline share of error logs
+	ExpectedErrorRatio float64 `json:"expected_error_ratio"`
+	Score              float64 `json:"score"` // deviation from the baseline in standard deviations
+}
+
+// ewma is an exponentially weighted moving mean and variance
+type ewma struct {
+	mean, variance float64
+}
+
+func (e *ewma) add(x, alpha float64) {
+	d := x - e.mean
+	e.mean += alpha * d
+	e.variance = (1 - alpha) * (e.variance + alpha*d*d)
+}
+
+// baseline is what the detector learned of one resource
+type baseline struct {
+	volume  ewma
+	ratio   ewma // of the intervals with logs
+	samples int
+	active  map[string]bool // kinds flagged in the previous interval, not flagged again
+}
+
+// volume counts the logs of each resource ingested during the current interval
+type volume struct {
+	logs, errors int
+}
+
+// volumeCounter counts the logs a storage ingests by resource, which the
+// detector collects every interval
+type volumeCounter struct {
+	errorLevels []string
+
+	mu     sync.Mutex
+	counts map[string]*volume
+}
+
+// add counts ingested logs
+func (vc *volumeCounter) add(logs []Log) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for _, log := range logs {
+		v := vc.counts[log.ResourceID]
+		if v == nil {
+			v = &volume{}
+			vc.counts[log.ResourceID] = v
+		}
+		v.logs++
+		if slices.Contains(vc.errorLevels, log.Level) {
+			v.errors++
+		}
+	}
+}
+
+// collect returns the counts of the interval ending and starts the next one
+func (vc *volumeCounter) collect() map[string]*volume {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	counts := vc.counts
+	vc.counts = make(map[string]*volume)
+	return counts
+}
+
+// tenantBaselines is the volume and baselines of one tenant
+type tenantBaselines struct {
+	tenant    *Tenant
+	counter   *volumeCounter
+	baselines map[string]*baseline
+}
+
+// AnomalyDetector learns the log rate and error ratio of every resource and
+// flags the intervals that deviate from them
+type AnomalyDetector struct {
+	interval     time.Duration
+	alpha        float64 // weight of the newest interval in the baselines
+	warmup       int     // intervals learned before anything is flagged
+	threshold    float64
+	maxResources int
+	maxEntries   int
+	webhooks     []string
+	client       *http.Client
+	tenants      []*tenantBaselines
+
+	mu        sync.Mutex // guards the anomalies and the baselines
+	anomalies []Anomaly  // oldest first
+	nextID    int64
+}
+
+// NewAnomalyDetector creates the detector of the anomaly settings and makes every
+// tenant's storage count its ingested logs for it
+func NewAnomalyDetector(cfg Config, tenants *Tenants, levels *LevelRegistry) (*AnomalyDetector, error) {
+	errorLevels, err := levels.AtLeast(cfg.AnomalyErrorLevel)
+	if err != nil {
+		return nil, fmt.Errorf("anomaly-error-level %v", err)
+	}
+
+	d := &AnomalyDetector{
+		interval:     cfg.AnomalyInterval,
+		alpha:        2 / float64(cfg.AnomalyBaseline+1),
+		warmup:       cfg.AnomalyBaseline,
+		threshold:    cfg.AnomalyThreshold,
+		maxResources: cfg.AnomalyMaxResources,
+		maxEntries:   cfg.AnomalyMaxEntries,
+		webhooks:     cfg.AnomalyWebhooks,
+		client:       &http.Client{Timeout: alertNotifyTimeout},
+		nextID:       1,
+	}
+	for _, tenant := range tenants.All() {
+		tb := &tenantBaselines{
+			tenant:    tenant,
+			counter:   &volumeCounter{errorLevels: errorLevels, counts: make(map[string]*volume)},
+			baselines: make(map[string]*baseline),
+		}
+		tenant.storage.volume = tb.counter
+		d.tenants = append(d.tenants, tb)
+	}
+
+	return d, nil
+}
+
+// Run closes an interval every anomaly-interval until ctx is done
+func (d *AnomalyDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var found []Anomaly
+			for _, tb := range d.tenants {
+				found = append(found, d.evaluate(tb, now)...)
+			}
+			for _, anomaly := range found {
+				d.notify(anomaly)
+			}
+		}
+	}
+}
+
+// evaluate compares the counts of the interval ending at now to the baselines
+// of a tenant, records the anomalies found and learns the counts
+func (d *AnomalyDetector) evaluate(tb *tenantBaselines, now time.Time) []Anomaly {
+	counts := tb.counter.collect()
+	from := now.Add(-d.interval)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tracked := d.tracked()
+	for resource, v := range counts {
+		if tb.baselines[resource] != nil {
+			continue
+		}
+		if tracked >= d.maxResources {
+			anomalyUntracked.Add(int64(v.logs))
+			continue
+		}
+		tb.baselines[resource] = &baseline{active: make(map[string]bool)}
+		tracked++
+	}
+
+	var found []Anomaly
+	for resource, b := range tb.baselines {
+		v := counts[resource]
+		if v == nil {
+			v = &volume{}
+		}
+		if b.samples >= d.warmup {
+			for _, anomaly := range d.check(b, *v) {
+				anomaly.Tenant, anomaly.ResourceID, anomaly.From, anomaly.To = tb.tenant.ID, resource, from, now
+				found = append(found, d.record(anomaly))
+			}
+		}
+
+		b.volume.add(float64(v.logs), d.alpha)
+		if v.logs > 0 {
+			b.ratio.add(float64(v.errors)/float64(v.logs), d.alpha)
+		}
+		b.samples++
+		// A resource gone quiet for long is forgotten once its baseline decays
+		if v.logs == 0 && b.volume.mean < 0.01 && len(b.active) == 0 {
+			delete(tb.baselines, resource)
+		}
+	}
+
+	anomalyResources.Set(int64(d.tracked()))
+	return found
+}
+
+// tracked returns the number of resources with a baseline; d.mu is held
+func (d *AnomalyDetector) tracked() int {
+	n := 0
+	for _, tb := range d.tenants {
+		n += len(tb.baselines)
+	}
+	return n
+}
+
+// check returns the anomalies of an interval's volume against a baseline, each
+// kind once when it starts, and tracks which kinds are still deviating
+func (d *AnomalyDetector) check(b *baseline, v volume) []Anomaly {
+	// The counts vary at least as a Poisson process of the same mean would
+	sigma := max(math.Sqrt(b.volume.variance), math.Sqrt(b.volume.mean), 1)
+	score := (float64(v.logs) - b.volume.mean) / sigma
+	deviating := make(map[string]bool)
+	switch {
+	case score > d.threshold && v.logs >= anomalyMinLogs:
+		deviating[AnomalySpike] = true
+	case score < -d.threshold && b.volume.mean >= anomalyMinLogs && v.logs == 0:
+		deviating[AnomalySilence] = true
+	case score < -d.threshold && b.volume.mean >= anomalyMinLogs:
+		deviating[AnomalyDrop] = true
+	}
+
+	var ratio, ratioScore float64
+	if v.logs > 0 {
+		ratio = float64(v.errors) / float64(v.logs)
+		ratioScore = (ratio - b.ratio.mean) / max(math.Sqrt(b.ratio.variance), anomalyMinRatioDeviation)
+		if ratioScore > d.threshold && v.errors >= anomalyMinErrors {
+			deviating[AnomalyErrorRatio] = true
+		}
+	}
+
+	var found []Anomaly
+	for _, kind := range anomalyKinds {
+		if deviating[kind] && !b.active[kind] {
+			anomaly := Anomaly{Kind: kind, Count: v.logs, Expected: b.volume.mean, Errors: v.errors,
+				ErrorRatio: ratio, ExpectedErrorRatio: b.ratio.mean, Score: score}
+			if kind == AnomalyErrorRatio {
+				anomaly.Score = ratioScore
+			}
+			found = append(found, anomaly)
+		}
+	}
+	b.active = deviating
+	return found
+}
+
+// record keeps an anomaly, the newest maxEntries of them; d.mu is held
+func (d *AnomalyDetector) record(anomaly Anomaly) Anomaly {
+	anomaly.ID = d.nextID
+	d.nextID++
+	anomaly.Expected = math.Round(anomaly.Expected*100) / 100
+	anomaly.ExpectedErrorRatio = math.Round(anomaly.ExpectedErrorRatio*1000) / 1000
+	anomaly.ErrorRatio = math.Round(anomaly.ErrorRatio*1000) / 1000
+	anomaly.Score = math.Round(anomaly.Score*100) / 100
+	d.anomalies = append(d.anomalies, anomaly)
+	if len(d.anomalies) > d.maxEntries {
+		d.anomalies = slices.Delete(d.anomalies, 0, len(d.anomalies)-d.maxEntries)
+	}
+	anomaliesDetected.With(anomaly.Kind).Inc()
+	logger.Warn("anomaly detected", "tenant", anomaly.Tenant, "resourceId", anomaly.ResourceID, "kind", anomaly.Kind,
+		"count", anomaly.Count, "expected", anomaly.Expected, "score", anomaly.Score)
+	return anomaly
+}
+
+// notify posts an anomaly to every anomaly webhook
+func (d *AnomalyDetector) notify(anomaly Anomaly) {
+	for _, url := range d.webhooks {
+		if err := postJSON(d.client, url, anomaly); err != nil {
+			anomalyNotifyFailures.Inc()
+			logger.Error("anomaly notification failed", "url", url, "error", err)
+		}
+	}
+}
+
+// List returns the anomalies of a tenant, newest first, optionally of one
+// resource or kind only, up to limit
+func (d *AnomalyDetector) List(tenant *Tenant, resource, kind string, limit int) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	anomalies := []Anomaly{}
+	for i := len(d.anomalies) - 1; i >= 0 && len(anomalies) < limit; i-- {
+		a := d.anomalies[i]
+		if a.Tenant == tenant.ID && (resource == "" || a.ResourceID == resource) && (kind == "" || a.Kind == kind) {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// AnomalyList is the body of GET /anomalies
+type AnomalyList struct {
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// handleAnomalies lists the anomalies of the request's tenant, newest first,
+// narrowed by the resourceId and kind URL parameters, up to limit (default 100)
+func (s *Server) handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.anomalies == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Anomaly detection is disabled (anomaly-interval is 0)", nil)
+		return
+	}
+	if accessScope(r.Context()).restricted() {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "Anomalies are detected over every resource and level: this API key is limited to some of them", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	if kind != "" && !slices.Contains(anomalyKinds, kind) {
+		writeValidationError(w, fmt.Errorf("kind must be one of %s", strings.Join(anomalyKinds, ", ")))
+		return
+	}
+	limit := defaultValuesLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > s.cfg.MaxPageSize {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+
+	anomalies := s.anomalies.List(s.tenant(r.Context()), query.Get("resourceId"), kind, limit)
+	auditResults(r.Context(), len(anomalies))
+	writeJSON(w, http.StatusOK, AnomalyList{Anomalies: anomalies})
+}
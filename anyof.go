@@ -0,0 +1,107 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Multi-value filters, matching logs whose field has any of the values given (IN)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// scalarFilterKeys are the filter keys that take a single value
+var scalarFilterKeys = []string{"timestamp", "timestamp_from", "timestamp_to", messageModeKey}
+
+// anyOfFilter matches the logs meeting the filter key with any of values. It is
+// part of the query expression, ANDed with the other filters, like min_level.
+type anyOfFilter struct {
+	key         string
+	values      []string // shares QueryRequest.AnyOf or Not, which LevelRegistry.apply normalizes
+	messageMode string
+}
+
+func (n anyOfFilter) eval(log Log) bool {
+	for _, value := range n.values {
+		if matchesFilter(log, n.key, value, n.messageMode) {
+			return true
+		}
+	}
+	return false
+}
+
+// positions unions the index lookups of every value, as an OR of them would
+func (n anyOfFilter) positions(sh *shard) ([]int, bool) {
+	var positions []int
+	for _, value := range n.values {
+		var matches []int
+		switch {
+		case slices.Contains(indexedFields, n.key):
+			matches = sh.index[n.key][value]
+		case n.key == "message" && n.messageMode != MessageFuzzy:
+			var ok bool
+			if matches, ok = sh.messages.lookup(value); !ok {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		positions = union(positions, matches)
+	}
+	return positions, true
+}
+
+// parseFilterValues decodes the array value of a filter key
+func parseFilterValues(key string, raw []byte) ([]string, error) {
+	if slices.Contains(scalarFilterKeys, key) {
+		return nil, errors.New("must be a string, not an array")
+	}
+	var values []string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, errors.New("must list at least one value")
+	}
+	return values, nil
+}
+
+// setFilterExpr adds the multi-value or, when negated, the negated filters of a
+// query to its expression, in key order so equal queries get equal expressions
+func setFilterExpr(expr lqlNode, sets map[string][]string, messageMode string, negated bool) lqlNode {
+	keys := make([]string, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		filter := anyOfFilter{key: key, values: sets[key], messageMode: messageMode}
+		var node lqlNode = filter
+		if negated {
+			node = noneOfFilter{filter}
+		}
+		if expr != nil {
+			node = lqlAnd{left: expr, right: node}
+		}
+		expr = node
+	}
+	return expr
+}
+
+// validateAnyOf precompiles the patterns of multi-value regex filters
+func validateAnyOf(anyOf map[string][]string) error {
+	for key, values := range anyOf {
+		if _, ok := regexField(key); !ok {
+			continue
+		}
+		for _, pattern := range values {
+			if _, err := compiledPatterns.compile(pattern); err != nil {
+				return fmt.Errorf("Invalid %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Audit log recording who queried which logs, with the results and latency of every query
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var auditWrites = metrics.Counter("logingestor_audit_entries_total", "Queries recorded in the audit log")
+
+// AuditEntry records one query: who ran it, what it asked for and what it returned
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Tenant     string          `json:"tenant"`
+	Key        string          `json:"key,omitempty"` // name of the API key, empty without authentication
+	RemoteAddr string          `json:"remoteAddr,omitempty"`
+	Route      string          `json:"route"`
+	Path       string          `json:"path"`
+	Query      json.RawMessage `json:"query,omitempty"` // the query fields, or the URL query of a GET
+	Status     int             `json:"status"`          // HTTP status, or the gRPC status code of a gRPC call
+	Results    int             `json:"results"`
+	DurationMs float64         `json:"durationMs"`
+}
+
+// AuditList is the body of GET /admin/audit
+type AuditList struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// AuditFilter selects audit entries; empty fields match every entry
+type AuditFilter struct {
+	Key   string
+	Route string
+	From  time.Time
+	To    time.Time
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Key == "" || entry.Key == f.Key) &&
+		(f.Route == "" || entry.Route == f.Route) &&
+		(f.From.IsZero() || !entry.Time.Before(f.From)) &&
+		(f.To.IsZero() || entry.Time.Before(f.To))
+}
+
+// AuditLog keeps the newest audit entries, up to max, in memory and, when path is
+// set, in a ringFile of its own
+type AuditLog struct {
+	max int
+
+	mu      sync.Mutex
+	file    *ringFile[AuditEntry] // nil in memory
+	entries []AuditEntry          // oldest first
+	nextID  int64
+}
+
+// OpenAuditLog loads the audit entries in path, creating the file if needed; an
+// empty path keeps them in memory only
+func OpenAuditLog(path string, maxEntries int) (*AuditLog, error) {
+	a := &AuditLog{max: maxEntries, nextID: 1}
+	if path == "" {
+		return a, nil
+	}
+
+	file, err := openRingFile(path, "audit", maxEntries, func(entry AuditEntry) {
+		a.entries = append(a.entries, entry)
+		a.nextID = max(a.nextID, entry.ID+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(a.entries) > maxEntries {
+		a.entries = a.entries[len(a.entries)-maxEntries:]
+	}
+
+	if err := file.rewrite(a.entries); err != nil {
+		return nil, err
+	}
+	a.file = file
+
+	return a, nil
+}
+
+// Add records an entry, evicting the oldest beyond the limit
+func (a *AuditLog) Add(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.ID = a.nextID
+	a.nextID++
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.max {
+		a.entries = slices.Delete(a.entries, 0, len(a.entries)-a.max)
+	}
+	a.file.write(entry, a.entries)
+	auditWrites.Inc()
+}
+
+// List returns the entries of a tenant matching filter, newest first, with the
+// total number of matches
+func (a *AuditLog) List(tenant string, filter AuditFilter, offset, limit int) ([]AuditEntry, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var matches []AuditEntry
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if a.entries[i].Tenant == tenant && filter.matches(a.entries[i]) {
+			matches = append(matches, a.entries[i])
+		}
+	}
+
+	total := len(matches)
+	matches = matches[min(offset, total):]
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, total
+}
+
+// Redact clears the query of the entries of a tenant whose query or path holds
+// id, which is lower case, and replaces their path by the route, then rewrites
+// the file; it returns how many entries were redacted
+func (a *AuditLog) Redact(tenant, id string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+	for i := range a.entries {
+		entry := &a.entries[i]
+		if entry.Tenant != tenant {
+			continue
+		}
+		var query any
+		json.Unmarshal(entry.Query, &query)
+		if !valueContains(query, id) && !strings.Contains(strings.ToLower(entry.Path), id) {
+			continue
+		}
+		entry.Query, entry.Path = nil, entry.Route
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, a.file.rewrite(a.entries)
+}
+
+// Len returns the number of entries held
+func (a *AuditLog) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.entries)
+}
+
+// Close closes the file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.close()
+}
+
+// auditKey is the context key of the auditRecord of a request
+type auditKey struct{}
+
+// auditRecord collects what the handler of an audited request reports about its query
+type auditRecord struct {
+	query   json.RawMessage
+	results int
+}
+
+// auditQuery records the query fields of the request of ctx, if it is audited
+func auditQuery(ctx context.Context, fields map[string]json.RawMessage) {
+	if rec, ok := ctx.Value(auditKey{}).(*auditRecord); ok {
+		rec.query, _ = json.Marshal(fields)
+	}
+}
+
+// auditResults records how many logs, or the count, the query of ctx returned
+func auditResults(ctx context.Context, n int) {
+	if rec, ok := ctx.Value(auditKey{}).(*auditRecord); ok {
+		rec.results = n
+	}
+}
+
+// startAudit returns the request with an auditRecord for its handler to fill
+func startAudit(r *http.Request) (*http.Request, *auditRecord) {
+	rec := &auditRecord{}
+	return r.WithContext(context.WithValue(r.Context(), auditKey{}, rec)), rec
+}
+
+// finishAudit adds the entry of an audited request to the audit log. Without
+// query fields from the handler, the URL query stands for them.
+func (s *Server) finishAudit(r *http.Request, rec *auditRecord, start time.Time, status int) {
+	query := rec.query
+	if query == nil && r.URL.RawQuery != "" {
+		params := make(map[string]string)
+		for key, values := range r.URL.Query() {
+			params[key] = values[0]
+		}
+		query, _ = json.Marshal(params)
+	}
+
+	route := r.Pattern
+	if route == "" {
+		route = r.URL.Path
+	}
+	s.audit.Add(AuditEntry{
+		Time:       start.UTC(),
+		Tenant:     s.tenant(r.Context()).ID,
+		Key:        principalName(r.Context()),
+		RemoteAddr: remoteHost(r),
+		Route:      route,
+		Path:       r.URL.Path,
+		Query:      query,
+		Status:     status,
+		Results:    rec.results,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+// audited records every request next serves in the audit log. Requests forwarded
+// by another node are recorded by the node that received them.
+func (s *Server) audited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isForwarded(r.Context()) {
+			next(w, r)
+			return
+		}
+		s.auditedAlways(next)(w, r)
+	}
+}
+
+// auditedAlways is audited for the routes only nodes call, whose forwarded
+// requests no other node records
+func (s *Server) auditedAlways(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		r, rec := startAudit(r)
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(sr, r)
+		s.finishAudit(r, rec, start, sr.status)
+	}
+}
+
+// handleAudit lists the audit entries of the caller's tenant, newest first,
+// filtered by key, route and a from/to time range
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.audit == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "The audit log is disabled (audit-max-entries=0)", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := s.cfg.MaxPageSize
+	offset := 0
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > s.cfg.MaxPageSize {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeValidationError(w, errors.New("offset must be a non-negative integer"))
+			return
+		}
+	}
+
+	filter := AuditFilter{Key: query.Get("key"), Route: query.Get("route")}
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				writeValidationError(w, fmt.Errorf("%s must be an RFC 3339 timestamp", name))
+				return
+			}
+		}
+	}
+
+	entries, total := s.audit.List(s.tenant(r.Context()).ID, filter, offset, limit)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, AuditList{Entries: entries, Total: total})
+}
@@ -0,0 +1,268 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : API key authentication with read/write scopes and per-key token bucket rate limiting
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var (
+	authFailures = metrics.CounterVec("logingestor_auth_failures_total", "Requests rejected by authentication or authorization", "reason")
+	rateLimited  = metrics.CounterVec("logingestor_rate_limited_total", "Requests rejected by the per-key rate limit", "key")
+)
+
+// APIKey is a credential with its scopes, rate limit and the logs it may
+// query; a zero RateLimit uses the server default
+type APIKey struct {
+	Key       string   `json:"key"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit float64  `json:"rate_limit"` // requests per second
+	RateBurst int      `json:"rate_burst"`
+	Tenant    string   `json:"tenant"` // binds the key to one tenant; empty allows X-Tenant-ID
+	AccessScope
+}
+
+// tokenBucket grants up to burst requests at once, refilled at rate per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take consumes a token, or reports how long until one is available
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// principal is an authenticated API key
+type principal struct {
+	APIKey
+	limiter *tokenBucket // nil when unlimited
+}
+
+// Authenticator checks API keys; with no keys configured every request is allowed.
+// Write requests also need a verified client certificate when tls-client-auth is set.
+type Authenticator struct {
+	keys              map[string]*principal
+	requireClientCert bool
+}
+
+// NewAuthenticator loads the keys of the api-keys setting and the api-keys-file
+func NewAuthenticator(cfg Config) (*Authenticator, error) {
+	var keys []APIKey
+	for _, entry := range cfg.APIKeys {
+		key, scopes, _ := strings.Cut(entry, ":")
+		scopes, tenant, _ := strings.Cut(scopes, "@")
+		keys = append(keys, APIKey{Key: key, Scopes: strings.Split(scopes, "+"), Tenant: tenant})
+	}
+
+	if cfg.APIKeysFile != "" {
+		data, err := os.ReadFile(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		var fileKeys []APIKey
+		if err := json.Unmarshal(data, &fileKeys); err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.APIKeysFile, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	auth := &Authenticator{keys: make(map[string]*principal), requireClientCert: cfg.TLSClientAuth != TLSClientAuthOff}
+	for i, key := range keys {
+		if key.Name == "" {
+			key.Name = "key-" + strconv.Itoa(i)
+		}
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %q: empty key", key.Name)
+		}
+		if _, ok := auth.keys[key.Key]; ok {
+			return nil, fmt.Errorf("API key %q: duplicate key", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin {
+				return nil, fmt.Errorf("API key %q: unknown scope %q", key.Name, scope)
+			}
+		}
+		for _, level := range key.Levels {
+			if !slices.Contains(cfg.Levels, level) {
+				return nil, fmt.Errorf("API key %q: unknown level %q", key.Name, level)
+			}
+		}
+		if key.Tenant != "" && key.Tenant != DefaultTenant && !slices.ContainsFunc(cfg.Tenants, func(entry string) bool {
+			id, _ := parseTenant(entry)
+			return id == key.Tenant
+		}) {
+			return nil, fmt.Errorf("API key %q: unknown tenant %q", key.Name, key.Tenant)
+		}
+		if key.RateLimit == 0 {
+			key.RateLimit, key.RateBurst = cfg.RateLimit, cfg.RateBurst
+		}
+
+		p := &principal{APIKey: key}
+		if key.RateLimit > 0 {
+			p.limiter = newTokenBucket(key.RateLimit, key.RateBurst)
+		}
+		auth.keys[key.Key] = p
+	}
+
+	return auth, nil
+}
+
+// enabled reports whether requests must carry an API key
+func (a *Authenticator) enabled() bool {
+	return len(a.keys) > 0
+}
+
+// requestKey returns the key of an "Authorization: Bearer" or "X-API-Key" header
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	// Splunk HEC clients send their token as "Authorization: Splunk <token>"
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Splunk "); ok {
+		return strings.TrimSpace(token)
+	}
+	// Clients that only speak basic auth, such as Elasticsearch outputs, send the key as password
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return ""
+}
+
+// authError is a rejected request: status and code for HTTP, retryAfter for 429s
+type authError struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *authError) Error() string { return e.message }
+
+// authenticate checks the client certificate of write requests when required, and
+// that the request key has scope and is within its rate limit
+func (a *Authenticator) authenticate(r *http.Request, scope string) (*principal, *authError) {
+	if a.requireClientCert && scope == ScopeWrite && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		authFailures.With("missing_client_cert").Inc()
+		return nil, &authError{status: http.StatusForbidden, code: ErrCodeForbidden, message: "A trusted client certificate is required to ingest"}
+	}
+	if !a.enabled() {
+		return nil, nil
+	}
+
+	key := requestKey(r)
+	if key == "" {
+		authFailures.With("missing_key").Inc()
+		return nil, &authError{status: http.StatusUnauthorized, code: ErrCodeUnauthorized, message: "Missing API key"}
+	}
+	p, ok := a.keys[key]
+	if !ok {
+		authFailures.With("invalid_key").Inc()
+		return nil, &authError{status: http.StatusUnauthorized, code: ErrCodeUnauthorized, message: "Invalid API key"}
+	}
+	if !slices.Contains(p.Scopes, scope) {
+		authFailures.With("missing_scope").Inc()
+		return nil, &authError{status: http.StatusForbidden, code: ErrCodeForbidden, message: fmt.Sprintf("API key lacks the %s scope", scope)}
+	}
+
+	if p.limiter != nil {
+		if ok, wait := p.limiter.take(time.Now()); !ok {
+			rateLimited.With(p.Name).Inc()
+			return nil, &authError{status: http.StatusTooManyRequests, code: ErrCodeRateLimited, message: "Rate limit exceeded", retryAfter: wait}
+		}
+	}
+
+	return p, nil
+}
+
+// requireScope wraps a handler so that it only runs for keys holding scope
+func (s *Server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireScopeAs(scope, writeAuthError, next)
+}
+
+// writeAuthError answers a request that failed authentication or named a tenant
+// it may not use
+func writeAuthError(w http.ResponseWriter, r *http.Request, err *authError) {
+	if err.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="logingestor"`)
+	}
+	if err.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	}
+	writeError(w, err.status, err.code, err.message, nil)
+}
+
+// requireScopeAs is requireScope answering failures with writeErr, for the
+// APIs of other products whose clients expect their own error bodies
+func (s *Server) requireScopeAs(scope string, writeErr func(http.ResponseWriter, *http.Request, *authError), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.auth.authenticate(r, scope)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+
+		tenant, err := s.tenants.resolve(r, p)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+
+		ctx := withTenant(r.Context(), tenant)
+		logAttrs(ctx, slog.String("tenant", tenant.ID))
+		if p != nil {
+			ctx = withPrincipal(ctx, p)
+			logAttrs(ctx, slog.String("key", p.Name))
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
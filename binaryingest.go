@@ -0,0 +1,236 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : MessagePack and CBOR bodies for /ingest and /ingest/batch, read under the JSON log schema
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Binary encodings of ingest bodies, by Content-Type
+const (
+	codecMsgpack = "MessagePack"
+	codecCBOR    = "CBOR"
+)
+
+var ingestCodecs = map[string]string{
+	"application/msgpack":     codecMsgpack,
+	"application/x-msgpack":   codecMsgpack,
+	"application/vnd.msgpack": codecMsgpack,
+	"application/cbor":        codecCBOR,
+}
+
+// ingestCodec returns the binary encoding of an ingest body, empty for JSON,
+// which any other Content-Type is read as
+func ingestCodec(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ingestCodecs[mediaType]
+}
+
+// decodeBinary decodes a body holding a single value of codec
+func decodeBinary(codec string, body []byte) (interface{}, error) {
+	reader := bytes.NewReader(body)
+	var value interface{}
+	var err error
+	// Nothing decodes to more than its encoding, so the body bounds every value
+	if codec == codecCBOR {
+		value, err = newCBORDecoder(reader, int64(len(body))).Decode()
+	} else {
+		value, err = newMsgpackDecoder(reader, int64(len(body))).Decode()
+	}
+	switch {
+	case err == io.EOF:
+		return nil, io.ErrUnexpectedEOF
+	case err == nil && reader.Len() > 0:
+		return nil, fmt.Errorf("unexpected data after the top-level %s value", codec)
+	}
+	return value, err
+}
+
+// writeMalformedBinary rejects a body that is not valid for its codec
+func writeMalformedBinary(w http.ResponseWriter, codec string, err error) {
+	writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Error decoding "+codec, err.Error())
+}
+
+// binaryBatch returns the entries of a decoded batch body: an array, or nil for none
+func binaryBatch(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot decode %s into a batch: expected an array of log entries", binaryType(value))
+}
+
+// binaryLog reads a decoded entry as the JSON body of /ingest would be: the same
+// fields with the same types, so that Validator.Validate sees the same log from
+// every codec. The maps and arrays of value are normalized in place, so that it
+// encodes to the JSON of the entry, which the dead letters keep.
+func binaryLog(value interface{}) (log Log, err error) {
+	if value, err = normalizeBinary(value, 0); err != nil {
+		return Log{}, err
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return Log{}, fmt.Errorf("cannot decode %s into a log entry", binaryType(value))
+	}
+
+	for _, field := range []struct {
+		name string
+		dst  *string
+	}{
+		{"level", &log.Level}, {"message", &log.Message}, {"resourceId", &log.ResourceID},
+		{"traceId", &log.TraceID}, {"spanId", &log.SpanID}, {"commit", &log.Commit},
+	} {
+		if *field.dst, err = binaryString(doc, field.name); err != nil {
+			return Log{}, err
+		}
+	}
+
+	switch t := doc["timestamp"].(type) {
+	case nil:
+	case time.Time:
+		log.Timestamp = t
+	case string:
+		if log.Timestamp, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return Log{}, fmt.Errorf("timestamp: %v", err)
+		}
+	default:
+		return Log{}, errors.New("timestamp must be an RFC 3339 string or a timestamp")
+	}
+
+	switch md := doc["metadata"].(type) {
+	case nil:
+	case map[string]interface{}:
+		if log.Metadata.ParentResourceID, err = binaryString(md, "parentResourceId"); err != nil {
+			return Log{}, fmt.Errorf("metadata.%v", err)
+		}
+		for key, value := range md {
+			if key == "parentResourceId" {
+				continue
+			}
+			if log.Metadata.Fields == nil {
+				log.Metadata.Fields = make(map[string]any, len(md))
+			}
+			log.Metadata.Fields[key] = value
+		}
+	default:
+		return Log{}, errors.New("metadata must be a map")
+	}
+
+	log.Custom = docCustom(doc)
+	return log, nil
+}
+
+// binaryString returns the string field name of doc, empty when missing or nil
+func binaryString(doc map[string]interface{}, name string) (string, error) {
+	switch v := doc[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s must be a string", name)
+}
+
+// normalizeBinary turns decoded values into those JSON decoding gives: numbers
+// to json.Number, byte strings to UTF-8 strings. Timestamps are kept at the top
+// level, for the timestamp field, and formatted in RFC 3339 below it.
+func normalizeBinary(value interface{}, depth int) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("unsupported number %v", v)
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case []byte:
+		if !utf8.Valid(v) {
+			return nil, errors.New("byte string is not valid UTF-8")
+		}
+		return string(v), nil
+	case msgpackExt:
+		t, err := msgpackTimestamp(v)
+		if err != nil {
+			return nil, err
+		}
+		return normalizeBinary(t, depth)
+	case time.Time:
+		if depth > 1 {
+			return v.Format(time.RFC3339Nano), nil
+		}
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = normalizeBinary(v[i], depth+2); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			item, err := normalizeBinary(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = item
+		}
+	}
+	return value, nil
+}
+
+// msgpackTimestamp decodes the MessagePack timestamp extension (type -1): 32-bit
+// seconds, 30-bit nanoseconds and 34-bit seconds, or 32-bit nanoseconds and
+// 64-bit seconds
+func msgpackTimestamp(ext msgpackExt) (time.Time, error) {
+	if ext.Type == -1 {
+		switch len(ext.Data) {
+		case 4:
+			return time.Unix(int64(binary.BigEndian.Uint32(ext.Data)), 0).UTC(), nil
+		case 8:
+			n := binary.BigEndian.Uint64(ext.Data)
+			return time.Unix(int64(n&(1<<34-1)), int64(n>>34)).UTC(), nil
+		case 12:
+			return time.Unix(int64(binary.BigEndian.Uint64(ext.Data[4:])), int64(binary.BigEndian.Uint32(ext.Data))).UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported msgpack extension type %d", ext.Type)
+}
+
+// binaryType names the type of a decoded value for an error
+func binaryType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, int64, uint64, float64:
+		return "number"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "timestamp"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
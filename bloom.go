@@ -0,0 +1,97 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Bloom filters letting point lookups skip cold tier files that cannot hold a value
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFields lists the filter keys every cold file keeps a bloom filter of
+var bloomFields = []string{"traceId", "spanId", "resourceId"}
+
+// bloomFalsePositiveRate is the chance a filter reports a value it was not given
+const bloomFalsePositiveRate = 0.01
+
+var bloomSkipped = metrics.Counter("logingestor_cold_bloom_skipped_total", "Cold files a query skipped because a bloom filter ruled them out")
+
+// bloomFilter is a set of strings that may report false positives but never
+// false negatives
+type bloomFilter struct {
+	Bits   []byte `json:"bits"`
+	Hashes int    `json:"hashes"`
+}
+
+// newBloomFilter sizes a filter for n values at bloomFalsePositiveRate
+func newBloomFilter(n int) *bloomFilter {
+	n = max(n, 1)
+	bits := int(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(n) * math.Ln2))
+
+	return &bloomFilter{Bits: make([]byte, (bits+7)/8), Hashes: max(hashes, 1)}
+}
+
+// positions derives the filter's bit positions of value from two halves of
+// one 64-bit hash
+func (bf *bloomFilter) positions(value string, fn func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	size := uint64(len(bf.Bits)) * 8
+	for i := 0; i < bf.Hashes; i++ {
+		fn((h1 + uint64(i)*h2) % size)
+	}
+}
+
+func (bf *bloomFilter) add(value string) {
+	bf.positions(value, func(bit uint64) { bf.Bits[bit/8] |= 1 << (bit % 8) })
+}
+
+// mayContain reports false only if value was never added
+func (bf *bloomFilter) mayContain(value string) bool {
+	if len(bf.Bits) == 0 {
+		return true
+	}
+	found := true
+	bf.positions(value, func(bit uint64) { found = found && bf.Bits[bit/8]&(1<<(bit%8)) != 0 })
+	return found
+}
+
+// newBloomFilters builds a filter of every bloomFields value of logs
+func newBloomFilters(logs []Log) map[string]*bloomFilter {
+	filters := make(map[string]*bloomFilter, len(bloomFields))
+	for _, field := range bloomFields {
+		distinct := make(map[string]bool)
+		for _, log := range logs {
+			distinct[fieldValue(log, field)] = true
+		}
+		bf := newBloomFilter(len(distinct))
+		for value := range distinct {
+			bf.add(value)
+		}
+		filters[field] = bf
+	}
+
+	return filters
+}
+
+// bloomExcludes reports whether a filter in blooms rules out an equality filter
+// of filters; files without filters exclude nothing
+func bloomExcludes(blooms map[string]*bloomFilter, filters map[string]string) bool {
+	for _, field := range bloomFields {
+		value, ok := filters[field]
+		if !ok {
+			continue
+		}
+		if bf, ok := blooms[field]; ok && !bf.mayContain(value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,288 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Elasticsearch-compatible bulk API (/_bulk) so Beats, Logstash and Fluent Bit ES outputs can ship logs
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// esVersion is the Elasticsearch version reported to clients that check it
+const esVersion = "8.11.0"
+
+var bulkDocuments = metrics.Counter("logingestor_bulk_documents_total", "Documents received through the Elasticsearch bulk API")
+
+// Document fields read for each log field by the bulk API and the forward input,
+// first present wins. Dotted names are looked up both as flat keys and as nested
+// objects ({"log": {"level": ...}}).
+var (
+	docLevelFields     = []string{"level", "log.level", "severity", "loglevel"}
+	docMessageFields   = []string{"message", "msg", "log"}
+	docResourceFields  = []string{"resourceId", "host.name", "service.name", "hostname", "host"}
+	docTimestampFields = []string{"timestamp", "@timestamp", "time"}
+	docTraceFields     = []string{"traceId", "trace.id"}
+	docSpanFields      = []string{"spanId", "span.id"}
+)
+
+// bulkItem is the outcome of one action, keyed by the action name in the response
+type bulkItem struct {
+	Index  string     `json:"_index"`
+	ID     string     `json:"_id,omitempty"`
+	Status int        `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  *bulkError `json:"error,omitempty"`
+}
+
+type bulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// BulkResponse is the Elasticsearch bulk response body
+type BulkResponse struct {
+	Took   int64                 `json:"took"`
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+// bulkAction is the metadata line preceding a document
+type bulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// docField returns the string value of a possibly dotted field of a document
+func docField(doc map[string]interface{}, names []string) string {
+	for _, name := range names {
+		value, ok := doc[name]
+		if !ok {
+			value, ok = docNested(doc, name)
+		}
+		if !ok || value == nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case map[string]interface{}:
+			// e.g. "host" as an object; its name is looked up as host.name
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	return ""
+}
+
+// docMetadata returns the metadata of a document: parentResourceId, possibly as
+// a dotted field, and the other keys of its "metadata" object
+func docMetadata(doc map[string]interface{}) Metadata {
+	md := Metadata{ParentResourceID: docField(doc, []string{"metadata.parentResourceId"})}
+	object, _ := doc["metadata"].(map[string]interface{})
+	for key, value := range object {
+		if key == "parentResourceId" {
+			continue
+		}
+		if md.Fields == nil {
+			md.Fields = make(map[string]any)
+		}
+		md.Fields[key] = value
+	}
+
+	return md
+}
+
+// docNested resolves a dotted name through nested objects
+func docNested(doc map[string]interface{}, name string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// bulkToLog maps a document to a log entry. Documents without a level are info,
+// without a timestamp are timed on arrival and without a resource use the index.
+func bulkToLog(doc map[string]interface{}, index string) (Log, error) {
+	log := Log{
+		Level:      docField(doc, docLevelFields),
+		Message:    docField(doc, docMessageFields),
+		ResourceID: docField(doc, docResourceFields),
+		TraceID:    docField(doc, docTraceFields),
+		SpanID:     docField(doc, docSpanFields),
+		Commit:     docField(doc, []string{"commit"}),
+		Timestamp:  time.Now().UTC(),
+		Metadata:   docMetadata(doc),
+		Custom:     docCustom(doc),
+	}
+	if log.Level == "" {
+		log.Level = "info"
+	}
+	if log.ResourceID == "" {
+		log.ResourceID = index
+	}
+	if ts := docField(doc, docTimestampFields); ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return Log{}, fmt.Errorf("timestamp %q is not RFC3339", ts)
+		}
+		log.Timestamp = t
+	}
+
+	return log, nil
+}
+
+// handleBulk ingests an Elasticsearch bulk request: NDJSON pairs of an index or
+// create action and a document. Other actions are refused per item, as are
+// documents that do not map to a valid log; the rest are stored together.
+func (s *Server) handleBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	start := time.Now()
+	buf, err := s.readPooledBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	defer releaseBody(buf)
+
+	var valid []Log
+	response := BulkResponse{Items: []map[string]bulkItem{}}
+	refuse := func(action string, item bulkItem, errType, reason string) {
+		item.Status = http.StatusBadRequest
+		item.Error = &bulkError{Type: errType, Reason: reason}
+		response.Items = append(response.Items, map[string]bulkItem{action: item})
+		response.Errors = true
+	}
+
+	// Lines are sliced from the body, not copied
+	rest := buf.Bytes()
+	lineNo := 0
+	next := func() ([]byte, bool) {
+		for len(rest) > 0 {
+			var line []byte
+			line, rest, _ = bytes.Cut(rest, []byte{'\n'})
+			lineNo++
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				return line, true
+			}
+		}
+		return nil, false
+	}
+
+	for n := 0; ; n++ {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		var actions map[string]bulkAction
+		if err := json.Unmarshal(line, &actions); err != nil || len(actions) != 1 {
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid bulk request", fmt.Sprintf("line %d: expected an action object", lineNo))
+			return
+		}
+		var name string
+		var action bulkAction
+		for key, value := range actions {
+			name, action = key, value
+		}
+		if action.Index == "" {
+			action.Index = r.PathValue("index")
+		}
+		item := bulkItem{Index: action.Index, ID: action.ID}
+
+		if name == "delete" {
+			refuse(name, item, "illegal_argument_exception", "delete is not supported, logs are immutable")
+			continue
+		}
+		doc, ok := next()
+		if !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid bulk request", "the last action has no document")
+			return
+		}
+		if name != "index" && name != "create" {
+			refuse(name, item, "illegal_argument_exception", name+" is not supported, only index and create")
+			continue
+		}
+
+		bulkDocuments.Inc()
+		var fields map[string]interface{}
+		if err := json.Unmarshal(doc, &fields); err != nil {
+			s.rejectRequest(r, "bulk", rejectionReason(err), doc)
+			refuse(name, item, "document_parsing_exception", err.Error())
+			continue
+		}
+		log, err := bulkToLog(fields, action.Index)
+		if err != nil {
+			s.rejectRequest(r, "bulk", rejectionReason(err), doc)
+			refuse(name, item, "document_parsing_exception", err.Error())
+			continue
+		}
+		if err := s.validator.Validate(&log); err != nil {
+			// The mapped log is kept, so that reprocessing decodes it as a log entry
+			payload, _ := json.Marshal(log)
+			s.rejectRequest(r, "bulk", rejectionReason(err), payload)
+			refuse(name, item, "document_parsing_exception", err.Error())
+			continue
+		}
+
+		if item.ID == "" {
+			item.ID = strconv.FormatInt(start.UnixNano(), 36) + "-" + strconv.Itoa(n)
+		}
+		item.Status = http.StatusCreated
+		item.Result = "created"
+		response.Items = append(response.Items, map[string]bulkItem{name: item})
+		valid = append(valid, log)
+	}
+
+	logs, err := s.admit(r.Context(), "bulk", valid)
+	if err == nil {
+		_, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	if err != nil {
+		writeStoreError(w, err, "Error storing logs")
+		return
+	}
+
+	response.Took = time.Since(start).Milliseconds()
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Write(encoded)
+}
+
+// handleESInfo answers the GET / version check of Elasticsearch clients
+func (s *Server) handleESInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	fmt.Fprintf(w, `{"name":"logingestor","cluster_name":"logingestor","version":{"number":%q,"build_flavor":"default","lucene_version":"9.8.0","minimum_wire_compatibility_version":"7.17.0","minimum_index_compatibility_version":"7.0.0"},"tagline":"You Know, for Search"}`, esVersion)
+}
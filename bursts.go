@@ -0,0 +1,325 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Error bursts of resources correlated in time (/query/bursts), to trace cascading failures
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Defaults of a /query/bursts request
+const (
+	defaultBurstInterval  = time.Minute
+	defaultBurstThreshold = 3.0
+	defaultBurstMaxLag    = 1
+)
+
+// burstMinErrors is the fewest errors a bucket needs to be a burst, whatever the
+// usual count of its resource
+const burstMinErrors = 3
+
+// maxBurstResources bounds the bursting resources compared pairwise, those with
+// the most bursts kept
+const maxBurstResources = 1000
+
+// BurstResource is a resource with its errors over the window and the start of
+// each bucket in which they burst
+type BurstResource struct {
+	ResourceID string      `json:"resourceId"`
+	Errors     int         `json:"errors"`
+	Bursts     []time.Time `json:"bursts"`
+}
+
+// BurstCorrelation is a pair of resources whose bursts coincide: score is the
+// share of the bursts of both lying within max_lag buckets of a burst of the
+// other, overlap the bursts of the pair so matched, and lead the resource that
+// tends to burst first, lag behind it on average; lead is empty when neither does
+type BurstCorrelation struct {
+	Resources [2]string `json:"resources"`
+	Score     float64   `json:"score"`
+	Overlap   int       `json:"overlap"`
+	Lead      string    `json:"lead,omitempty"`
+	Lag       string    `json:"lag,omitempty"`
+}
+
+// BurstResponse is the body of /query/bursts
+type BurstResponse struct {
+	Interval     string             `json:"interval"`
+	Resources    []BurstResource    `json:"resources"`
+	Correlations []BurstCorrelation `json:"correlations"`
+}
+
+// burstSeries is the errors of a resource per bucket and the buckets it burst in
+type burstSeries struct {
+	resource BurstResource
+	bursts   []int
+}
+
+// findBursts returns the buckets where errors stand out from the usual count of
+// the series, its median, by more than threshold times their spread: the
+// median absolute deviation scaled to a standard deviation, never below what a
+// Poisson count of the same median would vary
+func findBursts(series []int, threshold float64) []int {
+	counts := slices.Clone(series)
+	slices.Sort(counts)
+	median := float64(counts[len(counts)/2])
+	for i, n := range counts {
+		counts[i] = int(math.Abs(float64(n) - median))
+	}
+	slices.Sort(counts)
+	spread := max(1.4826*float64(counts[len(counts)/2]), math.Sqrt(median), 1)
+
+	var bursts []int
+	for i, n := range series {
+		if n >= burstMinErrors && float64(n) > median+threshold*spread {
+			bursts = append(bursts, i)
+		}
+	}
+	return bursts
+}
+
+// correlate scores how the bursts of two resources coincide, within maxLag
+// buckets of each other; offset is the mean number of buckets b's bursts follow
+// those of a they match
+func correlate(a, b []int, maxLag int) (score float64, overlap int, offset float64) {
+	matched := func(x, y []int) (n int, sum int) {
+		for _, i := range x {
+			best, found := 0, false
+			for _, j := range y {
+				if d := j - i; d >= -maxLag && d <= maxLag && (!found || abs(d) < abs(best)) {
+					best, found = d, true
+				}
+			}
+			if found {
+				n++
+				sum += best
+			}
+		}
+		return n, sum
+	}
+	na, sumA := matched(a, b)
+	nb, sumB := matched(b, a)
+	if na == 0 {
+		return 0, 0, 0
+	}
+	score = float64(na+nb) / float64(len(a)+len(b))
+	offset = float64(sumA-sumB) / float64(na+nb)
+	return score, min(na, nb), offset
+}
+
+// abs returns the magnitude of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// bursts finds the bursts of each resource in the buckets of a histogram grouped
+// by resourceId, and ranks the pairs of resources bursting together, best
+// score first, up to n
+func bursts(buckets []HistogramBucket, interval time.Duration, threshold float64, maxLag, n int) BurstResponse {
+	perResource := make(map[string][]int)
+	for i, bucket := range buckets {
+		for resource, count := range bucket.Groups {
+			if perResource[resource] == nil {
+				perResource[resource] = make([]int, len(buckets))
+			}
+			perResource[resource][i] = count
+		}
+	}
+
+	var series []burstSeries
+	for resource, counts := range perResource {
+		found := findBursts(counts, threshold)
+		if len(found) == 0 {
+			continue
+		}
+		s := burstSeries{resource: BurstResource{ResourceID: resource}, bursts: found}
+		for _, count := range counts {
+			s.resource.Errors += count
+		}
+		for _, i := range found {
+			s.resource.Bursts = append(s.resource.Bursts, buckets[i].Time)
+		}
+		series = append(series, s)
+	}
+	slices.SortFunc(series, func(a, b burstSeries) int {
+		return cmp.Or(cmp.Compare(len(b.bursts), len(a.bursts)), cmp.Compare(b.resource.Errors, a.resource.Errors),
+			strings.Compare(a.resource.ResourceID, b.resource.ResourceID))
+	})
+	if len(series) > maxBurstResources {
+		series = series[:maxBurstResources]
+	}
+
+	response := BurstResponse{Interval: interval.String(), Resources: []BurstResource{}, Correlations: []BurstCorrelation{}}
+	for i, a := range series {
+		response.Resources = append(response.Resources, a.resource)
+		for _, b := range series[i+1:] {
+			score, overlap, offset := correlate(a.bursts, b.bursts, maxLag)
+			if overlap == 0 {
+				continue
+			}
+			correlation := BurstCorrelation{
+				Resources: [2]string{a.resource.ResourceID, b.resource.ResourceID},
+				Score:     math.Round(score*1000) / 1000,
+				Overlap:   overlap,
+			}
+			lag := time.Duration(math.Abs(offset) * float64(interval)).Round(time.Second)
+			switch {
+			case lag == 0:
+			case offset > 0:
+				correlation.Lead, correlation.Lag = a.resource.ResourceID, lag.String()
+			default:
+				correlation.Lead, correlation.Lag = b.resource.ResourceID, lag.String()
+			}
+			response.Correlations = append(response.Correlations, correlation)
+		}
+	}
+	slices.SortFunc(response.Correlations, func(a, b BurstCorrelation) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Overlap, a.Overlap),
+			strings.Compare(a.Resources[0], b.Resources[0]), strings.Compare(a.Resources[1], b.Resources[1]))
+	})
+	if len(response.Correlations) > n {
+		response.Correlations = response.Correlations[:n]
+	}
+	return response
+}
+
+// handleBursts answers a /query body with a timestamp_from and timestamp_to by
+// the resources whose errors burst over the window, per "interval" (default
+// 1m), and the pairs of them whose bursts coincide within "max_lag" buckets
+// (default 1), best correlated first, up to "n" (default 10). Errors are the
+// logs at anomaly-error-level or above unless the body sets level or min_level.
+func (s *Server) handleBursts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	auditQuery(r.Context(), fields)
+
+	interval := defaultBurstInterval
+	if raw, ok := fields["interval"]; ok {
+		var v string
+		if err := json.Unmarshal(raw, &v); err == nil {
+			interval, err = parseDuration(v)
+		}
+		if err != nil || interval <= 0 {
+			writeValidationError(w, errors.New(`Invalid interval: expected a positive duration such as "30s" or "5m"`))
+			return
+		}
+	}
+	threshold := defaultBurstThreshold
+	if raw, ok := fields["threshold"]; ok {
+		if err := json.Unmarshal(raw, &threshold); err != nil || threshold <= 0 {
+			writeValidationError(w, errors.New("Invalid threshold: must be a positive number of deviations"))
+			return
+		}
+	}
+	maxLag := defaultBurstMaxLag
+	if raw, ok := fields["max_lag"]; ok {
+		if err := json.Unmarshal(raw, &maxLag); err != nil || maxLag < 0 || maxLag > 100 {
+			writeValidationError(w, errors.New("Invalid max_lag: must be between 0 and 100 buckets"))
+			return
+		}
+	}
+	n := defaultTopValues
+	if raw, ok := fields["n"]; ok {
+		if err := json.Unmarshal(raw, &n); err != nil || n <= 0 || n > maxTopValues {
+			writeValidationError(w, fmt.Errorf("Invalid n: must be between 1 and %d", maxTopValues))
+			return
+		}
+	}
+	query := make(map[string]json.RawMessage, len(fields)+1)
+	for key, raw := range fields {
+		if key != "interval" && key != "threshold" && key != "max_lag" && key != "n" {
+			query[key] = raw
+		}
+	}
+	_, level := query["level"]
+	if _, minLevel := query["min_level"]; !level && !minLevel {
+		query["min_level"], _ = json.Marshal(s.cfg.AnomalyErrorLevel)
+	}
+
+	req, err := buildQuery(query, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.CountOnly || req.Fields != nil || req.Highlight != nil || req.Context != nil || req.CollapseBy != "" {
+		writeValidationError(w, errors.New("limit, offset, page_token, sort, count_only, fields, highlight, context and collapse_by do not apply to /query/bursts"))
+		return
+	}
+	from, to := timeBounds(req.Filters)
+	if from.IsZero() || to.IsZero() {
+		writeValidationError(w, errors.New("timestamp_from and timestamp_to are required: bursts are found over a time window"))
+		return
+	}
+	if int64(to.Sub(from)/interval) >= maxHistogramBuckets {
+		writeValidationError(w, fmt.Errorf("Invalid interval: more than %d buckets over the time range", maxHistogramBuckets))
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var counts histogramCounts
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		// Peers count the errors of each resource as for a histogram
+		query["interval"], _ = json.Marshal(interval.String())
+		query["group_by"], _ = json.Marshal("resourceId")
+		histogramBody, _ := json.Marshal(query)
+		counts, err = s.clusterHistogram(ctx, r, histogramBody, req, interval, "resourceId")
+		if err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+	} else {
+		counts, err = s.tenant(r.Context()).storage.Histogram(ctx, req.Filters, req.Options, interval, "resourceId")
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{})
+			return
+		}
+	}
+
+	buckets, err := counts.buckets(interval, true, from, to)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	response := bursts(buckets, interval, threshold, maxLag, n)
+	auditResults(r.Context(), len(response.Correlations))
+	writeJSON(w, http.StatusOK, response)
+}
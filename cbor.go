@@ -0,0 +1,342 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : CBOR (RFC 8949) decoding into Go values, with a size budget against oversized input
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// maxCBORDepth bounds the nesting of arrays, maps and tags
+const maxCBORDepth = 100
+
+var errCBORTooLarge = errors.New("cbor value exceeds the size limit")
+
+// cborBreak ends an indefinite-length item
+type cborBreak struct{}
+
+// cborDecoder reads CBOR values from a stream into the same Go values as a
+// msgpackDecoder: integers to int64 (uint64 above its range), floats to float64,
+// text to string, byte strings to []byte, maps to map[string]interface{} with
+// non-string keys formatted, and arrays to []interface{}. Date/time tags 0 and 1
+// decode to time.Time; other tags to their content. budget caps the bytes a value
+// may allocate.
+type cborDecoder struct {
+	r      msgpackReader
+	budget int64
+	limit  int64
+}
+
+// newCBORDecoder creates a decoder whose values may each hold up to limit bytes;
+// r is read one value at a time
+func newCBORDecoder(r msgpackReader, limit int64) *cborDecoder {
+	return &cborDecoder{r: r, limit: limit}
+}
+
+// Decode reads the next value; io.EOF means the stream ended between values
+func (d *cborDecoder) Decode() (interface{}, error) {
+	d.budget = d.limit
+	value, err := d.value(0)
+	if _, ok := value.(cborBreak); ok && err == nil {
+		return nil, errors.New("unexpected cbor break")
+	}
+	return value, err
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor value nested too deeply")
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		if depth > 0 && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	major, info := b>>5, b&0x1f
+	if major == 7 {
+		return d.simple(info)
+	}
+	if info == 31 {
+		return d.indefinite(major, depth)
+	}
+	n, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor negative integer out of range")
+		}
+		return -1 - int64(n), nil
+	case 2:
+		return d.bytes(n)
+	case 3:
+		data, err := d.bytes(n)
+		return string(data), err
+	case 4:
+		return d.arrayOf(n, depth)
+	case 5:
+		return d.mapOf(n, depth)
+	}
+
+	// major 6: a tag on the value that follows
+	content, err := d.value(depth + 1)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return cborTag(n, content)
+}
+
+// argument reads the count or value of a head, held in info or the bytes after it
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		data, err := d.fixed(1 << (info - 24))
+		if err != nil {
+			return 0, err
+		}
+		return uintBE(data), nil
+	}
+	return 0, fmt.Errorf("invalid cbor additional information %d", info)
+}
+
+// simple decodes major type 7: false, true, null, undefined, floats and the break
+func (d *cborDecoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		data, err := d.fixed(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfFloat(binary.BigEndian.Uint16(data)), nil
+	case 26:
+		data, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 27:
+		data, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case 31:
+		return cborBreak{}, nil
+	}
+	return nil, fmt.Errorf("unsupported cbor simple value %d", info)
+}
+
+// indefinite decodes a string, array or map whose length is ended by a break
+func (d *cborDecoder) indefinite(major byte, depth int) (interface{}, error) {
+	switch major {
+	case 2, 3:
+		var data []byte
+		for {
+			chunk, err := d.value(depth + 1)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			switch c := chunk.(type) {
+			case cborBreak:
+				if major == 3 {
+					return string(data), nil
+				}
+				return data, nil
+			case []byte:
+				if major == 2 {
+					data = append(data, c...)
+					continue
+				}
+			case string:
+				if major == 3 {
+					data = append(data, c...)
+					continue
+				}
+			}
+			return nil, errors.New("invalid chunk in an indefinite-length cbor string")
+		}
+	case 4:
+		var array []interface{}
+		for {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if _, ok := item.(cborBreak); ok {
+				return array, nil
+			}
+			array = append(array, item)
+		}
+	case 5:
+		object := make(map[string]interface{})
+		for {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if _, ok := key.(cborBreak); ok {
+				return object, nil
+			}
+			if err := d.entry(object, key, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid indefinite length for cbor major type %d", major)
+}
+
+// bytes reads n bytes of payload, charging them to the budget
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(d.budget) {
+		return nil, errCBORTooLarge
+	}
+	d.budget -= int64(n)
+	return d.fixed(int(n))
+}
+
+// fixed reads size bytes that are part of a type's encoding
+func (d *cborDecoder) fixed(size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf, nil
+}
+
+func (d *cborDecoder) arrayOf(n uint64, depth int) (interface{}, error) {
+	// Every element takes at least a byte, so n is charged up front
+	if n > uint64(d.budget) {
+		return nil, errCBORTooLarge
+	}
+	d.budget -= int64(n)
+	array := make([]interface{}, n)
+	for i := range array {
+		var err error
+		if array[i], err = d.value(depth + 1); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if _, ok := array[i].(cborBreak); ok {
+			return nil, errors.New("unexpected cbor break")
+		}
+	}
+	return array, nil
+}
+
+func (d *cborDecoder) mapOf(n uint64, depth int) (interface{}, error) {
+	if n > uint64(d.budget)/2 {
+		return nil, errCBORTooLarge
+	}
+	d.budget -= 2 * int64(n)
+	object := make(map[string]interface{}, n)
+	for range n {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if err := d.entry(object, key, depth); err != nil {
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+// entry reads the value of key into object
+func (d *cborDecoder) entry(object map[string]interface{}, key interface{}, depth int) error {
+	value, err := d.value(depth + 1)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	_, keyBreak := key.(cborBreak)
+	_, valueBreak := value.(cborBreak)
+	if keyBreak || valueBreak {
+		return errors.New("unexpected cbor break")
+	}
+	switch k := key.(type) {
+	case string:
+		object[k] = value
+	case []byte:
+		object[string(k)] = value
+	default:
+		object[fmt.Sprint(k)] = value
+	}
+	return nil
+}
+
+// cborTag applies a tag to its content: 0 is an RFC 3339 date/time string and 1
+// seconds since the epoch; other tags, such as the self-describe tag, are dropped
+func cborTag(tag uint64, content interface{}) (interface{}, error) {
+	switch tag {
+	case 0:
+		s, ok := content.(string)
+		if !ok {
+			return nil, errors.New("cbor tag 0 must hold a string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("cbor tag 0: %v", err)
+		}
+		return t, nil
+	case 1:
+		switch n := content.(type) {
+		case int64:
+			return time.Unix(n, 0).UTC(), nil
+		case float64:
+			if math.IsNaN(n) || math.IsInf(n, 0) {
+				break
+			}
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("cbor tag 1 must hold a number of seconds")
+	}
+	if _, ok := content.(cborBreak); ok {
+		return nil, errors.New("unexpected cbor break")
+	}
+	return content, nil
+}
+
+// halfFloat decodes an IEEE 754 half-precision float
+func halfFloat(bits uint16) float64 {
+	sign := 1.0
+	if bits&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(bits>>10&0x1f), float64(bits&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
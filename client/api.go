@@ -0,0 +1,301 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Operations of the Log Ingestor API: ingest, query, saved queries, dead letters and health
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Ingest stores one log (POST /ingest)
+func (c *Client) Ingest(ctx context.Context, log Log) error {
+	return c.do(ctx, http.MethodPost, "/ingest", nil, log, nil)
+}
+
+// IngestBatch stores logs, reporting the outcome of each (POST /ingest/batch)
+func (c *Client) IngestBatch(ctx context.Context, logs []Log) (*BatchResponse, error) {
+	var response BatchResponse
+	if err := c.do(ctx, http.MethodPost, "/ingest/batch", nil, logs, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Query returns a page of the logs matching q (POST /query); q.CountOnly is ignored
+func (c *Client) Query(ctx context.Context, q Query) (*QueryResponse, error) {
+	q.CountOnly = false
+	var response QueryResponse
+	if err := c.do(ctx, http.MethodPost, "/query", nil, q, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Count returns the number of logs matching q (POST /query with count_only)
+func (c *Client) Count(ctx context.Context, q Query) (int, error) {
+	q.CountOnly = true
+	var response CountResponse
+	if err := c.do(ctx, http.MethodPost, "/query", nil, q, &response); err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+// Values returns the most frequent values of an indexed field starting with
+// prefix (GET /query/values); a limit of 0 leaves the server's default
+func (c *Client) Values(ctx context.Context, field, prefix string, limit int) (*ValuesResponse, error) {
+	query := url.Values{"field": {field}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response ValuesResponse
+	if err := c.do(ctx, http.MethodGet, "/query/values", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Histogram counts the logs matching q per interval (e.g. "5m"; empty for 1h),
+// split by the values of groupBy unless it is empty (POST /query/histogram)
+func (c *Client) Histogram(ctx context.Context, q Query, interval, groupBy string) (*HistogramResponse, error) {
+	fields := q.fields()
+	if interval != "" {
+		fields["interval"] = interval
+	}
+	if groupBy != "" {
+		fields["group_by"] = groupBy
+	}
+	var response HistogramResponse
+	if err := c.do(ctx, http.MethodPost, "/query/histogram", nil, fields, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Top returns the n values of field held by the most logs matching q, most
+// frequent first (POST /query/top); an n of 0 leaves the server's default
+func (c *Client) Top(ctx context.Context, q Query, field string, n int) (*TopResponse, error) {
+	fields := q.fields()
+	fields["field"] = field
+	if n > 0 {
+		fields["n"] = n
+	}
+	var response TopResponse
+	if err := c.do(ctx, http.MethodPost, "/query/top", nil, fields, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Bursts returns the resources whose errors burst over the window of q, which
+// needs From and To, and the pairs of them bursting together (POST
+// /query/bursts); an empty interval leaves the server's default
+func (c *Client) Bursts(ctx context.Context, q Query, interval string) (*BurstResponse, error) {
+	fields := q.fields()
+	if interval != "" {
+		fields["interval"] = interval
+	}
+	var response BurstResponse
+	if err := c.do(ctx, http.MethodPost, "/query/bursts", nil, fields, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Patterns returns the message patterns whose template contains search and fit
+// at least minCount logs, most frequent first (GET /patterns); a limit of 0
+// leaves the server's default
+func (c *Client) Patterns(ctx context.Context, search string, minCount, limit int) (*PatternList, error) {
+	query := url.Values{}
+	if search != "" {
+		query.Set("search", search)
+	}
+	if minCount > 0 {
+		query.Set("min_count", strconv.Itoa(minCount))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response PatternList
+	if err := c.do(ctx, http.MethodGet, "/patterns", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Aggregation returns the buckets of a continuous aggregation between from and
+// to, zero for the start of its retention and now (GET /aggregations/{name})
+func (c *Client) Aggregation(ctx context.Context, name string, from, to time.Time) (*AggregationResponse, error) {
+	query := url.Values{}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	var response AggregationResponse
+	if err := c.do(ctx, http.MethodGet, "/aggregations/"+url.PathEscape(name), query, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Trace returns the logs of a trace grouped by span (GET /traces/{traceId}/logs)
+func (c *Client) Trace(ctx context.Context, traceID string) (*TraceResponse, error) {
+	var response TraceResponse
+	if err := c.do(ctx, http.MethodGet, "/traces/"+url.PathEscape(traceID)+"/logs", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SavedQueries lists the saved queries the API key sees (GET /queries)
+func (c *Client) SavedQueries(ctx context.Context) ([]SavedQuery, error) {
+	var response struct {
+		Queries []SavedQuery `json:"queries"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/queries", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Queries, nil
+}
+
+// SavedQuery returns the named saved query (GET /queries?name=)
+func (c *Client) SavedQuery(ctx context.Context, name string) (*SavedQuery, error) {
+	var response SavedQuery
+	if err := c.do(ctx, http.MethodGet, "/queries", url.Values{"name": {name}}, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SaveQuery saves a new query (POST /queries), or replaces the one of the same
+// name when replace is set (PUT /queries)
+func (c *Client) SaveQuery(ctx context.Context, query SavedQuery, replace bool) (*SavedQuery, error) {
+	method := http.MethodPost
+	if replace {
+		method = http.MethodPut
+	}
+	var response SavedQuery
+	if err := c.do(ctx, method, "/queries", nil, query, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DeleteSavedQuery deletes the named saved query (DELETE /queries?name=)
+func (c *Client) DeleteSavedQuery(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/queries", url.Values{"name": {name}}, nil, nil)
+}
+
+// RunSavedQuery runs the named saved query, with overrides such as a limit or
+// page token replacing its own keys (POST /queries/{name}/run)
+func (c *Client) RunSavedQuery(ctx context.Context, name string, overrides Query) (*QueryResponse, error) {
+	overrides.CountOnly = false
+	var response QueryResponse
+	if err := c.do(ctx, http.MethodPost, "/queries/"+url.PathEscape(name)+"/run", nil, overrides, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DeadLetters lists rejected payloads, newest first, of one source unless it
+// is empty (GET /deadletter); a limit of 0 leaves the server's default
+func (c *Client) DeadLetters(ctx context.Context, source string, offset, limit int) (*DeadLetterList, error) {
+	query := url.Values{}
+	if source != "" {
+		query.Set("source", source)
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response DeadLetterList
+	if err := c.do(ctx, http.MethodGet, "/deadletter", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ReprocessDeadLetters validates the dead letters of ids, or all of them when
+// ids is empty, again and stores those that now pass (POST /deadletter/reprocess)
+func (c *Client) ReprocessDeadLetters(ctx context.Context, ids []int64) (*ReprocessResponse, error) {
+	var body interface{}
+	if len(ids) > 0 {
+		body = map[string][]int64{"ids": ids}
+	}
+	var response ReprocessResponse
+	if err := c.do(ctx, http.MethodPost, "/deadletter/reprocess", nil, body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// AuditFilter selects audit entries; zero fields match every entry
+type AuditFilter struct {
+	Key    string // API key name
+	Route  string // e.g. /query
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// Audit lists the audit log entries matching filter, newest first (GET /admin/audit)
+func (c *Client) Audit(ctx context.Context, filter AuditFilter) (*AuditList, error) {
+	query := url.Values{}
+	for key, value := range map[string]string{"key": filter.Key, "route": filter.Route} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if filter.Offset > 0 {
+		query.Set("offset", strconv.Itoa(filter.Offset))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	var response AuditList
+	if err := c.do(ctx, http.MethodGet, "/admin/audit", query, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Ready returns the readiness of the server (GET /readyz); a server that is not
+// ready answers with an *Error of status 503
+func (c *Client) Ready(ctx context.Context) (*HealthResponse, error) {
+	var response HealthResponse
+	if err := c.do(ctx, http.MethodGet, "/readyz", nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// OpenAPI returns the OpenAPI document the server describes its API with (GET /openapi.json)
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var spec json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/openapi.json", nil, nil, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
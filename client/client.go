@@ -0,0 +1,149 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Go client of the Log Ingestor HTTP API, following api/openapi.json
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Package client calls the Log Ingestor HTTP API described by api/openapi.json
+// (also served at /openapi.json), so services can ingest and search logs
+// without writing the requests themselves. Import it as
+// github.com/latharani23/DYTE_SDE_INTERN/client.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client sends requests to one Log Ingestor server; it is safe for concurrent use
+type Client struct {
+	baseURL string
+	apiKey  string
+	tenant  string
+	http    *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey sends key in the X-API-Key header of every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithTenant sends tenant in the X-Tenant-ID header, for keys not bound to one tenant
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+// WithHTTPClient sends the requests with hc instead of a client with a 30s timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// New creates a client of the server at baseURL, e.g. http://localhost:3000
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error response of the API: {"error": {"code", "message", "details"}}
+type Error struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"` // e.g. validation_error or not_found
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	RetryAfter time.Duration   `json:"-"` // from the Retry-After header of 429 and 503 responses
+}
+
+func (e *Error) Error() string {
+	if len(e.Details) > 0 && string(e.Details) != "null" {
+		return fmt.Sprintf("%d %s: %s: %s", e.StatusCode, e.Code, e.Message, e.Details)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// do sends a request with body, when not nil, as JSON and decodes a 2xx response
+// into out, when not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// checkResponse turns a non-2xx response into an *Error
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	if seconds, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil {
+		apiErr.RetryAfter = seconds
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var decoded struct {
+		Error *Error `json:"error"`
+	}
+	decoded.Error = apiErr
+	if json.Unmarshal(body, &decoded) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+
+	return apiErr
+}
@@ -0,0 +1,405 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Request and response types of the Log Ingestor API, the schemas of api/openapi.json
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package client
+
+import (
+	"encoding/json"
+	"maps"
+	"time"
+)
+
+// logFields are the JSON keys of the fixed fields of a Log
+var logFields = []string{"level", "message", "resourceId", "timestamp", "traceId", "spanId", "commit", "metadata"}
+
+// Log is a log entry
+type Log struct {
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	ResourceID string         `json:"resourceId"`
+	Timestamp  time.Time      `json:"timestamp"`
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	Commit     string         `json:"commit"`
+	Metadata   map[string]any `json:"metadata"`            // parentResourceId and any other keys
+	IngestedAt time.Time      `json:"ingestedAt,omitzero"` // set by the server; ignored on ingest
+	Custom     map[string]any `json:"-"`                   // custom fields declared on the server, at the top level
+}
+
+// MarshalJSON writes the custom fields next to the fixed ones
+func (l Log) MarshalJSON() ([]byte, error) {
+	type plain Log
+	data, err := json.Marshal(plain(l))
+	if err != nil || len(l.Custom) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range l.Custom {
+		if fields[name] != nil {
+			continue
+		}
+		if fields[name], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON reads the keys other than the fixed fields into Custom
+func (l *Log) UnmarshalJSON(data []byte) error {
+	type plain Log
+	if err := json.Unmarshal(data, (*plain)(l)); err != nil {
+		return err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, name := range logFields {
+		delete(fields, name)
+	}
+	l.Custom = nil
+	if len(fields) > 0 {
+		l.Custom = fields
+	}
+	return nil
+}
+
+// Query is the body of /query: exact-match filters on log fields plus options.
+// Zero options are left out.
+type Query struct {
+	Filters     map[string]string   // e.g. level, resourceId, metadata.parentResourceId, or message as /regex/
+	AnyOf       map[string][]string // multi-value filters, matching any of the values of a field
+	Not         map[string][]string // negated filters, excluding the logs matching any of the values
+	MessageMode string              // how the message filter matches: contains (default), case_insensitive, word or fuzzy
+	From        time.Time           // timestamp_from
+	To          time.Time           // timestamp_to
+	MinLevel    string              // this level and the more severe ones
+	Q           string              // query language expression
+	Limit       int
+	Offset      int
+	PageToken   string   // NextToken of the previous page
+	Sort        string   // e.g. "timestamp:desc,level"
+	Fields      []string // only these fields of each log
+	Highlight   string   // offsets or html: the matched parts of message, in QueryLog.Highlight
+	Context     int      // logs before and after each match
+	ContextBy   string   // resourceId (default) or traceId
+	CollapseBy  string   // one log per value of this field, its group's size in QueryLog.Count
+	Pattern     string   // id of a mined message pattern, from Client.Patterns
+	CountOnly   bool
+	Lenient     bool // ignore filter keys naming no field rather than fail with 400
+}
+
+// fields returns the keys of the /query body of q
+func (q Query) fields() map[string]any {
+	fields := make(map[string]any, len(q.Filters)+len(q.AnyOf)+8)
+	for key, value := range q.Filters {
+		fields[key] = value
+	}
+	for key, values := range q.AnyOf {
+		fields[key] = values
+	}
+	if len(q.Not) > 0 {
+		fields["not"] = q.Not
+	}
+	set := func(key string, value any, zero bool) {
+		if !zero {
+			fields[key] = value
+		}
+	}
+	set("timestamp_from", q.From.Format(time.RFC3339Nano), q.From.IsZero())
+	set("timestamp_to", q.To.Format(time.RFC3339Nano), q.To.IsZero())
+	set("min_level", q.MinLevel, q.MinLevel == "")
+	set("message_mode", q.MessageMode, q.MessageMode == "")
+	set("q", q.Q, q.Q == "")
+	set("limit", q.Limit, q.Limit == 0)
+	set("offset", q.Offset, q.Offset == 0)
+	set("page_token", q.PageToken, q.PageToken == "")
+	set("sort", q.Sort, q.Sort == "")
+	set("fields", q.Fields, q.Fields == nil)
+	set("highlight", q.Highlight, q.Highlight == "")
+	set("context", q.Context, q.Context == 0)
+	set("context_by", q.ContextBy, q.ContextBy == "")
+	set("collapse_by", q.CollapseBy, q.CollapseBy == "")
+	set("pattern", q.Pattern, q.Pattern == "")
+	set("count_only", true, !q.CountOnly)
+	set("lenient", true, !q.Lenient)
+	return fields
+}
+
+// MarshalJSON writes q as a flat /query body
+func (q Query) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.fields())
+}
+
+// LogContext holds the logs just before and after a match, as asked by Query.Context
+type LogContext struct {
+	Before []Log `json:"before"`
+	After  []Log `json:"after"`
+}
+
+// QueryLog is a log returned by a query, with its highlight, context and
+// collapsed count when asked for
+type QueryLog struct {
+	Log
+	Highlight map[string]json.RawMessage `json:"-"` // by field: [start, end) offsets or an HTML snippet
+	Context   *LogContext                `json:"-"`
+	Count     int                        `json:"-"` // logs of the group with Query.CollapseBy, zero otherwise
+}
+
+// UnmarshalJSON splits the highlight, context and count annotations from the log
+func (l *QueryLog) UnmarshalJSON(data []byte) error {
+	var annotations struct {
+		Highlight map[string]json.RawMessage `json:"highlight"`
+		Context   *LogContext                `json:"context"`
+		Count     int                        `json:"count"`
+	}
+	if err := json.Unmarshal(data, &annotations); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &l.Log); err != nil {
+		return err
+	}
+	l.Highlight, l.Context, l.Count = annotations.Highlight, annotations.Context, annotations.Count
+	if l.Highlight != nil || l.Context != nil || l.Count > 0 {
+		custom := maps.Clone(l.Custom)
+		delete(custom, "highlight")
+		delete(custom, "context")
+		delete(custom, "count")
+		if l.Custom = custom; len(custom) == 0 {
+			l.Custom = nil
+		}
+	}
+	return nil
+}
+
+// QueryResponse is a page of matching logs; NextToken is empty on the last page
+type QueryResponse struct {
+	Logs      []QueryLog `json:"logs"`
+	NextToken string     `json:"next_token,omitempty"`
+}
+
+// CountResponse is the body of a count_only query
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// FieldError is the problem with one field of a rejected log
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BatchResult is the outcome of one log of a batch
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Status string       `json:"status"` // ok or rejected
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// BatchResponse is the body of /ingest/batch
+type BatchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// FieldValue is a value of an indexed field and the number of logs with it
+type FieldValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ValuesResponse is the body of /query/values
+type ValuesResponse struct {
+	Field  string       `json:"field"`
+	Values []FieldValue `json:"values"`
+	More   bool         `json:"more,omitempty"`
+}
+
+// HistogramBucket is the number of matching logs in [Time, Time+interval)
+type HistogramBucket struct {
+	Time   time.Time      `json:"time"`
+	Count  int            `json:"count"`
+	Groups map[string]int `json:"groups,omitempty"`
+}
+
+// HistogramResponse is the body of /query/histogram
+type HistogramResponse struct {
+	Interval string            `json:"interval"`
+	GroupBy  string            `json:"group_by,omitempty"`
+	Buckets  []HistogramBucket `json:"buckets"`
+}
+
+// TopResponse is the body of /query/top; Total counts every matching log
+type TopResponse struct {
+	Field  string       `json:"field"`
+	Total  int          `json:"total"`
+	Values []FieldValue `json:"values"`
+}
+
+// BurstResource is a resource whose errors burst, at the start of each bucket
+// in Bursts
+type BurstResource struct {
+	ResourceID string      `json:"resourceId"`
+	Errors     int         `json:"errors"`
+	Bursts     []time.Time `json:"bursts"`
+}
+
+// BurstCorrelation is a pair of resources bursting together; Lead, when set,
+// bursts first, Lag earlier on average
+type BurstCorrelation struct {
+	Resources [2]string `json:"resources"`
+	Score     float64   `json:"score"`
+	Overlap   int       `json:"overlap"`
+	Lead      string    `json:"lead,omitempty"`
+	Lag       string    `json:"lag,omitempty"`
+}
+
+// BurstResponse is the body of /query/bursts
+type BurstResponse struct {
+	Interval     string             `json:"interval"`
+	Resources    []BurstResource    `json:"resources"`
+	Correlations []BurstCorrelation `json:"correlations"`
+}
+
+// Pattern is a message template mined by the server, <*> standing for the
+// tokens varying between its logs
+type Pattern struct {
+	ID        string    `json:"id"`
+	Template  string    `json:"template"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// PatternList is the body of GET /patterns
+type PatternList struct {
+	Patterns []Pattern `json:"patterns"`
+}
+
+// AggregationValue is the result of an aggregation over the logs of a bucket:
+// how many there are and, but for count, the function of their values
+type AggregationValue struct {
+	Count int      `json:"count"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+// AggregationBucket is the aggregation of the logs in [Time, Time+interval),
+// split by the values of the aggregation's group_by
+type AggregationBucket struct {
+	Time time.Time `json:"time"`
+	AggregationValue
+	Groups map[string]AggregationValue `json:"groups,omitempty"`
+}
+
+// AggregationResponse is the body of GET /aggregations/{name}
+type AggregationResponse struct {
+	Name     string              `json:"name"`
+	Function string              `json:"function"`
+	Field    string              `json:"field,omitempty"`
+	Interval string              `json:"interval"`
+	GroupBy  string              `json:"group_by,omitempty"`
+	Buckets  []AggregationBucket `json:"buckets"`
+}
+
+// TraceSpan is the logs of one span of a trace
+type TraceSpan struct {
+	SpanID string `json:"spanId"`
+	Logs   []Log  `json:"logs"`
+}
+
+// TraceResponse is the body of /traces/{traceId}/logs
+type TraceResponse struct {
+	TraceID   string      `json:"traceId"`
+	Count     int         `json:"count"`
+	Spans     []TraceSpan `json:"spans"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// SavedQuery is a named /query body; Owner, Tenant, Created and Updated are set by the server
+type SavedQuery struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Query       map[string]json.RawMessage `json:"query"`
+	Since       string                     `json:"since,omitempty"` // e.g. "1h": each run starts that long before now
+	Private     bool                       `json:"private,omitempty"`
+	Owner       string                     `json:"owner,omitempty"`
+	Tenant      string                     `json:"tenant,omitempty"`
+	Created     time.Time                  `json:"created,omitzero"`
+	Updated     time.Time                  `json:"updated,omitzero"`
+}
+
+// DeadLetter is a rejected payload
+type DeadLetter struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Tenant     string    `json:"tenant"`
+	Source     string    `json:"source"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	Reason     string    `json:"reason"`
+	Payload    string    `json:"payload"`
+}
+
+// DeadLetterList is the body of /deadletter
+type DeadLetterList struct {
+	Entries []DeadLetter `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// ReprocessResult is the outcome of one dead letter retried
+type ReprocessResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"` // ok or rejected
+	Error  string `json:"error,omitempty"`
+}
+
+// ReprocessResponse is the body of /deadletter/reprocess
+type ReprocessResponse struct {
+	Reprocessed int               `json:"reprocessed"`
+	Rejected    int               `json:"rejected"`
+	Results     []ReprocessResult `json:"results"`
+}
+
+// AuditEntry records one query in the audit log
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Tenant     string          `json:"tenant"`
+	Key        string          `json:"key,omitempty"`
+	RemoteAddr string          `json:"remoteAddr,omitempty"`
+	Route      string          `json:"route"`
+	Path       string          `json:"path"`
+	Query      json.RawMessage `json:"query,omitempty"`
+	Status     int             `json:"status"`
+	Results    int             `json:"results"`
+	DurationMs float64         `json:"durationMs"`
+}
+
+// AuditList is the body of /admin/audit
+type AuditList struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status  string `json:"status"` // ok or fail
+	Message string `json:"message,omitempty"`
+}
+
+// HealthResponse is the body of /healthz and /readyz
+type HealthResponse struct {
+	Status        string                 `json:"status"` // ok, ready or not_ready
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	Checks        map[string]HealthCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,603 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Cluster mode: logs sharded across nodes by consistent hashing, queries fanned out to all
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterForwardedHeader marks requests sent by another node, naming it; they
+// are served from local storage only and never forwarded again
+const clusterForwardedHeader = "X-Cluster-Forwarded"
+
+// clusterKeyHeader carries the cluster-api-key on the requests of other nodes,
+// next to the caller's own credentials on the queries they fan out
+const clusterKeyHeader = "X-Cluster-Key"
+
+// clusterDownHeader lists the nodes a forwarded query treats as down, whose logs
+// are answered for by their next live replica
+const clusterDownHeader = "X-Cluster-Down"
+
+// clusterVirtualNodes is how many points each node has on the hash ring, which
+// evens out the share of keys each node owns
+const clusterVirtualNodes = 128
+
+// clusterShardKeys lists the fields logs can be sharded by
+var clusterShardKeys = []string{"resourceId", "traceId"}
+
+var (
+	clusterForwarded  = metrics.CounterVec("logingestor_cluster_forwarded_logs_total", "Ingested logs forwarded to the node owning them", "node")
+	clusterPeerErrors = metrics.CounterVec("logingestor_cluster_peer_errors_total", "Failed requests to other cluster nodes", "node")
+)
+
+// clusterNode is one member of the static cluster membership
+type clusterNode struct {
+	ID  string
+	URL string // base URL of its HTTP API
+}
+
+// parseClusterNodes parses cluster-nodes entries, each "id=url"
+func parseClusterNodes(entries []string) ([]*clusterNode, error) {
+	var nodes []*clusterNode
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		id, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid cluster node %q, expected id=url", entry)
+		}
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("cluster node %s: %q must be an http(s) URL", id, rawURL)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("cluster node %s is listed twice", id)
+		}
+		seen[id] = true
+		nodes = append(nodes, &clusterNode{ID: id, URL: strings.TrimSuffix(rawURL, "/")})
+	}
+
+	return nodes, nil
+}
+
+// hashRing maps keys to nodes by consistent hashing, so adding or removing a
+// node only moves the keys of its share
+type hashRing struct {
+	points []uint32 // sorted
+	owners []*clusterNode
+}
+
+// ringHash hashes s with FNV-1a followed by the murmur3 finalizer, since FNV
+// alone clusters short keys differing in their last bytes, such as server-1
+// and server-2, on one arc of the ring
+func ringHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+func newHashRing(nodes []*clusterNode) *hashRing {
+	type point struct {
+		hash uint32
+		node *clusterNode
+	}
+	var points []point
+	for _, node := range nodes {
+		for i := range clusterVirtualNodes {
+			points = append(points, point{ringHash(node.ID + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	hr := &hashRing{}
+	for _, p := range points {
+		hr.points = append(hr.points, p.hash)
+		hr.owners = append(hr.owners, p.node)
+	}
+	return hr
+}
+
+// replicas returns the n nodes storing key: the owner of the first point at or
+// after its hash, then the next distinct owners around the ring
+func (hr *hashRing) replicas(key string, n int) []*clusterNode {
+	i := sort.Search(len(hr.points), func(i int) bool { return hr.points[i] >= ringHash(key) })
+	return hr.replicasAt(i, n)
+}
+
+// replicasAt returns the n nodes storing the keys hashed just before point i
+func (hr *hashRing) replicasAt(i, n int) []*clusterNode {
+	var nodes []*clusterNode
+	for j := range hr.points {
+		node := hr.owners[(i+j)%len(hr.points)]
+		if !slices.Contains(nodes, node) {
+			if nodes = append(nodes, node); len(nodes) == n {
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+// Cluster routes ingested logs to the nodes owning them and fans queries out to
+// every node. Membership is static: every node is started with the same
+// cluster-nodes list.
+type Cluster struct {
+	self     *clusterNode
+	nodes    []*clusterNode // self included, as configured
+	ring     *hashRing
+	shardKey string
+	apiKey   string // sent to peers with forwarded logs
+	client   *http.Client
+
+	replicas      int
+	async         bool
+	probeInterval time.Duration
+	overlap       time.Duration                      // cluster-catch-up-overlap
+	pending       map[*clusterNode]chan replicaBatch // asynchronous copies, per peer
+	catchUps      chan time.Time                     // catch-up requests from peers
+
+	mu   sync.Mutex
+	down map[*clusterNode]time.Time // peers failing since
+}
+
+// NewCluster creates the cluster of the cluster-* settings, nil when cluster
+// mode is off
+func NewCluster(cfg Config) *Cluster {
+	if len(cfg.ClusterNodes) == 0 {
+		return nil
+	}
+	nodes, _ := parseClusterNodes(cfg.ClusterNodes) // checked by Config.Validate
+
+	c := &Cluster{nodes: nodes, ring: newHashRing(nodes), shardKey: cfg.ClusterShardKey, apiKey: cfg.ClusterAPIKey,
+		client:   &http.Client{Timeout: cfg.ClusterTimeout},
+		replicas: cfg.ClusterReplicas, async: cfg.ClusterReplication == ReplicationAsync,
+		probeInterval: cfg.ClusterProbeInterval, overlap: cfg.ClusterCatchUpOverlap,
+		pending: make(map[*clusterNode]chan replicaBatch), catchUps: make(chan time.Time, catchUpQueueSize),
+		down: make(map[*clusterNode]time.Time)}
+	for _, node := range nodes {
+		if node.ID == cfg.ClusterNodeID {
+			c.self = node
+		} else {
+			c.pending[node] = make(chan replicaBatch, replicaQueueSize)
+		}
+	}
+	return c
+}
+
+// node returns the node with the given id, nil if there is none
+func (c *Cluster) node(id string) *clusterNode {
+	for _, node := range c.nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// nodeError is a failed request to another node
+type nodeError struct {
+	node *clusterNode
+	err  error
+}
+
+func (e *nodeError) Error() string { return fmt.Sprintf("cluster node %s: %v", e.node.ID, e.err) }
+
+func (e *nodeError) Unwrap() error { return e.err }
+
+// peerError records and wraps a failed request to node
+func peerError(node *clusterNode, err error) error {
+	clusterPeerErrors.With(node.ID).Inc()
+	return &nodeError{node: node, err: err}
+}
+
+// eachNode calls fn for every node concurrently, returning the errors joined
+func (c *Cluster) eachNode(fn func(i int, node *clusterNode) error) error {
+	return c.eachNodeExcept(nil, fn)
+}
+
+// eachNodeExcept calls fn concurrently for every node not named in skip
+func (c *Cluster) eachNodeExcept(skip []string, fn func(i int, node *clusterNode) error) error {
+	errs := make([]error, len(c.nodes))
+	var wg sync.WaitGroup
+	for i, node := range c.nodes {
+		if !slices.Contains(skip, node.ID) {
+			wg.Go(func() { errs[i] = fn(i, node) })
+		}
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// forwardedKey is the context key marking requests forwarded by another node
+type forwardedKey struct{}
+
+// markForwarded flags the context of requests another node forwarded, which
+// carry the cluster-api-key. The cluster headers of any other request are
+// dropped, so clients cannot skip the fan-out or pick the nodes seen as down.
+func (s *Server) markForwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(clusterForwardedHeader) != "" && s.cluster != nil && s.cluster.authenticates(r) {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedKey{}, true))
+		} else {
+			r.Header.Del(clusterForwardedHeader)
+			r.Header.Del(clusterDownHeader)
+		}
+		r.Header.Del(clusterKeyHeader)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticates reports whether r carries the cluster-api-key
+func (c *Cluster) authenticates(r *http.Request) bool {
+	key := r.Header.Get(clusterKeyHeader)
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(c.apiKey)) == 1
+}
+
+// isForwarded reports whether ctx belongs to a request forwarded by another node
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedKey{}).(bool)
+	return forwarded
+}
+
+// route sends the logs stored by other nodes to them and returns the logs this
+// node stores. Each log goes to its cluster-replicas nodes; peers known to be
+// down are skipped, as they catch up when they rejoin. Synchronous replication
+// waits for every other replica. Asynchronous replication waits for none when
+// this node is a replica, otherwise for the first live one, and queues the other
+// copies. It fails if a log could not be stored on any replica; logs stored
+// before are not rolled back.
+func (c *Cluster) route(ctx context.Context, tenant *Tenant, logs []Log) ([]Log, error) {
+	var local []Log
+	awaited := make([][]*clusterNode, len(logs)) // per log, the peers waited for
+	byNode, laterByNode := make(map[*clusterNode][]Log), make(map[*clusterNode][]Log)
+	for i, log := range logs {
+		replicas := c.ring.replicas(fieldValue(log, c.shardKey), c.replicas)
+		live := slices.DeleteFunc(slices.Clone(replicas), c.isDown)
+		if len(live) == 0 {
+			live = replicas // try them anyway rather than lose the log
+		}
+
+		var later []*clusterNode
+		switch {
+		case slices.Contains(replicas, c.self):
+			local = append(local, log)
+			if c.async {
+				later = live
+			} else {
+				awaited[i] = live
+			}
+		case c.async:
+			awaited[i], later = live[:1], live[1:]
+		default:
+			awaited[i] = live
+		}
+		for _, node := range awaited[i] {
+			if node != c.self {
+				byNode[node] = append(byNode[node], log)
+			}
+		}
+		for _, node := range later {
+			if node != c.self {
+				laterByNode[node] = append(laterByNode[node], log)
+			}
+		}
+	}
+	for node, nodeLogs := range laterByNode {
+		c.replicateLater(node, tenant, nodeLogs)
+	}
+
+	errs := make(map[*clusterNode]error)
+	var mu sync.Mutex
+	c.eachNode(func(_ int, node *clusterNode) error {
+		if nodeLogs, ok := byNode[node]; ok {
+			if err := c.sendLogs(ctx, node, tenant, nodeLogs); err != nil {
+				mu.Lock()
+				errs[node] = err
+				mu.Unlock()
+			}
+		}
+		return nil
+	})
+
+	// A replica failing is fine as long as another one stored the log
+	for i := range logs {
+		if len(awaited[i]) == 0 || slices.Contains(awaited[i], c.self) {
+			continue
+		}
+		var failed []error
+		for _, node := range awaited[i] {
+			if err, ok := errs[node]; ok {
+				failed = append(failed, err)
+			}
+		}
+		if len(failed) == len(awaited[i]) {
+			return local, errors.Join(failed...)
+		}
+	}
+	return local, nil
+}
+
+// sendLogs stores logs on node through its /ingest/batch endpoint
+func (c *Cluster) sendLogs(ctx context.Context, node *clusterNode, tenant *Tenant, logs []Log) error {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL+"/ingest/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tenantHeader, tenant.ID)
+	c.authorize(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.markDown(node, err)
+		return peerError(node, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		err := fmt.Errorf("ingest answered %s", resp.Status)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			c.markDown(node, err)
+		}
+		return peerError(node, err)
+	}
+	var result BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return peerError(node, err)
+	}
+	if result.Rejected > 0 {
+		return peerError(node, fmt.Errorf("%d of %d logs rejected", result.Rejected, len(logs)))
+	}
+
+	clusterForwarded.With(node.ID).Add(int64(len(logs)))
+	return nil
+}
+
+// peerQuery sends a query body to path on node, e.g. /query, with the caller's
+// credentials, so the node applies the same access scope, and the nodes
+// considered down, so it also answers for the logs they would have
+func (c *Cluster) peerQuery(ctx context.Context, node *clusterNode, path string, r *http.Request, body []byte, accept string, down []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	req.Header.Set(clusterForwardedHeader, c.self.ID)
+	req.Header.Set(clusterKeyHeader, c.apiKey)
+	if len(down) > 0 {
+		req.Header.Set(clusterDownHeader, strings.Join(down, ","))
+	}
+	for _, header := range []string{"Authorization", "X-API-Key", tenantHeader} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		c.markDown(node, err)
+		return nil, peerError(node, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("query answered %s", resp.Status)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			c.markDown(node, err)
+		}
+		return nil, peerError(node, err)
+	}
+	return resp, nil
+}
+
+// queryNode reads up to need matching logs from node, reporting whether it holds
+// more; a zero need reads them all
+func (c *Cluster) queryNode(ctx context.Context, node *clusterNode, r *http.Request, body []byte, need int, down []string) ([]Log, bool, error) {
+	resp, err := c.peerQuery(ctx, node, "/query", r, body, ndjsonContentType, down)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	var logs []Log
+	decoder := json.NewDecoder(resp.Body)
+	for need == 0 || len(logs) <= need {
+		var log Log
+		if err := decoder.Decode(&log); err == io.EOF {
+			break
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return logs, false, ctx.Err()
+			}
+			return logs, false, peerError(node, err)
+		}
+		logs = append(logs, log)
+	}
+	if need > 0 && len(logs) > need {
+		return logs[:need], true, nil
+	}
+	return logs, false, nil
+}
+
+// countNode returns the number of logs on node matching a count_only body
+func (c *Cluster) countNode(ctx context.Context, node *clusterNode, r *http.Request, body []byte, down []string) (int, error) {
+	resp, err := c.peerQuery(ctx, node, "/query", r, body, "application/json", down)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var count CountResponse
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return 0, peerError(node, err)
+	}
+	return count.Count, nil
+}
+
+// clusterQuery answers a /query by running it on every node and merging the
+// results. Each node returns its first offset+limit matches from offset 0; the
+// merged logs are ordered by the sort option, by timestamp without one, and the
+// requested page is cut from them.
+func (s *Server) clusterQuery(w http.ResponseWriter, r *http.Request, fields map[string]json.RawMessage, req QueryRequest) {
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	storage := s.tenant(r.Context()).storage
+
+	body, err := peerQueryBody(fields)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	if req.CountOnly {
+		var counts []int
+		err := s.cluster.fanOut(func(down []string) error {
+			counts = make([]int, len(s.cluster.nodes))
+			return s.cluster.eachNodeExcept(down, func(i int, node *clusterNode) (err error) {
+				if node == s.cluster.self {
+					opts := req.Options
+					opts.Serves = s.cluster.serves(down)
+					counts[i], err = storage.Count(ctx, req.Filters, opts)
+				} else {
+					counts[i], err = s.cluster.countNode(ctx, node, r, body, down)
+				}
+				return err
+			})
+		})
+		count := 0
+		for _, n := range counts {
+			count += n
+		}
+		if err != nil {
+			writeClusterError(w, err, PartialResponse{Count: &count})
+			return
+		}
+		auditResults(r.Context(), count)
+		writeCount(w, count)
+		return
+	}
+
+	if acceptsNDJSON(r) && !req.Paginated {
+		req.Options.Limit = 0
+	}
+	logs, more, err := s.clusterLogs(ctx, r, body, &req)
+	if err == nil {
+		err = s.addContext(ctx, r, &req, logs)
+	}
+	if err != nil {
+		writeClusterError(w, err, PartialResponse{Logs: req.project(logs)})
+		return
+	}
+	auditResults(r.Context(), len(logs))
+	writePage(w, r, req, logs, more)
+}
+
+// peerQueryBody is the /query body sent to the other nodes: they return whole
+// logs from the start of their matches, the page, the projection and collapsing
+// being applied once merged
+func peerQueryBody(fields map[string]json.RawMessage) ([]byte, error) {
+	peerFields := maps.Clone(fields)
+	for _, key := range []string{"limit", "offset", "page_token", "fields", "highlight", "context", "context_by", "collapse_by"} {
+		delete(peerFields, key)
+	}
+	return json.Marshal(peerFields)
+}
+
+// clusterLogs gathers the matches of every node for req, merged in sort order and
+// cut to its page; on error the logs gathered so far are returned with it.
+// Collapsed queries gather every match, the groups spanning nodes.
+func (s *Server) clusterLogs(ctx context.Context, r *http.Request, body []byte, req *QueryRequest) ([]Log, bool, error) {
+	storage := s.tenant(r.Context()).storage
+	need := 0
+	if req.Options.Limit > 0 && req.CollapseBy == "" {
+		need = req.Options.Offset + req.Options.Limit
+	}
+
+	var nodeLogs [][]Log
+	var nodeMore []bool
+	err := s.cluster.fanOut(func(down []string) error {
+		nodeLogs = make([][]Log, len(s.cluster.nodes))
+		nodeMore = make([]bool, len(s.cluster.nodes))
+		return s.cluster.eachNodeExcept(down, func(i int, node *clusterNode) (err error) {
+			if node == s.cluster.self {
+				opts := req.Options
+				opts.Offset, opts.Limit, opts.Serves = 0, need, s.cluster.serves(down)
+				nodeLogs[i], nodeMore[i], err = storage.Query(ctx, req.Filters, opts)
+			} else {
+				nodeLogs[i], nodeMore[i], err = s.cluster.queryNode(ctx, node, r, body, need, down)
+			}
+			return err
+		})
+	})
+
+	var logs []Log
+	more := false
+	for i := range nodeLogs {
+		logs = append(logs, nodeLogs[i]...)
+		more = more || nodeMore[i]
+	}
+	keys := req.Options.Sort
+	if len(keys) == 0 {
+		keys = []SortKey{{Field: "timestamp"}}
+	}
+	sortLogs(logs, keys)
+	if req.CollapseBy != "" {
+		logs, more = req.collapse(logs)
+		return logs, more, err
+	}
+	if need > 0 && len(logs) > need {
+		logs, more = logs[:need], true
+	}
+	return logs[min(req.Options.Offset, len(logs)):], more, err
+}
+
+// writeClusterError reports a failed fan-out: 503 when a node could not answer,
+// otherwise as writeQueryError
+func writeClusterError(w http.ResponseWriter, err error, partial PartialResponse) {
+	var nerr *nodeError
+	if errors.As(err, &nerr) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, fmt.Sprintf("Cluster node %s is unavailable", nerr.node.ID), nil)
+		return
+	}
+	writeQueryError(w, err, partial)
+}
+
+// resultsOf wraps logs as a page of Results
+func resultsOf(logs []Log) Results {
+	positions := make([]int, len(logs))
+	for i := range positions {
+		positions[i] = i
+	}
+	return Results{chunks: []resultChunk{{logs: logs, positions: positions}}, n: len(logs)}
+}
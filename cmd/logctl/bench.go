@@ -0,0 +1,380 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : The bench subcommand of logctl: synthetic load on ingest and query with latency percentiles
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchLevels are the levels of generated logs, with their share in percent
+var benchLevels = []struct {
+	level string
+	share int
+}{{"debug", 10}, {"info", 70}, {"warn", 12}, {"error", 7}, {"fatal", 1}}
+
+// benchMessages are the templates of generated messages, each completed with a
+// number below --messages
+var benchMessages = []string{
+	"Failed to connect to DB %d",
+	"GET /api/v1/items/%d 200",
+	"POST /api/v1/orders/%d 201",
+	"Cache miss for key user:%d",
+	"Request timed out after %dms",
+	"User %d logged in",
+	"Retrying job %d",
+	"Disk usage at %d%%",
+}
+
+// benchQueries are the kinds of queries a query worker cycles through
+var benchQueries = []string{"level", "resource", "message", "count"}
+
+// benchConfig is what bench generates and how hard it drives the server
+type benchConfig struct {
+	duration       time.Duration
+	rate           int // logs per second, 0 unlimited
+	batchSize      int
+	ingestWorkers  int
+	queryRate      int // queries per second, 0 unlimited
+	queryWorkers   int
+	resources      int
+	traces         int
+	messages       int
+	seed           uint64
+	queryLimit     int
+	queryTimeRange time.Duration
+}
+
+// benchStats is what a worker measured for one operation
+type benchStats struct {
+	requests, errors, logs int
+	latencies              []time.Duration
+	firstError             error
+}
+
+func (s *benchStats) record(latency time.Duration, logs int, err error) {
+	s.requests++
+	if err != nil {
+		s.errors++
+		if s.firstError == nil {
+			s.firstError = err
+		}
+		return
+	}
+	s.logs += logs
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *benchStats) merge(other *benchStats) {
+	s.requests += other.requests
+	s.errors += other.errors
+	s.logs += other.logs
+	s.latencies = append(s.latencies, other.latencies...)
+	if s.firstError == nil {
+		s.firstError = other.firstError
+	}
+}
+
+// benchResult is a line of the report, per operation
+type benchResult struct {
+	Operation    string  `json:"operation"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	RequestsPerS float64 `json:"requests_per_second"`
+	Logs         int     `json:"logs"` // ingested, or returned by queries
+	LogsPerS     float64 `json:"logs_per_second"`
+	P50          float64 `json:"p50_ms"`
+	P90          float64 `json:"p90_ms"`
+	P99          float64 `json:"p99_ms"`
+	Max          float64 `json:"max_ms"`
+	FirstError   string  `json:"first_error,omitempty"`
+}
+
+// benchReport is the output of bench
+type benchReport struct {
+	Duration   string        `json:"duration"`
+	TargetRate int           `json:"target_rate,omitempty"`
+	Results    []benchResult `json:"results"`
+}
+
+func runBench(c *Client, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: logctl bench [flags]\n\nIngests synthetic logs at --rate while querying them concurrently, then reports the\nthroughput and latency percentiles of each operation. Run it against a disposable server:\nthe logs it sends are stored like any other.")
+		fs.PrintDefaults()
+	}
+	var cfg benchConfig
+	fs.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to run")
+	fs.IntVar(&cfg.rate, "rate", 5000, "logs ingested per second; 0 ingests as fast as the server accepts")
+	fs.IntVar(&cfg.batchSize, "batch-size", 100, "logs per /ingest/batch request")
+	fs.IntVar(&cfg.ingestWorkers, "ingest-workers", 4, "concurrent ingest requests; 0 disables ingest")
+	fs.IntVar(&cfg.queryRate, "query-rate", 0, "queries per second; 0 queries as fast as the server answers")
+	fs.IntVar(&cfg.queryWorkers, "query-workers", 2, "concurrent queries; 0 disables queries")
+	fs.IntVar(&cfg.resources, "resources", 100, "distinct resourceIds of the generated logs")
+	fs.IntVar(&cfg.traces, "traces", 10000, "distinct traceIds of the generated logs")
+	fs.IntVar(&cfg.messages, "messages", 1000, "distinct numbers completing each message template")
+	fs.Uint64Var(&cfg.seed, "seed", 1, "seed of the generated logs and queries, for repeatable runs")
+	fs.IntVar(&cfg.queryLimit, "query-limit", 100, "limit of the queries returning logs")
+	fs.DurationVar(&cfg.queryTimeRange, "query-range", time.Minute, "time range of the count queries, ending now")
+	output := fs.String("output", "table", "report format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	switch {
+	case *output != "table" && *output != "json":
+		return fmt.Errorf("unknown output format %q, expected table or json", *output)
+	case cfg.duration <= 0:
+		return errors.New("--duration must be positive")
+	case cfg.rate < 0 || cfg.queryRate < 0:
+		return errors.New("--rate and --query-rate must not be negative")
+	case cfg.batchSize <= 0:
+		return errors.New("--batch-size must be positive")
+	case cfg.ingestWorkers < 0 || cfg.queryWorkers < 0 || cfg.ingestWorkers+cfg.queryWorkers == 0:
+		return errors.New("--ingest-workers and --query-workers must not be negative, nor both 0")
+	case cfg.resources <= 0 || cfg.traces <= 0 || cfg.messages <= 0:
+		return errors.New("--resources, --traces and --messages must be positive")
+	}
+
+	// One kept-alive connection per worker
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.ingestWorkers + cfg.queryWorkers
+	c.http.Transport = transport
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "benchmarking %s for %s...\n", c.server, cfg.duration)
+	start := time.Now()
+	ingest := make([]benchStats, cfg.ingestWorkers)
+	queries := make([][]benchStats, cfg.queryWorkers)
+	ingestPace := pace(ctx, float64(cfg.rate)/float64(cfg.batchSize))
+	queryPace := pace(ctx, float64(cfg.queryRate))
+	var wg sync.WaitGroup
+	for i := range ingest {
+		rng := rand.New(rand.NewPCG(cfg.seed, uint64(i)))
+		wg.Go(func() { benchIngest(ctx, c, &cfg, rng, ingestPace, &ingest[i]) })
+	}
+	for i := range queries {
+		queries[i] = make([]benchStats, len(benchQueries))
+		rng := rand.New(rand.NewPCG(cfg.seed, uint64(cfg.ingestWorkers+i)))
+		wg.Go(func() { benchQuery(ctx, c, &cfg, rng, queryPace, queries[i]) })
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := benchReport{Duration: elapsed.Round(time.Millisecond).String()}
+	if cfg.ingestWorkers > 0 {
+		report.TargetRate = cfg.rate
+		var total benchStats
+		for i := range ingest {
+			total.merge(&ingest[i])
+		}
+		report.Results = append(report.Results, total.result("ingest", elapsed))
+	}
+	if cfg.queryWorkers > 0 {
+		for kind, name := range benchQueries {
+			var total benchStats
+			for _, worker := range queries {
+				total.merge(&worker[kind])
+			}
+			report.Results = append(report.Results, total.result("query "+name, elapsed))
+		}
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	report.print()
+	return nil
+}
+
+// pace returns a channel yielding perSecond values a second until ctx is done,
+// or nil, which never blocks a select with a ctx.Done case, when perSecond is 0.
+// Ticks no worker is free to take are dropped, so a server that falls behind
+// shows as a rate below the target.
+func pace(ctx context.Context, perSecond float64) <-chan struct{} {
+	if perSecond <= 0 {
+		return nil
+	}
+	ticks := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / perSecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case ticks <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ticks
+}
+
+// wait blocks until the pace allows another request; false once ctx is done
+func wait(ctx context.Context, ticks <-chan struct{}) bool {
+	if ticks == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ticks:
+		return true
+	}
+}
+
+// benchIngest sends batches of generated logs until ctx is done
+func benchIngest(ctx context.Context, c *Client, cfg *benchConfig, rng *rand.Rand, ticks <-chan struct{}, stats *benchStats) {
+	batch := make([]map[string]interface{}, cfg.batchSize)
+	for wait(ctx, ticks) {
+		now := time.Now().UTC()
+		for i := range batch {
+			batch[i] = benchLog(cfg, rng, now)
+		}
+		var response batchResponse
+		started := time.Now()
+		err := c.PostJSON("/ingest/batch", batch, &response)
+		if ctx.Err() != nil && err != nil {
+			return // cut short by the end of the run
+		}
+		if err == nil && response.Rejected > 0 {
+			err = fmt.Errorf("%d logs rejected", response.Rejected)
+		}
+		stats.record(time.Since(started), response.Accepted, err)
+	}
+}
+
+// benchLog generates a log; the distributions are fixed so that runs compare
+func benchLog(cfg *benchConfig, rng *rand.Rand, now time.Time) map[string]interface{} {
+	level, n := benchLevels[0].level, rng.IntN(100)
+	for _, l := range benchLevels {
+		if n < l.share {
+			level = l.level
+			break
+		}
+		n -= l.share
+	}
+	trace := rng.IntN(cfg.traces)
+	return map[string]interface{}{
+		"level":      level,
+		"message":    fmt.Sprintf(benchMessages[rng.IntN(len(benchMessages))], rng.IntN(cfg.messages)),
+		"resourceId": fmt.Sprintf("server-%d", rng.IntN(cfg.resources)),
+		"timestamp":  now.Format(time.RFC3339Nano),
+		"traceId":    fmt.Sprintf("trace-%d", trace),
+		"spanId":     fmt.Sprintf("span-%d-%d", trace, rng.IntN(10)),
+		"commit":     fmt.Sprintf("%07x", rng.IntN(16)),
+		"metadata":   map[string]string{"parentResourceId": fmt.Sprintf("server-%d", rng.IntN(cfg.resources))},
+	}
+}
+
+// benchQuery sends generated queries, cycling through benchQueries, until ctx is done
+func benchQuery(ctx context.Context, c *Client, cfg *benchConfig, rng *rand.Rand, ticks <-chan struct{}, stats []benchStats) {
+	for i := 0; wait(ctx, ticks); i++ {
+		kind := i % len(benchQueries)
+		body := map[string]interface{}{"limit": cfg.queryLimit}
+		switch benchQueries[kind] {
+		case "level":
+			body["level"] = []string{"error", "fatal"}[rng.IntN(2)]
+		case "resource":
+			body["resourceId"] = fmt.Sprintf("server-%d", rng.IntN(cfg.resources))
+		case "message":
+			body["message"] = []string{"connect", "timed out", "logged in", "Retrying"}[rng.IntN(4)]
+		case "count":
+			now := time.Now().UTC()
+			body = map[string]interface{}{
+				"timestamp_from": now.Add(-cfg.queryTimeRange).Format(time.RFC3339Nano),
+				"timestamp_to":   now.Format(time.RFC3339Nano),
+				"count_only":     true,
+			}
+		}
+
+		var response struct {
+			Logs []json.RawMessage `json:"logs"`
+		}
+		started := time.Now()
+		err := c.PostJSON("/query", body, &response)
+		if ctx.Err() != nil && err != nil {
+			return
+		}
+		stats[kind].record(time.Since(started), len(response.Logs), err)
+	}
+}
+
+// result summarizes the stats of an operation over elapsed
+func (s *benchStats) result(operation string, elapsed time.Duration) benchResult {
+	result := benchResult{
+		Operation:    operation,
+		Requests:     s.requests,
+		Errors:       s.errors,
+		RequestsPerS: round(float64(s.requests) / elapsed.Seconds()),
+		Logs:         s.logs,
+		LogsPerS:     round(float64(s.logs) / elapsed.Seconds()),
+	}
+	if s.firstError != nil {
+		result.FirstError = s.firstError.Error()
+	}
+	slices.Sort(s.latencies)
+	percentile := func(p float64) float64 {
+		if len(s.latencies) == 0 {
+			return 0
+		}
+		i := max(int(math.Ceil(p*float64(len(s.latencies))))-1, 0)
+		return round(float64(s.latencies[i]) / float64(time.Millisecond))
+	}
+	result.P50, result.P90, result.P99, result.Max = percentile(0.5), percentile(0.9), percentile(0.99), percentile(1)
+	return result
+}
+
+// round keeps two decimals
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// print writes the report as an aligned table
+func (r benchReport) print() {
+	fmt.Printf("duration %s", r.Duration)
+	if r.TargetRate > 0 {
+		fmt.Printf(", target %d logs/s", r.TargetRate)
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tREQUESTS\tERRORS\tREQ/S\tLOGS/S\tP50 MS\tP90 MS\tP99 MS\tMAX MS")
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\n", result.Operation, result.Requests, result.Errors,
+			result.RequestsPerS, result.LogsPerS, result.P50, result.P90, result.P99, result.Max)
+	}
+	w.Flush()
+	for _, result := range r.Results {
+		if result.FirstError != "" {
+			fmt.Fprintf(os.Stderr, "%s: first error: %s\n", result.Operation, result.FirstError)
+		}
+	}
+}
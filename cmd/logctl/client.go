@@ -0,0 +1,149 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : HTTP client of logctl, sending requests to the Log Ingestor API with the configured credentials
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client sends requests to one Log Ingestor server
+type Client struct {
+	server string
+	apiKey string
+	tenant string
+	http   *http.Client
+	stream *http.Client // without a timeout, for /tail and /import
+}
+
+// apiError is the error body of the API: {"error": {"code", "message", "details"}}
+type apiError struct {
+	Error struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	} `json:"error"`
+}
+
+// NewClient creates a client for the server, tenant and API key of cfg
+func NewClient(cfg Config) *Client {
+	return &Client{
+		server: strings.TrimRight(cfg.Server, "/"),
+		apiKey: cfg.APIKey,
+		tenant: cfg.Tenant,
+		http:   &http.Client{Timeout: 30 * time.Second},
+		stream: &http.Client{},
+	}
+}
+
+// PostJSON posts body as JSON to path and decodes the response into out
+func (c *Client) PostJSON(path string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(http.MethodPost, path, nil, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Post streams body to path, with the query, and returns the open response body;
+// there is no timeout, for long requests such as /import
+func (c *Client) Post(path string, query url.Values, contentType string, body io.Reader) (io.ReadCloser, error) {
+	req, err := c.newRequest(http.MethodPost, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Stream sends a GET request to path and returns the open response body
+func (c *Client) Stream(path string, query url.Values) (io.ReadCloser, error) {
+	req, err := c.newRequest(http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (c *Client) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := c.server + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	return req, nil
+}
+
+// checkResponse turns a non-2xx response into an error carrying the API error message
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var decoded apiError
+	if json.Unmarshal(body, &decoded) != nil || decoded.Error.Message == "" {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if len(decoded.Error.Details) > 0 && string(decoded.Error.Details) != "null" {
+		return fmt.Errorf("%s: %s: %s", resp.Status, decoded.Error.Message, decoded.Error.Details)
+	}
+
+	return fmt.Errorf("%s: %s", resp.Status, decoded.Error.Message)
+}
@@ -0,0 +1,493 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : The ingest, import, query and tail subcommands of logctl
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchResponse is the /ingest/batch response body
+type batchResponse struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	Results  []struct {
+		Index  int    `json:"index"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Fields []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"fields"`
+	} `json:"results"`
+}
+
+// importEvent is a line of the /import response: a rejected line when Line is
+// set, otherwise the progress of the import, the last one with Done set
+type importEvent struct {
+	Line   int    `json:"line"`
+	Error  string `json:"error"`
+	Fields []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"fields"`
+	Lines    int  `json:"lines"`
+	Imported int  `json:"imported"`
+	Rejected int  `json:"rejected"`
+	Done     bool `json:"done"`
+}
+
+// queryResponse is the paginated /query response body
+type queryResponse struct {
+	Logs      []map[string]interface{} `json:"logs"`
+	NextToken string                   `json:"next_token"`
+}
+
+// filterFlags are the filters shared by query and tail
+type filterFlags struct {
+	level, minLevel, message, resource, traceID, spanID, commit, parent, q string
+}
+
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.level, "level", "", "only logs of this level")
+	fs.StringVar(&f.minLevel, "min-level", "", "only logs of this level or more severe")
+	fs.StringVar(&f.message, "message", "", "only logs whose message matches")
+	fs.StringVar(&f.resource, "resource", "", "only logs of this resourceId")
+	fs.StringVar(&f.traceID, "trace", "", "only logs of this traceId")
+	fs.StringVar(&f.spanID, "span", "", "only logs of this spanId")
+	fs.StringVar(&f.commit, "commit", "", "only logs of this commit")
+	fs.StringVar(&f.parent, "parent", "", "only logs of this metadata.parentResourceId")
+	fs.StringVar(&f.q, "q", "", "LQL expression, e.g. 'level=error AND NOT resourceId=server-1'")
+}
+
+// fields returns the set filters keyed by their API names
+func (f *filterFlags) fields() map[string]string {
+	fields := make(map[string]string)
+	for key, value := range map[string]string{
+		"level": f.level, "min_level": f.minLevel, "message": f.message, "resourceId": f.resource,
+		"traceId": f.traceID, "spanId": f.spanID, "commit": f.commit, "metadata.parentResourceId": f.parent, "q": f.q,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+func runIngest(c *Client, args []string) error {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: logctl ingest [flags] [file ...]\n\nSends the logs of the files, or of stdin when none is given, as JSON objects,\nJSON arrays or NDJSON. With --message a single log is built from the flags.")
+		fs.PrintDefaults()
+	}
+	level := fs.String("level", "info", "level of the log built with --message")
+	message := fs.String("message", "", "send a single log with this message")
+	resource := fs.String("resource", "", "resourceId of the log built with --message")
+	traceID := fs.String("trace", "", "traceId of the log built with --message")
+	spanID := fs.String("span", "", "spanId of the log built with --message")
+	commit := fs.String("commit", "", "commit of the log built with --message")
+	parent := fs.String("parent", "", "metadata.parentResourceId of the log built with --message")
+	batchSize := fs.Int("batch-size", 1000, "logs per /ingest/batch request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return errors.New("--batch-size must be positive")
+	}
+
+	var logs []json.RawMessage
+	if *message != "" {
+		if fs.NArg() > 0 {
+			return errors.New("--message cannot be combined with files")
+		}
+		log, err := json.Marshal(map[string]interface{}{
+			"level": *level, "message": *message, "resourceId": *resource, "timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+			"traceId": *traceID, "spanId": *spanID, "commit": *commit, "metadata": map[string]string{"parentResourceId": *parent},
+		})
+		if err != nil {
+			return err
+		}
+		logs = append(logs, log)
+	} else if fs.NArg() == 0 {
+		read, err := readLogs(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("stdin: %v", err)
+		}
+		logs = read
+	} else {
+		for _, path := range fs.Args() {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			read, err := readLogs(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			logs = append(logs, read...)
+		}
+	}
+
+	accepted, rejected := 0, 0
+	for start := 0; start < len(logs); start += *batchSize {
+		batch := logs[start:min(start+*batchSize, len(logs))]
+		var response batchResponse
+		if err := c.PostJSON("/ingest/batch", batch, &response); err != nil {
+			return fmt.Errorf("logs %d-%d: %v", start, start+len(batch)-1, err)
+		}
+		accepted += response.Accepted
+		rejected += response.Rejected
+		for _, result := range response.Results {
+			if result.Status != "rejected" {
+				continue
+			}
+			reason := result.Error
+			for _, field := range result.Fields {
+				reason += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+			}
+			fmt.Fprintf(os.Stderr, "log %d rejected: %s\n", start+result.Index, reason)
+		}
+	}
+
+	fmt.Printf("%d accepted, %d rejected\n", accepted, rejected)
+	if rejected > 0 {
+		return errors.New("some logs were rejected")
+	}
+	return nil
+}
+
+// readLogs reads a JSON array of logs or a stream of log objects, e.g. NDJSON
+func readLogs(r io.Reader) ([]json.RawMessage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var logs []json.RawMessage
+		err := json.Unmarshal(data, &logs)
+		return logs, err
+	}
+
+	var logs []json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var log json.RawMessage
+		if err := decoder.Decode(&log); err == io.EOF {
+			return logs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("log %d: %v", len(logs), err)
+		}
+		logs = append(logs, log)
+	}
+}
+
+func runImport(c *Client, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: logctl import [flags] path ...\n\nBackfills logs with their own timestamps from NDJSON or CSV files, and from every\n.ndjson, .jsonl and .csv file, optionally gzip-compressed (.gz), in directories.")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "", "ndjson or csv; by default taken from each file's extension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no files to import")
+	}
+	if *format != "" && *format != "ndjson" && *format != "csv" {
+		return fmt.Errorf("--format %q must be ndjson or csv", *format)
+	}
+
+	var paths []string
+	for _, path := range fs.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() && importFormat(file) != "" {
+				paths = append(paths, file)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	imported, rejected := 0, 0
+	for _, path := range paths {
+		fileFormat := pick(*format, importFormat(path), "ndjson")
+		contentType := "application/x-ndjson"
+		if fileFormat == "csv" {
+			contentType = "text/csv"
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		// Progress streams back while the file is still being sent
+		stream, err := c.Post("/import", url.Values{"format": {fileFormat}}, contentType, file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		var last importEvent
+		decoder := json.NewDecoder(stream)
+		for {
+			var event importEvent
+			if err := decoder.Decode(&event); err != nil {
+				stream.Close()
+				file.Close()
+				if err == io.EOF {
+					err = errors.New("response ended before the import was done")
+				}
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			if event.Line > 0 {
+				reason := event.Error
+				for _, field := range event.Fields {
+					reason += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+				}
+				fmt.Fprintf(os.Stderr, "%s:%d: %s\n", path, event.Line, reason)
+				continue
+			}
+			if last = event; last.Done {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "%s: %d lines read, %d imported, %d rejected\n", path, event.Lines, event.Imported, event.Rejected)
+		}
+		stream.Close()
+		file.Close()
+
+		imported += last.Imported
+		rejected += last.Rejected
+		if last.Error != "" {
+			return fmt.Errorf("%s: import stopped after %d imported logs: %s", path, last.Imported, last.Error)
+		}
+	}
+
+	fmt.Printf("%d imported, %d rejected from %d files\n", imported, rejected, len(paths))
+	if rejected > 0 {
+		return errors.New("some logs were rejected")
+	}
+	return nil
+}
+
+// importFormat returns the import format of a file from its extension, empty
+// when it is not one logctl import picks up in a directory
+func importFormat(path string) string {
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	}
+	return ""
+}
+
+func runQuery(c *Client, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: logctl query [flags]\n\nSearches logs, newest first unless --sort says otherwise.")
+		fs.PrintDefaults()
+	}
+	var filters filterFlags
+	filters.register(fs)
+	since := fs.String("since", "", "only logs after this time: a duration ago (30m, 1h, 7d) or RFC3339")
+	until := fs.String("until", "", "only logs before this time: a duration ago or RFC3339")
+	limit := fs.Int("limit", 100, "logs per page")
+	pageToken := fs.String("page-token", "", "page to fetch, from a previous query")
+	all := fs.Bool("all", false, "fetch every page")
+	sort := fs.String("sort", "timestamp:desc", "sort keys, e.g. timestamp:desc or level,timestamp:asc")
+	fieldList := fs.String("fields", "", "comma-separated fields to return and show, e.g. timestamp,level,message")
+	count := fs.Bool("count", false, "only print the number of matching logs")
+	output := fs.String("output", "table", "output format: table, json or ndjson")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	printer, err := newPrinter(*output, splitFields(*fieldList))
+	if err != nil {
+		return err
+	}
+
+	body := make(map[string]interface{})
+	for key, value := range filters.fields() {
+		body[key] = value
+	}
+	for key, value := range map[string]string{"timestamp_from": *since, "timestamp_to": *until} {
+		if value == "" {
+			continue
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+		body[key] = t.Format(time.RFC3339Nano)
+	}
+	if *fieldList != "" {
+		body["fields"] = *fieldList
+	}
+
+	if *count {
+		body["count_only"] = true
+		var response struct {
+			Count int `json:"count"`
+		}
+		if err := c.PostJSON("/query", body, &response); err != nil {
+			return err
+		}
+		fmt.Println(response.Count)
+		return nil
+	}
+
+	body["limit"] = *limit
+	body["sort"] = *sort
+	if *pageToken != "" {
+		body["page_token"] = *pageToken
+	}
+	for {
+		var response queryResponse
+		if err := c.PostJSON("/query", body, &response); err != nil {
+			return err
+		}
+		printer.add(response.Logs)
+		if response.NextToken == "" {
+			break
+		}
+		if !*all {
+			printer.flush()
+			fmt.Fprintf(os.Stderr, "more logs match; next page: --page-token %s\n", response.NextToken)
+			return nil
+		}
+		body["page_token"] = response.NextToken
+	}
+
+	printer.flush()
+	return nil
+}
+
+func runTail(c *Client, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: logctl tail [flags]\n\nPrints logs as they are ingested, until interrupted.")
+		fs.PrintDefaults()
+	}
+	var filters filterFlags
+	filters.register(fs)
+	fieldList := fs.String("fields", "", "comma-separated fields to show, e.g. timestamp,level,message")
+	output := fs.String("output", "table", "output format: table, json or ndjson")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	printer, err := newPrinter(*output, splitFields(*fieldList))
+	if err != nil {
+		return err
+	}
+	printer.streaming = true
+
+	query := url.Values{}
+	for key, value := range filters.fields() {
+		query.Set(key, value)
+	}
+	stream, err := c.Stream("/tail", query)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// Server-sent events: "data:" lines end with a blank line; "event: close"
+	// means the server ended the stream, e.g. because we fell behind
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(nil, 16<<20)
+	event, data := "", ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "close" {
+				return fmt.Errorf("stream closed by the server: %s", data)
+			}
+			if data != "" {
+				var log map[string]interface{}
+				if err := json.Unmarshal([]byte(data), &log); err != nil {
+					return fmt.Errorf("invalid event: %v", err)
+				}
+				printer.add([]map[string]interface{}{log})
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("stream ended")
+}
+
+// parseTime parses an RFC3339 time or a duration before now; durations take
+// the units of time.ParseDuration plus "d" for days
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	var ago time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is neither a duration nor RFC3339", value)
+		}
+		ago = time.Duration(n * float64(24*time.Hour))
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is neither a duration nor RFC3339", value)
+		}
+		ago = d
+	}
+
+	return time.Now().UTC().Add(-ago), nil
+}
+
+func splitFields(list string) []string {
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
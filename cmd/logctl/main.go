@@ -0,0 +1,141 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : logctl, a command line client ingesting, querying and tailing logs over the HTTP API
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const usage = `logctl talks to a Log Ingestor over its HTTP API.
+
+Usage:
+  logctl [global flags] <command> [flags]
+
+Commands:
+  ingest   send logs from files or stdin (JSON, JSON array or NDJSON), or one log from flags
+  import   backfill historical logs from NDJSON or CSV files or directories
+  query    search logs, e.g. logctl query --level error --since 1h
+  tail     stream newly ingested logs
+  bench    drive ingest and query with synthetic logs and report throughput and latency
+
+Global flags:
+  --server URL     server address (default http://localhost:3000)
+  --api-key KEY    API key
+  --tenant ID      tenant, sent as X-Tenant-ID
+  --config FILE    config file (default $LOGCTL_CONFIG or ~/.config/logctl/config.json)
+
+Run "logctl <command> --help" for the flags of a command.
+`
+
+// Config is the logctl config file: {"server": ..., "api_key": ..., "tenant": ...}.
+// LOGCTL_SERVER, LOGCTL_API_KEY and LOGCTL_TENANT override it, and flags override both.
+type Config struct {
+	Server string `json:"server"`
+	APIKey string `json:"api_key"`
+	Tenant string `json:"tenant"`
+}
+
+// command is a subcommand: it parses its own flags and runs against the client
+type command struct {
+	name string
+	run  func(c *Client, args []string) error
+}
+
+var commands = []command{
+	{"ingest", runIngest},
+	{"import", runImport},
+	{"query", runQuery},
+	{"tail", runTail},
+	{"bench", runBench},
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "logctl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("logctl", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := fs.String("server", "", "server address")
+	apiKey := fs.String("api-key", "", "API key")
+	tenant := fs.String("tenant", "", "tenant")
+	configFile := fs.String("config", "", "config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	cfg.Server = pick(*server, os.Getenv("LOGCTL_SERVER"), cfg.Server, "http://localhost:3000")
+	cfg.APIKey = pick(*apiKey, os.Getenv("LOGCTL_API_KEY"), cfg.APIKey)
+	cfg.Tenant = pick(*tenant, os.Getenv("LOGCTL_TENANT"), cfg.Tenant)
+
+	name, rest := fs.Arg(0), fs.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(NewClient(cfg), rest)
+		}
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// loadConfig reads the config file; a missing default config file is not an error
+func loadConfig(path string) (Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("LOGCTL_CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return Config{}, nil
+		}
+		path = filepath.Join(dir, "logctl", "config.json")
+	}
+
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// pick returns the first non-empty value
+func pick(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Output formats of logctl: aligned table, JSON array or NDJSON
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultColumns are the table columns when no fields are chosen
+var defaultColumns = []string{"timestamp", "level", "resourceId", "message"}
+
+// printer writes logs in one output format. Tables and JSON arrays are
+// written on flush, unless streaming, where every log is written as it comes.
+type printer struct {
+	format    string
+	columns   []string
+	streaming bool
+	logs      []map[string]interface{}
+}
+
+func newPrinter(format string, fields []string) (*printer, error) {
+	switch format {
+	case "table", "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected table, json or ndjson", format)
+	}
+	if len(fields) == 0 {
+		fields = defaultColumns
+	}
+
+	return &printer{format: format, columns: fields}, nil
+}
+
+func (p *printer) add(logs []map[string]interface{}) {
+	if p.format == "ndjson" || p.streaming {
+		for _, log := range logs {
+			p.writeOne(log)
+		}
+		return
+	}
+	p.logs = append(p.logs, logs...)
+}
+
+func (p *printer) writeOne(log map[string]interface{}) {
+	if p.format == "table" {
+		values := make([]string, len(p.columns))
+		for i, column := range p.columns {
+			values[i] = cell(log, column)
+		}
+		fmt.Println(strings.Join(values, "  "))
+		return
+	}
+	line, _ := json.Marshal(log)
+	fmt.Println(string(line))
+}
+
+func (p *printer) flush() {
+	logs := p.logs
+	p.logs = nil
+	switch p.format {
+	case "json":
+		if logs == nil {
+			logs = []map[string]interface{}{}
+		}
+		encoded, _ := json.MarshalIndent(logs, "", "  ")
+		fmt.Println(string(encoded))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := make([]string, len(p.columns))
+		for i, column := range p.columns {
+			header[i] = strings.ToUpper(column)
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, log := range logs {
+			values := make([]string, len(p.columns))
+			for i, column := range p.columns {
+				values[i] = cell(log, column)
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// cell formats a field of a log for the table; "metadata" shows its fields
+// and metadata.parentResourceId reads the nested field
+func cell(log map[string]interface{}, field string) string {
+	var value interface{} = log
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		// Tabs and newlines would break the alignment
+		return strings.NewReplacer("\t", " ", "\n", " ", "\r", "").Replace(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
@@ -0,0 +1,67 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Collapsing of query results to one representative log per value of a field, with a count
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// isCollapseField reports whether results can be collapsed by field: any field
+// a filter may name but the timestamp
+func isCollapseField(field string) bool {
+	return !strings.HasPrefix(field, "timestamp") && isFilterKey(field)
+}
+
+// parseCollapseBy validates the collapse_by field of a query
+func parseCollapseBy(field string) error {
+	if field == "" {
+		return errors.New("must not be empty")
+	}
+	if !isCollapseField(field) {
+		return errors.New("must name a log field such as message, traceId or a metadata path")
+	}
+	return nil
+}
+
+// collapse keeps the first log of every group of logs sharing a collapse_by
+// value, in order, then cuts the groups to the page of the query and records
+// the size of each group of the page. Logs without a value are groups of their own.
+func (req *QueryRequest) collapse(logs []Log) ([]Log, bool) {
+	var groups []Log
+	var counts []int
+	group := make(map[string]int)
+	for _, log := range logs {
+		value := fieldValue(log, req.CollapseBy)
+		if i, ok := group[value]; ok && value != "" {
+			counts[i]++
+			continue
+		}
+		group[value] = len(groups)
+		groups = append(groups, log)
+		counts = append(counts, 1)
+	}
+
+	start := min(req.Options.Offset, len(groups))
+	end := len(groups)
+	if req.Options.Limit > 0 {
+		end = min(start+req.Options.Limit, end)
+	}
+	req.counts = counts[start:end]
+	return groups[start:end], end < len(groups)
+}
+
+// collapsedQuery finds every log matching req, the page applying to the groups
+// collapse makes of them
+func collapsedQuery(ctx context.Context, storage *LogStorage, req *QueryRequest) ([]Log, bool, error) {
+	opts := req.Options
+	opts.Offset, opts.Limit = 0, 0
+	logs, _, err := storage.Query(ctx, req.Filters, opts)
+	logs, more := req.collapse(logs)
+	return logs, more, err
+}
@@ -0,0 +1,395 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Column-oriented encoding of cold tier blocks, each field encoded and compressed on its own
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coldEncodingColumnar marks cold files whose blocks are columnar; files without
+// an encoding hold gzip NDJSON blocks
+const coldEncodingColumnar = "columnar"
+
+// Columns of a columnar block, in the order they are stored
+const (
+	colTimestamp  = iota // zigzag varint deltas of the unix nanoseconds
+	colZone              // dictionary of UTC offsets in seconds, as decimal strings
+	colLevel             // dictionary
+	colResourceID        // dictionary
+	colMessage
+	colTraceID
+	colSpanID
+	colCommit           // dictionary
+	colParentResourceID // dictionary
+	colMetadata         // the other metadata keys as a JSON object, empty without any
+	colCustom           // the custom fields as a JSON object, empty without any
+	colIngestedAt       // unix nanoseconds as decimal strings, empty when unset
+	numColumns
+)
+
+// legacyColumns is the number of columns of blocks written before colMetadata,
+// whose files have no column count in their footer; blocks of files that have
+// one may still lack the later columns
+const legacyColumns = colMetadata
+
+// columnFilters are the equality filters a block can be checked against from
+// one column, without decoding the rest
+var columnFilters = map[string]int{
+	"level":                     colLevel,
+	"resourceId":                colResourceID,
+	"traceId":                   colTraceID,
+	"spanId":                    colSpanID,
+	"commit":                    colCommit,
+	"metadata.parentResourceId": colParentResourceID,
+}
+
+var errCorruptColumn = errors.New("corrupt column")
+
+// encodeColumnarBlock encodes logs column by column: a varint count and the
+// varint length of every column, then the columns, each DEFLATE-compressed
+func encodeColumnarBlock(logs []Log) ([]byte, error) {
+	var timestamps []byte
+	var prev int64
+	values := make([][]string, numColumns)
+	for i := range values {
+		values[i] = make([]string, len(logs))
+	}
+	for i, log := range logs {
+		n := log.Timestamp.UnixNano()
+		timestamps = binary.AppendVarint(timestamps, n-prev)
+		prev = n
+
+		_, offset := log.Timestamp.Zone()
+		if log.Timestamp.Location() != time.UTC {
+			values[colZone][i] = strconv.Itoa(offset)
+		}
+		values[colLevel][i] = log.Level
+		values[colResourceID][i] = log.ResourceID
+		values[colMessage][i] = log.Message
+		values[colTraceID][i] = log.TraceID
+		values[colSpanID][i] = log.SpanID
+		values[colCommit][i] = log.Commit
+		values[colParentResourceID][i] = log.Metadata.ParentResourceID
+		if len(log.Metadata.Fields) > 0 {
+			data, err := json.Marshal(log.Metadata.Fields)
+			if err != nil {
+				return nil, err
+			}
+			values[colMetadata][i] = string(data)
+		}
+		if len(log.Custom) > 0 {
+			data, err := json.Marshal(log.Custom)
+			if err != nil {
+				return nil, err
+			}
+			values[colCustom][i] = string(data)
+		}
+		if !log.IngestedAt.IsZero() {
+			values[colIngestedAt][i] = strconv.FormatInt(log.IngestedAt.UnixNano(), 10)
+		}
+	}
+
+	columns := make([][]byte, numColumns)
+	columns[colTimestamp] = timestamps
+	for col := colZone; col < numColumns; col++ {
+		switch col {
+		case colMessage, colTraceID, colSpanID, colMetadata, colCustom, colIngestedAt:
+			columns[col] = encodeStrings(values[col])
+		default:
+			columns[col] = encodeDictionary(values[col])
+		}
+	}
+
+	var header, body []byte
+	header = binary.AppendUvarint(header, uint64(len(logs)))
+	for _, column := range columns {
+		compressed, err := deflate(column)
+		if err != nil {
+			return nil, err
+		}
+		header = binary.AppendUvarint(header, uint64(len(compressed)))
+		body = append(body, compressed...)
+	}
+
+	return append(header, body...), nil
+}
+
+// encodeStrings stores every value as a varint length and its bytes
+func encodeStrings(values []string) []byte {
+	var out []byte
+	for _, value := range values {
+		out = binary.AppendUvarint(out, uint64(len(value)))
+		out = append(out, value...)
+	}
+	return out
+}
+
+// encodeDictionary stores the distinct values once, as encodeStrings, then a
+// varint code per value
+func encodeDictionary(values []string) []byte {
+	codes := make(map[string]uint64)
+	var dict []string
+	for _, value := range values {
+		if _, ok := codes[value]; !ok {
+			codes[value] = uint64(len(dict))
+			dict = append(dict, value)
+		}
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(dict)))
+	out = append(out, encodeStrings(dict)...)
+	for _, value := range values {
+		out = binary.AppendUvarint(out, codes[value])
+	}
+	return out
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(data)
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
+
+// columnarBlock is a block whose columns are decompressed on first use
+type columnarBlock struct {
+	count   int
+	columns [][]byte // compressed
+	values  [][]string
+}
+
+// parseColumnarBlock splits a block of the given number of columns; the columns
+// it lacks read as empty
+func parseColumnarBlock(data []byte, columns int) (*columnarBlock, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || columns > numColumns {
+		return nil, errCorruptColumn
+	}
+	data = data[n:]
+	lengths := make([]uint64, columns)
+	for i := range lengths {
+		if lengths[i], n = binary.Uvarint(data); n <= 0 {
+			return nil, errCorruptColumn
+		}
+		data = data[n:]
+	}
+
+	block := &columnarBlock{count: int(count), columns: make([][]byte, numColumns), values: make([][]string, numColumns)}
+	for i, length := range lengths {
+		if length > uint64(len(data)) {
+			return nil, errCorruptColumn
+		}
+		block.columns[i], data = data[:length], data[length:]
+	}
+
+	return block, nil
+}
+
+// column returns the values of a string column
+func (b *columnarBlock) column(col int) ([]string, error) {
+	if b.values[col] != nil {
+		return b.values[col], nil
+	}
+	if b.columns[col] == nil {
+		b.values[col] = make([]string, b.count)
+		return b.values[col], nil
+	}
+	data, err := inflate(b.columns[col])
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	switch col {
+	case colMessage, colTraceID, colSpanID, colMetadata, colCustom, colIngestedAt:
+		values, _, err = decodeStrings(data, b.count)
+	default:
+		values, err = decodeDictionary(data, b.count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.values[col] = values
+	return values, nil
+}
+
+func decodeStrings(data []byte, count int) ([]string, []byte, error) {
+	values := make([]string, count)
+	for i := range values {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return nil, nil, errCorruptColumn
+		}
+		values[i], data = string(data[n:n+int(length)]), data[n+int(length):]
+	}
+	return values, data, nil
+}
+
+func decodeDictionary(data []byte, count int) ([]string, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)) {
+		return nil, errCorruptColumn
+	}
+	dict, data, err := decodeStrings(data[n:], int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, count)
+	for i := range values {
+		code, n := binary.Uvarint(data)
+		if n <= 0 || code >= uint64(len(dict)) {
+			return nil, errCorruptColumn
+		}
+		values[i], data = dict[code], data[n:]
+	}
+	return values, nil
+}
+
+func (b *columnarBlock) timestamps() ([]time.Time, error) {
+	data, err := inflate(b.columns[colTimestamp])
+	if err != nil {
+		return nil, err
+	}
+	zones, err := b.column(colZone)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps := make([]time.Time, b.count)
+	var n int64
+	for i := range timestamps {
+		delta, size := binary.Varint(data)
+		if size <= 0 {
+			return nil, errCorruptColumn
+		}
+		data = data[size:]
+		n += delta
+		timestamps[i] = time.Unix(0, n).UTC()
+		if zones[i] != "" {
+			offset, err := strconv.Atoi(zones[i])
+			if err != nil {
+				return nil, errCorruptColumn
+			}
+			timestamps[i] = timestamps[i].In(time.FixedZone("", offset))
+		}
+	}
+	return timestamps, nil
+}
+
+// decodeColumnarBlock returns the logs of a block of the given number of columns
+// that may match the equality filters and time bounds of filters. Only the
+// filtered columns are decompressed until a log passes them, so blocks without
+// a match cost little to rule out.
+func decodeColumnarBlock(data []byte, columns int, filters map[string]string) ([]Log, error) {
+	block, err := parseColumnarBlock(data, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make([]bool, block.count)
+	for i := range keep {
+		keep[i] = true
+	}
+	for key, value := range filters {
+		col, ok := columnFilters[key]
+		if !ok {
+			continue
+		}
+		values, err := block.column(col)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			keep[i] = keep[i] && v == value
+		}
+	}
+
+	timestamps, err := block.timestamps()
+	if err != nil {
+		return nil, err
+	}
+	from, to := timeBounds(filters)
+	matched := false
+	for i, t := range timestamps {
+		keep[i] = keep[i] && (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+		matched = matched || keep[i]
+	}
+	if !matched {
+		return nil, nil
+	}
+
+	values := make([][]string, numColumns)
+	for col := colZone; col < numColumns; col++ {
+		if values[col], err = block.column(col); err != nil {
+			return nil, err
+		}
+	}
+
+	var logs []Log
+	for i, kept := range keep {
+		if !kept {
+			continue
+		}
+		metadata := Metadata{ParentResourceID: values[colParentResourceID][i]}
+		if values[colMetadata][i] != "" {
+			decoder := json.NewDecoder(strings.NewReader(values[colMetadata][i]))
+			decoder.UseNumber()
+			if err := decoder.Decode(&metadata.Fields); err != nil {
+				return nil, errCorruptColumn
+			}
+		}
+		var custom map[string]any
+		if values[colCustom][i] != "" {
+			decoder := json.NewDecoder(strings.NewReader(values[colCustom][i]))
+			decoder.UseNumber()
+			if err := decoder.Decode(&custom); err != nil {
+				return nil, errCorruptColumn
+			}
+		}
+		var ingestedAt time.Time
+		if values[colIngestedAt][i] != "" {
+			n, err := strconv.ParseInt(values[colIngestedAt][i], 10, 64)
+			if err != nil {
+				return nil, errCorruptColumn
+			}
+			ingestedAt = time.Unix(0, n).UTC()
+		}
+		logs = append(logs, Log{
+			Level:      values[colLevel][i],
+			Message:    values[colMessage][i],
+			ResourceID: values[colResourceID][i],
+			Timestamp:  timestamps[i],
+			TraceID:    values[colTraceID][i],
+			SpanID:     values[colSpanID][i],
+			Commit:     values[colCommit][i],
+			Metadata:   metadata,
+			IngestedAt: ingestedAt,
+			Custom:     custom,
+		})
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,309 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Background compaction merging small WAL segments, dropping evicted logs and rebuilding indexes
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	compactionRuns    = metrics.CounterVec("logingestor_compaction_runs_total", "Compaction passes by result", "result")
+	compactionDropped = metrics.Counter("logingestor_compaction_dropped_logs_total", "Evicted logs removed from storage by compaction")
+	compactionWritten = metrics.Counter("logingestor_compaction_written_bytes_total", "Bytes rewritten by compaction")
+)
+
+// Compactor is implemented by a Storage that can rewrite its files without
+// the logs that are no longer kept
+type Compactor interface {
+	// Compact rewrites stored data, dropping logs older than cutoff and those
+	// deleted reports
+	Compact(cutoff time.Time, deleted func(Log) bool, run *compactionRun) error
+}
+
+// CompactionStatus is the progress of a tenant's current or last compaction,
+// as listed by GET /admin/compaction
+type CompactionStatus struct {
+	Tenant        string    `json:"tenant"`
+	Running       bool      `json:"running"`
+	Pending       bool      `json:"pending"`
+	SegmentsTotal int       `json:"segments_total"`
+	SegmentsDone  int       `json:"segments_done"`
+	ShardsTotal   int       `json:"shards_total"`
+	ShardsDone    int       `json:"shards_done"`
+	BytesRead     int64     `json:"bytes_read"`
+	BytesWritten  int64     `json:"bytes_written"`
+	LogsDropped   int64     `json:"logs_dropped"`
+	StartedAt     time.Time `json:"started_at,omitzero"`
+	FinishedAt    time.Time `json:"finished_at,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+	Runs          int       `json:"runs"`
+}
+
+// compactionRun is one compaction pass of a tenant; it reports progress into
+// status and throttles the pass to rate bytes per second
+type compactionRun struct {
+	ctx   context.Context
+	rate  int64 // 0 is unlimited
+	start time.Time
+	io    int64 // bytes read and written so far
+
+	mu     *sync.Mutex
+	status *CompactionStatus
+}
+
+// update changes the status under its lock
+func (run *compactionRun) update(fn func(*CompactionStatus)) {
+	run.mu.Lock()
+	fn(run.status)
+	run.mu.Unlock()
+}
+
+// segmentDone records a rewritten segment and waits as long as the rate requires;
+// it fails once the run is cancelled
+func (run *compactionRun) segmentDone(read, written, dropped int64) error {
+	run.update(func(status *CompactionStatus) {
+		status.SegmentsDone++
+		status.BytesRead += read
+		status.BytesWritten += written
+		status.LogsDropped += dropped
+	})
+	compactionDropped.Add(dropped)
+	compactionWritten.Add(written)
+
+	return run.throttle(read + written)
+}
+
+// throttle accounts for n bytes of IO and sleeps until the rate allows them
+func (run *compactionRun) throttle(n int64) error {
+	run.io += n
+	if run.rate > 0 {
+		due := run.start.Add(time.Duration(float64(run.io) / float64(run.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-run.ctx.Done():
+			case <-timer.C:
+			}
+		}
+	}
+
+	return run.ctx.Err()
+}
+
+// Compact rewrites storage without the logs retention evicted from memory and
+// the deleted ones, when its engine supports it, then rebuilds the indexes of shards whose window has
+// passed, which no longer change
+func (ls *LogStorage) Compact(run *compactionRun) error {
+	if compactor, ok := ls.store.(Compactor); ok {
+		if err := compactor.Compact(ls.oldestTimestamp(), ls.deleted.has, run); err != nil {
+			return err
+		}
+	}
+
+	return ls.rebuildShards(run)
+}
+
+// oldestTimestamp returns the timestamp of the oldest log in memory, zero when empty;
+// retention evicts by timestamp, so stored logs older than it are gone
+func (ls *LogStorage) oldestTimestamp() time.Time {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var oldest time.Time
+	for _, sh := range ls.shards {
+		if !oldest.IsZero() && !sh.start.Before(oldest) {
+			break // the stripes of the oldest window were all seen
+		}
+		sh.mu.RLock()
+		if len(sh.byTime) > 0 {
+			if t := sh.logs[sh.byTime[0]].Timestamp; oldest.IsZero() || t.Before(oldest) {
+				oldest = t
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return oldest
+}
+
+// rebuildShards rebuilds each past shard not rebuilt since its last append, so its
+// slices and index maps shed the slack left by growth and evictions. The new indexes
+// are built outside the shard's lock and swapped in unless the shard changed meanwhile.
+func (ls *LogStorage) rebuildShards(run *compactionRun) error {
+	now := time.Now()
+	ls.mu.RLock()
+	var shards []*shard
+	for _, sh := range ls.shards {
+		sh.mu.RLock()
+		if sh.end.Before(now) && !sh.compacted && len(sh.logs) > 0 {
+			shards = append(shards, sh)
+		}
+		sh.mu.RUnlock()
+	}
+	ls.mu.RUnlock()
+	run.update(func(status *CompactionStatus) { status.ShardsTotal += len(shards) })
+
+	for _, sh := range shards {
+		sh.mu.RLock()
+		logs := slices.Clone(sh.logs)
+		sh.mu.RUnlock()
+
+		rebuilt := newShard(sh.start, ls.window)
+		rebuilt.appendLocked(logs...)
+
+		sh.mu.Lock()
+		if len(sh.logs) == len(logs) && !sh.compacted {
+			sh.logs, sh.index, sh.messages, sh.byTime, sh.bytes = rebuilt.logs, rebuilt.index, rebuilt.messages, rebuilt.byTime, rebuilt.bytes
+			sh.compacted = true
+		}
+		sh.mu.Unlock()
+
+		run.update(func(status *CompactionStatus) { status.ShardsDone++ })
+		if err := run.throttle(rebuilt.bytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CompactionRunner compacts every tenant every interval, one at a time, and any
+// tenant on request
+type CompactionRunner struct {
+	tenants *Tenants
+	rate    int64
+
+	mu      sync.Mutex
+	status  map[string]*CompactionStatus
+	pending map[string]bool
+	wake    chan struct{}
+}
+
+// NewCompactionRunner creates the runner of the compaction-* settings
+func NewCompactionRunner(cfg Config, tenants *Tenants) *CompactionRunner {
+	cr := &CompactionRunner{tenants: tenants, rate: cfg.CompactionRate, status: make(map[string]*CompactionStatus),
+		pending: make(map[string]bool), wake: make(chan struct{}, 1)}
+	for _, tenant := range tenants.All() {
+		cr.status[tenant.ID] = &CompactionStatus{Tenant: tenant.ID}
+	}
+
+	return cr
+}
+
+// Run compacts until ctx is cancelled; a zero interval only compacts on request
+func (cr *CompactionRunner) Run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			for _, tenant := range cr.tenants.All() {
+				cr.compact(ctx, tenant)
+			}
+		case <-cr.wake:
+		}
+
+		for _, tenant := range cr.tenants.All() {
+			cr.mu.Lock()
+			pending := cr.pending[tenant.ID]
+			cr.mu.Unlock()
+			if pending {
+				cr.compact(ctx, tenant)
+			}
+		}
+	}
+}
+
+// compact runs one compaction pass of tenant
+func (cr *CompactionRunner) compact(ctx context.Context, tenant *Tenant) {
+	cr.mu.Lock()
+	delete(cr.pending, tenant.ID)
+	status := cr.status[tenant.ID]
+	*status = CompactionStatus{Tenant: tenant.ID, Running: true, StartedAt: time.Now().UTC(), Runs: status.Runs + 1}
+	cr.mu.Unlock()
+
+	run := &compactionRun{ctx: ctx, rate: cr.rate, start: time.Now(), mu: &cr.mu, status: status}
+	err := tenant.storage.Compact(run)
+
+	run.update(func(status *CompactionStatus) {
+		status.Running = false
+		status.FinishedAt = time.Now().UTC()
+		if err != nil {
+			status.LastError = err.Error()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		compactionRuns.With("error").Inc()
+		logger.Error("compaction failed", "tenant", tenant.ID, "error", err)
+	} else if err == nil {
+		compactionRuns.With("ok").Inc()
+		logger.Info("compaction done", "tenant", tenant.ID, "segments", status.SegmentsDone, "shards", status.ShardsDone,
+			"dropped_logs", status.LogsDropped, "duration", time.Since(run.start))
+	}
+}
+
+// Trigger queues a compaction of tenant, unless one is already queued
+func (cr *CompactionRunner) Trigger(tenant *Tenant) {
+	cr.mu.Lock()
+	cr.pending[tenant.ID] = true
+	cr.mu.Unlock()
+
+	select {
+	case cr.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the progress of tenant's current or last compaction
+func (cr *CompactionRunner) Status(tenant *Tenant) CompactionStatus {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	status := *cr.status[tenant.ID]
+	status.Pending = cr.pending[tenant.ID]
+	return status
+}
+
+// handleCompaction reports the compaction progress of the request's tenant;
+// POST queues a compaction of it
+func (s *Server) handleCompaction(w http.ResponseWriter, r *http.Request) {
+	tenant := s.tenant(r.Context())
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.compaction.Trigger(tenant)
+		status = http.StatusAccepted
+	default:
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	response, err := json.Marshal(s.compaction.Status(tenant))
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
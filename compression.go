@@ -0,0 +1,117 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Gzip request decompression with a size limit and gzip response compression
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters reuses gzip writers, which are costly to allocate per response
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// gzipReaders reuses the gzip readers of compressed request bodies
+var gzipReaders sync.Pool
+
+// newGzipReader returns a gzip reader of r, reused from gzipReaders when one is
+// free; putGzipReader gives it back
+func newGzipReader(r io.Reader) (*gzip.Reader, error) {
+	if zr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+		if err := zr.Reset(r); err != nil {
+			gzipReaders.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+	return gzip.NewReader(r)
+}
+
+// putGzipReader closes a reader of newGzipReader and returns it to gzipReaders
+func putGzipReader(zr *gzip.Reader) {
+	zr.Close()
+	gzipReaders.Put(zr)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows a gzip response
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// gzipResponseWriter compresses everything written through it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if !gw.wroteHeader {
+		gw.wroteHeader = true
+		gw.Header().Del("Content-Length")
+		gw.Header().Set("Content-Encoding", "gzip")
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	return gw.gz.Write(b)
+}
+
+// Flush sends the data compressed so far, so streamed responses keep flowing
+func (gw *gzipResponseWriter) Flush() {
+	gw.gz.Flush()
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// gzipResponse compresses the responses of next for clients accepting gzip
+func gzipResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		defer func() {
+			// A response without a body, e.g. a bare status, is left uncompressed
+			if gw.wroteHeader {
+				gz.Close()
+			}
+			gzipWriters.Put(gz)
+		}()
+
+		next(gw, r)
+	}
+}
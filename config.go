@@ -0,0 +1,1222 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Server configuration from defaults, an optional config file, environment and flags
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the upper-cased setting name to form its environment variable,
+// e.g. LOGINGESTOR_PORT or LOGINGESTOR_DATA_DIR
+const envPrefix = "LOGINGESTOR_"
+
+// Config holds the settings of the log ingestor
+type Config struct {
+	Port                int
+	BindAddress         string
+	MaxBodySize         int64 // per request, e.g. a batch
+	MaxLogSize          int64 // per /ingest request, a single log
+	MaxDecompressedSize int64
+	MaxRestoreSize      int64
+	DataDir             string
+	Retention           time.Duration
+	LogLevel            string
+	LogFormat           string // text or json
+	LogSelfIngest       bool   // store the server's own logs in the default tenant
+	MaxPageSize         int
+	QueryTimeout        time.Duration // 0 is unlimited
+	QueryCacheSize      int           // query results cached per tenant; 0 disables the cache
+	ShutdownTimeout     time.Duration
+	GRPCPort            int
+	UnixSocket          string      // path of a Unix socket also serving the HTTP API; empty disables it
+	UnixSocketMode      os.FileMode // permissions of UnixSocket
+
+	// HTTP server timeouts against slow clients; 0 disables one
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// HTTP/2 of the HTTP and gRPC ports: H2C serves it without TLS on the HTTP port,
+	// to clients with prior knowledge, and HTTP2PingInterval pings connections
+	// silent that long to find dead ones; 0 sends no pings
+	H2C                       bool
+	HTTP2MaxConcurrentStreams int
+	HTTP2PingInterval         time.Duration
+
+	// Retention beyond the Retention max age
+	RetentionMaxEntries int
+	RetentionMaxBytes   int64
+	RetentionInterval   time.Duration
+
+	// Logs older than RollupAfter are replaced with per-minute summaries holding
+	// up to RollupExemplars of them, kept until the Retention max age; 0 disables it
+	RollupAfter     time.Duration
+	RollupExemplars int
+
+	// Persistence
+	ShardWindow     time.Duration
+	ShardStripes    int
+	StorageEngine   string
+	WALSegmentSize  int64
+	WALSyncInterval time.Duration
+
+	// Hot/cold tiering: windows that ended more than HotWindow ago leave memory; 0 disables it
+	HotWindow    time.Duration
+	TierInterval time.Duration
+
+	// Background compaction of storage; a zero interval only compacts on request
+	CompactionInterval time.Duration
+	CompactionRate     int64 // bytes per second, 0 is unlimited
+
+	// Rejected payloads kept for inspection and reprocessing; 0 disables the store
+	DeadLetterMaxEntries int
+
+	// Queries recorded in the audit log; 0 disables it
+	AuditMaxEntries int
+
+	// Cluster mode, sharding logs across the ClusterNodes; disabled when empty
+	ClusterNodes    []string // id=url of every node, this one included
+	ClusterNodeID   string
+	ClusterShardKey string
+	ClusterAPIKey   string // sent to peers with the logs forwarded to them
+	ClusterTimeout  time.Duration
+
+	// Replication of every log to ClusterReplicas nodes
+	ClusterReplicas       int
+	ClusterReplication    string // sync or async
+	ClusterProbeInterval  time.Duration
+	ClusterCatchUpOverlap time.Duration // how far before its newest log a rejoining node catches up
+
+	// Kafka ingestion through a Kafka REST Proxy; disabled when KafkaProxyURL is empty
+	KafkaProxyURL    string
+	KafkaTopics      []string
+	KafkaGroup       string
+	KafkaPollTimeout time.Duration
+
+	// Ingest queue: HTTP and syslog logs are queued and stored in batches; 0 stores synchronously
+	IngestQueueSize     int
+	IngestBatchSize     int
+	IngestFlushInterval time.Duration
+	IngestWorkers       int
+
+	// Duplicate detection: logs identical to one ingested within DedupWindow are
+	// dropped and Idempotency-Key requests replayed; 0 disables both
+	DedupWindow  time.Duration
+	DedupMaxKeys int
+
+	// Live tail: logs buffered per /tail stream and what happens when it is full
+	TailBuffer       int
+	TailSlowConsumer string
+
+	// API keys as "key:read+write" entries and/or a JSON file; authentication is off without keys
+	APIKeys     []string
+	APIKeysFile string
+	RateLimit   float64 // default requests per second per key; 0 is unlimited
+	RateBurst   int
+
+	// Tenants besides the default one, "id" or "id:retention"
+	Tenants []string
+
+	// CORS for browser tools on the CORSOrigins; disabled when empty
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+
+	// Syslog (RFC 5424) listener on UDP and TCP; disabled when empty
+	SyslogAddr string
+
+	// Fluentd forward protocol listener on TCP; disabled when empty
+	ForwardAddr string
+
+	// Listener of one JSON log per UDP datagram, queueing up to UDPQueueSize of
+	// them; disabled when empty
+	UDPAddr      string
+	UDPQueueSize int
+
+	// HTTPS for the HTTP and gRPC ports when TLSCertFile is set; the files are
+	// reloaded when they change. TLSClientAuth "ingest" requires a client
+	// certificate signed by TLSClientCAFile for write requests, "all" for every
+	// connection.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	TLSClientAuth   string
+
+	// Alerting rules from a JSON file, evaluated every AlertInterval; off without a
+	// file. The SMTP settings are used by email targets.
+	AlertRulesFile    string
+	AlertInterval     time.Duration
+	AlertSMTPAddr     string
+	AlertSMTPFrom     string
+	AlertSMTPUsername string
+	AlertSMTPPassword string
+
+	// Output sinks from a JSON file forwarding ingested logs; none without a file
+	SinksFile string
+
+	// Ingest policies from a JSON file sampling and rate-limiting logs per
+	// source; none without a file
+	IngestPoliciesFile string
+
+	// Daily quotas of logs and bytes per tenant or resource from a JSON file;
+	// none without a file
+	QuotasFile string
+
+	// Most logs one DELETE /logs or purge may delete
+	DeleteMaxLogs int
+	// Secret signing the reports of /admin/purge; purges are disabled without it
+	PurgeSigningKey string
+
+	// Redaction of the built-in patterns in Redact (email, credit_card) and those
+	// of a JSON file from logs at ingest; RedactDryRun only counts matches
+	Redact             []string
+	RedactPatternsFile string
+	RedactDryRun       bool
+
+	// Ingest pipelines from a YAML file dropping, renaming and enriching logs;
+	// none without a file
+	PipelinesFile string
+
+	// Scheduled export jobs from a JSON file writing logs to object storage; none
+	// without a file
+	ExportJobsFile string
+
+	// Continuous aggregations from a JSON file updated as logs are ingested;
+	// none without a file
+	AggregationsFile string
+
+	// Log entry schema enforced at ingest; Levels go from least to most severe
+	// and LevelAliases ("synonym=level") are normalized to them
+	Levels           []string
+	LevelAliases     []string
+	MaxMessageLength int
+	CustomFields     []string // name:type or name:type:indexed
+
+	// How far ahead of the server clock a log timestamp may be, and what
+	// happens to logs beyond it: SkewAccept, SkewClamp or SkewReject
+	MaxFutureSkew   time.Duration
+	ClockSkewPolicy string
+
+	// Delivery lag (ingestedAt - timestamp) beyond which logs count as delayed,
+	// or as timestamped ahead of the server clock when negative
+	IngestLagThreshold time.Duration
+
+	// Anomaly detection comparing the logs of each resource per AnomalyInterval
+	// to a baseline learned over AnomalyBaseline intervals; off when the interval
+	// is 0. Deviations beyond AnomalyThreshold standard deviations are kept,
+	// newest AnomalyMaxEntries, and posted to AnomalyWebhooks.
+	AnomalyInterval     time.Duration
+	AnomalyBaseline     int
+	AnomalyThreshold    float64
+	AnomalyErrorLevel   string // this level and the more severe ones make up the error ratio
+	AnomalyMaxResources int
+	AnomalyMaxEntries   int
+	AnomalyWebhooks     []string
+
+	// Mining of message templates, up to PatternMaxEntries per tenant (0 turns it
+	// off); a message joins a template when PatternSimilarity of its tokens match
+	PatternMaxEntries int
+	PatternSimilarity float64
+}
+
+// defaultConfig returns the settings used when nothing else is configured
+func defaultConfig() Config {
+	return Config{
+		Port:                3000,
+		BindAddress:         "",
+		MaxBodySize:         10 << 20,
+		MaxLogSize:          1 << 20,
+		MaxDecompressedSize: 100 << 20,
+		MaxRestoreSize:      4 << 30,
+		DataDir:             "data",
+		Retention:           0,
+		LogLevel:            "info",
+		LogFormat:           "text",
+		MaxPageSize:         1000,
+		QueryTimeout:        30 * time.Second,
+		QueryCacheSize:      256,
+		ShutdownTimeout:     15 * time.Second,
+		GRPCPort:            0,
+		UnixSocketMode:      0o660,
+
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+
+		HTTP2MaxConcurrentStreams: 250,
+
+		UDPQueueSize: 10000,
+
+		RetentionInterval: time.Minute,
+		RollupExemplars:   3,
+
+		ShardWindow:     time.Hour,
+		StorageEngine:   "wal",
+		WALSegmentSize:  64 << 20,
+		WALSyncInterval: 10 * time.Millisecond,
+
+		TierInterval: time.Minute,
+
+		CompactionInterval: time.Hour,
+		CompactionRate:     16 << 20,
+
+		DeadLetterMaxEntries: 10000,
+
+		AuditMaxEntries: 100000,
+
+		ClusterShardKey: "resourceId",
+		ClusterTimeout:  10 * time.Second,
+
+		ClusterReplicas:       1,
+		ClusterReplication:    ReplicationSync,
+		ClusterProbeInterval:  5 * time.Second,
+		ClusterCatchUpOverlap: time.Hour,
+
+		TLSClientAuth: TLSClientAuthOff,
+
+		CORSMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		CORSHeaders: []string{"Authorization", "Content-Type", "Content-Encoding", "Accept", "X-API-Key", tenantHeader, "Idempotency-Key"},
+		CORSMaxAge:  10 * time.Minute,
+
+		DedupMaxKeys: 1000000,
+
+		KafkaGroup:       "logingestor",
+		KafkaPollTimeout: time.Second,
+
+		IngestBatchSize:     500,
+		IngestFlushInterval: 20 * time.Millisecond,
+		IngestWorkers:       2,
+
+		TailBuffer:       1000,
+		TailSlowConsumer: TailDisconnect,
+
+		AlertInterval: 30 * time.Second,
+
+		Levels:           []string{"debug", "info", "warn", "error", "fatal"},
+		LevelAliases:     []string{"trace=debug", "information=info", "warning=warn", "err=error", "crit=fatal", "critical=fatal", "panic=fatal"},
+		MaxMessageLength: 64 << 10,
+		MaxFutureSkew:    24 * time.Hour,
+		ClockSkewPolicy:  SkewReject,
+
+		IngestLagThreshold:  time.Minute,
+		AnomalyBaseline:     60,
+		AnomalyThreshold:    3,
+		AnomalyErrorLevel:   "error",
+		AnomalyMaxResources: 10000,
+		AnomalyMaxEntries:   1000,
+		PatternMaxEntries:   10000,
+		PatternSimilarity:   0.5,
+		DeleteMaxLogs:       100000,
+	}
+}
+
+// Addr returns the address the HTTP server listens on
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+}
+
+// setting describes one configuration key, shared by the config file, environment and flags
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// settings lists every configurable key; the name is used as-is in config files and flags
+var settings = []setting{
+	{"port", "TCP port of the HTTP server", func(c *Config, v string) (err error) {
+		c.Port, err = strconv.Atoi(v)
+		return err
+	}},
+	{"grpc-port", "TCP port of the gRPC service (h2c); 0 disables it", func(c *Config, v string) (err error) {
+		c.GRPCPort, err = strconv.Atoi(v)
+		return err
+	}},
+	{"unix-socket", "path of a Unix socket also serving the HTTP API, for agents on the same host; empty disables it", func(c *Config, v string) error {
+		c.UnixSocket = v
+		return nil
+	}},
+	{"unix-socket-mode", "permissions of unix-socket in octal, e.g. 660 for its owner and group", func(c *Config, v string) error {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0o777 {
+			return fmt.Errorf("invalid permissions %q, expected octal such as 660", v)
+		}
+		c.UnixSocketMode = os.FileMode(mode)
+		return nil
+	}},
+	{"bind-address", "address to bind the HTTP server to; empty binds all interfaces", func(c *Config, v string) error {
+		c.BindAddress = v
+		return nil
+	}},
+	{"max-body-size", "maximum request body size, e.g. 1048576, 512KiB or 10MiB", func(c *Config, v string) (err error) {
+		c.MaxBodySize, err = parseSize(v)
+		return err
+	}},
+	{"max-log-size", "maximum request body size of /ingest, which carries a single log; capped by max-body-size", func(c *Config, v string) (err error) {
+		c.MaxLogSize, err = parseSize(v)
+		return err
+	}},
+	{"max-decompressed-size", "maximum size of a gzip request body once decompressed, e.g. 100MiB", func(c *Config, v string) (err error) {
+		c.MaxDecompressedSize, err = parseSize(v)
+		return err
+	}},
+	{"max-restore-size", "maximum decompressed size of a snapshot sent to /admin/restore, e.g. 4GiB", func(c *Config, v string) (err error) {
+		c.MaxRestoreSize, err = parseSize(v)
+		return err
+	}},
+	{"data-dir", "directory for persisted logs; empty keeps logs in memory only", func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}},
+	{"retention", "maximum age of stored logs, e.g. 72h or 7d; 0 keeps logs forever", func(c *Config, v string) (err error) {
+		c.Retention, err = parseDuration(v)
+		return err
+	}},
+	{"retention-max-entries", "maximum number of stored logs; the oldest are evicted first; 0 is unlimited", func(c *Config, v string) (err error) {
+		c.RetentionMaxEntries, err = strconv.Atoi(v)
+		return err
+	}},
+	{"retention-max-bytes", "approximate maximum size of stored logs, e.g. 2GiB; 0 is unlimited", func(c *Config, v string) (err error) {
+		c.RetentionMaxBytes, err = parseSize(v)
+		return err
+	}},
+	{"retention-interval", "how often the retention policy evicts logs", func(c *Config, v string) (err error) {
+		c.RetentionInterval, err = parseDuration(v)
+		return err
+	}},
+	{"rollup-after", "age past which logs are replaced with per-minute summaries by resourceId and level, kept until the retention; 0 disables rollups", func(c *Config, v string) (err error) {
+		c.RollupAfter, err = parseDuration(v)
+		return err
+	}},
+	{"rollup-exemplars", "logs sampled into each per-minute summary of a rollup", func(c *Config, v string) (err error) {
+		c.RollupExemplars, err = strconv.Atoi(v)
+		return err
+	}},
+	{"log-level", "server log level: debug, info, warn or error", func(c *Config, v string) error {
+		c.LogLevel = strings.ToLower(v)
+		return nil
+	}},
+	{"log-format", "format of the server log: text (key=value) or json", func(c *Config, v string) error {
+		c.LogFormat = strings.ToLower(v)
+		return nil
+	}},
+	{"log-self-ingest", "also store the server's own log records as logs of the default tenant, resourceId logingestor", func(c *Config, v string) (err error) {
+		c.LogSelfIngest, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"max-page-size", "maximum number of logs returned by a single query", func(c *Config, v string) (err error) {
+		c.MaxPageSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"query-timeout", "longest a query may scan before it returns 504 with partial results; 0 is unlimited", func(c *Config, v string) (err error) {
+		c.QueryTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"query-cache-size", "number of query results and counts cached per tenant until a shard they read changes; 0 disables the cache", func(c *Config, v string) (err error) {
+		c.QueryCacheSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"shutdown-timeout", "time allowed for in-flight requests to finish on SIGINT/SIGTERM", func(c *Config, v string) (err error) {
+		c.ShutdownTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"read-header-timeout", "time a client may take to send the request headers", func(c *Config, v string) (err error) {
+		c.ReadHeaderTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"read-timeout", "time a client may take to send a whole request, body included; 0 disables it", func(c *Config, v string) (err error) {
+		c.ReadTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"write-timeout", "time allowed from reading the request headers to writing the response; /tail streams and snapshots are exempt", func(c *Config, v string) (err error) {
+		c.WriteTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"idle-timeout", "time a keep-alive connection may stay idle between requests", func(c *Config, v string) (err error) {
+		c.IdleTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"h2c", "also serve HTTP/2 without TLS (h2c, prior knowledge) on port", func(c *Config, v string) (err error) {
+		c.H2C, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"http2-max-concurrent-streams", "requests an HTTP/2 connection may have in flight at once", func(c *Config, v string) (err error) {
+		c.HTTP2MaxConcurrentStreams, err = strconv.Atoi(v)
+		return err
+	}},
+	{"http2-ping-interval", "time an HTTP/2 connection may stay silent before it is pinged, and closed if the ping is not answered; 0 sends no pings", func(c *Config, v string) (err error) {
+		c.HTTP2PingInterval, err = parseDuration(v)
+		return err
+	}},
+	{"dead-letter-max-entries", "number of rejected payloads kept in the dead-letter store (data-dir/deadletter.ndjson); 0 disables it", func(c *Config, v string) (err error) {
+		c.DeadLetterMaxEntries, err = strconv.Atoi(v)
+		return err
+	}},
+	{"audit-max-entries", "number of queries kept in the audit log (data-dir/audit.ndjson); 0 disables it", func(c *Config, v string) (err error) {
+		c.AuditMaxEntries, err = strconv.Atoi(v)
+		return err
+	}},
+	{"shard-window", "time window of the in-memory shards, e.g. 1h; queries and retention work shard by shard", func(c *Config, v string) (err error) {
+		c.ShardWindow, err = parseDuration(v)
+		return err
+	}},
+	{"shard-stripes", "shards each window is split into by resourceId, each with its own lock, so ingest and queries spread over cores; 0 makes one per core", func(c *Config, v string) (err error) {
+		c.ShardStripes, err = strconv.Atoi(v)
+		return err
+	}},
+	{"storage", "storage engine under data-dir: wal (segmented write-ahead log) or file (single NDJSON file)", func(c *Config, v string) error {
+		c.StorageEngine = v
+		return nil
+	}},
+	{"wal-segment-size", "size at which the WAL starts a new segment file", func(c *Config, v string) (err error) {
+		c.WALSegmentSize, err = parseSize(v)
+		return err
+	}},
+	{"wal-sync-interval", "how often buffered WAL appends are fsynced together; 0 fsyncs every append", func(c *Config, v string) (err error) {
+		c.WALSyncInterval, err = parseDuration(v)
+		return err
+	}},
+	{"hot-window", "how long after its shard window ends a log stays in memory before moving to the cold tier on disk, e.g. 24h; 0 keeps every log in memory", func(c *Config, v string) (err error) {
+		c.HotWindow, err = parseDuration(v)
+		return err
+	}},
+	{"tier-interval", "how often past windows are moved to the cold tier", func(c *Config, v string) (err error) {
+		c.TierInterval, err = parseDuration(v)
+		return err
+	}},
+	{"compaction-interval", "how often storage is compacted in the background; 0 only compacts on POST /admin/compaction", func(c *Config, v string) (err error) {
+		c.CompactionInterval, err = parseDuration(v)
+		return err
+	}},
+	{"compaction-rate", "bytes per second compaction may read and write, e.g. 16MiB; 0 is unlimited", func(c *Config, v string) (err error) {
+		c.CompactionRate, err = parseSize(v)
+		return err
+	}},
+	{"cluster-nodes", "comma-separated id=url of every cluster node, this one included, e.g. a=http://10.0.0.1:3000,b=http://10.0.0.2:3000; empty disables cluster mode", func(c *Config, v string) error {
+		c.ClusterNodes = splitList(v)
+		return nil
+	}},
+	{"cluster-node-id", "id of this node in cluster-nodes", func(c *Config, v string) error {
+		c.ClusterNodeID = v
+		return nil
+	}},
+	{"cluster-shard-key", "log field hashed to pick the node storing a log: resourceId or traceId", func(c *Config, v string) error {
+		c.ClusterShardKey = v
+		return nil
+	}},
+	{"cluster-api-key", "secret shared by the nodes, sent with their requests to each other; with api-keys also a key with the write scope on every node, and with replicas the read scope, for catching up", func(c *Config, v string) error {
+		c.ClusterAPIKey = v
+		return nil
+	}},
+	{"cluster-timeout", "longest a request to another cluster node may take", func(c *Config, v string) (err error) {
+		c.ClusterTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"cluster-replicas", "number of nodes storing each log, 1 disables replication", func(c *Config, v string) (err error) {
+		c.ClusterReplicas, err = strconv.Atoi(v)
+		return err
+	}},
+	{"cluster-replication", "sync (an ingest waits for every replica) or async (for one, the others are sent in the background)", func(c *Config, v string) error {
+		c.ClusterReplication = v
+		return nil
+	}},
+	{"cluster-probe-interval", "how often other cluster nodes are checked for being up", func(c *Config, v string) (err error) {
+		c.ClusterProbeInterval, err = parseDuration(v)
+		return err
+	}},
+	{"cluster-catch-up-overlap", "how far before its newest log a rejoining node asks its peers for the logs it missed", func(c *Config, v string) (err error) {
+		c.ClusterCatchUpOverlap, err = parseDuration(v)
+		return err
+	}},
+	{"kafka-proxy-url", "Kafka REST Proxy URL to consume logs from, e.g. http://localhost:8082; empty disables Kafka", func(c *Config, v string) error {
+		c.KafkaProxyURL = v
+		return nil
+	}},
+	{"kafka-topics", "comma-separated Kafka topics holding JSON log entries", func(c *Config, v string) error {
+		c.KafkaTopics = splitList(v)
+		return nil
+	}},
+	{"kafka-group", "Kafka consumer group whose committed offsets track progress", func(c *Config, v string) error {
+		c.KafkaGroup = v
+		return nil
+	}},
+	{"kafka-poll-timeout", "how long a Kafka fetch waits for new records", func(c *Config, v string) (err error) {
+		c.KafkaPollTimeout, err = parseDuration(v)
+		return err
+	}},
+	{"ingest-queue-size", "logs the ingest queue holds; ingests then return 202 once queued; 0 stores synchronously", func(c *Config, v string) (err error) {
+		c.IngestQueueSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"ingest-batch-size", "most logs an ingest queue writer stores at once", func(c *Config, v string) (err error) {
+		c.IngestBatchSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"ingest-flush-interval", "how long an ingest queue writer waits to fill a batch", func(c *Config, v string) (err error) {
+		c.IngestFlushInterval, err = parseDuration(v)
+		return err
+	}},
+	{"ingest-workers", "number of ingest queue writers", func(c *Config, v string) (err error) {
+		c.IngestWorkers, err = strconv.Atoi(v)
+		return err
+	}},
+	{"tail-buffer", "logs buffered per /tail stream before the slow consumer policy applies", func(c *Config, v string) (err error) {
+		c.TailBuffer, err = strconv.Atoi(v)
+		return err
+	}},
+	{"tail-slow-consumer", "what to do when a /tail client falls behind: disconnect or drop", func(c *Config, v string) error {
+		c.TailSlowConsumer = v
+		return nil
+	}},
+	{"api-keys", "comma-separated API keys with their scopes and optional tenant, e.g. k1:read+write,k2:read@team-a; no keys disables authentication", func(c *Config, v string) error {
+		c.APIKeys = splitList(v)
+		return nil
+	}},
+	{"api-keys-file", "JSON file with an array of API keys: {key, name, scopes, rate_limit, rate_burst, tenant}", func(c *Config, v string) error {
+		c.APIKeysFile = v
+		return nil
+	}},
+	{"rate-limit", "requests per second allowed per API key; 0 is unlimited", func(c *Config, v string) (err error) {
+		c.RateLimit, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"rate-burst", "requests an API key may make at once above its rate; 0 uses the rate limit", func(c *Config, v string) (err error) {
+		c.RateBurst, err = strconv.Atoi(v)
+		return err
+	}},
+	{"cors-origins", "comma-separated origins allowed to call the API from a browser, e.g. https://grafana.example.com or https://*.example.com; * allows any, empty disables CORS", func(c *Config, v string) error {
+		c.CORSOrigins = splitList(v)
+		return nil
+	}},
+	{"cors-methods", "comma-separated methods allowed in CORS preflight answers", func(c *Config, v string) error {
+		c.CORSMethods = splitList(v)
+		return nil
+	}},
+	{"cors-headers", "comma-separated request headers allowed in CORS preflight answers", func(c *Config, v string) error {
+		c.CORSHeaders = splitList(v)
+		return nil
+	}},
+	{"cors-max-age", "time browsers may cache a CORS preflight answer", func(c *Config, v string) (err error) {
+		c.CORSMaxAge, err = parseDuration(v)
+		return err
+	}},
+	{"tenants", "comma-separated tenants with isolated storage, each id or id:retention, e.g. team-a:7d,team-b", func(c *Config, v string) error {
+		c.Tenants = splitList(v)
+		return nil
+	}},
+	{"syslog-addr", "UDP and TCP address of the syslog listener, e.g. :514; empty disables syslog", func(c *Config, v string) error {
+		c.SyslogAddr = v
+		return nil
+	}},
+	{"alert-rules-file", "JSON file with an array of alerting rules; empty disables alerting", func(c *Config, v string) error {
+		c.AlertRulesFile = v
+		return nil
+	}},
+	{"alert-interval", "how often alerting rules are evaluated", func(c *Config, v string) (err error) {
+		c.AlertInterval, err = parseDuration(v)
+		return err
+	}},
+	{"alert-smtp-addr", "host:port of the mail server used by email alert targets", func(c *Config, v string) error {
+		c.AlertSMTPAddr = v
+		return nil
+	}},
+	{"alert-smtp-from", "sender address of alert emails", func(c *Config, v string) error {
+		c.AlertSMTPFrom = v
+		return nil
+	}},
+	{"alert-smtp-username", "username for SMTP PLAIN authentication; empty sends without authentication", func(c *Config, v string) error {
+		c.AlertSMTPUsername = v
+		return nil
+	}},
+	{"alert-smtp-password", "password for SMTP PLAIN authentication", func(c *Config, v string) error {
+		c.AlertSMTPPassword = v
+		return nil
+	}},
+	{"sinks-file", "JSON file with an array of output sinks forwarding ingested logs; empty disables forwarding", func(c *Config, v string) error {
+		c.SinksFile = v
+		return nil
+	}},
+	{"ingest-policies-file", "JSON file with an array of ingest policies sampling logs and capping resource rates; empty disables them", func(c *Config, v string) error {
+		c.IngestPoliciesFile = v
+		return nil
+	}},
+	{"quotas-file", "JSON file with an array of daily quotas of logs and bytes per tenant or resource; empty disables them", func(c *Config, v string) error {
+		c.QuotasFile = v
+		return nil
+	}},
+	{"delete-max-logs", "most logs one DELETE /logs or /admin/purge may delete; a filter matching more is refused", func(c *Config, v string) (err error) {
+		c.DeleteMaxLogs, err = strconv.Atoi(v)
+		return err
+	}},
+	{"purge-signing-key", "secret signing the deletion reports of /admin/purge with HMAC-SHA256; empty disables purges", func(c *Config, v string) error {
+		c.PurgeSigningKey = v
+		return nil
+	}},
+	{"pipelines-file", "YAML (or .json) file of ingest pipelines whose processors drop, rename and enrich logs before storage; empty disables them", func(c *Config, v string) error {
+		c.PipelinesFile = v
+		return nil
+	}},
+	{"redact", "comma-separated built-in patterns redacted from log messages and metadata at ingest: email, credit_card", func(c *Config, v string) error {
+		c.Redact = splitList(v)
+		return nil
+	}},
+	{"redact-patterns-file", "JSON file with an array of name, pattern (regular expression) and optional replacement redacted at ingest", func(c *Config, v string) error {
+		c.RedactPatternsFile = v
+		return nil
+	}},
+	{"redact-dry-run", "only count the matches of the redact patterns in /metrics, leaving logs unchanged", func(c *Config, v string) (err error) {
+		c.RedactDryRun, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"export-jobs-file", "JSON file with an array of scheduled export jobs writing logs to S3 or GCS; empty disables them", func(c *Config, v string) error {
+		c.ExportJobsFile = v
+		return nil
+	}},
+	{"aggregations-file", "JSON file with an array of continuous aggregations updated on ingest and read through /aggregations/{name}; empty disables them", func(c *Config, v string) error {
+		c.AggregationsFile = v
+		return nil
+	}},
+	{"forward-addr", "TCP address of the Fluentd forward protocol listener, e.g. :24224; empty disables it", func(c *Config, v string) error {
+		c.ForwardAddr = v
+		return nil
+	}},
+	{"udp-addr", "UDP address of the listener of one JSON log per datagram, e.g. :5170; empty disables it", func(c *Config, v string) error {
+		c.UDPAddr = v
+		return nil
+	}},
+	{"udp-queue-size", "UDP datagrams queued for storage, beyond which they are dropped", func(c *Config, v string) (err error) {
+		c.UDPQueueSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"dedup-window", "how long ingested logs and Idempotency-Key requests are remembered to drop retried duplicates; 0 disables it", func(c *Config, v string) (err error) {
+		c.DedupWindow, err = parseDuration(v)
+		return err
+	}},
+	{"dedup-max-keys", "most logs and Idempotency-Keys remembered per tenant, oldest forgotten first", func(c *Config, v string) (err error) {
+		c.DedupMaxKeys, err = strconv.Atoi(v)
+		return err
+	}},
+	{"tls-cert-file", "PEM certificate (chain) served over HTTPS; empty serves plain HTTP", func(c *Config, v string) error {
+		c.TLSCertFile = v
+		return nil
+	}},
+	{"tls-key-file", "PEM private key of tls-cert-file", func(c *Config, v string) error {
+		c.TLSKeyFile = v
+		return nil
+	}},
+	{"tls-client-ca-file", "PEM CA certificates that client certificates must be signed by", func(c *Config, v string) error {
+		c.TLSClientCAFile = v
+		return nil
+	}},
+	{"tls-client-auth", "which requests need a trusted client certificate: off, ingest (write requests) or all", func(c *Config, v string) error {
+		c.TLSClientAuth = v
+		return nil
+	}},
+	{"levels", "comma-separated list of accepted log levels, least severe first", func(c *Config, v string) error {
+		c.Levels = splitList(v)
+		return nil
+	}},
+	{"level-aliases", "comma-separated synonym=level pairs normalized at ingest, e.g. warning=warn", func(c *Config, v string) error {
+		c.LevelAliases = splitList(v)
+		return nil
+	}},
+	{"max-message-length", "maximum length in bytes of a log message", func(c *Config, v string) (err error) {
+		c.MaxMessageLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"max-future-skew", "how far ahead of the server clock a log timestamp may be before clock-skew-policy applies", func(c *Config, v string) (err error) {
+		c.MaxFutureSkew, err = parseDuration(v)
+		return err
+	}},
+	{"clock-skew-policy", "what happens to logs timestamped beyond max-future-skew: accept (store as is), clamp (store at the server time) or reject", func(c *Config, v string) error {
+		c.ClockSkewPolicy = v
+		return nil
+	}},
+	{"ingest-lag-threshold", "delivery lag (ingestedAt - timestamp) beyond which logs are counted as delayed, or as clock-ahead when negative, per ingest source", func(c *Config, v string) (err error) {
+		c.IngestLagThreshold, err = parseDuration(v)
+		return err
+	}},
+	{"anomaly-interval", "interval over which the logs of each resource are counted and compared to their baseline, e.g. 1m; 0 disables anomaly detection", func(c *Config, v string) (err error) {
+		c.AnomalyInterval, err = parseDuration(v)
+		return err
+	}},
+	{"anomaly-baseline", "number of intervals the baseline of a resource averages over, and learns before flagging anything", func(c *Config, v string) (err error) {
+		c.AnomalyBaseline, err = strconv.Atoi(v)
+		return err
+	}},
+	{"anomaly-threshold", "standard deviations from the baseline beyond which an interval is an anomaly", func(c *Config, v string) (err error) {
+		c.AnomalyThreshold, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"anomaly-error-level", "least severe level counted as an error in the error ratio of a resource", func(c *Config, v string) error {
+		c.AnomalyErrorLevel = v
+		return nil
+	}},
+	{"anomaly-max-resources", "most resources with a baseline across tenants; the logs of further resources are not watched", func(c *Config, v string) (err error) {
+		c.AnomalyMaxResources, err = strconv.Atoi(v)
+		return err
+	}},
+	{"anomaly-max-entries", "most anomalies kept for GET /anomalies, oldest dropped first", func(c *Config, v string) (err error) {
+		c.AnomalyMaxEntries, err = strconv.Atoi(v)
+		return err
+	}},
+	{"anomaly-webhooks", "comma-separated URLs each anomaly is POSTed to as JSON", func(c *Config, v string) error {
+		c.AnomalyWebhooks = splitList(v)
+		return nil
+	}},
+	{"pattern-max-entries", "most message patterns mined per tenant; 0 disables pattern mining", func(c *Config, v string) (err error) {
+		c.PatternMaxEntries, err = strconv.Atoi(v)
+		return err
+	}},
+	{"pattern-similarity", "share of its tokens (0 to 1) a message must have in common with a pattern to join it", func(c *Config, v string) (err error) {
+		c.PatternSimilarity, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"custom-fields", "comma-separated top-level log fields to accept, each name:type (string, number or bool) with :indexed to index it, e.g. region:string:indexed,status:number", func(c *Config, v string) error {
+		c.CustomFields = splitList(v)
+		return nil
+	}},
+}
+
+// loadConfig builds the configuration from, in increasing precedence: defaults,
+// the file named by -config, LOGINGESTOR_* environment variables and flags
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("LogIngestor_QueryInterface", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "optional JSON or YAML config file")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.name
+		fs.Func(name, s.usage, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := applySettings(&cfg, values, "config file "+*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	envValues := make(map[string]string)
+	for _, s := range settings {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			envValues[s.name] = v
+		}
+	}
+	if err := applySettings(&cfg, envValues, "environment"); err != nil {
+		return Config{}, err
+	}
+
+	if err := applySettings(&cfg, flagValues, "flag"); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// applySettings sets every known key in values on cfg, rejecting unknown keys
+func applySettings(cfg *Config, values map[string]string, source string) error {
+	for _, s := range settings {
+		v, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(cfg, v); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %v", source, s.name, v, err)
+		}
+		delete(values, s.name)
+	}
+
+	for key := range values {
+		return fmt.Errorf("%s: unknown setting %q", source, key)
+	}
+
+	return nil
+}
+
+// Validate checks that the settings are usable before the server starts
+func (c Config) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d out of range 1-65535", c.Port)
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 || (c.GRPCPort != 0 && c.GRPCPort == c.Port) {
+		return fmt.Errorf("grpc-port %d must be 0 or a free port in 1-65535 other than port", c.GRPCPort)
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil && c.BindAddress != "localhost" {
+		return fmt.Errorf("bind-address %q is not an IP address", c.BindAddress)
+	}
+	if c.MaxBodySize <= 0 {
+		return errors.New("max-body-size must be positive")
+	}
+	if c.MaxLogSize <= 0 {
+		return errors.New("max-log-size must be positive")
+	}
+	if c.MaxDecompressedSize <= 0 {
+		return errors.New("max-decompressed-size must be positive")
+	}
+	if c.MaxRestoreSize <= 0 {
+		return errors.New("max-restore-size must be positive")
+	}
+	if c.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+	if c.RetentionMaxEntries < 0 || c.RetentionMaxBytes < 0 {
+		return errors.New("retention-max-entries and retention-max-bytes must not be negative")
+	}
+	if c.RetentionInterval <= 0 {
+		return errors.New("retention-interval must be positive")
+	}
+	if c.RollupAfter < 0 || (c.Retention > 0 && c.RollupAfter >= c.Retention) {
+		return errors.New("rollup-after must not be negative and must be below retention")
+	}
+	if c.RollupExemplars < 0 {
+		return errors.New("rollup-exemplars must not be negative")
+	}
+	if c.MaxPageSize <= 0 {
+		return errors.New("max-page-size must be positive")
+	}
+	if c.QueryTimeout < 0 {
+		return errors.New("query-timeout must not be negative")
+	}
+	if c.QueryCacheSize < 0 {
+		return errors.New("query-cache-size must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown-timeout must be positive")
+	}
+	if c.ReadHeaderTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		return errors.New("read-header-timeout, read-timeout, write-timeout and idle-timeout must not be negative")
+	}
+	if c.HTTP2MaxConcurrentStreams <= 0 {
+		return errors.New("http2-max-concurrent-streams must be positive")
+	}
+	if c.HTTP2PingInterval < 0 {
+		return errors.New("http2-ping-interval must not be negative")
+	}
+	if c.UDPQueueSize <= 0 {
+		return errors.New("udp-queue-size must be positive")
+	}
+
+	if c.DeadLetterMaxEntries < 0 {
+		return errors.New("dead-letter-max-entries must not be negative")
+	}
+	if c.AuditMaxEntries < 0 {
+		return errors.New("audit-max-entries must not be negative")
+	}
+	if c.ShardWindow <= 0 {
+		return errors.New("shard-window must be positive")
+	}
+	if c.ShardStripes < 0 || c.ShardStripes > 256 {
+		return errors.New("shard-stripes must be between 0 and 256")
+	}
+	if c.StorageEngine != "wal" && c.StorageEngine != "file" {
+		return fmt.Errorf("storage %q must be wal or file", c.StorageEngine)
+	}
+	if c.WALSegmentSize <= 0 {
+		return errors.New("wal-segment-size must be positive")
+	}
+	if c.WALSyncInterval < 0 {
+		return errors.New("wal-sync-interval must not be negative")
+	}
+	if c.HotWindow < 0 {
+		return errors.New("hot-window must not be negative")
+	}
+	if c.HotWindow > 0 && c.DataDir == "" {
+		return errors.New("hot-window needs a data-dir for the cold tier")
+	}
+	if c.TierInterval <= 0 {
+		return errors.New("tier-interval must be positive")
+	}
+	if c.CompactionInterval < 0 || c.CompactionRate < 0 {
+		return errors.New("compaction-interval and compaction-rate must not be negative")
+	}
+	if len(c.ClusterNodes) > 0 {
+		nodes, err := parseClusterNodes(c.ClusterNodes)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(nodes, func(node *clusterNode) bool { return node.ID == c.ClusterNodeID }) {
+			return fmt.Errorf("cluster-node-id %q must be one of the cluster-nodes", c.ClusterNodeID)
+		}
+		if c.ClusterAPIKey == "" {
+			return errors.New("cluster-api-key is required with cluster-nodes, it authenticates the requests nodes send each other")
+		}
+		if !slices.Contains(clusterShardKeys, c.ClusterShardKey) {
+			return fmt.Errorf("cluster-shard-key %q must be resourceId or traceId", c.ClusterShardKey)
+		}
+		if c.ClusterTimeout <= 0 || c.ClusterProbeInterval <= 0 {
+			return errors.New("cluster-timeout and cluster-probe-interval must be positive")
+		}
+		if c.ClusterReplicas < 1 || c.ClusterReplicas > len(nodes) {
+			return fmt.Errorf("cluster-replicas must be between 1 and the %d cluster-nodes", len(nodes))
+		}
+		if c.ClusterReplication != ReplicationSync && c.ClusterReplication != ReplicationAsync {
+			return fmt.Errorf("cluster-replication %q must be sync or async", c.ClusterReplication)
+		}
+		if c.ClusterCatchUpOverlap < 0 {
+			return errors.New("cluster-catch-up-overlap must not be negative")
+		}
+	}
+	if c.KafkaProxyURL != "" {
+		if len(c.KafkaTopics) == 0 || c.KafkaGroup == "" {
+			return errors.New("kafka-topics and kafka-group are required with kafka-proxy-url")
+		}
+		if !strings.HasPrefix(c.KafkaProxyURL, "http://") && !strings.HasPrefix(c.KafkaProxyURL, "https://") {
+			return fmt.Errorf("kafka-proxy-url %q must be an http(s) URL", c.KafkaProxyURL)
+		}
+		if c.KafkaPollTimeout <= 0 {
+			return errors.New("kafka-poll-timeout must be positive")
+		}
+	}
+	if c.IngestQueueSize < 0 {
+		return errors.New("ingest-queue-size must not be negative")
+	}
+	if c.IngestBatchSize <= 0 || c.IngestFlushInterval <= 0 || c.IngestWorkers <= 0 {
+		return errors.New("ingest-batch-size, ingest-flush-interval and ingest-workers must be positive")
+	}
+	if c.TailBuffer <= 0 {
+		return errors.New("tail-buffer must be positive")
+	}
+	if c.TailSlowConsumer != TailDisconnect && c.TailSlowConsumer != TailDrop {
+		return fmt.Errorf("tail-slow-consumer %q must be disconnect or drop", c.TailSlowConsumer)
+	}
+	for _, entry := range c.APIKeys {
+		if key, scopes, ok := strings.Cut(entry, ":"); !ok || key == "" || scopes == "" {
+			return fmt.Errorf("api-keys entry %q must be key:scope[+scope][@tenant]", entry)
+		}
+	}
+	seen := map[string]bool{DefaultTenant: true}
+	for _, entry := range c.Tenants {
+		id, retention := parseTenant(entry)
+		if !tenantIDPattern.MatchString(id) {
+			return fmt.Errorf("tenant %q must be letters, digits, '-' or '_' (up to 64)", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("tenant %q is listed twice or reserved", id)
+		}
+		seen[id] = true
+		if retention != "" {
+			if d, err := parseDuration(retention); err != nil || d < 0 {
+				return fmt.Errorf("tenant %s: invalid retention %q", id, retention)
+			}
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		return errors.New("cors-max-age must not be negative")
+	}
+	if c.RateLimit < 0 || math.IsNaN(c.RateLimit) || c.RateBurst < 0 {
+		return errors.New("rate-limit and rate-burst must not be negative")
+	}
+	if c.DedupWindow < 0 || c.DedupMaxKeys <= 0 {
+		return errors.New("dedup-window must not be negative and dedup-max-keys must be positive")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls-cert-file and tls-key-file must be set together")
+	}
+	switch c.TLSClientAuth {
+	case TLSClientAuthOff:
+		if c.TLSClientCAFile != "" {
+			return errors.New("tls-client-ca-file needs tls-client-auth ingest or all")
+		}
+	case TLSClientAuthIngest, TLSClientAuthAll:
+		if c.TLSCertFile == "" || c.TLSClientCAFile == "" {
+			return fmt.Errorf("tls-client-auth %s needs tls-cert-file, tls-key-file and tls-client-ca-file", c.TLSClientAuth)
+		}
+	default:
+		return fmt.Errorf("tls-client-auth %q must be off, ingest or all", c.TLSClientAuth)
+	}
+	if c.AlertInterval <= 0 {
+		return errors.New("alert-interval must be positive")
+	}
+	if len(c.Levels) == 0 {
+		return errors.New("levels must not be empty")
+	}
+	for _, alias := range c.LevelAliases {
+		if synonym, level, ok := strings.Cut(alias, "="); !ok || synonym == "" || level == "" {
+			return fmt.Errorf("invalid level alias %q, expected synonym=level", alias)
+		}
+	}
+	if c.MaxMessageLength <= 0 {
+		return errors.New("max-message-length must be positive")
+	}
+	if c.MaxFutureSkew < 0 {
+		return errors.New("max-future-skew must not be negative")
+	}
+	if c.ClockSkewPolicy != SkewAccept && c.ClockSkewPolicy != SkewClamp && c.ClockSkewPolicy != SkewReject {
+		return fmt.Errorf("clock-skew-policy must be %s, %s or %s", SkewAccept, SkewClamp, SkewReject)
+	}
+	if c.IngestLagThreshold <= 0 {
+		return errors.New("ingest-lag-threshold must be positive")
+	}
+	if c.AnomalyInterval < 0 {
+		return errors.New("anomaly-interval must not be negative")
+	}
+	if c.AnomalyBaseline <= 0 || c.AnomalyMaxResources <= 0 || c.AnomalyMaxEntries <= 0 {
+		return errors.New("anomaly-baseline, anomaly-max-resources and anomaly-max-entries must be positive")
+	}
+	if !(c.AnomalyThreshold > 0) {
+		return errors.New("anomaly-threshold must be positive")
+	}
+	if c.PatternMaxEntries < 0 {
+		return errors.New("pattern-max-entries must not be negative")
+	}
+	if !(c.PatternSimilarity > 0 && c.PatternSimilarity <= 1) {
+		return errors.New("pattern-similarity must be above 0 and at most 1")
+	}
+	if c.DeleteMaxLogs <= 0 {
+		return errors.New("delete-max-logs must be positive")
+	}
+	if _, err := parseCustomFields(c.CustomFields); err != nil {
+		return err
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log-level %q must be debug, info, warn or error", c.LogLevel)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("log-format %q must be text or json", c.LogFormat)
+	}
+
+	return nil
+}
+
+// readConfigFile reads a flat JSON object, or a flat YAML mapping of "key: value"
+// lines when the file has a .yaml or .yml extension
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseFlatYAML(data)
+	}
+
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		default:
+			return nil, fmt.Errorf("%s: %s must be a string or number", path, key)
+		}
+	}
+
+	return values, nil
+}
+
+// parseFlatYAML parses the subset of YAML used by config files: one "key: value"
+// pair per line, optional quotes around the value and # comments
+func parseFlatYAML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// parseDuration parses a Go duration, additionally accepting whole days such as "7d"
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// parseSize parses a byte count with an optional KiB, MiB or GiB suffix
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(value, suffix); ok {
+			value, multiplier = trimmed, m
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,86 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : HTTP/2 settings, connection metrics and the Unix socket of the HTTP API, for long-lived agents
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	connsAccepted = metrics.CounterVec("logingestor_http_connections_total", "Connections accepted, by listener (http, grpc or unix)", "server")
+	connsOpen     = metrics.GaugeVec("logingestor_http_connections", "Open connections by state: new, active (serving requests) or idle (kept alive)", "state")
+)
+
+// connStates is the last state of every open connection of the HTTP servers
+var connStates = struct {
+	sync.Mutex
+	m map[net.Conn]http.ConnState
+}{m: make(map[net.Conn]http.ConnState)}
+
+// trackConns returns the http.Server ConnState hook keeping the connection
+// metrics of server
+func trackConns(server string) func(net.Conn, http.ConnState) {
+	accepted := connsAccepted.With(server)
+	return func(conn net.Conn, state http.ConnState) {
+		connStates.Lock()
+		defer connStates.Unlock()
+		if state == http.StateNew {
+			accepted.Inc()
+		}
+		if prev, ok := connStates.m[conn]; ok {
+			connsOpen.With(prev.String()).Add(-1)
+		}
+		switch state {
+		case http.StateHijacked, http.StateClosed:
+			delete(connStates.m, conn)
+		default:
+			connStates.m[conn] = state
+			connsOpen.With(state.String()).Add(1)
+		}
+	}
+}
+
+// http2Config returns the HTTP/2 settings of the http2-* options
+func http2Config(cfg Config) *http.HTTP2Config {
+	return &http.HTTP2Config{
+		MaxConcurrentStreams: cfg.HTTP2MaxConcurrentStreams,
+		SendPingTimeout:      cfg.HTTP2PingInterval,
+	}
+}
+
+// listenUnix listens on the Unix socket path with the permissions mode. A socket
+// left behind by a previous run is replaced, but not one a running server answers
+// on, nor any other file.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,125 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Surrounding logs of each query match, like grep -C, within its resourceId or traceId
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxContextLogs bounds the "context" of a query: the logs shown on each side of a match
+const maxContextLogs = 100
+
+// contextFields are the fields whose logs make up the context of a match
+var contextFields = []string{"resourceId", "traceId"}
+
+// ContextOptions asks for the logs around each match of a query
+type ContextOptions struct {
+	Logs int    // logs before and after each match
+	By   string // resourceId or traceId
+}
+
+// LogContext is the "context" object of a log: the logs with the same context
+// field just before and after it in time, oldest first
+type LogContext struct {
+	Before []interface{} `json:"before"`
+	After  []interface{} `json:"after"`
+}
+
+// addContext fetches the context of every log of a page into req, in the order
+// of the logs, for annotate to add to them. The other filters of the query do
+// not apply to the context, only its access scope.
+func (s *Server) addContext(ctx context.Context, r *http.Request, req *QueryRequest, logs []Log) error {
+	if req.Context == nil {
+		return nil
+	}
+	req.contexts = make([]LogContext, len(logs))
+	for i, log := range logs {
+		before, after, err := s.surrounding(ctx, r, req, log)
+		if err != nil {
+			return err
+		}
+		req.contexts[i] = LogContext{Before: make([]interface{}, len(before)), After: make([]interface{}, len(after))}
+		for j, l := range before {
+			req.contexts[i].Before[j] = req.Fields.apply(l)
+		}
+		for j, l := range after {
+			req.contexts[i].After[j] = req.Fields.apply(l)
+		}
+	}
+	return nil
+}
+
+// surrounding returns the context logs of a match. Logs sharing its timestamp
+// are placed before or after it by their order of ingestion.
+func (s *Server) surrounding(ctx context.Context, r *http.Request, req *QueryRequest, log Log) (before, after []Log, err error) {
+	value := fieldValue(log, req.Context.By)
+	if value == "" {
+		return nil, nil, nil
+	}
+	n := req.Context.Logs
+	search := func(from, to time.Time, desc bool, limit int) ([]Log, error) {
+		filters := map[string]string{req.Context.By: value}
+		if !from.IsZero() {
+			filters["timestamp_from"] = from.Format(time.RFC3339Nano)
+		}
+		if !to.IsZero() {
+			filters["timestamp_to"] = to.Format(time.RFC3339Nano)
+		}
+		return s.searchLogs(ctx, r, filters, req.Options.Scope, desc, limit)
+	}
+
+	if before, err = search(time.Time{}, log.Timestamp.Add(-time.Nanosecond), true, n); err != nil {
+		return nil, nil, err
+	}
+	slices.Reverse(before)
+	ties, err := search(log.Timestamp, log.Timestamp, false, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if after, err = search(log.Timestamp.Add(time.Nanosecond), time.Time{}, false, n); err != nil {
+		return nil, nil, err
+	}
+
+	match, _ := json.Marshal(log)
+	for i, tie := range ties {
+		if data, _ := json.Marshal(tie); bytes.Equal(data, match) {
+			before = append(before, ties[:i]...)
+			after = append(slices.Clone(ties[i+1:]), after...)
+			break
+		}
+	}
+	return before[max(len(before)-n, 0):], after[:min(len(after), n)], nil
+}
+
+// searchLogs returns the logs of a request's tenant with the given filters in
+// timestamp order, up to limit (0 is no limit), from every node in cluster mode
+func (s *Server) searchLogs(ctx context.Context, r *http.Request, filters map[string]string, scope AccessScope, desc bool, limit int) ([]Log, error) {
+	opts := QueryOptions{Limit: limit, Sort: []SortKey{{Field: "timestamp", Desc: desc}}, Scope: scope}
+	if s.cluster == nil {
+		logs, _, err := s.tenant(r.Context()).storage.Query(ctx, filters, opts)
+		return logs, err
+	}
+
+	fields := make(map[string]string, len(filters)+1)
+	maps.Copy(fields, filters)
+	fields["sort"] = "timestamp"
+	if desc {
+		fields["sort"] = "timestamp:desc"
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	logs, _, err := s.clusterLogs(ctx, r, body, &QueryRequest{Filters: filters, Options: opts})
+	return logs, err
+}
@@ -0,0 +1,84 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : CORS headers and preflight answers for browser tools calling the API from other origins
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers of the API that browser scripts may read
+var corsExposedHeaders = []string{"X-Next-Token", "X-Partial-Results", "Retry-After", "Idempotent-Replayed", "Content-Disposition"}
+
+// corsPolicy answers the requests of the allowed origins with CORS headers
+type corsPolicy struct {
+	origins []string // exact origins or globs such as https://*.example.com; "*" allows any
+	methods string
+	headers string
+	maxAge  string
+}
+
+// newCORSPolicy creates the policy of the cors settings, nil when no origin is allowed
+func newCORSPolicy(cfg Config) (*corsPolicy, error) {
+	if len(cfg.CORSOrigins) == 0 {
+		return nil, nil
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("invalid cors-origins pattern %q", origin)
+		}
+	}
+
+	return &corsPolicy{
+		origins: cfg.CORSOrigins,
+		methods: strings.Join(cfg.CORSMethods, ", "),
+		headers: strings.Join(cfg.CORSHeaders, ", "),
+		maxAge:  strconv.Itoa(int(cfg.CORSMaxAge.Seconds())),
+	}, nil
+}
+
+// allows reports whether requests from origin get CORS headers
+func (c *corsPolicy) allows(origin string) bool {
+	for _, pattern := range c.origins {
+		if ok, _ := path.Match(pattern, origin); ok || pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// handler adds CORS headers to the responses to allowed origins and answers
+// their preflight OPTIONS requests itself, before authentication, on every
+// path. Other requests pass through unchanged, so browsers block them.
+func (c *corsPolicy) handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !c.allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,398 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Dead-letter store keeping rejected log payloads for inspection and reprocessing
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var deadLettersAdded = metrics.CounterVec("logingestor_dead_letters_total", "Rejected payloads captured in the dead-letter store", "source")
+
+// DeadLetter is a payload rejected by an ingest path, with the reason it was rejected
+type DeadLetter struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Tenant     string    `json:"tenant"`
+	Source     string    `json:"source"` // ingest, ingest/batch, otlp, syslog or kafka
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	Reason     string    `json:"reason"`
+	Payload    string    `json:"payload"`
+}
+
+// rejectFunc captures a rejected payload; the Server's deadLetter method is one
+type rejectFunc func(source, remoteAddr, reason string, payload []byte)
+
+// DeadLetterStore keeps the newest dead letters, up to max, in memory and, when path is set,
+// in a ringFile of its own
+type DeadLetterStore struct {
+	max int
+
+	mu      sync.Mutex
+	file    *ringFile[DeadLetter] // nil in memory
+	entries []DeadLetter          // oldest first
+	nextID  int64
+}
+
+// OpenDeadLetterStore loads the dead letters in path, creating the file if needed;
+// an empty path keeps them in memory only
+func OpenDeadLetterStore(path string, maxEntries int) (*DeadLetterStore, error) {
+	d := &DeadLetterStore{max: maxEntries, nextID: 1}
+	if path == "" {
+		return d, nil
+	}
+
+	file, err := openRingFile(path, "dead-letter", maxEntries, func(entry DeadLetter) {
+		// Later lines for the same id record a removal (empty payload and reason) or an update
+		d.entries = slices.DeleteFunc(d.entries, func(e DeadLetter) bool { return e.ID == entry.ID })
+		if entry.Reason != "" {
+			if entry.Tenant == "" {
+				entry.Tenant = DefaultTenant // written before tenants existed
+			}
+			d.entries = append(d.entries, entry)
+		}
+		d.nextID = max(d.nextID, entry.ID+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(d.entries) > maxEntries {
+		d.entries = d.entries[len(d.entries)-maxEntries:]
+	}
+
+	if err := file.rewrite(d.entries); err != nil {
+		return nil, err
+	}
+	d.file = file
+
+	return d, nil
+}
+
+// Add stores a dead letter, evicting the oldest beyond the limit
+func (d *DeadLetterStore) Add(entry DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry.ID = d.nextID
+	d.nextID++
+	d.entries = append(d.entries, entry)
+	if len(d.entries) > d.max {
+		d.entries = slices.Delete(d.entries, 0, len(d.entries)-d.max)
+	}
+	d.file.write(entry, d.entries)
+}
+
+// List returns the dead letters of a tenant from source (all when empty), newest
+// first, with the total number of matches
+func (d *DeadLetterStore) List(tenant, source string, offset, limit int) ([]DeadLetter, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var matches []DeadLetter
+	for i := len(d.entries) - 1; i >= 0; i-- {
+		if d.entries[i].Tenant == tenant && (source == "" || d.entries[i].Source == source) {
+			matches = append(matches, d.entries[i])
+		}
+	}
+
+	total := len(matches)
+	matches = matches[min(offset, total):]
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, total
+}
+
+// Get returns the dead letters of a tenant with the given ids, or all of them when
+// ids is empty, oldest first
+func (d *DeadLetterStore) Get(tenant string, ids []int64) []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var entries []DeadLetter
+	for _, entry := range d.entries {
+		if entry.Tenant == tenant && (len(ids) == 0 || slices.Contains(ids, entry.ID)) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Remove deletes a dead letter, e.g. once it was reprocessed
+func (d *DeadLetterStore) Remove(id int64) {
+	d.update(DeadLetter{ID: id})
+}
+
+// SetReason records why reprocessing a dead letter failed again
+func (d *DeadLetterStore) SetReason(id int64, reason string) {
+	d.update(DeadLetter{ID: id, Reason: reason})
+}
+
+// update replaces the reason of a dead letter, deleting it when the reason is empty
+func (d *DeadLetterStore) update(change DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.entries, func(e DeadLetter) bool { return e.ID == change.ID })
+	if i < 0 || d.entries[i].Reason == change.Reason {
+		return
+	}
+	if change.Reason == "" {
+		d.entries = slices.Delete(d.entries, i, i+1)
+	} else {
+		d.entries[i].Reason = change.Reason
+		change = d.entries[i]
+	}
+	d.file.write(change, d.entries)
+}
+
+// Purge drops the dead letters of a tenant whose payload or reason contains id,
+// which is lower case, rewriting the file without them; it returns how many
+func (d *DeadLetterStore) Purge(tenant, id string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := len(d.entries)
+	d.entries = slices.DeleteFunc(d.entries, func(e DeadLetter) bool {
+		return e.Tenant == tenant && (strings.Contains(strings.ToLower(e.Payload), id) || strings.Contains(strings.ToLower(e.Reason), id))
+	})
+	if len(d.entries) == n {
+		return 0, nil
+	}
+	return n - len(d.entries), d.file.rewrite(d.entries)
+}
+
+// Len returns the number of dead letters held
+func (d *DeadLetterStore) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// Close closes the file
+func (d *DeadLetterStore) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.close()
+}
+
+// deadLetter captures a payload rejected by the default tenant (syslog and Kafka)
+// when the dead-letter store is enabled
+func (s *Server) deadLetter(source, remoteAddr, reason string, payload []byte) {
+	s.addDeadLetter(DeadLetter{Tenant: DefaultTenant, Source: source, RemoteAddr: remoteAddr, Reason: reason, Payload: string(payload)})
+}
+
+// rejectRequest captures a payload rejected by an HTTP or gRPC request
+func (s *Server) rejectRequest(r *http.Request, source, reason string, payload []byte) {
+	s.addDeadLetter(DeadLetter{Tenant: s.tenant(r.Context()).ID, Source: source, RemoteAddr: remoteHost(r), Reason: reason, Payload: string(payload)})
+}
+
+func (s *Server) addDeadLetter(entry DeadLetter) {
+	if s.deadLetters == nil {
+		return
+	}
+
+	deadLettersAdded.With(entry.Source).Inc()
+	entry.Time = time.Now().UTC()
+	entry.Payload = s.validator.Redactor.redact(entry.Payload)
+	s.deadLetters.Add(entry)
+}
+
+// remoteHost returns the client address of a request without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rejectionReason describes a decoding or validation error for a dead letter
+func rejectionReason(err error) string {
+	var fieldErrs ValidationError
+	if errors.As(err, &fieldErrs) {
+		data, _ := json.Marshal(fieldErrs)
+		return "Invalid log entry: " + string(data)
+	}
+	return err.Error()
+}
+
+// decodeDeadLetter turns a dead letter payload back into logs: a syslog frame for
+// syslog entries, otherwise one JSON log or an array of logs
+func (s *Server) decodeDeadLetter(entry DeadLetter) ([]Log, error) {
+	if entry.Source == "syslog" {
+		msg, err := parseSyslog(entry.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return []Log{msg.toLog(entry.RemoteAddr)}, nil
+	}
+
+	payload := bytes.TrimSpace([]byte(entry.Payload))
+	var logs []Log
+	if len(payload) > 0 && payload[0] == '[' {
+		if err := json.Unmarshal(payload, &logs); err != nil {
+			return nil, err
+		}
+	} else {
+		var log Log
+		if err := json.Unmarshal(payload, &log); err != nil {
+			return nil, err
+		}
+		logs = []Log{log}
+	}
+
+	for i := range logs {
+		if err := s.validator.Validate(&logs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return logs, nil
+}
+
+// DeadLetterList is the body of GET /deadletter, holding the dead letters of the request's tenant
+type DeadLetterList struct {
+	Entries []DeadLetter `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// handleDeadLetters lists dead letters, newest first, optionally limited to a
+// source and paged with limit and offset URL parameters. Payloads may hold logs
+// of any resource or level, so the route needs the admin scope.
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.deadLetters == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "The dead-letter store is disabled (dead-letter-max-entries=0)", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := s.cfg.MaxPageSize
+	offset := 0
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > s.cfg.MaxPageSize {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeValidationError(w, errors.New("offset must be a non-negative integer"))
+			return
+		}
+	}
+
+	entries, total := s.deadLetters.List(s.tenant(r.Context()).ID, query.Get("source"), offset, limit)
+	if entries == nil {
+		entries = []DeadLetter{}
+	}
+	response, err := json.Marshal(DeadLetterList{Entries: entries, Total: total})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// ReprocessResult reports the outcome for one dead letter
+type ReprocessResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"` // "ok" or "rejected"
+	Error  string `json:"error,omitempty"`
+}
+
+// ReprocessResponse is the body of POST /deadletter/reprocess
+type ReprocessResponse struct {
+	Reprocessed int               `json:"reprocessed"`
+	Rejected    int               `json:"rejected"`
+	Results     []ReprocessResult `json:"results"`
+}
+
+// handleReprocess decodes and validates dead letters again, e.g. after levels
+// were added to the configuration, storing the ones that now pass, unless a quota
+// rejects them, and removing them from the store. The body {"ids": [...]} selects entries; without ids every
+// entry is retried.
+func (s *Server) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.deadLetters == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "The dead-letter store is disabled (dead-letter-max-entries=0)", nil)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeMalformedJSON(w, err)
+			return
+		}
+	}
+
+	tenant := s.tenant(r.Context())
+	response := ReprocessResponse{Results: []ReprocessResult{}}
+	for _, entry := range s.deadLetters.Get(tenant.ID, req.IDs) {
+		logs, err := s.decodeDeadLetter(entry)
+		if err != nil {
+			reason := rejectionReason(err)
+			s.deadLetters.SetReason(entry.ID, reason)
+			response.Results = append(response.Results, ReprocessResult{ID: entry.ID, Status: "rejected", Error: reason})
+			response.Rejected++
+			continue
+		}
+
+		logs, err = s.admit(r.Context(), entry.Source, logs)
+		var qerr *QuotaError
+		if errors.As(err, &qerr) {
+			// Kept to be retried once the quota resets
+			response.Results = append(response.Results, ReprocessResult{ID: entry.ID, Status: "rejected", Error: qerr.Error()})
+			response.Rejected++
+			continue
+		}
+		if _, err := s.store(r.Context(), tenant, logs); err != nil {
+			writeStoreError(w, err, "Error storing logs")
+			return
+		}
+		s.deadLetters.Remove(entry.ID)
+		response.Results = append(response.Results, ReprocessResult{ID: entry.ID, Status: "ok"})
+		response.Reprocessed++
+	}
+
+	result, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
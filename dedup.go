@@ -0,0 +1,259 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Duplicate detection within a time window, so retried ingests do not store logs twice
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxReplayBody is the largest response kept for replaying an Idempotency-Key
+const maxReplayBody = 64 << 10
+
+var (
+	dedupDuplicates   = metrics.CounterVec("logingestor_dedup_duplicates_total", "Logs dropped as duplicates of a log ingested within dedup-window", "tenant")
+	dedupKeys         = metrics.GaugeVec("logingestor_dedup_keys", "Logs remembered for duplicate detection", "tenant")
+	idempotencyKeys   = metrics.Gauge("logingestor_idempotency_keys", "Idempotency-Key responses remembered for replay")
+	idempotentReplays = metrics.Counter("logingestor_idempotent_replays_total", "Requests answered from the response of an earlier request with the same Idempotency-Key")
+)
+
+// dedupCache remembers keys for window, and at most maxKeys of them, oldest
+// forgotten first. Entries may carry a response to replay.
+type dedupCache struct {
+	size       *Gauge   // number of keys
+	duplicates *Counter // logs dropped by filter
+	window     time.Duration
+	maxKeys    int
+
+	mu       sync.Mutex
+	entries  map[string]dedupEntry
+	order    []dedupKey // insertion order, from head on
+	head     int
+	inflight map[string]chan struct{} // keys reserved by a running request, closed once it is done
+}
+
+type dedupEntry struct {
+	at     time.Time
+	status int
+	header http.Header
+	body   []byte
+}
+
+type dedupKey struct {
+	key string
+	at  time.Time
+}
+
+func newDedupCache(size *Gauge, duplicates *Counter, window time.Duration, maxKeys int) *dedupCache {
+	return &dedupCache{size: size, duplicates: duplicates, window: window, maxKeys: maxKeys, entries: make(map[string]dedupEntry),
+		inflight: make(map[string]chan struct{})}
+}
+
+// add records key, reporting false when it is already known
+func (dc *dedupCache) add(key string, entry dedupEntry) bool {
+	if _, ok := dc.entries[key]; ok {
+		return false
+	}
+	dc.entries[key] = entry
+	dc.order = append(dc.order, dedupKey{key: key, at: entry.at})
+	return true
+}
+
+// expireLocked forgets keys older than the window or beyond maxKeys
+func (dc *dedupCache) expireLocked(now time.Time) {
+	cutoff := now.Add(-dc.window)
+	for dc.head < len(dc.order) {
+		oldest := dc.order[dc.head]
+		if len(dc.entries) <= dc.maxKeys && oldest.at.After(cutoff) {
+			break
+		}
+		// A key removed and added again has a newer entry that stays
+		if entry, ok := dc.entries[oldest.key]; ok && entry.at.Equal(oldest.at) {
+			delete(dc.entries, oldest.key)
+		}
+		dc.order[dc.head] = dedupKey{}
+		dc.head++
+	}
+	if dc.head > len(dc.order)/2 {
+		dc.order = append(dc.order[:0], dc.order[dc.head:]...)
+		dc.head = 0
+	}
+	dc.size.Set(int64(len(dc.entries)))
+}
+
+// filter returns the logs not seen within the window, remembering them, and
+// their keys so a failed store can forget them again
+func (dc *dedupCache) filter(logs []Log) (fresh []Log, keys []string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	now := time.Now()
+	dc.expireLocked(now)
+	fresh = logs[:0:0]
+	for _, log := range logs {
+		key := logKey(log)
+		if dc.add(key, dedupEntry{at: now}) {
+			fresh = append(fresh, log)
+			keys = append(keys, key)
+		}
+	}
+	if dropped := len(logs) - len(fresh); dropped > 0 {
+		dc.duplicates.Add(int64(dropped))
+	}
+	dc.size.Set(int64(len(dc.entries)))
+
+	return fresh, keys
+}
+
+// forget removes keys, e.g. of logs that could not be stored and will be retried
+func (dc *dedupCache) forget(keys []string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for _, key := range keys {
+		delete(dc.entries, key)
+	}
+	dc.size.Set(int64(len(dc.entries)))
+}
+
+// reserve returns the entry of key when it is within the window. Otherwise it
+// reserves key for the caller, who must release it, unless another request holds
+// it: wait is then closed once that request is done.
+func (dc *dedupCache) reserve(key string) (entry dedupEntry, found bool, wait <-chan struct{}) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.expireLocked(time.Now())
+	if entry, ok := dc.entries[key]; ok {
+		return entry, true, nil
+	}
+	if done, ok := dc.inflight[key]; ok {
+		return dedupEntry{}, false, done
+	}
+	dc.inflight[key] = make(chan struct{})
+	return dedupEntry{}, false, nil
+}
+
+// release ends the reservation of key, recording entry unless it is nil, and
+// wakes up the requests waiting for it
+func (dc *dedupCache) release(key string, entry *dedupEntry) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if entry != nil {
+		dc.expireLocked(entry.at)
+		dc.add(key, *entry)
+		dc.size.Set(int64(len(dc.entries)))
+	}
+	close(dc.inflight[key])
+	delete(dc.inflight, key)
+}
+
+// logKey identifies a log by its traceId, spanId, timestamp, resourceId and message
+func logKey(log Log) string {
+	h := fnv.New128a()
+	for _, field := range []string{log.TraceID, log.SpanID, log.Timestamp.UTC().Format(time.RFC3339Nano), log.ResourceID, log.Message} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return string(h.Sum(nil))
+}
+
+// EnableDedup makes the storage drop logs identical (by logKey) to one it
+// ingested within window, remembering at most maxKeys logs
+func (ls *LogStorage) EnableDedup(tenant string, window time.Duration, maxKeys int) {
+	ls.dedup = newDedupCache(dedupKeys.With(tenant), dedupDuplicates.With(tenant), window, maxKeys)
+}
+
+// replayRecorder captures a response as it is written, for replaying it later
+type replayRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *replayRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *replayRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	if rr.body.Len()+len(b) <= maxReplayBody {
+		rr.body.Write(b)
+	} else {
+		rr.status = -1 // too large to keep
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (rr *replayRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// idempotent answers a request repeating the Idempotency-Key of an earlier
+// successful one within dedup-window with that request's response, instead of
+// running next again; while the earlier one runs, the repeat waits for it.
+// Requests without the header always run.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if s.idempotency == nil || header == "" {
+			next(w, r)
+			return
+		}
+		key := s.tenant(r.Context()).ID + "\x00" + r.URL.Path + "\x00" + header
+
+		for {
+			entry, ok, wait := s.idempotency.reserve(key)
+			if ok {
+				idempotentReplays.Inc()
+				for name, values := range entry.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+				return
+			}
+			if wait == nil {
+				break
+			}
+			// A failed first request leaves nothing to replay, and the next loop runs this one
+			select {
+			case <-wait:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		var result *dedupEntry
+		defer func() { s.idempotency.release(key, result) }()
+		rr := &replayRecorder{ResponseWriter: w}
+		next(rr, r)
+		// Failed requests are not remembered, so that their retry runs again
+		if rr.status >= 200 && rr.status < 300 {
+			header := http.Header{}
+			for _, name := range []string{"Content-Type", "Content-Encoding"} {
+				if value := w.Header().Get(name); value != "" {
+					header.Set(name, value)
+				}
+			}
+			header.Set("Content-Length", strconv.Itoa(rr.body.Len()))
+			result = &dedupEntry{at: time.Now(), status: rr.status, header: header, body: bytes.Clone(rr.body.Bytes())}
+		}
+	}
+}
@@ -0,0 +1,394 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Deletion of the logs matching a filter (DELETE /logs), tombstoned in storage and indexes
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deleteSampleSize is the number of matching logs a dry run returns
+const deleteSampleSize = 10
+
+var deletedLogs = metrics.Counter("logingestor_deleted_logs_total", "Logs deleted through DELETE /logs")
+
+// tombstoneFormat is the format of tombstones.json whose keys are tombstoneKeys;
+// files without one hold logKeys, which stay legacy tombstones
+const tombstoneFormat = 1
+
+// tombstones are the keys (see tombstoneKey) of deleted logs, which replays of
+// the storage, cold tier reads and compaction leave out, kept in tombstones.json
+type tombstones struct {
+	path string // empty keeps them in memory only
+
+	mu     sync.RWMutex
+	keys   map[string]time.Time // the timestamp of each deleted log
+	legacy map[string]time.Time // by logKey, from files written before tombstoneFormat
+}
+
+// tombstoneFile is the content of tombstones.json
+type tombstoneFile struct {
+	Format     int              `json:"format,omitempty"`
+	Tombstones []tombstoneEntry `json:"tombstones"`
+	Legacy     []tombstoneEntry `json:"legacy,omitempty"`
+}
+
+type tombstoneEntry struct {
+	Key       string    `json:"key"` // hex of the tombstoneKey, or of the logKey of a legacy one
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// tombstoneKey identifies a stored log by all of its fields, so that deleting it
+// leaves the logs differing from it in any field alone
+func tombstoneKey(log Log) string {
+	data, _ := json.Marshal(log)
+	h := fnv.New128a()
+	h.Write(data)
+	return string(h.Sum(nil))
+}
+
+// openTombstones loads the tombstones saved in dir; an empty dir keeps them in memory
+func openTombstones(dir string) (*tombstones, error) {
+	ts := &tombstones{keys: make(map[string]time.Time), legacy: make(map[string]time.Time)}
+	if dir == "" {
+		return ts, nil
+	}
+
+	ts.path = filepath.Join(dir, "tombstones.json")
+	data, err := os.ReadFile(ts.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file tombstoneFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", ts.path, err)
+	}
+	current, legacy := file.Tombstones, file.Legacy
+	if file.Format < tombstoneFormat {
+		current, legacy = nil, file.Tombstones
+	}
+	load := func(keys map[string]time.Time, entries []tombstoneEntry) error {
+		for _, entry := range entries {
+			key, err := hex.DecodeString(entry.Key)
+			if err != nil {
+				return fmt.Errorf("%s: invalid key %q", ts.path, entry.Key)
+			}
+			keys[string(key)] = entry.Timestamp
+		}
+		return nil
+	}
+	if err := load(ts.keys, current); err != nil {
+		return nil, err
+	}
+	if err := load(ts.legacy, legacy); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// has reports whether a log was deleted
+func (ts *tombstones) has(log Log) bool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if len(ts.keys) == 0 && len(ts.legacy) == 0 {
+		return false
+	}
+	if _, ok := ts.keys[tombstoneKey(log)]; ok {
+		return true
+	}
+	_, ok := ts.legacy[logKey(log)]
+	return ok
+}
+
+// drop returns the logs that were not deleted
+func (ts *tombstones) drop(logs []Log) []Log {
+	kept := logs[:0:0]
+	for _, log := range logs {
+		if !ts.has(log) {
+			kept = append(kept, log)
+		}
+	}
+	return kept
+}
+
+// add tombstones logs, durably before returning when kept on disk
+func (ts *tombstones) add(logs []Log) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	added := make(map[string]time.Time, len(logs))
+	for _, log := range logs {
+		key := tombstoneKey(log)
+		if _, ok := ts.keys[key]; !ok {
+			added[key] = log.Timestamp
+		}
+	}
+	if ts.path != "" {
+		file := tombstoneFile{Format: tombstoneFormat, Tombstones: make([]tombstoneEntry, 0, len(ts.keys)+len(added))}
+		for _, keys := range []map[string]time.Time{ts.keys, added} {
+			for key, t := range keys {
+				file.Tombstones = append(file.Tombstones, tombstoneEntry{Key: hex.EncodeToString([]byte(key)), Timestamp: t})
+			}
+		}
+		for key, t := range ts.legacy {
+			file.Legacy = append(file.Legacy, tombstoneEntry{Key: hex.EncodeToString([]byte(key)), Timestamp: t})
+		}
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		if err := writeFileSync(ts.path, data); err != nil {
+			return err
+		}
+	}
+	for key, t := range added {
+		ts.keys[key] = t
+	}
+	return nil
+}
+
+// DeleteResponse is the body of DELETE /logs
+type DeleteResponse struct {
+	DryRun  bool           `json:"dry_run"`
+	Matched int            `json:"matched"`
+	Logs    []Log          `json:"logs,omitempty"`  // the first matches of a dry run
+	Nodes   map[string]int `json:"nodes,omitempty"` // the matches of each cluster node, copies included
+}
+
+// errTooManyMatches rejects a deletion matching more logs than delete-max-logs
+type errTooManyMatches struct {
+	matched, max int
+}
+
+func (e *errTooManyMatches) Error() string {
+	return fmt.Sprintf("The filter matches %d logs, more than delete-max-logs (%d) allows at once: narrow it", e.matched, e.max)
+}
+
+// Delete finds the logs matching the filters and opts, then unless dryRun
+// tombstones them and rebuilds the shards holding them without them. Nothing is
+// deleted when more than maxLogs match.
+func (ls *LogStorage) Delete(ctx context.Context, filters map[string]string, opts QueryOptions, dryRun bool, maxLogs int) (DeleteResponse, error) {
+	response := DeleteResponse{DryRun: dryRun}
+	matched, err := ls.collect(ctx, filters, opts.Expr, opts.allows, maxLogs)
+	if err != nil {
+		return response, err
+	}
+
+	response.Matched = len(matched)
+	if dryRun {
+		response.Logs = matched[:min(deleteSampleSize, len(matched))]
+		return response, nil
+	}
+	if err := ls.remove(matched, filters); err != nil {
+		return response, err
+	}
+	deletedLogs.Add(int64(len(matched)))
+	return response, nil
+}
+
+// collect returns the logs matching the filters and expr for which keep is true,
+// failing with errTooManyMatches once more than maxLogs are found
+func (ls *LogStorage) collect(ctx context.Context, filters map[string]string, expr lqlNode, keep func(Log) bool, maxLogs int) ([]Log, error) {
+	var matched []Log
+	from, to := timeBounds(filters)
+	for _, sh := range ls.shardsBetween(from, to) {
+		sh, err := ls.openShard(sh, filters)
+		if err != nil {
+			return nil, err
+		}
+		sh.mu.RLock()
+		sh.scan(ctx, filters, expr, func(pos int) bool {
+			if log := sh.logs[pos]; keep(log) {
+				matched = append(matched, log)
+			}
+			return len(matched) <= maxLogs
+		})
+		sh.mu.RUnlock()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(matched) > maxLogs {
+			return nil, &errTooManyMatches{matched: len(matched), max: maxLogs}
+		}
+	}
+	return matched, nil
+}
+
+// remove tombstones logs, found within the time bounds of filters, and rebuilds
+// the shards in memory holding them without them
+func (ls *LogStorage) remove(logs []Log, filters map[string]string) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if err := ls.deleted.add(logs); err != nil {
+		return err
+	}
+
+	from, to := timeBounds(filters)
+	ls.mu.RLock()
+	var shards []*shard
+	for _, sh := range ls.shards {
+		if sh.overlaps(from, to) {
+			shards = append(shards, sh)
+		}
+	}
+	ls.mu.RUnlock()
+	for _, sh := range shards {
+		sh.mu.Lock()
+		if kept := ls.deleted.drop(sh.logs); len(kept) < len(sh.logs) {
+			sh.rebuildLocked(kept)
+		}
+		sh.mu.Unlock()
+	}
+	ls.invalidateCache()
+	return nil
+}
+
+// hasConstraint reports whether a query narrows the logs by more than time and
+// level, as a deletion must
+func (req *QueryRequest) hasConstraint() bool {
+	for key := range req.Filters {
+		switch key {
+		case "timestamp", "timestamp_from", "timestamp_to", "level", messageModeKey:
+		default:
+			return true
+		}
+	}
+	return len(req.Not) > 0 || req.Pattern != "" || req.Options.Expr != nil ||
+		(len(req.AnyOf) > 0 && (len(req.AnyOf) > 1 || req.AnyOf["level"] == nil))
+}
+
+// handleDeleteLogs deletes the logs matching a /query body, which must bound the
+// time range and constrain another field; with "dry_run": true it only reports
+// what would go. In cluster mode every node deletes its copies, so
+// every node must be up. POST /logs/delete does the same for clients that cannot
+// send a DELETE body.
+func (s *Server) handleDeleteLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete && !(r.Method == http.MethodPost && r.URL.Path == "/logs/delete") {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	auditQuery(r.Context(), fields)
+
+	var dryRun bool
+	if raw, ok := fields["dry_run"]; ok {
+		if err := json.Unmarshal(raw, &dryRun); err != nil {
+			writeValidationError(w, errors.New("Invalid dry_run: must be a boolean"))
+			return
+		}
+	}
+	query := make(map[string]json.RawMessage, len(fields))
+	for key, raw := range fields {
+		if key != "dry_run" {
+			query[key] = raw
+		}
+	}
+
+	req, err := buildQuery(query, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.CountOnly || req.Fields != nil || req.Highlight != nil || req.Context != nil || req.CollapseBy != "" {
+		writeValidationError(w, errors.New("limit, offset, page_token, sort, count_only, fields, highlight, context and collapse_by do not apply to a deletion"))
+		return
+	}
+	if from, to := timeBounds(req.Filters); from.IsZero() || to.IsZero() || !req.hasConstraint() {
+		writeValidationError(w, errors.New("A deletion needs timestamp_from, timestamp_to and a filter on another field than timestamp and level"))
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var response DeleteResponse
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		response, err = s.clusterDelete(ctx, r, body, req, dryRun)
+	} else {
+		response, err = s.tenant(r.Context()).storage.Delete(ctx, req.Filters, req.Options, dryRun, s.cfg.DeleteMaxLogs)
+	}
+	var tooMany *errTooManyMatches
+	switch {
+	case errors.As(err, &tooMany):
+		writeValidationError(w, err)
+		return
+	case err != nil:
+		writeClusterError(w, err, PartialResponse{})
+		return
+	}
+
+	if !dryRun {
+		logger.Warn("logs deleted", "tenant", s.tenant(r.Context()).ID, "key", principalName(r.Context()), "logs", response.Matched)
+	}
+	auditResults(r.Context(), response.Matched)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// clusterDelete runs a deletion on every node, which deletes the logs it holds
+// whatever node they belong to, replicas included
+func (s *Server) clusterDelete(ctx context.Context, r *http.Request, body []byte, req QueryRequest, dryRun bool) (DeleteResponse, error) {
+	responses := make([]DeleteResponse, len(s.cluster.nodes))
+	err := s.cluster.eachNode(func(i int, node *clusterNode) (err error) {
+		if node == s.cluster.self {
+			responses[i], err = s.tenant(r.Context()).storage.Delete(ctx, req.Filters, req.Options, dryRun, s.cfg.DeleteMaxLogs)
+			return err
+		}
+
+		resp, err := s.cluster.peerQuery(ctx, node, "/logs/delete", r, body, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&responses[i]); err != nil {
+			return peerError(node, err)
+		}
+		return nil
+	})
+
+	merged := DeleteResponse{DryRun: dryRun, Nodes: make(map[string]int, len(responses))}
+	for i, response := range responses {
+		merged.Nodes[s.cluster.nodes[i].ID] = response.Matched
+		merged.Matched += response.Matched
+		if len(merged.Logs) < deleteSampleSize {
+			merged.Logs = append(merged.Logs, response.Logs[:min(deleteSampleSize-len(merged.Logs), len(response.Logs))]...)
+		}
+	}
+	return merged, err
+}
@@ -0,0 +1,131 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Consistent JSON error responses for every endpoint of the log ingestor
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error codes returned in the "code" field of an error response
+const (
+	ErrCodeMethodNotAllowed    = "method_not_allowed"
+	ErrCodeMalformedJSON       = "malformed_json"
+	ErrCodeMalformedBody       = "malformed_body"
+	ErrCodeUnsupportedEncoding = "unsupported_encoding"
+	ErrCodeUnsupportedMedia    = "unsupported_media_type"
+	ErrCodeValidation          = "validation_error"
+	ErrCodePayloadTooLarge     = "payload_too_large"
+	ErrCodeRequestTimeout      = "request_timeout"
+	ErrCodeQueryTimeout        = "query_timeout"
+	ErrCodeUnauthorized        = "unauthorized"
+	ErrCodeForbidden           = "forbidden"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeQuotaExceeded       = "quota_exceeded"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeConflict            = "conflict"
+	ErrCodeUnavailable         = "unavailable"
+	ErrCodeInternal            = "internal_error"
+)
+
+// APIError describes why a request failed
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ErrorResponse is the body of every error response: {"error": {...}}
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// PartialResponse is the body of a query_timeout error: the error plus what the
+// query had found when it was stopped, either logs or a count
+type PartialResponse struct {
+	Error   APIError    `json:"error"`
+	Partial bool        `json:"partial"`
+	Logs    interface{} `json:"logs,omitempty"`
+	Count   *int        `json:"count,omitempty"`
+}
+
+// writeError sends an error response with the given status, code and message;
+// details is optional extra context such as the underlying decode error
+func writeError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	body, err := json.Marshal(ErrorResponse{Error: APIError{Code: code, Message: message, Details: details}})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeMethodNotAllowed rejects a request made with an unsupported HTTP method
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Invalid request method", nil)
+}
+
+// writeMalformedJSON rejects a request body that is not valid JSON for the endpoint
+func writeMalformedJSON(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, ErrCodeMalformedJSON, "Error decoding JSON", err.Error())
+}
+
+// writeValidationError rejects a well-formed request with invalid contents;
+// a ValidationError is reported field by field in the details
+func writeValidationError(w http.ResponseWriter, err error) {
+	var fieldErrs ValidationError
+	if errors.As(err, &fieldErrs) {
+		writeError(w, http.StatusBadRequest, ErrCodeValidation, "Invalid log entry", fieldErrs)
+		return
+	}
+	var unknown UnknownFilterKeysError
+	if errors.As(err, &unknown) {
+		writeError(w, http.StatusBadRequest, ErrCodeValidation, err.Error(), map[string][]string{"unknown_keys": unknown})
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, ErrCodeValidation, err.Error(), nil)
+}
+
+// writeInternalError reports a server-side failure; the underlying error is not exposed
+func writeInternalError(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusInternalServerError, ErrCodeInternal, message, nil)
+}
+
+// writeQueryError reports a query that did not complete. Past query-timeout the
+// client gets 504 with the partial results; a cancelled query means the client
+// went away, so nothing is written. Anything else is a storage failure.
+func writeQueryError(w http.ResponseWriter, err error, partial PartialResponse) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		queriesStopped.With("cancelled").Inc()
+		return
+	case !errors.Is(err, context.DeadlineExceeded):
+		writeInternalError(w, "Error reading stored logs")
+		return
+	}
+	queriesStopped.With("timeout").Inc()
+
+	partial.Error = APIError{Code: ErrCodeQueryTimeout, Message: "The query did not complete within query-timeout; the results are partial"}
+	partial.Partial = true
+	body, err := json.Marshal(partial)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Partial-Results", "true")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(body)
+}
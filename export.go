@@ -0,0 +1,176 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : /export, streaming the results of a query as CSV or Parquet
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Export formats
+const (
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+// exportContentTypes maps each export format to its response Content-Type
+var exportContentTypes = map[string]string{
+	ExportCSV:     "text/csv; charset=utf-8",
+	ExportParquet: "application/vnd.apache.parquet",
+}
+
+// handleExport runs a /query body and streams every match as a file, CSV or
+// Parquet by "format" in the body or the URL (csv by default); "fields" picks
+// the columns
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if raw, ok := fields["format"]; ok {
+		if err := json.Unmarshal(raw, &format); err != nil {
+			writeValidationError(w, fmt.Errorf("Invalid format: %v", err))
+			return
+		}
+		delete(fields, "format")
+	}
+	if format == "" {
+		format = ExportCSV
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		writeValidationError(w, fmt.Errorf("Invalid format: unknown format %q, expected csv or parquet", format))
+		return
+	}
+
+	auditQuery(r.Context(), fields)
+	req, err := buildQuery(fields, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.CountOnly {
+		writeValidationError(w, errors.New("Invalid count_only: does not apply to an export"))
+		return
+	}
+	if req.Context != nil {
+		writeValidationError(w, errors.New("Invalid context: does not apply to an export"))
+		return
+	}
+	if req.CollapseBy != "" {
+		writeValidationError(w, errors.New("Invalid collapse_by: does not apply to an export"))
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+	// Like an NDJSON query, an export is only bounded by an explicit limit
+	if !req.Paginated {
+		req.Options.Limit = 0
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var results Results
+	if s.cluster != nil {
+		peerBody, err := peerQueryBody(fields)
+		if err != nil {
+			writeInternalError(w, "Error encoding JSON")
+			return
+		}
+		logs, _, err := s.clusterLogs(ctx, r, peerBody, &req)
+		if err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+		results = resultsOf(logs)
+	} else {
+		results, err = s.tenant(r.Context()).storage.Select(ctx, req.Filters, req.Options)
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{})
+			return
+		}
+	}
+	logAttrs(r.Context(), slog.String("format", format), slog.Int("results", results.Len()))
+	auditResults(r.Context(), results.Len())
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs.%s"`, format))
+	columns := exportColumns(req.Fields)
+	if format == ExportParquet {
+		pw := NewParquetWriter(w, columns)
+		results.Each(func(log Log) bool {
+			return pw.Write(log) == nil // a failed write means the client went away
+		})
+		pw.Close()
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	row := make([]string, len(columns))
+	results.Each(func(log Log) bool {
+		for i, field := range columns {
+			row[i] = exportValue(log, field)
+		}
+		return cw.Write(row) == nil
+	})
+	cw.Flush()
+}
+
+// exportColumns returns the fields exported for each log: the projection, or
+// every log field followed by the custom fields
+func exportColumns(fields Projection) []string {
+	if fields != nil {
+		return fields
+	}
+	columns := append([]string{}, projectableFields...)
+	for _, field := range customFields {
+		columns = append(columns, field.Name)
+	}
+	return columns
+}
+
+// exportValue formats a field of a log as a CSV cell: the timestamp in RFC 3339,
+// metadata as JSON and the other fields as filters compare them
+func exportValue(log Log, field string) string {
+	switch field {
+	case "timestamp":
+		return log.Timestamp.Format(time.RFC3339Nano)
+	case "metadata":
+		data, _ := json.Marshal(log.Metadata)
+		return string(data)
+	}
+	return fieldValue(log, field)
+}
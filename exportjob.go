@@ -0,0 +1,381 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Scheduled export jobs writing new matching logs to S3 or GCS, checkpointed across runs
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// exportJobPartSize is the most logs written to one object; bigger runs are split
+const exportJobPartSize = 100000
+
+// exportJobTimeout bounds each upload of an export job
+const exportJobTimeout = 5 * time.Minute
+
+var (
+	exportJobRuns     = metrics.CounterVec("logingestor_export_job_runs_total", "Scheduled export runs that completed", "job")
+	exportJobFailures = metrics.CounterVec("logingestor_export_job_failures_total", "Scheduled export runs that failed, retried at the next run", "job")
+	exportJobLogs     = metrics.CounterVec("logingestor_export_job_logs_total", "Logs written to object storage by scheduled exports", "job")
+)
+
+// ExportJob is an entry of the export-jobs-file. Every Every, it writes the logs
+// of the tenant matching Query, a /query body of filters, min_level and "q",
+// that are newer than its checkpoint and older than Delay to the bucket.
+type ExportJob struct {
+	Name   string                     `json:"name"`
+	Tenant string                     `json:"tenant"`
+	Query  map[string]json.RawMessage `json:"query"`
+	Every  string                     `json:"every"`  // default 1h
+	Delay  string                     `json:"delay"`  // left for late logs to arrive, default 5m
+	Format string                     `json:"format"` // ndjson (gzip-compressed, default) or parquet
+
+	Bucket          string `json:"bucket"`
+	Region          string `json:"region"`
+	Prefix          string `json:"prefix"`
+	Endpoint        string `json:"endpoint"` // empty for AWS, https://storage.googleapis.com for GCS
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// ExportJobState is the current state of a job, as listed by GET /exports
+type ExportJobState struct {
+	Job        string    `json:"job"`
+	Checkpoint time.Time `json:"checkpoint,omitzero"` // matching logs older than it are exported
+	LastRunAt  time.Time `json:"last_run_at,omitzero"`
+	Exported   int       `json:"exported"` // logs written by the last successful run
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// exportJob is a loaded job with its parsed query, client and state
+type exportJob struct {
+	ExportJob
+	tenant *Tenant
+	req    QueryRequest
+	every  time.Duration
+	delay  time.Duration
+	s3     *s3Client
+
+	state ExportJobState
+}
+
+// Exporter runs the export jobs on their schedules and keeps their checkpoints
+// in a file of the data-dir, so a restarted instance resumes where they stopped
+type Exporter struct {
+	jobs []*exportJob
+	path string
+
+	// In cluster mode each node exports the logs it is the first replica of,
+	// to objects named after the node
+	node   string
+	serves func(Log) bool
+
+	mu sync.Mutex // guards the job states and the checkpoint file
+}
+
+// LoadExporter reads and checks the jobs of the export-jobs-file and their
+// checkpoints
+func LoadExporter(cfg Config, tenants *Tenants, levels *LevelRegistry) (*Exporter, error) {
+	data, err := os.ReadFile(cfg.ExportJobsFile)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []ExportJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.ExportJobsFile, err)
+	}
+
+	e := &Exporter{path: filepath.Join(cfg.DataDir, "export-checkpoints.json")}
+	checkpoints := make(map[string]time.Time)
+	if data, err := os.ReadFile(e.path); err == nil {
+		if err := json.Unmarshal(data, &checkpoints); err != nil {
+			return nil, fmt.Errorf("%s: %v", e.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Name == "" {
+			return nil, errors.New("export job without a name")
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("export job %q: duplicate name", job.Name)
+		}
+		names[job.Name] = true
+
+		loaded, err := loadExportJob(cfg, tenants, levels, job)
+		if err != nil {
+			return nil, fmt.Errorf("export job %q: %v", job.Name, err)
+		}
+		loaded.state.Checkpoint = checkpoints[job.Name]
+		e.jobs = append(e.jobs, loaded)
+	}
+
+	return e, nil
+}
+
+// loadExportJob checks one job and fills in its defaults
+func loadExportJob(cfg Config, tenants *Tenants, levels *LevelRegistry, job ExportJob) (*exportJob, error) {
+	if job.Tenant == "" {
+		job.Tenant = DefaultTenant
+	}
+	tenant, ok := tenants.byID[job.Tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", job.Tenant)
+	}
+
+	req, err := buildRuleQuery(job.Query, cfg.MaxPageSize, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Every == "" {
+		job.Every = "1h"
+	}
+	every, err := parseDuration(job.Every)
+	if err != nil || every <= 0 {
+		return nil, fmt.Errorf("invalid every %q", job.Every)
+	}
+	if job.Delay == "" {
+		job.Delay = "5m"
+	}
+	delay, err := parseDuration(job.Delay)
+	if err != nil || delay < 0 {
+		return nil, fmt.Errorf("invalid delay %q", job.Delay)
+	}
+	switch job.Format {
+	case "":
+		job.Format = "ndjson"
+	case "ndjson", ExportParquet:
+	default:
+		return nil, fmt.Errorf("format %q must be ndjson or parquet", job.Format)
+	}
+
+	client, err := newS3Client(job.Endpoint, job.Bucket, job.Region, job.AccessKeyID, job.SecretAccessKey, exportJobTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &exportJob{
+		ExportJob: job,
+		tenant:    tenant,
+		req:       req,
+		every:     every,
+		delay:     delay,
+		s3:        client,
+		state:     ExportJobState{Job: job.Name},
+	}, nil
+}
+
+// Run runs every job on its schedule until ctx is done. A job first runs at
+// startup unless its last successful run is recent; a failed run is retried
+// after the job's interval, from the same checkpoint.
+func (e *Exporter) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range e.jobs {
+		wg.Go(func() {
+			next := time.Now()
+			if !job.state.Checkpoint.IsZero() {
+				next = job.state.Checkpoint.Add(job.delay + job.every)
+			}
+			for {
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case now := <-timer.C:
+					e.run(ctx, job, now)
+					next = now.Add(job.every)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// run exports the logs of a job from its checkpoint to delay before now and
+// moves the checkpoint there once every object is written
+func (e *Exporter) run(ctx context.Context, job *exportJob, now time.Time) {
+	e.mu.Lock()
+	from := job.state.Checkpoint
+	e.mu.Unlock()
+	to := now.Add(-job.delay).UTC()
+	if !to.After(from) {
+		return
+	}
+
+	exported, err := e.export(ctx, job, from, to)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job.state.LastRunAt = now
+	if err != nil {
+		exportJobFailures.With(job.Name).Inc()
+		job.state.LastError = err.Error()
+		logger.Error("export job failed", "job", job.Name, "from", from, "to", to, "error", err)
+		return
+	}
+	exportJobRuns.With(job.Name).Inc()
+	exportJobLogs.With(job.Name).Add(int64(exported))
+	job.state.Checkpoint, job.state.Exported, job.state.LastError = to, exported, ""
+	if err := e.saveLocked(); err != nil {
+		logger.Error("saving export checkpoints failed", "error", err)
+	}
+	logger.Info("export job ran", "job", job.Name, "from", from, "to", to, "logs", exported)
+}
+
+// export writes the matching logs of [from, to) in parts of exportJobPartSize
+// and returns how many were written
+func (e *Exporter) export(ctx context.Context, job *exportJob, from, to time.Time) (int, error) {
+	filters := maps.Clone(job.req.Filters)
+	if !from.IsZero() {
+		filters["timestamp_from"] = from.Format(time.RFC3339Nano)
+	}
+	filters["timestamp_to"] = to.Add(-time.Nanosecond).Format(time.RFC3339Nano)
+	opts := job.req.Options
+	opts.Limit = 0
+	opts.Sort = []SortKey{{Field: "timestamp"}}
+	opts.Serves = e.serves
+	results, err := job.tenant.storage.Select(ctx, filters, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var part []Log
+	parts := 0
+	results.Each(func(log Log) bool {
+		part = append(part, log)
+		if len(part) < exportJobPartSize {
+			return true
+		}
+		parts++
+		err = e.upload(job, to, parts, part)
+		part = part[:0]
+		return err == nil
+	})
+	if err == nil && len(part) > 0 {
+		parts++
+		err = e.upload(job, to, parts, part)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return results.Len(), nil
+}
+
+// upload writes one part of a run as the object
+// <prefix><tenant>/<job>/<yyyy>/<mm>/<dd>/<to>[-<node>]-<part>.ndjson.gz or .parquet
+func (e *Exporter) upload(job *exportJob, to time.Time, part int, logs []Log) error {
+	var buf bytes.Buffer
+	contentType, extension := "application/gzip", "ndjson.gz"
+	if job.Format == ExportParquet {
+		contentType, extension = exportContentTypes[ExportParquet], "parquet"
+		pw := NewParquetWriter(&buf, exportColumns(nil))
+		for _, log := range logs {
+			pw.Write(log)
+		}
+		if err := pw.Close(); err != nil {
+			return err
+		}
+	} else {
+		gz := gzip.NewWriter(&buf)
+		encoder := json.NewEncoder(gz)
+		for _, log := range logs {
+			if err := encoder.Encode(log); err != nil {
+				return err
+			}
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := to.Format("20060102T150405.000000000Z")
+	if e.node != "" {
+		name += "-" + e.node
+	}
+	key := fmt.Sprintf("%s%s/%s/%s/%s-%d.%s", job.Prefix, job.tenant.ID, job.Name, to.Format("2006/01/02"), name, part, extension)
+	return job.s3.PutObject(key, contentType, buf.Bytes())
+}
+
+// saveLocked replaces the checkpoint file with the checkpoints of every job
+func (e *Exporter) saveLocked() error {
+	checkpoints := make(map[string]time.Time, len(e.jobs))
+	for _, job := range e.jobs {
+		if !job.state.Checkpoint.IsZero() {
+			checkpoints[job.Name] = job.state.Checkpoint
+		}
+	}
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
+
+// States returns the state of every job of a tenant, in file order
+func (e *Exporter) States(tenant *Tenant) []ExportJobState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	states := []ExportJobState{}
+	for _, job := range e.jobs {
+		if job.tenant == tenant {
+			states = append(states, job.state)
+		}
+	}
+
+	return states
+}
+
+// ExportJobList is the body of GET /exports
+type ExportJobList struct {
+	Jobs []ExportJobState `json:"jobs"`
+}
+
+// handleExportJobs lists the export jobs of the request's tenant with their state
+func (s *Server) handleExportJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.exports == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Export jobs are disabled (no export-jobs-file)", nil)
+		return
+	}
+
+	response, err := json.Marshal(ExportJobList{Jobs: s.exports.States(s.tenant(r.Context()))})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,287 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Fluentd forward protocol (msgpack over TCP) input for Fluent Bit and Fluentd agents
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	forwardMessages = metrics.Counter("logingestor_forward_messages_total", "Forward protocol messages received")
+	forwardRecords  = metrics.Counter("logingestor_forward_records_total", "Forward protocol records received")
+	forwardRejected = metrics.Counter("logingestor_forward_rejected_total", "Forward protocol messages or records that could not be decoded, validated or stored")
+)
+
+// ForwardListener accepts Fluentd forward protocol connections. Every mode is
+// supported (Message, Forward, PackedForward and CompressedPackedForward) and
+// chunks are acknowledged once stored. Shared-key authentication and the UDP
+// heartbeat are not.
+type ForwardListener struct {
+	tcp             net.Listener
+	ingest          func(logs []Log) error
+	validator       Validator
+	reject          rejectFunc
+	maxMessage      int64 // bytes a message may take
+	maxDecompressed int64 // bytes a CompressedPackedForward message may inflate to
+}
+
+// ListenForward binds the TCP socket for addr, e.g. ":24224"; valid records are
+// passed to ingest
+func ListenForward(addr string, ingest func(logs []Log) error, validator Validator, reject rejectFunc, maxMessage, maxDecompressed int64) (*ForwardListener, error) {
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForwardListener{tcp: tcp, ingest: ingest, validator: validator, reject: reject, maxMessage: maxMessage, maxDecompressed: maxDecompressed}, nil
+}
+
+// Serve accepts connections until ctx is cancelled and open connections are closed
+func (fl *ForwardListener) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		fl.tcp.Close()
+	}()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := fl.tcp.Accept()
+		if err != nil {
+			return
+		}
+		conns.Go(func() {
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			fl.serveConn(conn)
+		})
+	}
+}
+
+// serveConn handles the messages of one connection; a message that cannot be
+// decoded ends it, since the stream cannot be resynchronized
+func (fl *ForwardListener) serveConn(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	decoder := newMsgpackDecoder(bufio.NewReader(conn), fl.maxMessage)
+	for {
+		value, err := decoder.Decode()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				forwardRejected.Inc()
+				logger.Warn("forward connection failed", "host", host, "error", err)
+			}
+			return
+		}
+		forwardMessages.Inc()
+
+		chunk, err := fl.handle(value, host)
+		if err != nil {
+			forwardRejected.Inc()
+			logger.Warn("forward message rejected", "host", host, "error", err)
+			return
+		}
+		if chunk != "" {
+			// {"ack": chunk} tells the client the chunk is stored
+			ack := appendMsgpackString(appendMsgpackString([]byte{0x81}, "ack"), chunk)
+			if _, err := conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle stores the records of one message and returns the chunk id to
+// acknowledge, empty when the client asked for none or storing failed
+func (fl *ForwardListener) handle(value interface{}, sender string) (chunk string, err error) {
+	message, ok := value.([]interface{})
+	if !ok || len(message) < 2 {
+		return "", errors.New("message is not an array of a tag and entries")
+	}
+	tag, ok := message[0].(string)
+	if !ok {
+		return "", errors.New("tag is not a string")
+	}
+
+	entries, option, err := fl.entries(message)
+	if err != nil {
+		return "", err
+	}
+	if signal, ok := option["fluent_signal"].(int64); ok && signal != 0 {
+		return "", nil // metrics and traces of Fluent Bit are not logs
+	}
+
+	var valid []Log
+	for _, entry := range entries {
+		forwardRecords.Inc()
+		log, err := forwardToLog(tag, entry)
+		if err != nil {
+			forwardRejected.Inc()
+			payload, _ := json.Marshal(entry)
+			fl.reject("forward", sender, err.Error(), payload)
+			continue
+		}
+		if err := fl.validator.Validate(&log); err != nil {
+			forwardRejected.Inc()
+			payload, _ := json.Marshal(log)
+			fl.reject("forward", sender, rejectionReason(err), payload)
+			continue
+		}
+		valid = append(valid, log)
+	}
+
+	if len(valid) > 0 {
+		if err := fl.ingest(valid); err != nil {
+			forwardRejected.Add(int64(len(valid)))
+			logger.Error("storing forwarded logs failed", "logs", len(valid), "error", err)
+			return "", nil // not acknowledged, so the client sends the chunk again
+		}
+	}
+	chunk, _ = option["chunk"].(string)
+
+	return chunk, nil
+}
+
+// entries returns the [time, record] entries and the option of a message in any mode:
+// Message [tag, time, record, option?], Forward [tag, [[time, record], ...], option?]
+// or PackedForward [tag, entries as msgpack stream, option?], gzip-compressed when
+// option.compressed is "gzip"
+func (fl *ForwardListener) entries(message []interface{}) (entries [][]interface{}, option map[string]interface{}, err error) {
+	optionAt := 3
+	switch message[1].(type) {
+	case []interface{}, string, []byte:
+		optionAt = 2
+	}
+	if len(message) > optionAt {
+		option, _ = message[optionAt].(map[string]interface{})
+	}
+	compressed := option["compressed"] == "gzip"
+
+	switch events := message[1].(type) {
+	case []interface{}:
+		for _, event := range events {
+			entry, ok := event.([]interface{})
+			if !ok || len(entry) < 2 {
+				return nil, nil, errors.New("Forward mode entry is not [time, record]")
+			}
+			entries = append(entries, entry)
+		}
+	case string:
+		entries, err = fl.unpack([]byte(events), compressed)
+	case []byte:
+		entries, err = fl.unpack(events, compressed)
+	default:
+		if len(message) < 3 {
+			return nil, nil, errors.New("Message mode entry has no record")
+		}
+		entries = [][]interface{}{{message[1], message[2]}}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, option, nil
+}
+
+// unpack decodes the [time, record] stream of a PackedForward message
+func (fl *ForwardListener) unpack(packed []byte, compressed bool) ([][]interface{}, error) {
+	var reader msgpackReader = bytes.NewReader(packed)
+	if compressed {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("CompressedPackedForward: %v", err)
+		}
+		defer zr.Close()
+		reader = bufio.NewReader(io.LimitReader(zr, fl.maxDecompressed))
+	}
+
+	var entries [][]interface{}
+	decoder := newMsgpackDecoder(reader, fl.maxMessage)
+	for {
+		value, err := decoder.Decode()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("PackedForward entries: %v", err)
+		}
+		entry, ok := value.([]interface{})
+		if !ok || len(entry) < 2 {
+			return nil, errors.New("PackedForward entry is not [time, record]")
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// forwardToLog maps a [time, record] entry to a log entry. The tag is the resourceId
+// unless the record has one; other fields are read like bulk API documents.
+func forwardToLog(tag string, entry []interface{}) (Log, error) {
+	record, ok := entry[1].(map[string]interface{})
+	if !ok {
+		return Log{}, errors.New("record is not a map")
+	}
+	eventTime := entry[0]
+	if header, ok := eventTime.([]interface{}); ok && len(header) > 0 {
+		eventTime = header[0] // [[time, metadata], record] of newer Fluent Bit versions
+	}
+	timestamp, err := forwardTime(eventTime)
+	if err != nil {
+		return Log{}, err
+	}
+
+	log := Log{
+		Level:      docField(record, docLevelFields),
+		Message:    docField(record, docMessageFields),
+		ResourceID: docField(record, []string{"resourceId"}),
+		Timestamp:  timestamp,
+		TraceID:    docField(record, docTraceFields),
+		SpanID:     docField(record, docSpanFields),
+		Commit:     docField(record, []string{"commit"}),
+		Metadata:   docMetadata(record),
+		Custom:     docCustom(record),
+	}
+	if log.Level == "" {
+		log.Level = "info"
+	}
+	if log.ResourceID == "" {
+		log.ResourceID = tag
+	}
+
+	return log, nil
+}
+
+// forwardTime decodes an event time: seconds as an integer or float, or the
+// EventTime extension (type 0: 32-bit seconds and nanoseconds)
+func forwardTime(value interface{}) (time.Time, error) {
+	switch t := value.(type) {
+	case int64:
+		return time.Unix(t, 0).UTC(), nil
+	case uint64:
+		return time.Unix(int64(t), 0).UTC(), nil
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	case msgpackExt:
+		if t.Type == 0 && len(t.Data) == 8 {
+			return time.Unix(int64(binary.BigEndian.Uint32(t.Data)), int64(binary.BigEndian.Uint32(t.Data[4:]))).UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid event time %v", value)
+}
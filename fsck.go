@@ -0,0 +1,113 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Checksums of storage files and the corruption check of GET /admin/fsck
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"hash/crc32"
+	"net/http"
+	"time"
+)
+
+// maxFsckCorruptions bounds the corruptions a report lists; all are counted
+const maxFsckCorruptions = 100
+
+// crcTable is the CRC-32C table checksumming WAL records and cold tier blocks
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var corruptRecords = metrics.CounterVec("logingestor_corrupt_records_total", "Corrupt WAL records and cold tier blocks skipped, by tier", "tier")
+
+// Corruption is a damaged WAL record or cold tier block
+type Corruption struct {
+	Tier   string `json:"tier"` // wal or cold
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+}
+
+// reportCorruption logs and counts a corruption skipped while reading storage
+func reportCorruption(c Corruption) {
+	logger.Warn("skipping corrupt "+c.Tier+" data", "file", c.File, "offset", c.Offset, "error", c.Error)
+	corruptRecords.With(c.Tier).Inc()
+}
+
+// FsckReport is the body of GET /admin/fsck: what a check of the tenant's storage
+// files read and the corruptions it found
+type FsckReport struct {
+	Tenant      string       `json:"tenant"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  time.Time    `json:"finished_at"`
+	Files       int          `json:"files"`
+	Records     int          `json:"records"`     // WAL records and logs of cold blocks read
+	Blocks      int          `json:"blocks"`      // cold tier blocks read
+	Corrupt     int          `json:"corrupt"`     // corrupt records and blocks
+	Corruptions []Corruption `json:"corruptions"` // the first of them
+}
+
+// add records a corruption found by the check
+func (report *FsckReport) add(c Corruption) {
+	report.Corrupt++
+	if len(report.Corruptions) < maxFsckCorruptions {
+		report.Corruptions = append(report.Corruptions, c)
+	}
+}
+
+// Checker is implemented by a Storage whose files can be checked for corruption
+type Checker interface {
+	// Fsck reads every stored file, adding what it finds to report
+	Fsck(ctx context.Context, report *FsckReport) error
+}
+
+// Fsck reads the files of the storage engine, when it supports it, and every
+// block of the cold tier, verifying their checksums and decoding them
+func (ls *LogStorage) Fsck(ctx context.Context) (FsckReport, error) {
+	report := FsckReport{StartedAt: time.Now().UTC(), Corruptions: []Corruption{}}
+	if checker, ok := ls.store.(Checker); ok {
+		if err := checker.Fsck(ctx, &report); err != nil {
+			return report, err
+		}
+	}
+	if ls.cold != nil {
+		ls.mu.RLock()
+		segments := make([]*coldSegment, len(ls.cold.segments))
+		copy(segments, ls.cold.segments)
+		for _, seg := range segments {
+			seg.acquire()
+		}
+		ls.mu.RUnlock()
+
+		for _, seg := range segments {
+			if ctx.Err() == nil {
+				seg.fsck(&report)
+			}
+			seg.release()
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report, ctx.Err()
+}
+
+// handleFsck checks the storage files of the request's tenant and summarizes the
+// corruptions found; it runs until done or the client disconnects
+func (s *Server) handleFsck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	tenant := s.tenant(r.Context())
+
+	report, err := tenant.storage.Fsck(r.Context())
+	if err != nil {
+		writeInternalError(w, "Error checking storage files")
+		return
+	}
+	report.Tenant = tenant.ID
+	if report.Corrupt > 0 {
+		logger.Warn("fsck found corruption", "tenant", tenant.ID, "corrupt", report.Corrupt)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
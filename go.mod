@@ -0,0 +1,3 @@
+module github.com/latharani23/DYTE_SDE_INTERN
+
+go 1.25
@@ -0,0 +1,481 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : gRPC ingestion and query service (proto/logingestor.proto) served over HTTP/2
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcServicePrefix is the path prefix of the LogIngestor service methods
+const grpcServicePrefix = "/logingestor.v1.LogIngestor/"
+
+// grpcStreamBatchSize is how many streamed logs are stored together
+const grpcStreamBatchSize = 500
+
+// gRPC status codes used by the service
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcMethodScopes is the API key scope each method requires
+var grpcMethodScopes = map[string]string{"Ingest": ScopeWrite, "IngestStream": ScopeWrite, "Query": ScopeRead, otlpGRPCMethod: ScopeWrite}
+
+// grpcAuthCodes maps authentication and tenant failures to gRPC status codes
+var grpcAuthCodes = map[int]int{
+	http.StatusNotFound:        grpcNotFound,
+	http.StatusUnauthorized:    grpcUnauthenticated,
+	http.StatusForbidden:       grpcPermissionDenied,
+	http.StatusTooManyRequests: grpcResourceExhausted,
+}
+
+// grpcError is an error carrying a gRPC status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string { return e.message }
+
+// grpcErrorf creates a grpcError with a formatted message
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// GRPCHandler returns the handler serving the gRPC LogIngestor service and the OTLP logs service
+func (s *Server) GRPCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			writeGRPCResponse(w, nil, grpcErrorf(grpcUnimplemented, "expected a gRPC request"))
+			return
+		}
+
+		method := strings.TrimPrefix(r.URL.Path, grpcServicePrefix)
+		if scope, ok := grpcMethodScopes[method]; ok {
+			p, err := s.auth.authenticate(r, scope)
+			if err != nil {
+				writeGRPCResponse(w, nil, grpcErrorf(grpcAuthCodes[err.status], "%s", err.message))
+				return
+			}
+			tenant, err := s.tenants.resolve(r, p)
+			if err != nil {
+				writeGRPCResponse(w, nil, grpcErrorf(grpcAuthCodes[err.status], "%s", err.message))
+				return
+			}
+
+			ctx := withTenant(r.Context(), tenant)
+			if p != nil {
+				ctx = withPrincipal(ctx, p)
+			}
+			r = r.WithContext(ctx)
+		}
+
+		start := time.Now()
+		var audit *auditRecord
+		if method == "Query" && s.audit != nil {
+			r, audit = startAudit(r)
+		}
+		var response []byte
+		var err error
+		switch method {
+		case "Ingest":
+			response, err = s.grpcIngest(r)
+		case "IngestStream":
+			response, err = s.grpcIngestStream(r)
+		case "Query":
+			response, err = s.grpcQuery(r)
+		case otlpGRPCMethod:
+			response, err = s.grpcOTLPExport(r)
+		default:
+			err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+		}
+
+		writeGRPCResponse(w, response, err)
+		if audit != nil {
+			s.finishAudit(r, audit, start, grpcCode(err))
+		}
+		attrs := []slog.Attr{slog.String("method", method), slog.String("tenant", s.tenant(r.Context()).ID), slog.Duration("duration", time.Since(start))}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "grpc request", attrs...)
+	})
+}
+
+// readGRPCMessage reads one length-prefixed gRPC message, returning io.EOF at the end of the stream
+func (s *Server) readGRPCMessage(r *http.Request) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > s.cfg.MaxBodySize {
+		return nil, grpcErrorf(grpcInvalidArgument, "message of %d bytes exceeds %d", length, s.cfg.MaxBodySize)
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r.Body, message); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+
+	if prefix[0] == 1 {
+		if r.Header.Get("Grpc-Encoding") != "gzip" {
+			return nil, grpcErrorf(grpcUnimplemented, "unsupported message encoding %q", r.Header.Get("Grpc-Encoding"))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(message))
+		if err != nil {
+			return nil, grpcErrorf(grpcInvalidArgument, "decompressing message: %v", err)
+		}
+		message, err = io.ReadAll(io.LimitReader(zr, s.cfg.MaxBodySize+1))
+		if err != nil || int64(len(message)) > s.cfg.MaxBodySize {
+			return nil, grpcErrorf(grpcInvalidArgument, "decompressing message failed or exceeds %d bytes", s.cfg.MaxBodySize)
+		}
+	}
+
+	return message, nil
+}
+
+// readGRPCUnary reads the single request message of a unary call
+func (s *Server) readGRPCUnary(r *http.Request) ([]byte, error) {
+	message, err := s.readGRPCMessage(r)
+	if err == io.EOF {
+		return nil, grpcErrorf(grpcInvalidArgument, "missing request message")
+	}
+
+	return message, err
+}
+
+// grpcCode returns the gRPC status code of an error, grpcOK for nil
+func grpcCode(err error) int {
+	if err == nil {
+		return grpcOK
+	}
+	var gerr *grpcError
+	if errors.As(err, &gerr) {
+		return gerr.code
+	}
+	return grpcInternal
+}
+
+// writeGRPCResponse writes the response message, if any, followed by the status trailers
+func writeGRPCResponse(w http.ResponseWriter, message []byte, err error) {
+	code, text := grpcCode(err), ""
+	if err != nil {
+		text = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	if err == nil {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+		w.Write(prefix[:])
+		w.Write(message)
+	}
+
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(text))
+}
+
+// grpcIngest handles LogIngestor/Ingest
+func (s *Server) grpcIngest(r *http.Request) ([]byte, error) {
+	message, err := s.readGRPCUnary(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []Log
+	err = parseProto(message, func(f protoField) error {
+		if f.Num != 1 {
+			return nil
+		}
+		log, err := decodeProtoLog(f.Bytes)
+		logs = append(logs, log)
+		return err
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "decoding IngestRequest: %v", err)
+	}
+
+	var result grpcIngestResult
+	if err := s.grpcStore(r.Context(), result.validate(s.validator, logs, 0)); err != nil {
+		return nil, err
+	}
+
+	return result.encode(), nil
+}
+
+// grpcStore admits and stores validated logs, failing with a gRPC status
+func (s *Server) grpcStore(ctx context.Context, logs []Log) error {
+	logs, err := s.admit(ctx, "grpc", logs)
+	if err != nil {
+		return grpcErrorf(grpcResourceExhausted, "%v", err)
+	}
+	if _, err := s.store(ctx, s.tenant(ctx), logs); err != nil {
+		var qerr *QuotaError
+		var nerr *nodeError
+		switch {
+		case errors.As(err, &qerr):
+			return grpcErrorf(grpcResourceExhausted, "%v", err)
+		case errors.Is(err, errQueueFull):
+			return grpcErrorf(grpcUnavailable, "Ingest queue is full, retry later")
+		case errors.As(err, &nerr):
+			return grpcErrorf(grpcUnavailable, "Cluster node %s could not store its logs, retry later", nerr.node.ID)
+		}
+		return grpcErrorf(grpcInternal, "Error storing logs")
+	}
+	return nil
+}
+
+// grpcIngestStream handles the client-streaming LogIngestor/IngestStream, storing
+// logs in batches as they arrive
+func (s *Server) grpcIngestStream(r *http.Request) ([]byte, error) {
+	var result grpcIngestResult
+	var pending []Log
+	for index := 0; ; index++ {
+		message, err := s.readGRPCMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		log, err := decodeProtoLog(message)
+		if err != nil {
+			return nil, grpcErrorf(grpcInvalidArgument, "decoding Log %d: %v", index, err)
+		}
+
+		pending = append(pending, result.validate(s.validator, []Log{log}, index)...)
+		if len(pending) >= grpcStreamBatchSize {
+			if err := s.grpcStore(r.Context(), pending); err != nil {
+				return nil, err
+			}
+			pending = pending[:0]
+		}
+	}
+
+	if err := s.grpcStore(r.Context(), pending); err != nil {
+		return nil, err
+	}
+
+	return result.encode(), nil
+}
+
+// grpcQuery handles LogIngestor/Query by translating the request into the JSON
+// query fields, so both APIs share the same parsing and validation
+func (s *Server) grpcQuery(r *http.Request) ([]byte, error) {
+	message, err := s.readGRPCUnary(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	set := func(key string, value interface{}) {
+		raw, _ := json.Marshal(value)
+		fields[key] = raw
+	}
+	err = parseProto(message, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			var key, value string
+			err := parseProto(f.Bytes, func(entry protoField) error {
+				switch entry.Num {
+				case 1:
+					key = entry.String()
+				case 2:
+					value = entry.String()
+				}
+				return nil
+			})
+			set(key, value)
+			return err
+		case 2:
+			set("limit", f.Int64())
+		case 3:
+			set("offset", f.Int64())
+		case 4:
+			set("page_token", f.String())
+		case 5:
+			set("sort", f.String())
+		case 6:
+			set("q", f.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "decoding QueryRequest: %v", err)
+	}
+	auditQuery(r.Context(), fields)
+
+	req, err := buildQuery(fields, s.cfg.MaxPageSize)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		return nil, grpcErrorf(grpcPermissionDenied, "%v", err)
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var logs []Log
+	var more bool
+	if s.cluster != nil {
+		var body []byte
+		if body, err = peerQueryBody(fields); err != nil {
+			return nil, grpcErrorf(grpcInternal, "encoding the query: %v", err)
+		}
+		logs, more, err = s.clusterLogs(ctx, r, body, &req)
+	} else {
+		logs, more, err = s.tenant(r.Context()).storage.Query(ctx, req.Filters, req.Options)
+	}
+	var nerr *nodeError
+	if errors.Is(err, context.DeadlineExceeded) {
+		queriesStopped.With("timeout").Inc()
+		return nil, grpcErrorf(grpcDeadlineExceeded, "the query did not complete within query-timeout")
+	} else if errors.Is(err, context.Canceled) {
+		queriesStopped.With("cancelled").Inc()
+		return nil, grpcErrorf(grpcCanceled, "%v", err)
+	} else if errors.As(err, &nerr) {
+		return nil, grpcErrorf(grpcUnavailable, "Cluster node %s is unavailable", nerr.node.ID)
+	} else if err != nil {
+		return nil, grpcErrorf(grpcInternal, "reading stored logs: %v", err)
+	}
+	auditResults(r.Context(), len(logs))
+
+	var e protoEncoder
+	for _, log := range logs {
+		e.message(1, func(m *protoEncoder) { encodeProtoLog(m, log) })
+	}
+	if more {
+		e.string(2, encodePageToken(req.Options.Offset+len(logs)))
+	}
+
+	return e.buf, nil
+}
+
+// grpcIngestResult accumulates an IngestResponse
+type grpcIngestResult struct {
+	accepted   int
+	rejections []BatchResult
+}
+
+// validate returns the valid logs, recording the rejected ones; first is the
+// stream index of logs[0]
+func (res *grpcIngestResult) validate(v Validator, logs []Log, first int) []Log {
+	var valid []Log
+	for i, log := range logs {
+		if err := v.Validate(&log); err != nil {
+			res.rejections = append(res.rejections, BatchResult{Index: first + i, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, log)
+		res.accepted++
+	}
+
+	return valid
+}
+
+// encode returns the IngestResponse message
+func (res *grpcIngestResult) encode() []byte {
+	var e protoEncoder
+	e.int64(1, int64(res.accepted))
+	e.int64(2, int64(len(res.rejections)))
+	for _, rejection := range res.rejections {
+		e.message(3, func(m *protoEncoder) {
+			m.int64(1, int64(rejection.Index))
+			m.string(2, rejection.Error)
+		})
+	}
+
+	return e.buf
+}
+
+// encodeProtoLog writes the fields of a Log message
+func encodeProtoLog(e *protoEncoder, log Log) {
+	e.string(1, log.Level)
+	e.string(2, log.Message)
+	e.string(3, log.ResourceID)
+	e.timestamp(4, log.Timestamp)
+	e.string(5, log.TraceID)
+	e.string(6, log.SpanID)
+	e.string(7, log.Commit)
+	if log.Metadata.ParentResourceID != "" {
+		e.message(8, func(m *protoEncoder) { m.string(1, log.Metadata.ParentResourceID) })
+	}
+	if !log.IngestedAt.IsZero() {
+		e.timestamp(9, log.IngestedAt)
+	}
+}
+
+// decodeProtoLog decodes a Log message
+func decodeProtoLog(b []byte) (Log, error) {
+	var log Log
+	err := parseProto(b, func(f protoField) error {
+		var err error
+		switch f.Num {
+		case 1:
+			log.Level = f.String()
+		case 2:
+			log.Message = f.String()
+		case 3:
+			log.ResourceID = f.String()
+		case 4:
+			log.Timestamp, err = parseProtoTimestamp(f.Bytes)
+		case 5:
+			log.TraceID = f.String()
+		case 6:
+			log.SpanID = f.String()
+		case 7:
+			log.Commit = f.String()
+		case 8:
+			err = parseProto(f.Bytes, func(m protoField) error {
+				if m.Num == 1 {
+					log.Metadata.ParentResourceID = m.String()
+				}
+				return nil
+			})
+		}
+		return err
+	})
+
+	return log, err
+}
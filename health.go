@@ -0,0 +1,119 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Liveness and readiness endpoints for Kubernetes probes and load balancers
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// queueSaturation is the fraction of the ingest queue in use at which the
+// instance reports itself not ready, so load balancers send ingests elsewhere
+const queueSaturation = 0.9
+
+// HealthChecker is implemented by a Storage that can fail after opening, such
+// as a WAL whose fsync failed
+type HealthChecker interface {
+	// Healthy returns why the storage can no longer accept writes, nil if it can
+	Healthy() error
+}
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status  string `json:"status"` // ok or fail
+	Message string `json:"message,omitempty"`
+	Logs    *int   `json:"logs,omitempty"`
+	Depth   *int64 `json:"depth,omitempty"`
+	Size    *int   `json:"size,omitempty"`
+}
+
+// HealthResponse is the response body of /healthz and /readyz
+type HealthResponse struct {
+	Status        string                 `json:"status"` // ok, ready or not_ready
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	Checks        map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// handleHealthz reports that the process is alive and serving requests
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	writeHealth(w, http.StatusOK, HealthResponse{Status: "ok", UptimeSeconds: s.uptime()})
+}
+
+// handleReadyz reports whether the instance should receive traffic: every tenant's
+// storage is open and writable, its logs replayed, and the ingest queue not
+// saturated. Any failing check answers 503.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	checks := make(map[string]HealthCheck)
+	ready := true
+	check := func(name string, result HealthCheck) {
+		if result.Status != "ok" {
+			ready = false
+		}
+		checks[name] = result
+	}
+
+	storage := HealthCheck{Status: "ok"}
+	replayed := 0
+	for _, tenant := range s.tenants.All() {
+		replayed += tenant.storage.replayed
+		if checker, ok := tenant.storage.store.(HealthChecker); ok {
+			if err := checker.Healthy(); err != nil && storage.Status == "ok" {
+				storage = HealthCheck{Status: "fail", Message: fmt.Sprintf("tenant %s: %v", tenant.ID, err)}
+			}
+		}
+	}
+	check("storage", storage)
+	// Storage is replayed before the server starts listening, so a served request
+	// always finds the replay complete
+	check("wal_replay", HealthCheck{Status: "ok", Logs: &replayed})
+
+	if s.queue != nil {
+		depth, size := s.queue.Depth(), s.queue.Size()
+		queue := HealthCheck{Status: "ok", Depth: &depth, Size: &size}
+		if float64(depth) >= queueSaturation*float64(size) {
+			queue.Status, queue.Message = "fail", "ingest queue is saturated"
+		}
+		check("ingest_queue", queue)
+	}
+
+	response := HealthResponse{Status: "ready", UptimeSeconds: s.uptime(), Checks: checks}
+	status := http.StatusOK
+	if !ready {
+		response.Status, status = "not_ready", http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, response)
+}
+
+// uptime returns the whole seconds since the server was created
+func (s *Server) uptime() int64 {
+	return int64(time.Since(s.started) / time.Second)
+}
+
+func writeHealth(w http.ResponseWriter, status int, response HealthResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,274 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Splunk HTTP Event Collector endpoint (/services/collector/event) for apps shipping to HEC
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	hecEvents   = metrics.Counter("logingestor_hec_events_total", "Splunk HEC events received")
+	hecRejected = metrics.Counter("logingestor_hec_rejected_total", "Splunk HEC events rejected")
+)
+
+// Splunk HEC status codes of the response bodies
+const (
+	hecSuccess       = 0
+	hecTokenRequired = 2
+	hecInvalidToken  = 4
+	hecNoData        = 5
+	hecInvalidFormat = 6
+	hecEventRequired = 12
+	hecEventBlank    = 13
+	hecHealthy       = 17
+)
+
+var (
+	errHECEventRequired = errors.New("event field is required")
+	errHECEventBlank    = errors.New("event field cannot be blank")
+)
+
+// hecResponse is the body of every HEC answer: a text, its Splunk status code
+// and, when an event was rejected, the index of the first one in the request
+type hecResponse struct {
+	Text               string `json:"text"`
+	Code               int    `json:"code"`
+	InvalidEventNumber *int   `json:"invalid-event-number,omitempty"`
+}
+
+// hecRejection is the response to a request whose event i was rejected for err
+func hecRejection(i int, err error) hecResponse {
+	switch {
+	case errors.Is(err, errHECEventRequired):
+		return hecResponse{Text: "Event field is required", Code: hecEventRequired, InvalidEventNumber: &i}
+	case errors.Is(err, errHECEventBlank):
+		return hecResponse{Text: "Event field cannot be blank", Code: hecEventBlank, InvalidEventNumber: &i}
+	}
+	return hecResponse{Text: "Invalid data format", Code: hecInvalidFormat, InvalidEventNumber: &i}
+}
+
+// writeHECAuthError answers a failed authentication as Splunk does: 401 with
+// code 2 without a token, 403 with code 4 for a token that is unknown or may not
+// ingest, and the usual error body otherwise
+func writeHECAuthError(w http.ResponseWriter, r *http.Request, err *authError) {
+	switch {
+	case err.status == http.StatusUnauthorized && requestKey(r) == "":
+		w.Header().Set("WWW-Authenticate", `Splunk realm="logingestor"`)
+		writeJSON(w, http.StatusUnauthorized, hecResponse{Text: "Token is required", Code: hecTokenRequired})
+	case err.status == http.StatusUnauthorized || err.status == http.StatusForbidden:
+		writeJSON(w, http.StatusForbidden, hecResponse{Text: "Invalid token", Code: hecInvalidToken})
+	default:
+		writeAuthError(w, r, err)
+	}
+}
+
+// hecEvent is the envelope of an event: the event itself, a string or an
+// object, with its time in epoch seconds and the Splunk indexing keys
+type hecEvent struct {
+	Time       json.RawMessage        `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	SourceType string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Event      json.RawMessage        `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// hecTime parses the time of an envelope, epoch seconds with an optional
+// fraction, as a number or a string
+func hecTime(raw json.RawMessage) (time.Time, error) {
+	value := string(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return time.Time{}, err
+		}
+	}
+	seconds, fraction, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	var ns uint64
+	if err == nil && fraction != "" {
+		ns, err = strconv.ParseUint((fraction + "00000000")[:9], 10, 64)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %s is not epoch seconds", raw)
+	}
+	return time.Unix(sec, int64(ns)).UTC(), nil
+}
+
+// toLog maps an event onto the log schema. A string event is the message; an
+// object is read like a bulk document, its JSON being the message when it has
+// none. The envelope time wins over a timestamp in the event, host and then
+// source stand in for a missing resourceId, and a level in the fields for a
+// missing level, else info. source, sourcetype, index and the fields become
+// metadata keys.
+func (ev hecEvent) toLog(arrival time.Time) (Log, error) {
+	log := Log{Timestamp: arrival}
+	switch {
+	case len(ev.Event) == 0 || string(ev.Event) == "null":
+		return Log{}, errHECEventRequired
+	case ev.Event[0] == '"':
+		if err := json.Unmarshal(ev.Event, &log.Message); err != nil {
+			return Log{}, err
+		}
+		if strings.TrimSpace(log.Message) == "" {
+			return Log{}, errHECEventBlank
+		}
+	case ev.Event[0] == '{':
+		var doc map[string]interface{}
+		if err := json.Unmarshal(ev.Event, &doc); err != nil {
+			return Log{}, err
+		}
+		log.Level = docField(doc, docLevelFields)
+		log.Message = docField(doc, docMessageFields)
+		log.ResourceID = docField(doc, docResourceFields)
+		log.TraceID = docField(doc, docTraceFields)
+		log.SpanID = docField(doc, docSpanFields)
+		log.Commit = docField(doc, []string{"commit"})
+		log.Metadata = docMetadata(doc)
+		log.Custom = docCustom(doc)
+		if ts := docField(doc, docTimestampFields); ts != "" && len(ev.Time) == 0 {
+			t, err := time.Parse(time.RFC3339Nano, ts)
+			if err != nil {
+				return Log{}, fmt.Errorf("timestamp %q is not RFC3339", ts)
+			}
+			log.Timestamp = t
+		}
+	}
+	if log.Message == "" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, ev.Event); err != nil {
+			return Log{}, err
+		}
+		log.Message = compact.String()
+	}
+
+	if len(ev.Time) > 0 {
+		t, err := hecTime(ev.Time)
+		if err != nil {
+			return Log{}, err
+		}
+		log.Timestamp = t
+	}
+	log.Level = cmp.Or(log.Level, docField(ev.Fields, docLevelFields), "info")
+	log.ResourceID = cmp.Or(log.ResourceID, ev.Host, ev.Source)
+
+	for key, value := range ev.Fields {
+		if log.Metadata.Fields == nil {
+			log.Metadata.Fields = make(map[string]any)
+		}
+		log.Metadata.Fields[key] = value
+	}
+	for key, value := range map[string]string{"source": ev.Source, "sourcetype": ev.SourceType, "index": ev.Index} {
+		if value == "" {
+			continue
+		}
+		if log.Metadata.Fields == nil {
+			log.Metadata.Fields = make(map[string]any)
+		}
+		log.Metadata.Fields[key] = value
+	}
+	return log, nil
+}
+
+// handleHEC receives the events of a Splunk HEC client: event envelopes,
+// concatenated or one per line, with the token sent as "Authorization: Splunk
+// <token>". Valid events are stored; if any was rejected the response is a 400
+// naming the first, as Splunk answers.
+func (s *Server) handleHEC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	arrival := time.Now().UTC()
+
+	var events []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			s.rejectRequest(r, "hec", rejectionReason(err), body)
+			writeJSON(w, http.StatusBadRequest, hecRejection(len(events), err))
+			return
+		}
+		events = append(events, raw)
+	}
+	if len(events) == 0 {
+		writeJSON(w, http.StatusBadRequest, hecResponse{Text: "No data", Code: hecNoData})
+		return
+	}
+	hecEvents.Add(int64(len(events)))
+
+	var valid []Log
+	var rejection *hecResponse
+	for i, raw := range events {
+		var ev hecEvent
+		err := json.Unmarshal(raw, &ev)
+		var log Log
+		if err == nil {
+			log, err = ev.toLog(arrival)
+		}
+		if err != nil {
+			s.rejectRequest(r, "hec", rejectionReason(err), raw)
+		} else if err = s.validator.Validate(&log); err != nil {
+			// The mapped log is kept, so that reprocessing decodes it as a log entry
+			payload, _ := json.Marshal(log)
+			s.rejectRequest(r, "hec", rejectionReason(err), payload)
+		}
+		if err != nil {
+			if rejection == nil {
+				response := hecRejection(i, err)
+				rejection = &response
+			}
+			continue
+		}
+		valid = append(valid, log)
+	}
+	rejected := len(events) - len(valid)
+	hecRejected.Add(int64(rejected))
+
+	logs, err := s.admit(r.Context(), "hec", valid)
+	if err == nil {
+		_, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	if err != nil {
+		writeStoreError(w, err, "Error storing logs")
+		return
+	}
+	logAttrs(r.Context(), slog.Int("accepted", len(valid)), slog.Int("rejected", rejected))
+
+	if rejection != nil {
+		writeJSON(w, http.StatusBadRequest, *rejection)
+		return
+	}
+	writeJSON(w, http.StatusOK, hecResponse{Text: "Success", Code: hecSuccess})
+}
+
+// handleHECHealth answers the health check of HEC clients
+func handleHECHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, hecResponse{Text: "HEC is healthy", Code: hecHealthy})
+}
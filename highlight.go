@@ -0,0 +1,310 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Highlighting of the message matches of a query, as offsets or HTML-safe snippets
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Highlight modes of a query
+const (
+	HighlightOffsets = "offsets" // [start, end) byte offsets of each match in the message
+	HighlightHTML    = "html"    // escaped snippet with every match in <mark>
+)
+
+// snippetContext is how many bytes of the message an HTML snippet keeps around
+// each match, and snippetLength how many it keeps of a message without any
+const (
+	snippetContext = 40
+	snippetLength  = 2 * snippetContext
+)
+
+// Highlighter finds the parts of a message that matched a query: the "message"
+// and "message_regex" filters and the message comparisons of "q" outside NOT
+type Highlighter struct {
+	mode     string
+	terms    []string
+	patterns []*regexp.Regexp
+
+	messageTerms []string // the message filter values when its message_mode is not contains
+	messageMode  string
+}
+
+// newHighlighter collects the message terms and patterns of a query
+func newHighlighter(mode string, filters map[string]string, expr lqlNode) (*Highlighter, error) {
+	if mode != HighlightOffsets && mode != HighlightHTML {
+		return nil, fmt.Errorf("must be %s or %s", HighlightOffsets, HighlightHTML)
+	}
+
+	h := &Highlighter{mode: mode}
+	if term, ok := filters["message"]; ok {
+		h.addMessageTerm(term, filters[messageModeKey])
+	}
+	if pattern, ok := filters["message"+regexSuffix]; ok {
+		re, err := compiledPatterns.compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		h.patterns = append(h.patterns, re)
+	}
+	if err := h.collect(expr); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// collect adds the message comparisons of an LQL expression; those under NOT
+// match logs by their absence, so they have nothing to highlight
+func (h *Highlighter) collect(node lqlNode) error {
+	switch n := node.(type) {
+	case lqlAnd:
+		if err := h.collect(n.left); err != nil {
+			return err
+		}
+		return h.collect(n.right)
+	case lqlOr:
+		if err := h.collect(n.left); err != nil {
+			return err
+		}
+		return h.collect(n.right)
+	case anyOfFilter:
+		for _, value := range n.values {
+			switch n.key {
+			case "message":
+				h.addMessageTerm(value, n.messageMode)
+			case "message" + regexSuffix:
+				re, err := compiledPatterns.compile(value)
+				if err != nil {
+					return err
+				}
+				h.patterns = append(h.patterns, re)
+			}
+		}
+	case lqlCompare:
+		if n.field != "message" {
+			return nil
+		}
+		switch n.op {
+		case "~":
+			if n.value != "" {
+				h.terms = append(h.terms, n.value)
+			}
+		case "=~":
+			re, err := compiledPatterns.compile(n.value)
+			if err != nil {
+				return err
+			}
+			h.patterns = append(h.patterns, re)
+		}
+	}
+	return nil
+}
+
+// addMessageTerm adds a value of the message filter, matched in message_mode mode
+func (h *Highlighter) addMessageTerm(term, mode string) {
+	switch {
+	case term == "":
+	case mode != "" && mode != MessageContains:
+		h.messageTerms, h.messageMode = append(h.messageTerms, term), mode
+	default:
+		h.terms = append(h.terms, term)
+	}
+}
+
+// ranges returns the sorted, merged [start, end) byte ranges of the matches in a message
+func (h *Highlighter) ranges(message string) [][2]int {
+	var ranges [][2]int
+	for _, term := range h.terms {
+		for start := 0; ; {
+			i := strings.Index(message[start:], term)
+			if i < 0 {
+				break
+			}
+			ranges = append(ranges, [2]int{start + i, start + i + len(term)})
+			start += i + len(term)
+		}
+	}
+	for _, term := range h.messageTerms {
+		ranges = append(ranges, messageRanges(message, term, h.messageMode)...)
+	}
+	for _, re := range h.patterns {
+		for _, match := range re.FindAllStringIndex(message, -1) {
+			if match[1] > match[0] {
+				ranges = append(ranges, [2]int{match[0], match[1]})
+			}
+		}
+	}
+
+	slices.SortFunc(ranges, func(a, b [2]int) int { return a[0] - b[0] })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// highlight returns the "highlight" object of a log
+func (h *Highlighter) highlight(log Log) map[string]any {
+	ranges := h.ranges(log.Message)
+	if h.mode == HighlightOffsets {
+		if ranges == nil {
+			ranges = [][2]int{}
+		}
+		return map[string]any{"message": ranges}
+	}
+	return map[string]any{"message": snippet(log.Message, ranges)}
+}
+
+// snippet escapes the parts of a message around its matches, snippetContext bytes
+// on each side, marking the matches and eliding the rest with "…"
+func snippet(message string, ranges [][2]int) string {
+	if len(ranges) == 0 {
+		end := runeBoundary(message, min(len(message), snippetLength))
+		if end < len(message) {
+			return html.EscapeString(message[:end]) + "…"
+		}
+		return html.EscapeString(message)
+	}
+
+	var b strings.Builder
+	last := 0 // end of what was written
+	for i, r := range ranges {
+		start := runeBoundary(message, max(r[0]-snippetContext, 0))
+		if start > last {
+			b.WriteString("…")
+		} else {
+			start = last
+		}
+		b.WriteString(html.EscapeString(message[start:r[0]]))
+		b.WriteString("<mark>" + html.EscapeString(message[r[0]:r[1]]) + "</mark>")
+
+		end := runeBoundary(message, min(r[1]+snippetContext, len(message)))
+		if i+1 < len(ranges) && ranges[i+1][0] < end {
+			end = ranges[i+1][0]
+		}
+		b.WriteString(html.EscapeString(message[r[1]:end]))
+		last = end
+	}
+	if last < len(message) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// runeBoundary moves i back to the start of the UTF-8 sequence it falls in
+func runeBoundary(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+// annotatedLog marshals a log, or its projection, with its "highlight" and
+// "context" objects and its collapsed "count" when the query asked for them
+type annotatedLog struct {
+	log       interface{}
+	highlight map[string]any
+	context   *LogContext
+	count     int // zero unless collapsed
+}
+
+func (al annotatedLog) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(al.log)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	add := func(key string, value interface{}) error {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"` + key + `":`)
+		buf.Write(encoded)
+		return nil
+	}
+	if al.highlight != nil {
+		if err := add("highlight", al.highlight); err != nil {
+			return nil, err
+		}
+	}
+	if al.context != nil {
+		if err := add("context", al.context); err != nil {
+			return nil, err
+		}
+	}
+	if al.count > 0 {
+		if err := add("count", al.count); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// apply returns what is marshaled for a log of the query's results: its
+// projection, with the highlight object when asked for
+func (req QueryRequest) apply(log Log) interface{} {
+	return req.annotate(-1, log)
+}
+
+// annotate returns what is marshaled for the log at index i of the page, with
+// its highlight, its collapsed count and, once fetched, its context; i is -1
+// for a log of no page
+func (req QueryRequest) annotate(i int, log Log) interface{} {
+	al := annotatedLog{log: req.Fields.apply(log)}
+	if req.Highlight != nil {
+		al.highlight = req.Highlight.highlight(log)
+	}
+	if i >= 0 && i < len(req.contexts) {
+		al.context = &req.contexts[i]
+	}
+	if i >= 0 && i < len(req.counts) {
+		al.count = req.counts[i]
+	}
+	if al.highlight == nil && al.context == nil && al.count == 0 {
+		return al.log
+	}
+	return al
+}
+
+// pageApply returns apply for the logs of the page in order, so each gets its context
+func (req QueryRequest) pageApply() func(Log) interface{} {
+	i := -1
+	return func(log Log) interface{} {
+		i++
+		return req.annotate(i, log)
+	}
+}
+
+// project returns what is marshaled for a page of the query's results
+func (req QueryRequest) project(logs []Log) interface{} {
+	if req.Highlight == nil && req.contexts == nil && req.counts == nil {
+		return req.Fields.project(logs)
+	}
+	annotated := make([]interface{}, len(logs))
+	for i, log := range logs {
+		annotated[i] = req.annotate(i, log)
+	}
+	return annotated
+}
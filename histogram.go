@@ -0,0 +1,277 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Bucketed log counts over time (/query/histogram) for activity charts
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultHistogramInterval is the bucket size when a request gives no interval
+const defaultHistogramInterval = time.Hour
+
+// maxHistogramBuckets bounds the buckets of one histogram, empty ones included
+const maxHistogramBuckets = 10000
+
+// HistogramBucket is the number of matching logs in [Time, Time+interval), split
+// by the values of the group_by field when one is given
+type HistogramBucket struct {
+	Time   time.Time      `json:"time"`
+	Count  int            `json:"count"`
+	Groups map[string]int `json:"groups,omitempty"`
+}
+
+// HistogramResponse is the body of /query/histogram
+type HistogramResponse struct {
+	Interval string            `json:"interval"`
+	GroupBy  string            `json:"group_by,omitempty"`
+	Buckets  []HistogramBucket `json:"buckets"`
+}
+
+// histogramCounts holds the counts of each bucket start, in Unix nanoseconds, by
+// group value; the value is empty without group_by
+type histogramCounts map[int64]map[string]int
+
+func (hc histogramCounts) add(start int64, group string, n int) {
+	if hc[start] == nil {
+		hc[start] = make(map[string]int)
+	}
+	hc[start][group] += n
+}
+
+// Histogram counts the logs matching the filters and opts in buckets of interval,
+// cut as time.Truncate does so hour and day buckets start on UTC hours and
+// midnights, by the value of groupBy unless it is empty. When ctx or a cold tier
+// read ends the scan early, the counts so far come with the error.
+func (ls *LogStorage) Histogram(ctx context.Context, filters map[string]string, opts QueryOptions, interval time.Duration, groupBy string) (histogramCounts, error) {
+	counts := make(histogramCounts)
+	from, to := timeBounds(filters)
+	for _, sh := range ls.shardsBetween(from, to) {
+		sh, err := ls.openShard(sh, filters)
+		if err != nil {
+			return counts, err
+		}
+		sh.mu.RLock()
+		sh.scan(ctx, filters, opts.Expr, func(pos int) bool {
+			log := sh.logs[pos]
+			if !opts.allows(log) {
+				return true
+			}
+			var group string
+			if groupBy != "" {
+				group = fieldValue(log, groupBy)
+			}
+			counts.add(log.Timestamp.Truncate(interval).UnixNano(), group, 1)
+			return true
+		})
+		sh.mu.RUnlock()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return counts, ctx.Err()
+}
+
+// buckets lists the counts from the bucket of from to the bucket of to, empty
+// buckets included; a zero from or to is taken from the earliest or latest count
+func (hc histogramCounts) buckets(interval time.Duration, grouped bool, from, to time.Time) ([]HistogramBucket, error) {
+	buckets := []HistogramBucket{}
+	if len(hc) == 0 && (from.IsZero() || to.IsZero()) {
+		return buckets, nil
+	}
+
+	starts := make([]int64, 0, len(hc))
+	for start := range hc {
+		starts = append(starts, start)
+	}
+	slices.Sort(starts)
+	first, last := from.Truncate(interval).UnixNano(), to.Truncate(interval).UnixNano()
+	if from.IsZero() {
+		first = starts[0]
+	}
+	if to.IsZero() {
+		last = starts[len(starts)-1]
+	}
+	if (last-first)/int64(interval) >= maxHistogramBuckets {
+		return nil, fmt.Errorf("Invalid interval: more than %d buckets over the time range", maxHistogramBuckets)
+	}
+
+	for start := first; start <= last; start += int64(interval) {
+		bucket := HistogramBucket{Time: time.Unix(0, start).UTC()}
+		if grouped {
+			bucket.Groups = make(map[string]int)
+		}
+		for group, n := range hc[start] {
+			bucket.Count += n
+			if grouped {
+				bucket.Groups[group] += n
+			}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// handleHistogram answers a /query body (filters, min_level and "q") with the
+// number of matching logs per "interval" (default 1h), split by the values of
+// "group_by" (an indexed field such as level or resourceId) when it is given
+func (s *Server) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	auditQuery(r.Context(), fields)
+
+	interval := defaultHistogramInterval
+	if raw, ok := fields["interval"]; ok {
+		var v string
+		if err := json.Unmarshal(raw, &v); err == nil {
+			interval, err = parseDuration(v)
+		}
+		if err != nil || interval <= 0 {
+			writeValidationError(w, errors.New(`Invalid interval: expected a positive duration such as "5m" or "1d"`))
+			return
+		}
+	}
+	var groupBy string
+	if raw, ok := fields["group_by"]; ok {
+		if err := json.Unmarshal(raw, &groupBy); err != nil || !slices.Contains(indexedFields, groupBy) {
+			writeValidationError(w, fmt.Errorf("Invalid group_by: must be one of %s", strings.Join(indexedFields, ", ")))
+			return
+		}
+	}
+	query := make(map[string]json.RawMessage, len(fields))
+	for key, raw := range fields {
+		if key != "interval" && key != "group_by" {
+			query[key] = raw
+		}
+	}
+
+	req, err := buildQuery(query, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.CountOnly || req.Fields != nil || req.Highlight != nil || req.Context != nil || req.CollapseBy != "" {
+		writeValidationError(w, errors.New("limit, offset, page_token, sort, count_only, fields, highlight, context and collapse_by do not apply to a histogram"))
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+	from, to := timeBounds(req.Filters)
+	if !from.IsZero() && !to.IsZero() && int64(to.Sub(from)/interval) >= maxHistogramBuckets {
+		writeValidationError(w, fmt.Errorf("Invalid interval: more than %d buckets over the time range", maxHistogramBuckets))
+		return
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var counts histogramCounts
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		counts, err = s.clusterHistogram(ctx, r, body, req, interval, groupBy)
+		if err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+	} else {
+		opts := req.Options
+		if s.cluster != nil {
+			opts.Serves = s.cluster.serves(splitList(r.Header.Get(clusterDownHeader)))
+		}
+		counts, err = s.tenant(r.Context()).storage.Histogram(ctx, req.Filters, opts, interval, groupBy)
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{})
+			return
+		}
+	}
+
+	buckets, err := counts.buckets(interval, groupBy != "", from, to)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	total := 0
+	for _, bucket := range buckets {
+		total += bucket.Count
+	}
+	auditResults(r.Context(), total)
+	writeJSON(w, http.StatusOK, HistogramResponse{Interval: interval.String(), GroupBy: groupBy, Buckets: buckets})
+}
+
+// clusterHistogram adds up the histograms of every node; body is the request's
+// own, which peers answer from the logs they serve
+func (s *Server) clusterHistogram(ctx context.Context, r *http.Request, body []byte, req QueryRequest, interval time.Duration, groupBy string) (histogramCounts, error) {
+	var nodeCounts []histogramCounts
+	err := s.cluster.fanOut(func(down []string) error {
+		nodeCounts = make([]histogramCounts, len(s.cluster.nodes))
+		return s.cluster.eachNodeExcept(down, func(i int, node *clusterNode) (err error) {
+			if node == s.cluster.self {
+				opts := req.Options
+				opts.Serves = s.cluster.serves(down)
+				nodeCounts[i], err = s.tenant(r.Context()).storage.Histogram(ctx, req.Filters, opts, interval, groupBy)
+				return err
+			}
+
+			resp, err := s.cluster.peerQuery(ctx, node, "/query/histogram", r, body, "application/json", down)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			var histogram HistogramResponse
+			if err := json.NewDecoder(resp.Body).Decode(&histogram); err != nil {
+				return peerError(node, err)
+			}
+			nodeCounts[i] = make(histogramCounts)
+			for _, bucket := range histogram.Buckets {
+				if groupBy == "" {
+					nodeCounts[i].add(bucket.Time.UnixNano(), "", bucket.Count)
+				}
+				for group, n := range bucket.Groups {
+					nodeCounts[i].add(bucket.Time.UnixNano(), group, n)
+				}
+			}
+			return nil
+		})
+	})
+
+	counts := make(histogramCounts)
+	for _, nc := range nodeCounts {
+		for start, groups := range nc {
+			for group, n := range groups {
+				counts.add(start, group, n)
+			}
+		}
+	}
+	return counts, err
+}
@@ -0,0 +1,289 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : /import, backfilling historical logs from NDJSON or CSV with streamed progress and line errors
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// importBatchSize is how many valid logs an import stores at a time
+const importBatchSize = 1000
+
+// importProgressEvery is how many lines an import reads between progress reports
+const importProgressEvery = 10000
+
+// ImportLineError reports a line of an import that was rejected
+type ImportLineError struct {
+	Line   int             `json:"line"`
+	Error  string          `json:"error"`
+	Fields ValidationError `json:"fields,omitempty"`
+}
+
+// ImportProgress reports how far an import got; the last report has Done set,
+// and Error when the import stopped early
+type ImportProgress struct {
+	Lines    int    `json:"lines"`
+	Imported int    `json:"imported"`
+	Rejected int    `json:"rejected"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
+}
+
+// importReader yields the lines of an import one log at a time, as JSON
+type importReader interface {
+	// Next returns the next log and its line number, io.EOF after the last; an
+	// importRejection only rejects that line
+	Next() (entry []byte, line int, err error)
+}
+
+// importRejection is the reason an importReader rejects a line
+type importRejection string
+
+func (e importRejection) Error() string { return string(e) }
+
+// handleImport stores the logs of an NDJSON or CSV body (gzip-compressed or not)
+// with their own timestamps, in batches as they are read. The response streams
+// NDJSON: an ImportLineError per rejected line, an ImportProgress every
+// importProgressEvery lines and a last one with done set.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+			format = ExportCSV
+		}
+	}
+	if format != "ndjson" && format != ExportCSV {
+		writeValidationError(w, fmt.Errorf("Invalid format: %q must be ndjson or csv", format))
+		return
+	}
+
+	// Backfills can be large: only max-restore-size bounds them, not read-timeout.
+	// Progress is reported while the body is still being read.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	rc.EnableFullDuplex()
+	body := bufio.NewReader(&io.LimitedReader{R: r.Body, N: s.cfg.MaxRestoreSize})
+	if magic, _ := body.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid gzip body", err.Error())
+			return
+		}
+		defer zr.Close()
+		body = bufio.NewReader(&io.LimitedReader{R: zr, N: s.cfg.MaxRestoreSize})
+	}
+
+	var reader importReader
+	if format == ExportCSV {
+		var err error
+		if reader, err = newCSVImportReader(body); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid CSV header", err.Error())
+			return
+		}
+	} else {
+		reader = &ndjsonImportReader{reader: body, maxLine: s.cfg.MaxBodySize}
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	encoder := json.NewEncoder(w)
+	tenant := s.tenant(r.Context())
+	var progress ImportProgress
+	var batch []Log
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := s.store(r.Context(), tenant, batch); err != nil {
+			return err
+		}
+		progress.Imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		entry, line, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var rejection importRejection
+		if err != nil && !errors.As(err, &rejection) {
+			progress.Error = fmt.Sprintf("line %d: %v", line, err)
+			break
+		}
+		progress.Lines++
+
+		var log Log
+		if err != nil {
+			encoder.Encode(ImportLineError{Line: line, Error: rejection.Error()})
+			progress.Rejected++
+		} else if err := json.Unmarshal(entry, &log); err != nil {
+			s.rejectRequest(r, "import", rejectionReason(err), entry)
+			encoder.Encode(ImportLineError{Line: line, Error: "Error decoding JSON: " + err.Error()})
+			progress.Rejected++
+		} else if err := s.validator.Validate(&log); err != nil {
+			s.rejectRequest(r, "import", rejectionReason(err), entry)
+			encoder.Encode(ImportLineError{Line: line, Error: "Invalid log entry", Fields: err.(ValidationError)})
+			progress.Rejected++
+		} else if batch = append(batch, log); len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				progress.Error = "Error storing logs: " + err.Error()
+				break
+			}
+		}
+
+		if progress.Lines%importProgressEvery == 0 {
+			encoder.Encode(progress)
+			rc.Flush()
+		}
+	}
+	if progress.Error == "" {
+		if err := flush(); err != nil {
+			progress.Error = "Error storing logs: " + err.Error()
+		}
+	}
+	logAttrs(r.Context(), slog.Int("imported", progress.Imported), slog.Int("rejected", progress.Rejected))
+
+	progress.Done = true
+	encoder.Encode(progress)
+}
+
+// ndjsonImportReader reads one JSON log per line, skipping blank lines
+type ndjsonImportReader struct {
+	reader  *bufio.Reader
+	maxLine int64
+	line    int
+}
+
+func (nr *ndjsonImportReader) Next() ([]byte, int, error) {
+	for {
+		var line []byte
+		tooLong := false
+		data, err := nr.reader.ReadSlice('\n')
+		for {
+			if !tooLong {
+				line = append(line, data...)
+				tooLong = int64(len(line)) > nr.maxLine
+			}
+			if !errors.Is(err, bufio.ErrBufferFull) {
+				break
+			}
+			data, err = nr.reader.ReadSlice('\n')
+		}
+		if len(line) == 0 && err != nil {
+			return nil, nr.line, err
+		}
+
+		nr.line++
+		switch {
+		case err != nil && err != io.EOF:
+			return nil, nr.line, err
+		case tooLong:
+			return nil, nr.line, importRejection("line is longer than max-body-size")
+		}
+		if entry := bytes.TrimSpace(line); len(entry) > 0 {
+			return entry, nr.line, nil
+		}
+	}
+}
+
+// csvImportReader reads logs from CSV with a header row naming the log field of
+// each column, as /export writes it
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns []string
+}
+
+// newCSVImportReader reads the header row, which may only name log fields and
+// declared custom fields
+func newCSVImportReader(r io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := slices.Clone(header)
+	for _, column := range columns {
+		if !slices.Contains(projectableFields, column) && column != "metadata.parentResourceId" && customField(column) == nil {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+// Next turns the next row into a JSON log: metadata holds a JSON object and typed
+// custom fields are converted to numbers and booleans when they parse; empty
+// cells are left out
+func (cr *csvImportReader) Next() ([]byte, int, error) {
+	record, err := cr.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, parseErr.Line, parseErr.Err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := cr.reader.FieldPos(0)
+
+	doc := make(map[string]any, len(cr.columns))
+	for i, cell := range record {
+		if i >= len(cr.columns) || cell == "" {
+			continue
+		}
+		column := cr.columns[i]
+		switch column {
+		case "metadata":
+			if !json.Valid([]byte(cell)) {
+				return nil, line, importRejection("metadata is not valid JSON")
+			}
+			doc[column] = json.RawMessage(cell)
+			continue
+		case "metadata.parentResourceId":
+			doc["metadata"] = map[string]string{"parentResourceId": cell}
+			continue
+		}
+		doc[column] = cell
+		if field := customField(column); field != nil {
+			switch field.Type {
+			case FieldTypeNumber:
+				if _, err := strconv.ParseFloat(cell, 64); err == nil {
+					doc[column] = json.Number(cell)
+				}
+			case FieldTypeBool:
+				if value, err := strconv.ParseBool(cell); err == nil {
+					doc[column] = value
+				}
+			}
+		}
+	}
+
+	entry, err := json.Marshal(doc)
+	return entry, line, err
+}
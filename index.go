@@ -0,0 +1,123 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Per-field hash indexes so equality filters avoid a full scan of the stored logs
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+// indexedFields lists the filter keys that are resolved through the field index
+var indexedFields = []string{"level", "resourceId", "traceId", "spanId", "commit"}
+
+// fieldValue returns the value of a string field of a log entry, of a
+// metadata.<path> key or of a custom field
+func fieldValue(log Log, field string) string {
+	switch field {
+	case "message":
+		return log.Message
+	case "metadata.parentResourceId":
+		return log.Metadata.ParentResourceID
+	case "level":
+		return log.Level
+	case "resourceId":
+		return log.ResourceID
+	case "traceId":
+		return log.TraceID
+	case "spanId":
+		return log.SpanID
+	case "commit":
+		return log.Commit
+	}
+	if isMetadataPath(field) {
+		return log.Metadata.Lookup(field[len(metadataPrefix):])
+	}
+	if customField(field) != nil {
+		return formatValue(log.Custom[field])
+	}
+
+	return ""
+}
+
+// fieldIndex maps field -> value -> positions of the logs holding that value.
+// Positions are appended in ingestion order, so every posting list is sorted.
+type fieldIndex map[string]map[string][]int
+
+// newFieldIndex creates an empty index for all indexed fields
+func newFieldIndex() fieldIndex {
+	idx := make(fieldIndex, len(indexedFields))
+	for _, field := range indexedFields {
+		idx[field] = make(map[string][]int)
+	}
+
+	return idx
+}
+
+// add records the log entry stored at position pos
+func (idx fieldIndex) add(log Log, pos int) {
+	for _, field := range indexedFields {
+		value := fieldValue(log, field)
+		idx[field][value] = append(idx[field][value], pos)
+	}
+}
+
+// lookup intersects the posting lists of every indexed equality filter.
+// ok is false when none of the filters is indexed and a full scan is needed.
+func (idx fieldIndex) lookup(filters map[string]string) (positions []int, ok bool) {
+	for _, field := range indexedFields {
+		value, present := filters[field]
+		if !present {
+			continue
+		}
+
+		postings := idx[field][value]
+		if !ok {
+			positions, ok = postings, true
+		} else {
+			positions = intersect(positions, postings)
+		}
+
+		if len(positions) == 0 {
+			return nil, true
+		}
+	}
+
+	return positions, ok
+}
+
+// subtract returns the positions of the sorted list a that are not in b
+func subtract(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	var result []int
+	j := 0
+	for _, pos := range a {
+		for j < len(b) && b[j] < pos {
+			j++
+		}
+		if j == len(b) || b[j] != pos {
+			result = append(result, pos)
+		}
+	}
+
+	return result
+}
+
+// intersect returns the positions present in both sorted lists
+func intersect(a, b []int) []int {
+	var result []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
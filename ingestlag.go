@@ -0,0 +1,54 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Server-assigned ingestion time of every log and the delivery lag of each ingest source
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import "time"
+
+var (
+	ingestLagLogs    = metrics.CounterVec("logingestor_ingest_lag_logs_total", "Logs stamped with their ingestion time", "source")
+	ingestLagSum     = metrics.CounterVec("logingestor_ingest_lag_milliseconds_total", "Total time between the timestamp and the ingestion of logs delivered after their timestamp", "source")
+	ingestLagMax     = metrics.GaugeVec("logingestor_ingest_lag_max_milliseconds", "Largest lag of the last batch of logs received", "source")
+	ingestDelayed    = metrics.CounterVec("logingestor_ingest_delayed_logs_total", "Logs ingested more than ingest-lag-threshold after their timestamp", "source")
+	ingestClockAhead = metrics.CounterVec("logingestor_ingest_clock_ahead_logs_total", "Logs timestamped more than ingest-lag-threshold after they were ingested", "source")
+)
+
+// stampIngested sets the ingestion time of logs received from source, replacing
+// any the client sent, and records their lag: ingestedAt - timestamp. Logs
+// forwarded by another node or stored again by replication, snapshots or imports
+// keep the time they were given.
+func stampIngested(logs []Log, source string, threshold time.Duration) {
+	if len(logs) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	var total, largest time.Duration
+	var delayed, ahead int64
+	for i := range logs {
+		logs[i].IngestedAt = now
+		lag := now.Sub(logs[i].Timestamp)
+		switch {
+		case lag > threshold:
+			delayed++
+		case lag < -threshold:
+			ahead++
+		}
+		if lag > 0 {
+			total += lag
+		}
+		largest = max(largest, lag)
+	}
+
+	ingestLagLogs.With(source).Add(int64(len(logs)))
+	ingestLagSum.With(source).Add(total.Milliseconds())
+	ingestLagMax.With(source).Set(largest.Milliseconds())
+	if delayed > 0 {
+		ingestDelayed.With(source).Add(delayed)
+	}
+	if ahead > 0 {
+		ingestClockAhead.With(source).Add(ahead)
+	}
+}
@@ -0,0 +1,313 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Ingestion client of the Log Ingestor SDK, retrying failed sends with exponential backoff
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Package ingestor sends logs from Go programs to a Log Ingestor server: Client
+// posts them over HTTP and GRPCClient over gRPC, both with retries, and Logger
+// batches them in the background, buffering them while the server is
+// unreachable, behind an io.Writer or a slog.Handler. Import it as
+// github.com/latharani23/DYTE_SDE_INTERN/ingestor.
+package ingestor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log is a log entry in the schema of the server
+type Log struct {
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	ResourceID string         `json:"resourceId"`
+	Timestamp  time.Time      `json:"timestamp"`
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	Commit     string         `json:"commit"`
+	Metadata   map[string]any `json:"metadata"` // parentResourceId and any other keys
+}
+
+// BatchResult is the outcome of one log of a batch
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // ok or rejected
+	Error  string `json:"error,omitempty"`
+	Fields []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"fields,omitempty"`
+}
+
+// BatchResponse is the body of /ingest/batch
+type BatchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// Error is an error response of the server
+type Error struct {
+	StatusCode int
+	Code       string // e.g. validation_error or rate_limited
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed when sent again: rate
+// limits, a full ingest queue and server errors
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+}
+
+// Backoff is how failed sends are retried: after MinDelay, doubled up to MaxDelay
+// with jitter, at most Attempts times in all; a Retry-After from the server
+// replaces the delay
+type Backoff struct {
+	Attempts int // sends of a request, the first included; 0 retries until the context ends
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultBackoff sends a request up to 5 times within about 10 seconds
+var DefaultBackoff = Backoff{Attempts: 5, MinDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// delay returns the wait before the retry following attempt (1 for the first send)
+func (b Backoff) delay(attempt int, err error) time.Duration {
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	d := b.MinDelay << min(attempt-1, 30)
+	if d <= 0 || d > b.MaxDelay {
+		d = b.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// options are the settings shared by Client and GRPCClient
+type options struct {
+	apiKey  string
+	tenant  string
+	http    *http.Client
+	backoff Backoff
+}
+
+// Option configures a Client or a GRPCClient
+type Option func(*options)
+
+// WithAPIKey sends key with every request; it needs the write scope
+func WithAPIKey(key string) Option {
+	return func(o *options) { o.apiKey = key }
+}
+
+// WithTenant names the tenant of the logs, for keys not bound to one tenant
+func WithTenant(tenant string) Option {
+	return func(o *options) { o.tenant = tenant }
+}
+
+// WithHTTPClient sends the requests with hc instead of a client with a 10s
+// timeout; a GRPCClient needs one speaking HTTP/2
+func WithHTTPClient(hc *http.Client) Option {
+	return func(o *options) { o.http = hc }
+}
+
+// WithBackoff retries failed sends by b instead of DefaultBackoff
+func WithBackoff(b Backoff) Option {
+	return func(o *options) { o.backoff = b }
+}
+
+func newOptions(opts []Option) options {
+	o := options{backoff: DefaultBackoff}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// retry calls attempt until it succeeds, fails for good or runs out of the
+// attempts of b, waiting by b between attempts
+func (b Backoff) retry(ctx context.Context, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || ctx.Err() != nil || !temporary(err) {
+			return err
+		}
+		if b.Attempts > 0 && n >= b.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(b.delay(n, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// temporary reports whether a failed request may succeed when sent again: it
+// may not after an error response that is not Temporary or logs that cannot be
+// encoded
+func temporary(err error) bool {
+	var apiErr *Error
+	var valueErr *json.UnsupportedValueError
+	var typeErr *json.UnsupportedTypeError
+	var marshalerErr *json.MarshalerError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Temporary()
+	case errors.As(err, &valueErr), errors.As(err, &typeErr), errors.As(err, &marshalerErr):
+		return false
+	}
+	return true
+}
+
+// newIdempotencyKey returns a random Idempotency-Key
+func newIdempotencyKey() string {
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+}
+
+// Sender is the transport of a Logger: a *Client, over HTTP, or a *GRPCClient
+type Sender interface {
+	// sendBatch makes one attempt at storing logs; idempotencyKey is the same
+	// for every attempt of a batch
+	sendBatch(ctx context.Context, logs []Log, idempotencyKey string) (*BatchResponse, error)
+	retryDelay(attempt int, err error) time.Duration
+}
+
+// Dial returns the Sender of target: a *GRPCClient for grpc:// and grpcs://
+// addresses, a *Client for http:// and https:// URLs
+func Dial(target string, opts ...Option) Sender {
+	if strings.HasPrefix(target, "grpc://") || strings.HasPrefix(target, "grpcs://") {
+		return NewGRPC(target, opts...)
+	}
+	return New(target, opts...)
+}
+
+// Client sends logs to one Log Ingestor server over HTTP; it is safe for concurrent use
+type Client struct {
+	baseURL string
+	options
+}
+
+// New creates a client of the server at baseURL, e.g. http://localhost:3000
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), options: newOptions(opts)}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	return c
+}
+
+// Ingest stores one log (POST /ingest), retrying by the client's backoff
+func (c *Client) Ingest(ctx context.Context, log Log) error {
+	return c.send(ctx, "/ingest", log, nil)
+}
+
+// IngestBatch stores logs, reporting the outcome of each (POST /ingest/batch).
+// Logs rejected by validation are in the response, not an error.
+func (c *Client) IngestBatch(ctx context.Context, logs []Log) (*BatchResponse, error) {
+	var response BatchResponse
+	if err := c.send(ctx, "/ingest/batch", logs, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// send posts body to path until it succeeds, fails for good or runs out of
+// attempts. Every attempt carries the same Idempotency-Key, so a retry of a
+// request the server stored but did not answer is not stored twice.
+func (c *Client) send(ctx context.Context, path string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	idempotencyKey := newIdempotencyKey()
+	return c.backoff.retry(ctx, func() error { return c.post(ctx, path, encoded, idempotencyKey, out) })
+}
+
+func (c *Client) sendBatch(ctx context.Context, logs []Log, idempotencyKey string) (*BatchResponse, error) {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return nil, err
+	}
+	var response BatchResponse
+	if err := c.post(ctx, "/ingest/batch", body, idempotencyKey, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Client) retryDelay(attempt int, err error) time.Duration {
+	return c.backoff.delay(attempt, err)
+}
+
+// post sends one attempt of a request
+func (c *Client) post(ctx context.Context, path string, body []byte, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// responseError decodes the {"error": {"code", "message"}} body of a failed request
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var decoded struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &decoded) == nil && decoded.Error.Message != "" {
+		apiErr.Code, apiErr.Message = decoded.Error.Code, decoded.Error.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,295 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : gRPC transport of the SDK, calling LogIngestor/Ingest of proto/logingestor.proto over HTTP/2
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package ingestor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcIngestPath is the path of the LogIngestor/Ingest method
+const grpcIngestPath = "/logingestor.v1.LogIngestor/Ingest"
+
+// grpcHTTPStatus maps the gRPC status codes of the service to the HTTP status of
+// the same failure, so a GRPCClient reports and retries errors like a Client
+var grpcHTTPStatus = map[int]int{
+	1:  http.StatusRequestTimeout,      // canceled
+	3:  http.StatusBadRequest,          // invalid argument
+	4:  http.StatusGatewayTimeout,      // deadline exceeded
+	5:  http.StatusNotFound,            // not found
+	7:  http.StatusForbidden,           // permission denied
+	8:  http.StatusTooManyRequests,     // resource exhausted
+	12: http.StatusNotImplemented,      // unimplemented
+	13: http.StatusInternalServerError, // internal
+	14: http.StatusServiceUnavailable,  // unavailable
+	16: http.StatusUnauthorized,        // unauthenticated
+}
+
+// GRPCClient sends logs to the gRPC service of a Log Ingestor server (grpc-port);
+// it is safe for concurrent use. Only the parentResourceId of metadata is sent,
+// as the Log message has no other metadata field.
+type GRPCClient struct {
+	baseURL string
+	options
+}
+
+// NewGRPC creates a client of the gRPC service at target: grpc://host:port for
+// h2c, as the server serves it, or grpcs://host:port behind a TLS proxy
+func NewGRPC(target string, opts ...Option) *GRPCClient {
+	c := &GRPCClient{options: newOptions(opts)}
+	address, secure := strings.CutPrefix(target, "grpcs://")
+	if secure {
+		c.baseURL = "https://" + strings.TrimRight(address, "/")
+	} else {
+		c.baseURL = "http://" + strings.TrimRight(strings.TrimPrefix(target, "grpc://"), "/")
+	}
+
+	if c.http == nil {
+		protocols := new(http.Protocols)
+		if secure {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		c.http = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			Protocols:       protocols,
+			TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+		}}
+	}
+	return c
+}
+
+// Ingest stores one log, retrying by the client's backoff
+func (c *GRPCClient) Ingest(ctx context.Context, log Log) error {
+	response, err := c.IngestBatch(ctx, []Log{log})
+	if err == nil && response.Rejected > 0 {
+		err = &Error{StatusCode: http.StatusBadRequest, Code: "validation_error", Message: response.Results[0].Error}
+	}
+	return err
+}
+
+// IngestBatch stores logs, reporting the rejected ones in the response, retrying
+// by the client's backoff. Unlike over HTTP, a retry after a lost response may
+// store the logs twice.
+func (c *GRPCClient) IngestBatch(ctx context.Context, logs []Log) (*BatchResponse, error) {
+	var response *BatchResponse
+	err := c.backoff.retry(ctx, func() (err error) {
+		response, err = c.sendBatch(ctx, logs, "")
+		return err
+	})
+	return response, err
+}
+
+func (c *GRPCClient) sendBatch(ctx context.Context, logs []Log, _ string) (*BatchResponse, error) {
+	var e protoEncoder
+	for _, log := range logs {
+		e.message(1, func(m *protoEncoder) { encodeLog(m, log) })
+	}
+	message := make([]byte, 5, 5+len(e.buf))
+	binary.BigEndian.PutUint32(message[1:], uint32(len(e.buf)))
+	message = append(message, e.buf...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+grpcIngestPath, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: "gRPC call failed: " + resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err := grpcStatus(resp); err != nil {
+		return nil, err
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return nil, errors.New("ingestor: malformed gRPC response")
+	}
+	return decodeIngestResponse(body[5:])
+}
+
+func (c *GRPCClient) retryDelay(attempt int, err error) time.Duration {
+	return c.backoff.delay(attempt, err)
+}
+
+// grpcStatus returns the error of the Grpc-Status trailer, or header when the
+// response had no body
+func grpcStatus(resp *http.Response) error {
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("ingestor: gRPC response without a valid status %q", status)
+	}
+	if code == 0 {
+		return nil
+	}
+
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	httpStatus, ok := grpcHTTPStatus[code]
+	if !ok {
+		httpStatus = http.StatusInternalServerError
+	}
+	return &Error{StatusCode: httpStatus, Code: "grpc_" + strconv.Itoa(code), Message: message}
+}
+
+// encodeLog writes the fields of a Log message
+func encodeLog(e *protoEncoder, log Log) {
+	e.string(1, log.Level)
+	e.string(2, log.Message)
+	e.string(3, log.ResourceID)
+	if !log.Timestamp.IsZero() {
+		e.message(4, func(m *protoEncoder) {
+			m.varint(1, uint64(log.Timestamp.Unix()))
+			m.varint(2, uint64(log.Timestamp.Nanosecond()))
+		})
+	}
+	e.string(5, log.TraceID)
+	e.string(6, log.SpanID)
+	e.string(7, log.Commit)
+	if parent, _ := log.Metadata["parentResourceId"].(string); parent != "" {
+		e.message(8, func(m *protoEncoder) { m.string(1, parent) })
+	}
+}
+
+// decodeIngestResponse decodes an IngestResponse into the BatchResponse of its
+// counts and rejected logs
+func decodeIngestResponse(b []byte) (*BatchResponse, error) {
+	response := &BatchResponse{}
+	err := parseProto(b, func(num int, varint uint64, data []byte) error {
+		switch num {
+		case 1:
+			response.Accepted = int(varint)
+		case 2:
+			response.Rejected = int(varint)
+		case 3:
+			result := BatchResult{Status: "rejected"}
+			err := parseProto(data, func(num int, varint uint64, data []byte) error {
+				switch num {
+				case 1:
+					result.Index = int(varint)
+				case 2:
+					result.Error = string(data)
+				}
+				return nil
+			})
+			response.Results = append(response.Results, result)
+			return err
+		}
+		return nil
+	})
+	return response, err
+}
+
+// protoEncoder appends fields in the protocol buffers wire format, leaving out
+// zero values as proto3 does
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	if s != "" {
+		e.bytes(field, []byte(s))
+	}
+}
+
+func (e *protoEncoder) bytes(field int, b []byte) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|2)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// message writes an embedded message built by fn
+func (e *protoEncoder) message(field int, fn func(*protoEncoder)) {
+	var inner protoEncoder
+	fn(&inner)
+	e.bytes(field, inner.buf)
+}
+
+var errProtoTruncated = errors.New("ingestor: truncated protobuf message")
+
+// parseProto calls fn with the number and value of every varint and
+// length-delimited field of a message; fields of other wire types are skipped
+func parseProto(b []byte, fn func(num int, varint uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		b = b[n:]
+
+		var varint uint64
+		var data []byte
+		switch key & 7 {
+		case 0:
+			if varint, n = binary.Uvarint(b); n <= 0 {
+				return errProtoTruncated
+			}
+			b = b[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return errProtoTruncated
+			}
+			b = b[size:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errProtoTruncated
+			}
+			data, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return fmt.Errorf("ingestor: unsupported protobuf wire type %d", key&7)
+		}
+
+		if err := fn(int(key>>3), varint, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
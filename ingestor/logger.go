@@ -0,0 +1,323 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Asynchronous batching Logger of the SDK, buffering logs while the server is unreachable
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package ingestor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoggerOptions configures a Logger; zero fields take their defaults
+type LoggerOptions struct {
+	BatchSize     int           // most logs per request, 100 by default
+	FlushInterval time.Duration // longest a log waits to be sent, 1s by default
+	BufferSize    int           // logs held while the server is unreachable, 10000 by default; the oldest are dropped beyond
+	Defaults      Log           // level, resourceId, commit and metadata of the logs that do not set them
+	OnError       func(error)   // called with failed sends and rejected logs, from the sending goroutine
+}
+
+// Logger sends logs to the server in batches from a goroutine of its own, so
+// logging never waits for the network. While the server is unreachable or
+// overloaded the logs are held in memory and the batch is retried with
+// exponential backoff; Close sends what is left.
+type Logger struct {
+	sender Sender
+	opts   LoggerOptions
+
+	mu      sync.Mutex
+	buf     []Log
+	closed  bool
+	dropped atomic.Int64
+
+	full    chan struct{} // a batch is ready
+	flushes chan chan error
+	done    chan struct{}
+	stopped chan struct{}
+	ctx     context.Context // of the sends of the goroutine, canceled by Close
+	cancel  context.CancelFunc
+
+	// the batch being sent, owned by the goroutine and, after it stops, by Close
+	pending    []Log
+	pendingKey string
+}
+
+// NewLogger starts a Logger sending through s, a *Client or a *GRPCClient
+func NewLogger(s Sender, opts LoggerOptions) *Logger {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10000
+	}
+	if opts.Defaults.Level == "" {
+		opts.Defaults.Level = "info"
+	}
+
+	l := &Logger{
+		sender:  s,
+		opts:    opts,
+		full:    make(chan struct{}, 1),
+		flushes: make(chan chan error),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	go l.run()
+	return l
+}
+
+// Log queues a log, filling in the default fields it leaves empty and the
+// current time. It never blocks: beyond BufferSize the oldest log is dropped.
+func (l *Logger) Log(log Log) {
+	d := l.opts.Defaults
+	if log.Level == "" {
+		log.Level = d.Level
+	}
+	if log.ResourceID == "" {
+		log.ResourceID = d.ResourceID
+	}
+	if log.TraceID == "" {
+		log.TraceID = d.TraceID
+	}
+	if log.SpanID == "" {
+		log.SpanID = d.SpanID
+	}
+	if log.Commit == "" {
+		log.Commit = d.Commit
+	}
+	if log.Timestamp.IsZero() {
+		log.Timestamp = time.Now()
+	}
+	if len(d.Metadata) > 0 {
+		metadata := maps.Clone(d.Metadata)
+		maps.Copy(metadata, log.Metadata)
+		log.Metadata = metadata
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		l.dropped.Add(1)
+		return
+	}
+	if len(l.buf) >= l.opts.BufferSize {
+		l.buf = slices.Delete(l.buf, 0, 1)
+		l.dropped.Add(1)
+	}
+	l.buf = append(l.buf, log)
+	if len(l.buf) >= l.opts.BatchSize {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Write queues a log per line of p, so a Logger can back log.New or any other
+// line writer. A line holding a JSON object is decoded as a Log, its keys other
+// than the Log fields going to metadata; any other line is the message of a log
+// with the default fields. A zap JSON core writing to a Logger thus ships zap
+// logs once its encoder names the level, message and time keys "level",
+// "message" and "timestamp", with lowercase levels and RFC 3339 times.
+func (l *Logger) Write(p []byte) (int, error) {
+	for line := range bytes.Lines(p) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		log, ok := decodeLine(line)
+		if !ok {
+			log = Log{Message: string(line)}
+		}
+		l.Log(log)
+	}
+	return len(p), nil
+}
+
+// logFields are the JSON keys of the fields of a Log
+var logFields = []string{"level", "message", "resourceId", "timestamp", "traceId", "spanId", "commit", "metadata"}
+
+// decodeLine decodes a JSON line of Write, reporting false when it is not a
+// JSON object of log fields
+func decodeLine(line []byte) (Log, bool) {
+	var log Log
+	var fields map[string]json.RawMessage
+	if line[0] != '{' || json.Unmarshal(line, &log) != nil || json.Unmarshal(line, &fields) != nil {
+		return Log{}, false
+	}
+	for key, raw := range fields {
+		if slices.Contains(logFields, key) {
+			continue
+		}
+		var v any
+		json.Unmarshal(raw, &v)
+		if log.Metadata == nil {
+			log.Metadata = make(map[string]any)
+		}
+		log.Metadata[key] = v
+	}
+	return log, true
+}
+
+// Dropped returns the number of logs dropped because the buffer was full, the
+// Logger was closed or the server rejected them
+func (l *Logger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Flush sends the logs queued so far and returns the error of the first batch
+// that failed, if any
+func (l *Logger) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case l.flushes <- reply:
+	case <-l.stopped:
+		return errors.New("ingestor: logger closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the Logger and sends the logs left, retrying until ctx ends; the
+// logs still unsent then are dropped and reported in the error
+func (l *Logger) Close(ctx context.Context) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	close(l.done)
+	l.cancel()
+	<-l.stopped
+
+	for failures := 1; ; failures++ {
+		err := l.sendAll(ctx)
+		if err == nil {
+			return nil
+		}
+		timer := time.NewTimer(l.sender.retryDelay(failures, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			l.mu.Lock()
+			lost := len(l.pending) + len(l.buf)
+			l.mu.Unlock()
+			l.dropped.Add(int64(lost))
+			return fmt.Errorf("ingestor: %d logs not sent: %w", lost, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// run sends a batch as soon as one is full and whatever is queued every
+// FlushInterval; after a failure it waits by the client's backoff instead
+func (l *Logger) run() {
+	defer close(l.stopped)
+
+	wait, failures := l.opts.FlushInterval, 0
+	for {
+		full := l.full
+		if failures > 0 {
+			full = nil
+		}
+		timer := time.NewTimer(wait)
+		var reply chan error
+		select {
+		case <-l.done:
+			timer.Stop()
+			return
+		case reply = <-l.flushes:
+		case <-full:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		err := l.sendAll(l.ctx)
+		if reply != nil {
+			reply <- err
+		}
+		if err != nil {
+			failures++
+			wait = l.sender.retryDelay(failures, err)
+			if l.ctx.Err() == nil {
+				l.report(err)
+			}
+		} else {
+			wait, failures = l.opts.FlushInterval, 0
+		}
+	}
+}
+
+// sendAll sends the queued logs a batch at a time until none are left or a
+// batch fails with a temporary error, which it returns; that batch is kept,
+// with its Idempotency-Key, for the next attempt. Batches the server refuses
+// for good are dropped.
+func (l *Logger) sendAll(ctx context.Context) error {
+	for {
+		if l.pending == nil {
+			l.mu.Lock()
+			n := min(len(l.buf), l.opts.BatchSize)
+			if n == 0 {
+				l.mu.Unlock()
+				return nil
+			}
+			l.pending = slices.Clone(l.buf[:n])
+			l.buf = slices.Delete(l.buf, 0, n)
+			l.mu.Unlock()
+			l.pendingKey = newIdempotencyKey()
+		}
+
+		response, err := l.sender.sendBatch(ctx, l.pending, l.pendingKey)
+		if err != nil && temporary(err) {
+			return err
+		}
+		if err != nil {
+			l.drop(err)
+			continue
+		}
+
+		for _, result := range response.Results {
+			if result.Status == "rejected" && result.Index < len(l.pending) {
+				l.dropped.Add(1)
+				l.report(fmt.Errorf("ingestor: log %q rejected: %s", l.pending[result.Index].Message, result.Error))
+			}
+		}
+		l.pending = nil
+	}
+}
+
+// drop discards the pending batch after an error it will not recover from
+func (l *Logger) drop(err error) {
+	l.dropped.Add(int64(len(l.pending)))
+	l.report(fmt.Errorf("ingestor: %d logs dropped: %w", len(l.pending), err))
+	l.pending = nil
+}
+
+func (l *Logger) report(err error) {
+	if l.opts.OnError != nil {
+		l.opts.OnError(err)
+	}
+}
@@ -0,0 +1,195 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : slog.Handler of the SDK, turning log/slog records into logs of the server's schema
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package ingestor
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandlerOptions configures a Handler
+type HandlerOptions struct {
+	Level     slog.Leveler // least level sent, slog.LevelInfo by default
+	AddSource bool         // adds the file:line of the logging call to metadata as "source"
+	// Trace returns the trace and span of the context of a record, e.g. from an
+	// OpenTelemetry span, for the logs that do not set them as attributes
+	Trace func(ctx context.Context) (traceID, spanID string)
+}
+
+// SetDefault makes slog's default logger send its records of level info and
+// above to target with a new Logger, returned for the program to Close before
+// it exits. target is an http(s):// server URL or a grpc(s):// address:
+//
+//	defer ingestor.SetDefault("http://localhost:3000", ingestor.LoggerOptions{Defaults: ingestor.Log{ResourceID: "payments"}}, ingestor.WithAPIKey(key)).Close(context.Background())
+func SetDefault(target string, opts LoggerOptions, clientOpts ...Option) *Logger {
+	l := NewLogger(Dial(target, clientOpts...), opts)
+	slog.SetDefault(slog.New(NewHandler(l, nil)))
+	return l
+}
+
+// Handler is a slog.Handler queuing every record on a Logger. The attributes
+// resourceId, traceId, spanId and commit, outside groups, set those fields;
+// the others go to metadata, keyed group.key inside groups.
+type Handler struct {
+	logger *Logger
+	opts   HandlerOptions
+	attrs  []slog.Attr // of WithAttrs, keys already prefixed by their groups
+	groups []string
+}
+
+// NewHandler creates a Handler logging to l; opts may be nil
+func NewHandler(l *Logger, opts *HandlerOptions) *Handler {
+	h := &Handler{logger: l}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+// Enabled reports whether records of level are sent
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+// Handle queues the log of a record
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	log := Log{Level: levelName(r.Level), Message: r.Message, Timestamp: r.Time, Metadata: map[string]any{}}
+	if log.Timestamp.IsZero() {
+		log.Timestamp = time.Now()
+	}
+	if h.opts.Trace != nil {
+		log.TraceID, log.SpanID = h.opts.Trace(ctx)
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		log.Metadata["source"] = frame.File + ":" + strconv.Itoa(frame.Line)
+	}
+	for _, attr := range h.attrs {
+		setAttr(&log, attr)
+	}
+	prefix := groupPrefix(h.groups)
+	r.Attrs(func(attr slog.Attr) bool {
+		flatten(prefix, attr, func(a slog.Attr) { setAttr(&log, a) })
+		return true
+	})
+	if len(log.Metadata) == 0 {
+		log.Metadata = nil
+	}
+
+	h.logger.Log(log)
+	return nil
+}
+
+// WithAttrs returns a Handler adding attrs to every record
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	prefix := groupPrefix(h.groups)
+	for _, attr := range attrs {
+		flatten(prefix, attr, func(a slog.Attr) { h2.attrs = append(h2.attrs, a) })
+	}
+	return &h2
+}
+
+// WithGroup returns a Handler nesting the attributes that follow under name
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(slices.Clip(h.groups), name)
+	return &h2
+}
+
+// levelName maps a slog level to a level of the server's default configuration;
+// levels above error are fatal
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warn"
+	case level == slog.LevelError:
+		return "error"
+	}
+	return "fatal"
+}
+
+func groupPrefix(groups []string) string {
+	if len(groups) == 0 {
+		return ""
+	}
+	return strings.Join(groups, ".") + "."
+}
+
+// flatten calls fn with attr, or with each attribute of a group attr, keyed by
+// its groups; empty attributes are left out, as slog handlers do
+func flatten(prefix string, attr slog.Attr, fn func(slog.Attr)) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() != slog.KindGroup {
+		fn(slog.Attr{Key: prefix + attr.Key, Value: attr.Value})
+		return
+	}
+	if attr.Key != "" {
+		prefix += attr.Key + "."
+	}
+	for _, a := range attr.Value.Group() {
+		flatten(prefix, a, fn)
+	}
+}
+
+// setAttr sets the field of a log named by the key of attr, or its metadata key
+func setAttr(log *Log, attr slog.Attr) {
+	value := attr.Value
+	switch attr.Key {
+	case "resourceId":
+		log.ResourceID = value.String()
+		return
+	case "traceId":
+		log.TraceID = value.String()
+		return
+	case "spanId":
+		log.SpanID = value.String()
+		return
+	case "commit":
+		log.Commit = value.String()
+		return
+	}
+
+	var v any
+	switch value.Kind() {
+	case slog.KindTime:
+		v = value.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		v = value.Duration().String()
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			v = err.Error()
+		} else {
+			v = value.Any()
+		}
+	default:
+		v = value.Any()
+	}
+	if log.Metadata == nil {
+		log.Metadata = map[string]any{}
+	}
+	log.Metadata[attr.Key] = v
+}
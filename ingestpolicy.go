@@ -0,0 +1,231 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Ingest policies sampling logs by level and capping the rate of each resource, per source
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// policySweepInterval is how often idle rate buckets are dropped and capped
+// resources reported
+const policySweepInterval = time.Minute
+
+var (
+	policySampledOut = metrics.CounterVec("logingestor_ingest_sampled_out_total", "Logs dropped by the sampling of an ingest policy", "policy")
+	policyCapped     = metrics.CounterVec("logingestor_ingest_rate_capped_total", "Logs dropped because their resource exceeded the rate limit of an ingest policy", "policy")
+)
+
+// IngestPolicy is an entry of the ingest-policies-file. Each log is governed by
+// the first policy matching its resourceId and the API key it was sent with.
+type IngestPolicy struct {
+	Name       string `json:"name"`
+	ResourceID string `json:"resource_id"` // glob such as "checkout-*"; empty matches every resource
+	APIKey     string `json:"api_key"`     // name of the API key; empty matches every key and none
+
+	Sample       map[string]float64 `json:"sample"`         // fraction kept per level, e.g. {"debug": 0.1}; other levels are kept
+	KeepMinLevel string             `json:"keep_min_level"` // logs this severe or more are always kept, e.g. "error"
+
+	ResourceRateLimit float64 `json:"resource_rate_limit"` // logs per second per resourceId; 0 is unlimited
+	ResourceRateBurst int     `json:"resource_rate_burst"` // default the rate limit
+}
+
+// ingestPolicy is a loaded policy with the rate bucket of each resource it saw
+type ingestPolicy struct {
+	IngestPolicy
+	keep   map[string]bool
+	sample map[string]float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	capped  map[string]int // logs dropped by the cap per resource since the last sweep
+	swept   time.Time
+}
+
+// IngestPolicies sample and rate-limit logs before they are stored
+type IngestPolicies struct {
+	policies []*ingestPolicy
+}
+
+// LoadIngestPolicies reads and checks the policies of the ingest-policies-file
+func LoadIngestPolicies(cfg Config, levels *LevelRegistry) (*IngestPolicies, error) {
+	data, err := os.ReadFile(cfg.IngestPoliciesFile)
+	if err != nil {
+		return nil, err
+	}
+	var policies []IngestPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.IngestPoliciesFile, err)
+	}
+
+	ip := &IngestPolicies{}
+	names := make(map[string]bool)
+	for _, policy := range policies {
+		if policy.Name == "" {
+			return nil, errors.New("ingest policy without a name")
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("ingest policy %q: duplicate name", policy.Name)
+		}
+		names[policy.Name] = true
+
+		loaded, err := loadIngestPolicy(policy, levels)
+		if err != nil {
+			return nil, fmt.Errorf("ingest policy %q: %v", policy.Name, err)
+		}
+		ip.policies = append(ip.policies, loaded)
+	}
+
+	return ip, nil
+}
+
+// loadIngestPolicy checks one policy, normalizing its levels
+func loadIngestPolicy(policy IngestPolicy, levels *LevelRegistry) (*ingestPolicy, error) {
+	if _, err := path.Match(policy.ResourceID, ""); err != nil {
+		return nil, fmt.Errorf("invalid resource_id pattern %q", policy.ResourceID)
+	}
+
+	p := &ingestPolicy{
+		IngestPolicy: policy,
+		keep:         make(map[string]bool),
+		sample:       make(map[string]float64),
+		buckets:      make(map[string]*tokenBucket),
+		capped:       make(map[string]int),
+	}
+	for level, rate := range policy.Sample {
+		if !levels.Known(levels.Normalize(level)) {
+			return nil, fmt.Errorf("sample: unknown level %q", level)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sample: %s must be between 0 and 1", level)
+		}
+		p.sample[levels.Normalize(level)] = rate
+	}
+	if policy.KeepMinLevel != "" {
+		kept, err := levels.AtLeast(policy.KeepMinLevel)
+		if err != nil {
+			return nil, fmt.Errorf("keep_min_level: %v", err)
+		}
+		for _, level := range kept {
+			p.keep[level] = true
+		}
+	}
+	if policy.ResourceRateLimit < 0 || policy.ResourceRateBurst < 0 {
+		return nil, errors.New("resource_rate_limit and resource_rate_burst must not be negative")
+	}
+
+	return p, nil
+}
+
+// admit returns the logs sent with the named API key that their policies keep
+func (ip *IngestPolicies) admit(key string, logs []Log, now time.Time) []Log {
+	kept := logs[:0:0]
+	for _, log := range logs {
+		policy := ip.match(key, log)
+		if policy == nil || policy.admit(log, now) {
+			kept = append(kept, log)
+		}
+	}
+	return kept
+}
+
+// match returns the first policy governing a log, nil if none does
+func (ip *IngestPolicies) match(key string, log Log) *ingestPolicy {
+	for _, policy := range ip.policies {
+		if policy.APIKey != "" && policy.APIKey != key {
+			continue
+		}
+		if ok, _ := path.Match(policy.ResourceID, log.ResourceID); policy.ResourceID != "" && !ok {
+			continue
+		}
+		return policy
+	}
+	return nil
+}
+
+// admit reports whether the policy keeps a log: kept levels always are, others
+// are sampled at their level's rate, then held to the rate of their resource
+func (p *ingestPolicy) admit(log Log, now time.Time) bool {
+	if p.keep[log.Level] {
+		return true
+	}
+	if rate, ok := p.sample[log.Level]; ok && !sampled(log, rate) {
+		policySampledOut.With(p.Name).Inc()
+		return false
+	}
+	if p.ResourceRateLimit == 0 {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.swept) >= policySweepInterval {
+		p.sweepLocked(now)
+	}
+	bucket, ok := p.buckets[log.ResourceID]
+	if !ok {
+		bucket = newTokenBucket(p.ResourceRateLimit, p.ResourceRateBurst)
+		p.buckets[log.ResourceID] = bucket
+	}
+	if ok, _ := bucket.take(now); !ok {
+		policyCapped.With(p.Name).Inc()
+		p.capped[log.ResourceID]++
+		return false
+	}
+	return true
+}
+
+// sweepLocked reports the resources capped since the last sweep and drops the
+// buckets of resources that sent nothing since
+func (p *ingestPolicy) sweepLocked(now time.Time) {
+	for resource, dropped := range p.capped {
+		logger.Warn("resource exceeded its ingest rate limit", "policy", p.Name, "resourceId", resource, "dropped", dropped, "since", p.swept)
+	}
+	clear(p.capped)
+	for resource, bucket := range p.buckets {
+		if now.Sub(bucket.last) >= policySweepInterval {
+			delete(p.buckets, resource)
+		}
+	}
+	p.swept = now
+}
+
+// sampled decides whether a log is kept at rate. Logs of a trace are all kept or
+// all dropped, by a hash of their traceId, so sampled traces stay whole.
+func sampled(log Log, rate float64) bool {
+	if log.TraceID == "" {
+		return rand.Float64() < rate
+	}
+	return float64(ringHash(log.TraceID))/(1<<32) < rate
+}
+
+// admit stamps validated logs received from source with their ingestion time,
+// then runs the ingest pipelines, the ingest policies and the quotas over them,
+// returning those kept; a *QuotaError when quotas rejected every one. Logs
+// forwarded by another node were already stamped and processed by the node
+// receiving them, and are returned unchanged.
+func (s *Server) admit(ctx context.Context, source string, logs []Log) ([]Log, error) {
+	if isForwarded(ctx) {
+		return logs, nil
+	}
+	stampIngested(logs, source, s.cfg.IngestLagThreshold)
+	logs = s.pipelines.process(s.tenant(ctx).ID, logs)
+	if s.policies != nil {
+		logs = s.policies.admit(principalName(ctx), logs, time.Now())
+	}
+	if s.quotas == nil || len(logs) == 0 {
+		return logs, nil
+	}
+	return s.quotas.admit(s.tenant(ctx).ID, logs, time.Now())
+}
@@ -0,0 +1,162 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Bounded ingest queue whose writers append logs to the LogStorage in batches
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errQueueFull is returned when the ingest queue cannot take every log of a request
+var errQueueFull = errors.New("ingest queue is full")
+
+var (
+	queueDropped       = metrics.Counter("logingestor_ingest_queue_dropped_total", "Logs rejected because the ingest queue was full")
+	queueBatches       = metrics.Counter("logingestor_ingest_queue_batches_total", "Batches written by the ingest queue writers")
+	queueWriteFailures = metrics.Counter("logingestor_ingest_queue_write_failures_total", "Queued logs lost because storing their batch failed")
+)
+
+// IngestQueue accepts logs without waiting for storage. A pool of writers drains
+// it, appending up to batchSize logs at a time, or whatever arrived within
+// flushInterval, so concurrent ingests share one lock acquisition and one
+// Storage write.
+type IngestQueue struct {
+	queue         chan queuedLog
+	depth         atomic.Int64 // logs reserved in the queue
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	writers sync.WaitGroup
+}
+
+// queuedLog is a log waiting to be stored in the LogStorage of its tenant
+type queuedLog struct {
+	storage *LogStorage
+	log     Log
+}
+
+// NewIngestQueue creates a queue of cfg.IngestQueueSize logs and starts its writers
+func NewIngestQueue(cfg Config) *IngestQueue {
+	q := &IngestQueue{
+		queue:         make(chan queuedLog, cfg.IngestQueueSize),
+		batchSize:     cfg.IngestBatchSize,
+		flushInterval: cfg.IngestFlushInterval,
+	}
+	metrics.GaugeFunc("logingestor_ingest_queue_depth", "Logs waiting in the ingest queue", func() float64 {
+		return float64(q.depth.Load())
+	})
+
+	for range cfg.IngestWorkers {
+		q.writers.Go(q.write)
+	}
+
+	return q
+}
+
+// Enqueue queues every log for storage or, when they do not all fit, none of them
+func (q *IngestQueue) Enqueue(storage *LogStorage, logs []Log) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueFull
+	}
+
+	n := int64(len(logs))
+	for {
+		depth := q.depth.Load()
+		if depth+n > int64(cap(q.queue)) {
+			queueDropped.Add(n)
+			return errQueueFull
+		}
+		if q.depth.CompareAndSwap(depth, depth+n) {
+			break
+		}
+	}
+
+	// The reservation guarantees these sends do not block
+	for _, log := range logs {
+		q.queue <- queuedLog{storage: storage, log: log}
+	}
+
+	return nil
+}
+
+// Depth returns the number of logs waiting in the queue
+func (q *IngestQueue) Depth() int64 { return q.depth.Load() }
+
+// Size returns the number of logs the queue holds when full
+func (q *IngestQueue) Size() int { return cap(q.queue) }
+
+// Close stops accepting logs and waits for the writers to store the queued ones
+func (q *IngestQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	q.writers.Wait()
+}
+
+// write collects batches from the queue until it is closed and drained
+func (q *IngestQueue) write() {
+	batch := make([]queuedLog, 0, q.batchSize)
+	timer := time.NewTimer(q.flushInterval)
+	defer timer.Stop()
+
+	for {
+		log, ok := <-q.queue
+		if !ok {
+			return
+		}
+		batch = append(batch[:0], log)
+
+		timer.Reset(q.flushInterval)
+	collect:
+		for len(batch) < q.batchSize {
+			select {
+			case log, ok := <-q.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, log)
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		q.flush(batch)
+	}
+}
+
+// flush stores one batch, with one IngestBatch per tenant storage in it
+func (q *IngestQueue) flush(batch []queuedLog) {
+	var order []*LogStorage
+	byStorage := make(map[*LogStorage][]Log)
+	for _, queued := range batch {
+		if _, ok := byStorage[queued.storage]; !ok {
+			order = append(order, queued.storage)
+		}
+		byStorage[queued.storage] = append(byStorage[queued.storage], queued.log)
+	}
+
+	for _, storage := range order {
+		logs := byStorage[storage]
+		err := storage.IngestBatch(logs)
+		q.depth.Add(-int64(len(logs)))
+		queueBatches.Inc()
+		if err != nil {
+			queueWriteFailures.Add(int64(len(logs)))
+			logger.Error("storing queued logs failed", "logs", len(logs), "error", err)
+		}
+	}
+}
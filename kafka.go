@@ -0,0 +1,286 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Optional Kafka ingestion source consuming topics through a Kafka REST Proxy (v2 API)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Content types of the Kafka REST Proxy v2 API
+const (
+	kafkaContentType = "application/vnd.kafka.v2+json"
+	kafkaBinaryType  = "application/vnd.kafka.binary.v2+json"
+)
+
+// Backoff bounds between retries of failed proxy or storage calls
+const (
+	kafkaMinBackoff = 500 * time.Millisecond
+	kafkaMaxBackoff = 30 * time.Second
+)
+
+var (
+	kafkaRecords        = metrics.Counter("logingestor_kafka_records_total", "Kafka records consumed")
+	kafkaIngested       = metrics.Counter("logingestor_kafka_logs_ingested_total", "Logs ingested from Kafka")
+	kafkaDecodeFailures = metrics.Counter("logingestor_kafka_decode_failures_total", "Kafka records skipped because they are not valid logs")
+	kafkaRetries        = metrics.Counter("logingestor_kafka_retries_total", "Kafka proxy or storage calls retried after a failure")
+)
+
+// kafkaRecord is a record returned by the REST Proxy in the binary embedded format
+type kafkaRecord struct {
+	Topic     string `json:"topic"`
+	Value     []byte `json:"value"` // base64 in the proxy response
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// kafkaOffset is a consumer group position to commit
+type kafkaOffset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// KafkaConsumer subscribes a consumer group to the configured topics and feeds
+// decoded log entries to ingest. Offsets are committed only after the records
+// are stored, so a crash re-delivers rather than loses logs.
+type KafkaConsumer struct {
+	proxyURL string
+	group    string
+	topics   []string
+	timeout  time.Duration
+
+	client    *http.Client
+	ingest    func(logs []Log) error
+	validator Validator
+	reject    rejectFunc
+	instance  string // base URI of the consumer instance, empty until created
+}
+
+// NewKafkaConsumer creates a consumer for the Kafka settings of cfg
+func NewKafkaConsumer(cfg Config, ingest func(logs []Log) error, validator Validator, reject rejectFunc) *KafkaConsumer {
+	return &KafkaConsumer{
+		proxyURL:  strings.TrimSuffix(cfg.KafkaProxyURL, "/"),
+		group:     cfg.KafkaGroup,
+		topics:    cfg.KafkaTopics,
+		timeout:   cfg.KafkaPollTimeout,
+		client:    &http.Client{Timeout: cfg.KafkaPollTimeout + 10*time.Second},
+		ingest:    ingest,
+		validator: validator,
+		reject:    reject,
+	}
+}
+
+// Run consumes until ctx is cancelled, retrying failures with exponential backoff
+func (kc *KafkaConsumer) Run(ctx context.Context) {
+	backoff := kafkaMinBackoff
+	for ctx.Err() == nil {
+		if err := kc.poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			kafkaRetries.Inc()
+			logger.Warn("kafka fetch failed", "error", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, kafkaMaxBackoff)
+			continue
+		}
+		backoff = kafkaMinBackoff
+	}
+
+	kc.close()
+}
+
+// poll fetches one batch of records, stores the logs and commits the offsets
+func (kc *KafkaConsumer) poll(ctx context.Context) error {
+	if kc.instance == "" {
+		if err := kc.subscribe(ctx); err != nil {
+			return err
+		}
+	}
+
+	var records []kafkaRecord
+	url := fmt.Sprintf("%s/records?timeout=%d", kc.instance, kc.timeout.Milliseconds())
+	if err := kc.call(ctx, http.MethodGet, url, nil, &records); err != nil {
+		// The proxy drops idle instances; replace it on the next poll
+		if ctx.Err() == nil {
+			kc.close()
+		}
+		return fmt.Errorf("fetching records: %v", err)
+	}
+
+	var logs []Log
+	positions := make(map[string]kafkaOffset)
+	for _, record := range records {
+		kafkaRecords.Inc()
+		origin := fmt.Sprintf("%s/%d@%d", record.Topic, record.Partition, record.Offset)
+		decoded, err := kc.decode(record.Value, origin)
+		if err != nil {
+			kafkaDecodeFailures.Inc()
+			logger.Warn("kafka record skipped", "origin", origin, "error", err)
+		}
+		logs = append(logs, decoded...)
+
+		// The committed offset is the next one to read
+		key := fmt.Sprintf("%s/%d", record.Topic, record.Partition)
+		positions[key] = kafkaOffset{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset + 1}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	var qerr *QuotaError
+	if err := kc.ingest(logs); errors.As(err, &qerr) {
+		// Dropped like sampled logs, as re-delivering cannot get them under the quota
+		logger.Warn("kafka logs dropped by a quota", "quota", qerr.Quota, "count", len(logs))
+	} else if err != nil {
+		// Not committing makes the proxy re-deliver the batch to a fresh instance
+		kc.close()
+		return fmt.Errorf("storing logs: %v", err)
+	} else {
+		kafkaIngested.Add(int64(len(logs)))
+	}
+
+	offsets := make([]kafkaOffset, 0, len(positions))
+	for _, offset := range positions {
+		offsets = append(offsets, offset)
+	}
+	if err := kc.call(ctx, http.MethodPost, kc.instance+"/offsets", map[string]interface{}{"offsets": offsets}, nil); err != nil {
+		return fmt.Errorf("committing offsets: %v", err)
+	}
+
+	return nil
+}
+
+// decode parses a record value holding one JSON log or a JSON array of logs.
+// Rejected values, or the invalid logs of an array, go to the dead-letter store
+// with the record position as origin.
+func (kc *KafkaConsumer) decode(value []byte, origin string) ([]Log, error) {
+	value = bytes.TrimSpace(value)
+
+	var logs []Log
+	if len(value) > 0 && value[0] == '[' {
+		if err := json.Unmarshal(value, &logs); err != nil {
+			kc.reject("kafka", origin, rejectionReason(err), value)
+			return nil, err
+		}
+	} else {
+		var log Log
+		if err := json.Unmarshal(value, &log); err != nil {
+			kc.reject("kafka", origin, rejectionReason(err), value)
+			return nil, err
+		}
+		logs = []Log{log}
+	}
+
+	valid := logs[:0]
+	var firstErr error
+	for _, log := range logs {
+		if err := kc.validator.Validate(&log); err != nil {
+			payload, _ := json.Marshal(log)
+			kc.reject("kafka", origin, rejectionReason(err), payload)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		valid = append(valid, log)
+	}
+
+	return valid, firstErr
+}
+
+// subscribe creates a consumer instance in the group and subscribes it to the topics
+func (kc *KafkaConsumer) subscribe(ctx context.Context) error {
+	hostname, _ := os.Hostname()
+	create := map[string]string{
+		"name":               fmt.Sprintf("logingestor-%s-%d", hostname, time.Now().UnixNano()),
+		"format":             "binary",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}
+
+	var created struct {
+		BaseURI string `json:"base_uri"`
+	}
+	if err := kc.call(ctx, http.MethodPost, kc.proxyURL+"/consumers/"+kc.group, create, &created); err != nil {
+		return fmt.Errorf("creating consumer: %v", err)
+	}
+	kc.instance = strings.TrimSuffix(created.BaseURI, "/")
+
+	if err := kc.call(ctx, http.MethodPost, kc.instance+"/subscription", map[string][]string{"topics": kc.topics}, nil); err != nil {
+		kc.close()
+		return fmt.Errorf("subscribing to %s: %v", strings.Join(kc.topics, ","), err)
+	}
+
+	logger.Info("kafka consumer started", "topics", strings.Join(kc.topics, ","), "group", kc.group)
+	return nil
+}
+
+// close deletes the consumer instance so the group rebalances promptly
+func (kc *KafkaConsumer) close() {
+	if kc.instance == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kc.call(ctx, http.MethodDelete, kc.instance, nil, nil)
+	kc.instance = ""
+}
+
+// call sends a REST Proxy request with an optional JSON body and decodes the JSON response into out
+func (kc *KafkaConsumer) call(ctx context.Context, method, url string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", kafkaContentType)
+	}
+	req.Header.Set("Accept", kafkaContentType)
+	if method == http.MethodGet {
+		req.Header.Set("Accept", kafkaBinaryType)
+	}
+
+	resp, err := kc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(detail))
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,104 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Level registry: severity order of the accepted levels and normalization of synonyms
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LevelRegistry holds the accepted log levels in severity order, least severe
+// first, and the synonyms normalized to them. Lookups ignore case.
+type LevelRegistry struct {
+	levels    []string
+	rank      map[string]int    // lower-cased level -> position in levels
+	canonical map[string]string // lower-cased level or synonym -> level
+}
+
+// NewLevelRegistry creates a registry of levels, given least severe first, and
+// aliases, each "synonym=level"; aliases of levels not in levels are ignored
+func NewLevelRegistry(levels, aliases []string) *LevelRegistry {
+	lr := &LevelRegistry{rank: make(map[string]int), canonical: make(map[string]string)}
+	for _, level := range levels {
+		key := strings.ToLower(level)
+		if _, ok := lr.rank[key]; ok {
+			continue
+		}
+		lr.rank[key] = len(lr.levels)
+		lr.canonical[key] = level
+		lr.levels = append(lr.levels, level)
+	}
+	for _, alias := range aliases {
+		synonym, level, _ := strings.Cut(alias, "=")
+		synonym = strings.ToLower(strings.TrimSpace(synonym))
+		if canonical, ok := lr.canonical[strings.ToLower(strings.TrimSpace(level))]; ok {
+			if _, taken := lr.rank[synonym]; !taken {
+				lr.canonical[synonym] = canonical
+			}
+		}
+	}
+
+	return lr
+}
+
+// Normalize returns the accepted level a level or synonym stands for, matched
+// without regard to case; unknown levels are returned unchanged
+func (lr *LevelRegistry) Normalize(level string) string {
+	if canonical, ok := lr.canonical[strings.ToLower(strings.TrimSpace(level))]; ok {
+		return canonical
+	}
+	return level
+}
+
+// Known reports whether level is an accepted level as it is, without normalization
+func (lr *LevelRegistry) Known(level string) bool {
+	rank, ok := lr.rank[strings.ToLower(level)]
+	return ok && lr.levels[rank] == level
+}
+
+// AtLeast returns the accepted levels as severe as level or more
+func (lr *LevelRegistry) AtLeast(level string) ([]string, error) {
+	rank, ok := lr.rank[strings.ToLower(lr.Normalize(level))]
+	if !ok {
+		return nil, fmt.Errorf("must be one of %s", strings.Join(lr.levels, ", "))
+	}
+	return lr.levels[rank:], nil
+}
+
+// Levels returns the accepted levels, least severe first
+func (lr *LevelRegistry) Levels() []string { return lr.levels }
+
+// apply normalizes the level filter of a query and turns its min_level into an
+// expression matching every level from min_level up, combined with "q"
+func (lr *LevelRegistry) apply(req *QueryRequest) error {
+	if level, ok := req.Filters["level"]; ok {
+		req.Filters["level"] = lr.Normalize(level)
+	}
+	for _, levels := range [][]string{req.AnyOf["level"], req.Not["level"]} {
+		for i, level := range levels {
+			levels[i] = lr.Normalize(level)
+		}
+	}
+	if req.MinLevel == "" {
+		return nil
+	}
+
+	levels, err := lr.AtLeast(req.MinLevel)
+	if err != nil {
+		return fmt.Errorf("Invalid min_level: %v", err)
+	}
+	var expr lqlNode = lqlCompare{field: "level", op: "=", value: levels[0]}
+	for _, level := range levels[1:] {
+		expr = lqlOr{left: expr, right: lqlCompare{field: "level", op: "=", value: level}}
+	}
+	if req.Options.Expr != nil {
+		expr = lqlAnd{left: req.Options.Expr, right: expr}
+	}
+	req.Options.Expr = expr
+
+	return nil
+}
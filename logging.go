@@ -0,0 +1,232 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Structured logging of the server's own events, optionally ingested into its storage
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logger is the server's own log; it is replaced by newLogger once the
+// configuration is loaded
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// serverLogLevels maps the log-level setting to slog levels
+var serverLogLevels = map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+
+// selfIngestResource is the resourceId of self-ingested server logs
+const selfIngestResource = "logingestor"
+
+var selfIngestDropped = metrics.Counter("logingestor_self_ingest_dropped_total", "Server log records not self-ingested because the queue was full or storing failed")
+
+// newLogger creates the logger of the log-level and log-format settings writing
+// to out; records are also handed to self unless it is nil
+func newLogger(cfg Config, out io.Writer, self *selfIngester) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: serverLogLevels[cfg.LogLevel]}
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	if self != nil {
+		handler = &selfIngestHandler{Handler: handler, self: self}
+	}
+
+	return slog.New(handler)
+}
+
+// selfIngester stores the server's log records as logs of the default tenant.
+// Records are queued and dropped when the queue is full, so logging never blocks
+// on storage, and failures to store them are only counted, since logging them
+// would feed back into the queue.
+type selfIngester struct {
+	queue chan Log
+	host  string
+}
+
+// selfIngestQueueSize is how many records wait for storage before new ones are dropped
+const selfIngestQueueSize = 10000
+
+// newSelfIngester creates an empty self-ingester
+func newSelfIngester() *selfIngester {
+	host, _ := os.Hostname()
+	return &selfIngester{queue: make(chan Log, selfIngestQueueSize), host: host}
+}
+
+// Run stores the queued records in storage until ctx is cancelled, in batches of
+// what arrived while the previous batch was stored
+func (si *selfIngester) Run(ctx context.Context, storage *LogStorage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case log := <-si.queue:
+			batch := []Log{log}
+		collect:
+			for {
+				select {
+				case log := <-si.queue:
+					batch = append(batch, log)
+				default:
+					break collect
+				}
+			}
+			if err := storage.IngestBatch(batch); err != nil {
+				selfIngestDropped.Add(int64(len(batch)))
+			}
+		}
+	}
+}
+
+// selfIngestHandler hands every record its Handler writes to a selfIngester
+type selfIngestHandler struct {
+	slog.Handler
+	self   *selfIngester
+	attrs  []slog.Attr // added with WithAttrs, keys prefixed by their groups
+	prefix string      // groups opened with WithGroup, each followed by "."
+}
+
+func (h *selfIngestHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.Handler.Handle(ctx, r)
+
+	var message strings.Builder
+	message.WriteString(r.Message)
+	write := func(key string, value slog.Value) {
+		message.WriteString(" " + key + "=" + value.Resolve().String())
+	}
+	for _, attr := range h.attrs {
+		write(attr.Key, attr.Value)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		write(h.prefix+attr.Key, attr.Value)
+		return true
+	})
+
+	log := Log{
+		Level:      selfIngestLevel(r.Level),
+		Message:    message.String(),
+		ResourceID: selfIngestResource,
+		Timestamp:  r.Time.UTC(),
+		Metadata:   Metadata{ParentResourceID: h.self.host},
+		IngestedAt: time.Now().UTC(),
+	}
+	select {
+	case h.self.queue <- log:
+	default:
+		selfIngestDropped.Inc()
+	}
+
+	return err
+}
+
+func (h *selfIngestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &selfIngestHandler{Handler: h.Handler.WithAttrs(attrs), self: h.self, prefix: h.prefix}
+	next.attrs = append(next.attrs, h.attrs...)
+	for _, attr := range attrs {
+		next.attrs = append(next.attrs, slog.Attr{Key: h.prefix + attr.Key, Value: attr.Value})
+	}
+	return next
+}
+
+func (h *selfIngestHandler) WithGroup(name string) slog.Handler {
+	return &selfIngestHandler{Handler: h.Handler.WithGroup(name), self: h.self, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// selfIngestLevel names a record level by the default levels of the ingestor
+func selfIngestLevel(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warn"
+	}
+	return "error"
+}
+
+// requestLogKey is the context key of the attributes logged with a request
+type requestLogKey struct{}
+
+// requestLog collects the attributes handlers add to the log line of their request
+type requestLog struct {
+	attrs []slog.Attr
+}
+
+// logAttrs adds attributes to the log line of the request of ctx, if it is logged
+func logAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.attrs = append(rl.attrs, attrs...)
+	}
+}
+
+// quietPaths are polled by probes and scrapers, so their requests are only
+// logged at debug level
+var quietPaths = map[string]bool{"/metrics": true, "/healthz": true, "/readyz": true}
+
+// logRequests logs every request once it is served, with its route, status,
+// size and duration and the attributes its handler added; server errors are
+// logged at error level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rl := &requestLog{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl))
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case quietPaths[r.URL.Path]:
+			level = slog.LevelDebug
+		}
+		attrs := append([]slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+		}, rl.attrs...)
+		logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// statusRecorder remembers the status and counts the body bytes of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if !sr.wrote {
+		sr.status, sr.wrote = status, true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	sr.wrote = true
+	n, err := sr.ResponseWriter.Write(p)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,321 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Grafana Loki push API (/loki/api/v1/push, snappy protobuf or JSON) for Promtail and other Loki clients
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiTenantHeader names the tenant of Loki clients, e.g. Promtail's tenant_id,
+// read when X-Tenant-ID is not set
+const lokiTenantHeader = "X-Scope-OrgID"
+
+var (
+	lokiEntries  = metrics.Counter("logingestor_loki_entries_total", "Loki push entries received")
+	lokiRejected = metrics.Counter("logingestor_loki_rejected_total", "Loki push entries rejected by validation")
+)
+
+// lokiResourceLabels are the labels used as resourceId, in order of preference
+var lokiResourceLabels = []string{"resourceId", "instance", "host", "hostname", "service_name", "job"}
+
+// lokiFieldLabels are the labels and structured metadata filling a log field
+// rather than a metadata key
+var lokiFieldLabels = map[string]string{
+	"level": "level", "detected_level": "level", "severity": "level",
+	"traceId": "traceId", "trace_id": "traceId",
+	"spanId": "spanId", "span_id": "spanId",
+	"commit": "commit", "parentResourceId": "parentResourceId",
+}
+
+// lokiEntry is a pushed log line with the labels of its stream
+type lokiEntry struct {
+	labels   map[string]string
+	time     time.Time
+	line     string
+	metadata map[string]string // structured metadata of the entry
+}
+
+// toLog maps an entry onto the log schema: the line is the message, a stream
+// label the resourceId, and the level, trace and commit labels or structured
+// metadata fill their fields; the other labels and structured metadata become
+// metadata keys. A missing level is info.
+func (entry lokiEntry) toLog() Log {
+	log := Log{Message: entry.line, Timestamp: entry.time}
+	for _, values := range []map[string]string{entry.labels, entry.metadata} {
+		for name, value := range values {
+			switch lokiFieldLabels[name] {
+			case "level":
+				log.Level = value
+			case "traceId":
+				log.TraceID = value
+			case "spanId":
+				log.SpanID = value
+			case "commit":
+				log.Commit = value
+			case "parentResourceId":
+				log.Metadata.ParentResourceID = value
+			default:
+				if name == "resourceId" {
+					continue
+				}
+				if log.Metadata.Fields == nil {
+					log.Metadata.Fields = make(map[string]any)
+				}
+				log.Metadata.Fields[name] = value
+			}
+		}
+	}
+	if log.Level == "" {
+		log.Level = "info"
+	}
+	for _, name := range lokiResourceLabels {
+		if value := entry.labels[name]; value != "" {
+			log.ResourceID = value
+			break
+		}
+	}
+	return log
+}
+
+// parseLokiLabels parses a stream selector of label matchers, {name="value", ...}
+func parseLokiLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid labels %q: expected {name=\"value\", ...}", s)
+	}
+	labels := make(map[string]string)
+	for rest := strings.TrimSpace(s[1 : len(s)-1]); rest != ""; {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid labels %q", s)
+		}
+		name := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimSpace(rest[eq+1:])
+		end := quotedEnd(rest)
+		if end < 0 {
+			return nil, fmt.Errorf("invalid labels %q: unterminated value of %s", s, name)
+		}
+		value, err := strconv.Unquote(rest[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels %q: %v", s, err)
+		}
+		labels[name] = value
+		rest = strings.TrimSpace(rest[end:])
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("invalid labels %q: expected a comma after %s", s, name)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return labels, nil
+}
+
+// quotedEnd returns the length of the double-quoted string s starts with, -1 if
+// it does not start with one
+func quotedEnd(s string) int {
+	if s == "" || s[0] != '"' {
+		return -1
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// decodeLokiProto decodes a PushRequest: streams (1) of labels (1) and entries
+// (2), each a timestamp (1), line (2) and structured metadata pairs (3)
+func decodeLokiProto(b []byte) ([]lokiEntry, error) {
+	var entries []lokiEntry
+	err := parseProto(b, func(f protoField) error {
+		if f.Num != 1 {
+			return nil
+		}
+
+		var labels map[string]string
+		var raw [][]byte
+		err := parseProto(f.Bytes, func(sf protoField) error {
+			var err error
+			switch sf.Num {
+			case 1:
+				labels, err = parseLokiLabels(sf.String())
+			case 2: // decoded once the labels are known
+				raw = append(raw, sf.Bytes)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, b := range raw {
+			entry := lokiEntry{labels: labels}
+			err := parseProto(b, func(ef protoField) error {
+				var err error
+				switch ef.Num {
+				case 1:
+					entry.time, err = parseProtoTimestamp(ef.Bytes)
+				case 2:
+					entry.line = ef.String()
+				case 3:
+					var name, value string
+					err = parseProto(ef.Bytes, func(pf protoField) error {
+						switch pf.Num {
+						case 1:
+							name = pf.String()
+						case 2:
+							value = pf.String()
+						}
+						return nil
+					})
+					if entry.metadata == nil {
+						entry.metadata = make(map[string]string)
+					}
+					entry.metadata[name] = value
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// lokiPushJSON is the JSON body of a push: streams of labels and
+// [nanoseconds, line, structured metadata?] values
+type lokiPushJSON struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+// decodeLokiJSON decodes the JSON body of a push
+func decodeLokiJSON(b []byte) ([]lokiEntry, error) {
+	var push lokiPushJSON
+	if err := json.Unmarshal(b, &push); err != nil {
+		return nil, err
+	}
+
+	var entries []lokiEntry
+	for _, stream := range push.Streams {
+		for _, value := range stream.Values {
+			if len(value) < 2 || len(value) > 3 {
+				return nil, errors.New("a value must be [timestamp, line] or [timestamp, line, structured metadata]")
+			}
+			entry := lokiEntry{labels: stream.Stream}
+			var ns string
+			if err := json.Unmarshal(value[0], &ns); err != nil {
+				return nil, fmt.Errorf("invalid timestamp %s: expected a string of nanoseconds", value[0])
+			}
+			n, err := strconv.ParseInt(ns, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q: expected nanoseconds since the epoch", ns)
+			}
+			entry.time = time.Unix(0, n).UTC()
+			if err := json.Unmarshal(value[1], &entry.line); err != nil {
+				return nil, fmt.Errorf("invalid line %s: expected a string", value[1])
+			}
+			if len(value) == 3 {
+				if err := json.Unmarshal(value[2], &entry.metadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata %s: expected an object of strings", value[2])
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// handleLokiPush receives a push request of a Loki client, such as Promtail: a
+// snappy-compressed protobuf PushRequest or its JSON form. Valid entries are
+// stored and 204 returned; if any was rejected the response is a 400 saying
+// how many, which Loki clients do not retry.
+func (s *Server) handleLokiPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-protobuf" && mediaType != "application/json" && mediaType != "" {
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, "Content-Type must be application/x-protobuf or application/json", nil)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+
+	var entries []lokiEntry
+	if mediaType == "application/json" {
+		entries, err = decodeLokiJSON(body)
+	} else if body, err = decodeSnappy(body, s.cfg.MaxDecompressedSize); err == nil {
+		entries, err = decodeLokiProto(body)
+	} else if errors.Is(err, errSnappyTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge,
+			fmt.Sprintf("Decompressed request body exceeds %d bytes", s.cfg.MaxDecompressedSize), nil)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Error decoding Loki push request", err.Error())
+		return
+	}
+	lokiEntries.Add(int64(len(entries)))
+
+	var valid []Log
+	var reason string
+	for _, entry := range entries {
+		log := entry.toLog()
+		if err := s.validator.Validate(&log); err != nil {
+			if reason == "" {
+				reason = err.Error()
+			}
+			payload, _ := json.Marshal(log)
+			s.rejectRequest(r, "loki", rejectionReason(err), payload)
+			continue
+		}
+		valid = append(valid, log)
+	}
+	rejected := len(entries) - len(valid)
+	lokiRejected.Add(int64(rejected))
+
+	logs, err := s.admit(r.Context(), "loki", valid)
+	if err == nil {
+		_, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	if err != nil {
+		writeStoreError(w, err, "Error storing logs")
+		return
+	}
+	logAttrs(r.Context(), slog.Int("accepted", len(valid)), slog.Int("rejected", rejected))
+
+	if rejected > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidation,
+			fmt.Sprintf("%d of %d entries were rejected: %s", rejected, len(entries), reason), nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
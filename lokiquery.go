@@ -0,0 +1,810 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Grafana Loki query API (query_range, query, labels), translating LogQL to LQL
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogQL subset:
+//
+//	query    = selector { filter } | metric | vector { "+" vector }
+//	selector = "{" [ matcher { "," matcher } ] "}"
+//	matcher  = label ( "=" | "!=" | "=~" | "!~" ) string
+//	filter   = ( "|=" | "!=" | "|~" | "!~" ) string | "|" "drop" label { "," label }
+//	metric   = "sum" [ by ] "(" range ")" [ by ] | range
+//	range    = ( "count_over_time" | "rate" ) "(" selector { filter } "[" duration "]" ")"
+//	by       = "by" "(" [ label { "," label } ] ")"
+//	vector   = "vector" "(" number ")"
+//
+// Label matchers are anchored regular expressions, line filters are not, as in
+// Loki; e.g. sum by (level) (count_over_time({job="api"} |= "timeout" [5m]))
+
+// lokiDefaultLimit is the number of lines a log query returns without a limit
+const lokiDefaultLimit = 100
+
+// lokiMaxPoints bounds the steps of a metric query, as Loki does
+const lokiMaxPoints = 11000
+
+// logqlQuery is a parsed LogQL query. A log query selects the lines matching
+// its LQL translation; a metric query counts them per series over a range.
+type logqlQuery struct {
+	lql      string        // the selector and line filters as LQL, empty for every log
+	drop     []string      // labels dropped from the streams
+	function string        // count_over_time or rate; empty for a log query
+	over     time.Duration // range of function
+	sum      bool          // series summed into those of the by labels
+	by       []string
+	constant bool // a sum of vector() literals, worth value at every step
+	value    float64
+}
+
+// logqlParser parses LogQL with the tokens and helpers of the LQL parser
+type logqlParser struct {
+	lqlParser
+	query logqlQuery
+	terms []string // LQL comparisons of the matchers and line filters, ANDed
+}
+
+// lqlEscaper escapes a value for a quoted LQL string
+var lqlEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// lqlQuote quotes a value for LQL
+func lqlQuote(value string) string { return `"` + lqlEscaper.Replace(value) + `"` }
+
+// lexLogQL splits a LogQL query into tokens
+func lexLogQL(query string) ([]lqlToken, error) {
+	var tokens []lqlToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("{}()[],+", c) >= 0:
+			tokens = append(tokens, lqlToken{kind: string(c), text: string(c), start: i})
+			i++
+		case c == '"':
+			end := quotedEnd(query[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			text, err := strconv.Unquote(query[i : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", i, err)
+			}
+			tokens = append(tokens, lqlToken{kind: "string", text: text, start: i})
+			i += end
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, lqlToken{kind: "string", text: query[i+1 : i+1+end], start: i})
+			i += end + 2
+		case strings.IndexByte("=!|", c) >= 0:
+			op := string(c)
+			if i+1 < len(query) && (query[i+1] == '=' || query[i+1] == '~') {
+				op = query[i : i+2]
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q at position %d", op, i)
+			}
+			tokens = append(tokens, lqlToken{kind: "op", text: op, start: i})
+			i += len(op)
+		default:
+			j := i
+			for j < len(query) && strings.IndexByte(" \t\r\n{}()[],+=!|\"`", query[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, lqlToken{kind: "word", text: query[i:j], start: i})
+			i = j
+		}
+	}
+
+	return append(tokens, lqlToken{kind: "end", start: len(query)}), nil
+}
+
+// parseLogQL parses a LogQL query of the supported subset
+func parseLogQL(query string) (logqlQuery, error) {
+	if strings.TrimSpace(query) == "" {
+		return logqlQuery{}, errors.New("must not be empty")
+	}
+	tokens, err := lexLogQL(query)
+	if err != nil {
+		return logqlQuery{}, err
+	}
+
+	p := &logqlParser{lqlParser: lqlParser{tokens: tokens}}
+	if err := p.parseQuery(); err != nil {
+		return logqlQuery{}, err
+	}
+	if next := p.peek(); next.kind != "end" {
+		return logqlQuery{}, fmt.Errorf("unexpected %q at position %d", next.text, next.start)
+	}
+	p.query.lql = strings.Join(p.terms, " AND ")
+	return p.query, nil
+}
+
+// expect consumes the next token, which must be of kind
+func (p *logqlParser) expect(kind string) error {
+	if token := p.next(); token.kind != kind {
+		return fmt.Errorf("expected %s at position %d", kind, token.start)
+	}
+	return nil
+}
+
+func (p *logqlParser) parseQuery() error {
+	switch {
+	case p.peek().kind == "{":
+		return p.parseLog()
+	case p.peek().kind == "word" && p.peek().text == "vector":
+		p.query.constant = true
+		for {
+			p.next()
+			if err := p.expect("("); err != nil {
+				return err
+			}
+			number := p.next()
+			value, err := strconv.ParseFloat(number.text, 64)
+			if number.kind != "word" || err != nil {
+				return fmt.Errorf("expected a number at position %d", number.start)
+			}
+			p.query.value += value
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+			if p.peek().kind != "+" {
+				return nil
+			}
+			p.next()
+			if next := p.peek(); next.kind != "word" || next.text != "vector" {
+				return fmt.Errorf("expected vector at position %d", next.start)
+			}
+		}
+	case p.keyword("sum"):
+		p.query.sum = true
+		if err := p.parseBy(); err != nil {
+			return err
+		}
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		if err := p.parseRange(); err != nil {
+			return err
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		if p.query.by == nil {
+			return p.parseBy()
+		}
+		return nil
+	}
+
+	return p.parseRange()
+}
+
+// parseBy parses the grouping labels of sum, if given
+func (p *logqlParser) parseBy() error {
+	if p.keyword("without") {
+		return errors.New("sum without is not supported, use sum by")
+	}
+	if !p.keyword("by") {
+		return nil
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	p.query.by = []string{}
+	for p.peek().kind == "word" {
+		p.query.by = append(p.query.by, p.next().text)
+		if p.peek().kind != "," {
+			break
+		}
+		p.next()
+	}
+	return p.expect(")")
+}
+
+// parseRange parses a range aggregation of a log query
+func (p *logqlParser) parseRange() error {
+	function := p.next()
+	if function.kind != "word" || (function.text != "count_over_time" && function.text != "rate") {
+		return fmt.Errorf("unsupported expression %q at position %d: expected a stream selector, count_over_time, rate, sum or vector", function.text, function.start)
+	}
+	p.query.function = function.text
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if err := p.parseLog(); err != nil {
+		return err
+	}
+	if err := p.expect("["); err != nil {
+		return err
+	}
+	duration := p.next()
+	over, err := parseDuration(duration.text)
+	if duration.kind != "word" || err != nil || over <= 0 {
+		return fmt.Errorf("expected a range such as 5m at position %d", duration.start)
+	}
+	p.query.over = over
+	if err := p.expect("]"); err != nil {
+		return err
+	}
+	return p.expect(")")
+}
+
+// parseLog parses a stream selector and the pipeline following it
+func (p *logqlParser) parseLog() error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for p.peek().kind != "}" {
+		name, op, value := p.next(), p.next(), p.next()
+		if name.kind != "word" || !isLabelName(name.text) {
+			return fmt.Errorf("expected a label name at position %d", name.start)
+		}
+		if op.kind != "op" || (op.text != "=" && op.text != "!=" && op.text != "=~" && op.text != "!~") {
+			return fmt.Errorf("expected =, !=, =~ or !~ after %s at position %d", name.text, op.start)
+		}
+		if value.kind != "string" {
+			return fmt.Errorf("expected a quoted value after %s%s at position %d", name.text, op.text, value.start)
+		}
+		comparison := value.text
+		if op.text == "=~" || op.text == "!~" {
+			if _, err := compiledPatterns.compile(value.text); err != nil {
+				return fmt.Errorf("regular expression %q: %v", value.text, err)
+			}
+			comparison = "^(?:" + value.text + ")$"
+		}
+		if err := p.compare(logqlField(name.text), strings.Replace(op.text, "!~", "=~", 1), comparison, op.text == "!~"); err != nil {
+			return err
+		}
+		if p.peek().kind != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("}"); err != nil {
+		return err
+	}
+
+	for {
+		op := p.peek()
+		if op.kind != "op" {
+			return nil
+		}
+		switch op.text {
+		case "|=", "!=", "|~", "!~":
+			p.next()
+			value := p.next()
+			if value.kind != "string" {
+				return fmt.Errorf("expected a quoted value after %s at position %d", op.text, value.start)
+			}
+			if value.text == "" && (op.text == "|=" || op.text == "|~") {
+				continue // matches every line, as Grafana sends by default
+			}
+			lqlOp := "~"
+			if op.text[1] == '~' {
+				lqlOp = "=~"
+			}
+			if err := p.compare("message", lqlOp, value.text, op.text[0] == '!'); err != nil {
+				return err
+			}
+		case "|":
+			p.next()
+			if !p.keyword("drop") {
+				stage := p.peek()
+				return fmt.Errorf("unsupported pipeline stage %q at position %d: only line filters and drop are", stage.text, stage.start)
+			}
+			for p.peek().kind == "word" {
+				p.query.drop = append(p.query.drop, p.next().text)
+				if p.peek().kind != "," {
+					break
+				}
+				p.next()
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// compare adds the LQL comparison of a matcher or line filter, op being an LQL
+// operator, negated with NOT
+func (p *logqlParser) compare(field, op, value string, negated bool) error {
+	if op == "=~" {
+		if _, err := compiledPatterns.compile(value); err != nil {
+			return fmt.Errorf("regular expression %q: %v", value, err)
+		}
+	}
+	term := field + op + lqlQuote(value)
+	if negated {
+		term = "NOT " + term
+	}
+	p.terms = append(p.terms, term)
+	return nil
+}
+
+// logqlField returns the log field a label stands for, as the push API stores
+// it: a field label (see lokiFieldLabels), resourceId, or a metadata key
+func logqlField(label string) string {
+	switch field := lokiFieldLabels[label]; field {
+	case "":
+		if label == "resourceId" {
+			return label
+		}
+		return metadataPrefix + label
+	case "parentResourceId":
+		return "metadata.parentResourceId"
+	default:
+		return field
+	}
+}
+
+// isLabelName reports whether name is a valid Loki label name
+func isLabelName(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// lokiLabels returns the stream labels of a log: resourceId, level, the parent
+// resource and the scalar top-level metadata keys
+func lokiLabels(log Log) map[string]string {
+	labels := make(map[string]string, len(log.Metadata.Fields)+3)
+	for name, value := range log.Metadata.Fields {
+		switch value.(type) {
+		case map[string]any, []any:
+			continue
+		}
+		if value := formatValue(value); value != "" && isLabelName(name) {
+			labels[name] = value
+		}
+	}
+	for name, value := range map[string]string{"resourceId": log.ResourceID, "level": log.Level, "parentResourceId": log.Metadata.ParentResourceID} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// lokiTime parses a Loki time parameter: nanoseconds, seconds (with a fraction
+// or at most 10 digits) or RFC 3339; empty is def
+func lokiTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if strings.Contains(value, ".") {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			seconds, fraction := math.Modf(f)
+			return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+		}
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if len(value) <= 10 {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.Unix(0, n).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected nanoseconds, seconds or an RFC 3339 time")
+	}
+	return t, nil
+}
+
+// lokiStep parses the step of a range query, seconds or a duration; without it
+// the range is split into about 250 steps of whole seconds, as in Loki
+func lokiStep(value string, start, end time.Time) (time.Duration, error) {
+	if value == "" {
+		return max(end.Sub(start)/250/time.Second*time.Second, time.Second), nil
+	}
+	step, err := parseDuration(value)
+	if f, ferr := strconv.ParseFloat(value, 64); ferr == nil {
+		step, err = time.Duration(f*float64(time.Second)), nil
+	}
+	if err != nil || step <= 0 {
+		return 0, errors.New("expected a positive number of seconds or a duration such as 30s")
+	}
+	return step, nil
+}
+
+// lokiResponse is the body of the Loki query and label responses
+type lokiResponse struct {
+	Status string `json:"status"` // always success, errors being writeError ones
+	Data   any    `json:"data"`
+}
+
+// lokiQueryData is the data of a query response: streams, matrix or vector results
+type lokiQueryData struct {
+	ResultType string   `json:"resultType"`
+	Result     any      `json:"result"`
+	Stats      struct{} `json:"stats"`
+}
+
+// lokiStream is a stream of a log query result and its [nanoseconds, line] values
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiMatrixSeries is a series of a range query result, [seconds, "value"] each
+type lokiMatrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+// lokiVectorSample is a series of an instant query result
+type lokiVectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+// logqlPoint is the value of a series at one step
+type logqlPoint struct {
+	time  time.Time
+	value float64
+}
+
+// logqlSeries is a series of a metric query
+type logqlSeries struct {
+	labels map[string]string
+	points []logqlPoint
+}
+
+// lokiSample encodes a point as Loki does, seconds and a decimal string
+func lokiSample(point logqlPoint) [2]any {
+	return [2]any{json.Number(strconv.FormatFloat(float64(point.time.UnixMilli())/1000, 'f', -1, 64)), strconv.FormatFloat(point.value, 'f', -1, 64)}
+}
+
+// streamLabels returns the labels of a log after drop
+func (q logqlQuery) streamLabels(log Log) map[string]string {
+	labels := lokiLabels(log)
+	for _, name := range q.drop {
+		delete(labels, name)
+	}
+	return labels
+}
+
+// streams groups the logs of a log query by labels, in the order of the logs
+func (q logqlQuery) streams(logs []Log) []lokiStream {
+	streams := []lokiStream{}
+	index := make(map[string]int)
+	for _, log := range logs {
+		labels := q.streamLabels(log)
+		key, _ := json.Marshal(labels) // maps encode in key order
+		i, ok := index[string(key)]
+		if !ok {
+			i = len(streams)
+			index[string(key)] = i
+			streams = append(streams, lokiStream{Stream: labels})
+		}
+		streams[i].Values = append(streams[i].Values, [2]string{strconv.FormatInt(log.Timestamp.UnixNano(), 10), log.Message})
+	}
+	return streams
+}
+
+// eval evaluates a metric query at steps points from start: the logs of the
+// range ending at each, counted or per second, summed per series. Points
+// without logs are left out, as in Loki.
+func (q logqlQuery) eval(logs []Log, start time.Time, steps int, step time.Duration) []logqlSeries {
+	if q.constant {
+		series := logqlSeries{labels: map[string]string{}}
+		for i := range steps {
+			series.points = append(series.points, logqlPoint{start.Add(time.Duration(i) * step), q.value})
+		}
+		return []logqlSeries{series}
+	}
+
+	counts := make(map[string][]int)
+	labels := make(map[string]map[string]string)
+	for _, log := range logs {
+		series := q.streamLabels(log)
+		if q.sum {
+			grouped := make(map[string]string, len(q.by))
+			for _, name := range q.by {
+				if value, ok := series[name]; ok {
+					grouped[name] = value
+				}
+			}
+			series = grouped
+		}
+		key, _ := json.Marshal(series)
+		if counts[string(key)] == nil {
+			counts[string(key)] = make([]int, steps)
+			labels[string(key)] = series
+		}
+
+		// The log counts at the steps t with t-over < timestamp <= t
+		first := 0
+		if d := log.Timestamp.Sub(start); d > 0 {
+			first = int((d + step - 1) / step)
+		}
+		end := log.Timestamp.Add(q.over)
+		for i := first; i < steps && start.Add(time.Duration(i)*step).Before(end); i++ {
+			counts[string(key)][i]++
+		}
+	}
+
+	var result []logqlSeries
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		series := logqlSeries{labels: labels[key]}
+		for i, count := range counts[key] {
+			if count == 0 {
+				continue
+			}
+			value := float64(count)
+			if q.function == "rate" {
+				value /= q.over.Seconds()
+			}
+			series.points = append(series.points, logqlPoint{start.Add(time.Duration(i) * step), value})
+		}
+		if len(series.points) > 0 {
+			result = append(result, series)
+		}
+	}
+	return result
+}
+
+// lokiParams returns the parameters of a Loki request, of its URL or form body
+func lokiParams(w http.ResponseWriter, r *http.Request) (map[string]string, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return nil, errors.New("method not allowed")
+	}
+	if err := r.ParseForm(); err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid parameters: %v", err))
+		return nil, err
+	}
+	params := make(map[string]string, len(r.Form))
+	for key := range r.Form {
+		params[key] = r.Form.Get(key)
+	}
+	return params, nil
+}
+
+// lokiLogs returns the logs of the caller's tenant and scope matching a LogQL
+// query from from to to, both included, in timestamp order, up to limit (0 is
+// no limit), from every node in cluster mode. On error the response is written.
+func (s *Server) lokiLogs(w http.ResponseWriter, r *http.Request, q logqlQuery, from, to time.Time, limit int, desc bool) ([]Log, error) {
+	fields := map[string]json.RawMessage{}
+	set := func(key string, value any) { fields[key], _ = json.Marshal(value) }
+	set("timestamp_from", from.Format(time.RFC3339Nano))
+	set("timestamp_to", to.Format(time.RFC3339Nano))
+	set("sort", "timestamp")
+	if desc {
+		set("sort", "timestamp:desc")
+	}
+	if q.lql != "" {
+		set("q", q.lql)
+	}
+	if limit > 0 {
+		set("limit", limit)
+	}
+
+	auditQuery(r.Context(), fields)
+	req, err := buildQuery(fields, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return nil, err
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return nil, err
+	}
+	if limit == 0 {
+		req.Options.Limit = 0
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var logs []Log
+	if s.cluster != nil {
+		body, err := peerQueryBody(fields)
+		if err != nil {
+			writeInternalError(w, "Error encoding JSON")
+			return nil, err
+		}
+		if logs, _, err = s.clusterLogs(ctx, r, body, &req); err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return nil, err
+		}
+	} else if logs, _, err = s.tenant(r.Context()).storage.Query(ctx, req.Filters, req.Options); err != nil {
+		writeQueryError(w, err, PartialResponse{})
+		return nil, err
+	}
+	logAttrs(r.Context(), slog.Int("results", len(logs)))
+	auditResults(r.Context(), len(logs))
+	return logs, nil
+}
+
+// lokiMetric evaluates a metric query at steps points from start
+func (s *Server) lokiMetric(w http.ResponseWriter, r *http.Request, q logqlQuery, start time.Time, steps int, step time.Duration) ([]logqlSeries, error) {
+	var logs []Log
+	if !q.constant {
+		var err error
+		end := start.Add(time.Duration(steps-1) * step)
+		if logs, err = s.lokiLogs(w, r, q, start.Add(-q.over+time.Nanosecond), end, 0, false); err != nil {
+			return nil, err
+		}
+	}
+	return q.eval(logs, start, steps, step), nil
+}
+
+// handleLokiQueryRange answers a Loki range query: the lines of a log query
+// from start to end, newest first unless direction=forward, or the series of a
+// metric query at each step
+func (s *Server) handleLokiQueryRange(w http.ResponseWriter, r *http.Request) {
+	params, err := lokiParams(w, r)
+	if err != nil {
+		return
+	}
+	q, err := parseLogQL(params["query"])
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid query: %v", err))
+		return
+	}
+	end, err := lokiTime(params["end"], time.Now().UTC())
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid end: %v", err))
+		return
+	}
+	start, err := lokiTime(params["start"], end.Add(-time.Hour))
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid start: %v", err))
+		return
+	}
+	if end.Before(start) {
+		writeValidationError(w, errors.New("Invalid time range: end is before start"))
+		return
+	}
+
+	if q.function == "" && !q.constant {
+		limit := lokiDefaultLimit
+		if value, ok := params["limit"]; ok {
+			if limit, err = strconv.Atoi(value); err != nil {
+				writeValidationError(w, errors.New("Invalid limit: expected an integer"))
+				return
+			}
+		}
+		direction := params["direction"]
+		if direction != "" && direction != "forward" && direction != "backward" {
+			writeValidationError(w, errors.New("Invalid direction: expected forward or backward"))
+			return
+		}
+		// The end is excluded, as in Loki
+		logs, err := s.lokiLogs(w, r, q, start, end.Add(-time.Nanosecond), limit, direction != "forward")
+		if err != nil {
+			return
+		}
+		writeJSON(w, http.StatusOK, lokiResponse{Status: "success", Data: lokiQueryData{ResultType: "streams", Result: q.streams(logs)}})
+		return
+	}
+
+	step, err := lokiStep(params["step"], start, end)
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid step: %v", err))
+		return
+	}
+	steps := end.Sub(start)/step + 1
+	if steps > lokiMaxPoints {
+		writeValidationError(w, fmt.Errorf("Invalid step: the range would have more than %d points, use a larger step", lokiMaxPoints))
+		return
+	}
+	series, err := s.lokiMetric(w, r, q, start, int(steps), step)
+	if err != nil {
+		return
+	}
+	matrix := make([]lokiMatrixSeries, len(series))
+	for i, series := range series {
+		matrix[i] = lokiMatrixSeries{Metric: series.labels}
+		for _, point := range series.points {
+			matrix[i].Values = append(matrix[i].Values, lokiSample(point))
+		}
+	}
+	writeJSON(w, http.StatusOK, lokiResponse{Status: "success", Data: lokiQueryData{ResultType: "matrix", Result: matrix}})
+}
+
+// handleLokiQuery answers a Loki instant query, the series of a metric query
+// at time; log queries are only answered over a range, as in Loki 3
+func (s *Server) handleLokiQuery(w http.ResponseWriter, r *http.Request) {
+	params, err := lokiParams(w, r)
+	if err != nil {
+		return
+	}
+	q, err := parseLogQL(params["query"])
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid query: %v", err))
+		return
+	}
+	if q.function == "" && !q.constant {
+		writeValidationError(w, errors.New("Invalid query: log queries are not supported as instant queries, use /loki/api/v1/query_range"))
+		return
+	}
+	at, err := lokiTime(params["time"], time.Now().UTC())
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid time: %v", err))
+		return
+	}
+
+	series, err := s.lokiMetric(w, r, q, at, 1, time.Second)
+	if err != nil {
+		return
+	}
+	vector := make([]lokiVectorSample, len(series))
+	for i, series := range series {
+		vector[i] = lokiVectorSample{Metric: series.labels, Value: lokiSample(series.points[0])}
+	}
+	writeJSON(w, http.StatusOK, lokiResponse{Status: "success", Data: lokiQueryData{ResultType: "vector", Result: vector}})
+}
+
+// handleLokiLabels lists the label names, or the values of the label {name},
+// of the most recent logs from start to end matching the optional query, up to
+// max-page-size logs
+func (s *Server) handleLokiLabels(w http.ResponseWriter, r *http.Request) {
+	params, err := lokiParams(w, r)
+	if err != nil {
+		return
+	}
+	var q logqlQuery
+	if params["query"] != "" {
+		if q, err = parseLogQL(params["query"]); err == nil && (q.function != "" || q.constant) {
+			err = errors.New("expected a stream selector")
+		}
+		if err != nil {
+			writeValidationError(w, fmt.Errorf("Invalid query: %v", err))
+			return
+		}
+	}
+	end, err := lokiTime(params["end"], time.Now().UTC())
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid end: %v", err))
+		return
+	}
+	start, err := lokiTime(params["start"], end.Add(-6*time.Hour))
+	if err != nil {
+		writeValidationError(w, fmt.Errorf("Invalid start: %v", err))
+		return
+	}
+
+	logs, err := s.lokiLogs(w, r, q, start, end, s.cfg.MaxPageSize, true)
+	if err != nil {
+		return
+	}
+	name := r.PathValue("name")
+	found := make(map[string]bool)
+	for _, log := range logs {
+		labels := q.streamLabels(log)
+		if name == "" {
+			for label := range labels {
+				found[label] = true
+			}
+		} else if value, ok := labels[name]; ok {
+			found[value] = true
+		}
+	}
+	values := slices.Sorted(maps.Keys(found))
+	if values == nil {
+		values = []string{}
+	}
+	writeJSON(w, http.StatusOK, lokiResponse{Status: "success", Data: values})
+}
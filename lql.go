@@ -0,0 +1,335 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : LQL, a small query language with AND/OR/NOT accepted in the "q" field of /query
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LQL grammar:
+//
+//	expr       = and { "OR" and }
+//	and        = unary { "AND" unary }
+//	unary      = "NOT" unary | "(" expr ")" | comparison
+//	comparison = field op value
+//	op         = "=" | "!=" | "~" (contains) | "=~" (regular expression) | "<" | "<=" | ">" | ">="
+//	value      = "quoted string" | bare-word
+//
+// Keywords are case-insensitive. Ordering operators only apply to timestamp, e.g.
+// level=error AND (resourceId=server-1 OR resourceId=server-2) AND message~"timeout"
+
+// lqlNode is a node of a parsed LQL expression
+type lqlNode interface {
+	// eval reports whether a log matches the expression
+	eval(log Log) bool
+	// positions returns a sorted superset of the matching positions in a shard, or ok=false
+	// when the expression cannot be resolved through the indexes
+	positions(sh *shard) (positions []int, ok bool)
+}
+
+type lqlAnd struct{ left, right lqlNode }
+type lqlOr struct{ left, right lqlNode }
+type lqlNot struct{ operand lqlNode }
+
+// lqlCompare compares one field with a value
+type lqlCompare struct {
+	field string
+	op    string
+	value string
+	time  time.Time // parsed value of timestamp comparisons
+}
+
+func (n lqlAnd) eval(log Log) bool { return n.left.eval(log) && n.right.eval(log) }
+func (n lqlOr) eval(log Log) bool  { return n.left.eval(log) || n.right.eval(log) }
+func (n lqlNot) eval(log Log) bool { return !n.operand.eval(log) }
+
+func (n lqlCompare) eval(log Log) bool {
+	if n.field == "timestamp" {
+		switch n.op {
+		case "=":
+			return log.Timestamp.Equal(n.time)
+		case "!=":
+			return !log.Timestamp.Equal(n.time)
+		case "<":
+			return log.Timestamp.Before(n.time)
+		case "<=":
+			return !log.Timestamp.After(n.time)
+		case ">":
+			return log.Timestamp.After(n.time)
+		case ">=":
+			return !log.Timestamp.Before(n.time)
+		}
+		return false
+	}
+
+	value := fieldValue(log, n.field)
+	switch n.op {
+	case "=":
+		return value == n.value
+	case "!=":
+		return value != n.value
+	case "~":
+		return strings.Contains(value, n.value)
+	case "=~":
+		return matchesRegex(value, n.value)
+	}
+
+	return false
+}
+
+func (n lqlAnd) positions(sh *shard) ([]int, bool) {
+	left, lok := n.left.positions(sh)
+	right, rok := n.right.positions(sh)
+	switch {
+	case lok && rok:
+		return intersect(left, right), true
+	case lok:
+		return left, true
+	case rok:
+		return right, true
+	}
+
+	return nil, false
+}
+
+func (n lqlOr) positions(sh *shard) ([]int, bool) {
+	left, lok := n.left.positions(sh)
+	if !lok {
+		return nil, false
+	}
+	right, rok := n.right.positions(sh)
+	if !rok {
+		return nil, false
+	}
+
+	return union(left, right), true
+}
+
+func (n lqlNot) positions(sh *shard) ([]int, bool) { return nil, false }
+
+func (n lqlCompare) positions(sh *shard) ([]int, bool) {
+	switch {
+	case n.op == "=" && slices.Contains(indexedFields, n.field):
+		return sh.index[n.field][n.value], true
+	case n.op == "~" && n.field == "message":
+		return sh.messages.lookup(n.value)
+	}
+
+	return nil, false
+}
+
+// union returns the positions present in either sorted list
+func union(a, b []int) []int {
+	result := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+
+	return append(result, b[j:]...)
+}
+
+// lqlFields lists the fields an LQL comparison may reference
+var lqlFields = []string{"level", "message", "resourceId", "timestamp", "traceId", "spanId", "commit", "metadata.parentResourceId"}
+
+// lqlToken is a lexical token; kind is "word", "string", "op", "(", ")" or "end"
+type lqlToken struct {
+	kind  string
+	text  string
+	start int
+}
+
+// lexLQL splits a query into tokens
+func lexLQL(query string) ([]lqlToken, error) {
+	var tokens []lqlToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, lqlToken{kind: string(c), text: string(c), start: i})
+			i++
+		case c == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(query) && query[j] != '"'; j++ {
+				if query[j] == '\\' && j+1 < len(query) {
+					j++
+				}
+				text.WriteByte(query[j])
+			}
+			if j == len(query) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, lqlToken{kind: "string", text: text.String(), start: i})
+			i = j + 1
+		case strings.IndexByte("=!~<>", c) >= 0:
+			op := string(c)
+			if i+1 < len(query) && (query[i+1] == '=' || (c == '=' && query[i+1] == '~')) {
+				op = query[i : i+2]
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q at position %d", op, i)
+			}
+			tokens = append(tokens, lqlToken{kind: "op", text: op, start: i})
+			i += len(op)
+		default:
+			j := i
+			for j < len(query) && strings.IndexByte(" \t\r\n()\"=!~<>", query[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, lqlToken{kind: "word", text: query[i:j], start: i})
+			i = j
+		}
+	}
+
+	return append(tokens, lqlToken{kind: "end", start: len(query)}), nil
+}
+
+// lqlParser is a recursive descent parser over the tokens of a query
+type lqlParser struct {
+	tokens []lqlToken
+	pos    int
+}
+
+// parseLQL parses a query into an expression tree
+func parseLQL(query string) (lqlNode, error) {
+	tokens, err := lexLQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &lqlParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != "end" {
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text, next.start)
+	}
+
+	return node, nil
+}
+
+func (p *lqlParser) peek() lqlToken { return p.tokens[p.pos] }
+
+func (p *lqlParser) next() lqlToken {
+	token := p.tokens[p.pos]
+	if token.kind != "end" {
+		p.pos++
+	}
+	return token
+}
+
+// keyword consumes the next token when it is the given keyword
+func (p *lqlParser) keyword(word string) bool {
+	if token := p.peek(); token.kind == "word" && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *lqlParser) parseOr() (lqlNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("OR") {
+		var right lqlNode
+		right, err = p.parseAnd()
+		left = lqlOr{left, right}
+	}
+
+	return left, err
+}
+
+func (p *lqlParser) parseAnd() (lqlNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.keyword("AND") {
+		var right lqlNode
+		right, err = p.parseUnary()
+		left = lqlAnd{left, right}
+	}
+
+	return left, err
+}
+
+func (p *lqlParser) parseUnary() (lqlNode, error) {
+	if p.keyword("NOT") {
+		operand, err := p.parseUnary()
+		return lqlNot{operand}, err
+	}
+
+	if p.peek().kind == "(" {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != ")" {
+			return nil, fmt.Errorf("expected ) at position %d", closing.start)
+		}
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *lqlParser) parseComparison() (lqlNode, error) {
+	field := p.next()
+	if field.kind != "word" {
+		return nil, fmt.Errorf("expected a field name at position %d", field.start)
+	}
+	if !slices.Contains(lqlFields, field.text) && !isMetadataPath(field.text) && customField(field.text) == nil {
+		return nil, fmt.Errorf("unknown field %q at position %d", field.text, field.start)
+	}
+
+	op := p.next()
+	if op.kind != "op" {
+		return nil, fmt.Errorf("expected an operator after %s at position %d", field.text, op.start)
+	}
+
+	value := p.next()
+	if value.kind != "word" && value.kind != "string" {
+		return nil, fmt.Errorf("expected a value after %s%s at position %d", field.text, op.text, value.start)
+	}
+
+	node := lqlCompare{field: field.text, op: op.text, value: value.text}
+	switch {
+	case field.text == "timestamp":
+		if op.text == "~" || op.text == "=~" {
+			return nil, fmt.Errorf("operator %s does not apply to timestamp", op.text)
+		}
+		t, err := time.Parse(time.RFC3339, value.text)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp %q at position %d: expected RFC3339 time", value.text, value.start)
+		}
+		node.time = t
+	case op.text == "<" || op.text == "<=" || op.text == ">" || op.text == ">=":
+		return nil, fmt.Errorf("operator %s only applies to timestamp", op.text)
+	case op.text == "=~":
+		if _, err := compiledPatterns.compile(value.text); err != nil {
+			return nil, fmt.Errorf("regular expression %q: %v", value.text, err)
+		}
+	}
+
+	return node, nil
+}
@@ -0,0 +1,194 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Search modes of the message filter: substring, case-insensitive, whole-word and fuzzy
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// messageModeKey is the query key selecting how the "message" filter matches
+const messageModeKey = "message_mode"
+
+// Search modes of the message filter
+const (
+	MessageContains        = "contains"         // substring, case-sensitive; the default
+	MessageCaseInsensitive = "case_insensitive" // substring, ignoring case
+	MessageWord            = "word"             // whole words, ignoring case
+	MessageFuzzy           = "fuzzy"            // every word within a few edits of a message word, ignoring case
+)
+
+var messageModes = []string{MessageContains, MessageCaseInsensitive, MessageWord, MessageFuzzy}
+
+// validateMessageMode checks the message_mode of a query's filters
+func validateMessageMode(filters map[string]string, sets ...map[string][]string) error {
+	mode, ok := filters[messageModeKey]
+	if !ok {
+		return nil
+	}
+	if !slices.Contains(messageModes, mode) {
+		return fmt.Errorf("Invalid message_mode: must be one of %s", strings.Join(messageModes, ", "))
+	}
+	if _, ok := filters["message"]; ok {
+		return nil
+	}
+	for _, set := range sets {
+		if _, ok := set["message"]; ok {
+			return nil
+		}
+	}
+	return errors.New("Invalid message_mode: requires a message filter")
+}
+
+// matchesMessage reports whether a message matches the term of a message filter
+// in mode; an empty mode is MessageContains
+func matchesMessage(message, term, mode string) bool {
+	if mode == "" || mode == MessageContains || term == "" {
+		return strings.Contains(message, term)
+	}
+	return messageRanges(message, term, mode) != nil
+}
+
+// messageRanges returns the sorted [start, end) byte ranges of the matches of
+// term in message in a mode other than MessageContains, nil without a match
+func messageRanges(message, term, mode string) [][2]int {
+	if mode == MessageFuzzy {
+		return fuzzyRanges(message, term)
+	}
+
+	re, err := compiledPatterns.compile("(?i)" + regexp.QuoteMeta(term))
+	if err != nil {
+		return nil
+	}
+	var ranges [][2]int
+	for _, match := range re.FindAllStringIndex(message, -1) {
+		if mode == MessageWord && !isWordBoundary(message, match[0], match[1]) {
+			continue
+		}
+		ranges = append(ranges, [2]int{match[0], match[1]})
+	}
+	return ranges
+}
+
+// isWordRune reports whether r is part of a word: a letter, a digit or "_"
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// isWordBoundary reports whether message[start:end] is neither preceded nor
+// followed by a word rune
+func isWordBoundary(message string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(message[:start])
+	after, _ := utf8.DecodeRuneInString(message[end:])
+	return (start == 0 || !isWordRune(before)) && (end == len(message) || !isWordRune(after))
+}
+
+// words returns the byte ranges of the words of text
+func words(text string) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			ranges = append(ranges, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ranges = append(ranges, [2]int{start, len(text)})
+	}
+	return ranges
+}
+
+// fuzzyEdits is the number of edits a query word of n runes may be away from a
+// message word: none up to 2 runes, one up to 5 and two beyond
+func fuzzyEdits(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// fuzzyRanges returns the ranges of the message words within fuzzyEdits of a
+// word of term, nil unless every word of term has one. A term without words is
+// matched as a case-insensitive substring.
+func fuzzyRanges(message, term string) [][2]int {
+	termWords := words(term)
+	if len(termWords) == 0 {
+		return messageRanges(message, term, MessageCaseInsensitive)
+	}
+
+	messageWords := words(message)
+	lowered := make([][]rune, len(messageWords))
+	for i, w := range messageWords {
+		lowered[i] = []rune(strings.ToLower(message[w[0]:w[1]]))
+	}
+	matched := make([]bool, len(messageWords))
+	for _, tw := range termWords {
+		query := []rune(strings.ToLower(term[tw[0]:tw[1]]))
+		edits := fuzzyEdits(len(query))
+		found := false
+		for i, word := range lowered {
+			if withinEdits(word, query, edits) {
+				matched[i], found = true, true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	var ranges [][2]int
+	for i, w := range messageWords {
+		if matched[i] {
+			ranges = append(ranges, w)
+		}
+	}
+	return ranges
+}
+
+// withinEdits reports whether the Levenshtein distance between a and b is at
+// most limit, giving up on a row once every cell of it exceeds the limit
+func withinEdits(a, b []rune, limit int) bool {
+	if len(a)-len(b) > limit || len(b)-len(a) > limit {
+		return false
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return false
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)] <= limit
+}
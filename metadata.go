@@ -0,0 +1,173 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Arbitrary JSON metadata of a log and the metadata.<path> keys reaching into it
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strings"
+)
+
+// metadataPrefix starts the filter, sort and LQL keys naming a metadata path
+const metadataPrefix = "metadata."
+
+// MarshalJSON writes parentResourceId and the other metadata keys as one object
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	if len(m.Fields) == 0 {
+		return json.Marshal(struct {
+			ParentResourceID string `json:"parentResourceId"`
+		}{m.ParentResourceID})
+	}
+
+	fields := maps.Clone(m.Fields)
+	fields["parentResourceId"] = m.ParentResourceID
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON reads a metadata object: parentResourceId must be a string, every
+// other key is kept in Fields, numbers as json.Number so they keep their digits
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+
+	*m = Metadata{}
+	if parent, ok := fields["parentResourceId"]; ok {
+		if parent != nil {
+			if m.ParentResourceID, ok = parent.(string); !ok {
+				return errors.New("metadata.parentResourceId must be a string")
+			}
+		}
+		delete(fields, "parentResourceId")
+	}
+	if len(fields) > 0 {
+		m.Fields = fields
+	}
+	return nil
+}
+
+// Lookup returns the value at a dot-separated path such as "region" or
+// "k8s.pod", formatted by formatValue; a missing path is empty
+func (m Metadata) Lookup(path string) string {
+	if path == "parentResourceId" {
+		return m.ParentResourceID
+	}
+
+	var value any = m.Fields
+	for key := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		if value, ok = object[key]; !ok {
+			return ""
+		}
+	}
+
+	return formatValue(value)
+}
+
+// formatValue formats a decoded JSON value as filters compare it: strings as
+// they are, numbers, booleans and null as written in JSON, objects and arrays
+// as compact JSON; nil, a missing value, is empty
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// size approximates the bytes the metadata takes in memory
+func (m Metadata) size() int {
+	n := len(m.ParentResourceID)
+	if len(m.Fields) > 0 {
+		data, _ := json.Marshal(m.Fields)
+		n += len(data)
+	}
+	return n
+}
+
+// isMetadataPath reports whether key names a path inside the metadata
+func isMetadataPath(key string) bool {
+	path, ok := strings.CutPrefix(key, metadataPrefix)
+	return ok && path != "" && !strings.HasPrefix(path, ".") && !strings.HasSuffix(path, ".") && !strings.Contains(path, "..")
+}
+
+// value returns the metadata value at a dot-separated path, as decoded
+func (m *Metadata) value(path string) (any, bool) {
+	if path == "parentResourceId" {
+		return m.ParentResourceID, m.ParentResourceID != ""
+	}
+	var value any = m.Fields
+	for key := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// set stores a value at a dot-separated metadata path, creating the objects on
+// the way; parentResourceId only takes strings
+func (m *Metadata) set(path string, value any) {
+	if path == "parentResourceId" {
+		if parent, ok := value.(string); ok {
+			m.ParentResourceID = parent
+		}
+		return
+	}
+	if m.Fields == nil {
+		m.Fields = make(map[string]any)
+	}
+	object := m.Fields
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]any)
+		if !ok {
+			child = make(map[string]any)
+			object[key] = child
+		}
+		object = child
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+// delete removes the value at a dot-separated metadata path
+func (m *Metadata) delete(path string) {
+	if path == "parentResourceId" {
+		m.ParentResourceID = ""
+		return
+	}
+	object := m.Fields
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]any)
+		if !ok {
+			return
+		}
+		object = child
+	}
+	delete(object, keys[len(keys)-1])
+}
@@ -0,0 +1,190 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Minimal metrics registry exposed at /metrics in the Prometheus text format
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add adds n to the counter
+func (c *Counter) Add(n int64) { c.value.Add(n) }
+
+// Value returns the current count
+func (c *Counter) Value() int64 { return c.value.Load() }
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Set replaces the gauge value
+func (g *Gauge) Set(n int64) { g.value.Store(n) }
+
+// Add adds n, which may be negative, to the gauge
+func (g *Gauge) Add(n int64) { g.value.Add(n) }
+
+// Value returns the current gauge value
+func (g *Gauge) Value() int64 { return g.value.Load() }
+
+// valuer is implemented by Counter and Gauge
+type valuer interface {
+	Value() int64
+}
+
+// metric is one registered metric family; it either holds one value per label
+// value or computes its value on scrape
+type metric struct {
+	name   string
+	help   string
+	kind   string // "counter" or "gauge"
+	label  string
+	mu     sync.Mutex
+	values map[string]valuer
+	fn     func() float64
+}
+
+// Metrics is a registry of metric families
+type Metrics struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// metrics is the registry served at /metrics
+var metrics = NewMetrics()
+
+// NewMetrics creates an empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{metrics: make(map[string]*metric)}
+}
+
+// register returns the family with the given name, creating it on first use
+func (m *Metrics) register(name, help, kind, label string) *metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.metrics[name]; ok {
+		return existing
+	}
+
+	family := &metric{name: name, help: help, kind: kind, label: label, values: make(map[string]valuer)}
+	m.metrics[name] = family
+	return family
+}
+
+// get returns the value of the family for a label value, creating it with newValue
+func (f *metric) get(labelValue string, newValue func() valuer) valuer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.values[labelValue]
+	if !ok {
+		value = newValue()
+		f.values[labelValue] = value
+	}
+
+	return value
+}
+
+// Counter registers (or returns) an unlabelled counter
+func (m *Metrics) Counter(name, help string) *Counter {
+	return m.register(name, help, "counter", "").get("", func() valuer { return &Counter{} }).(*Counter)
+}
+
+// Gauge registers (or returns) an unlabelled gauge
+func (m *Metrics) Gauge(name, help string) *Gauge {
+	return m.register(name, help, "gauge", "").get("", func() valuer { return &Gauge{} }).(*Gauge)
+}
+
+// CounterVec is a counter family partitioned by one label
+type CounterVec struct{ family *metric }
+
+// CounterVec registers (or returns) a counter family with one label
+func (m *Metrics) CounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{family: m.register(name, help, "counter", label)}
+}
+
+// With returns the counter for a label value
+func (cv *CounterVec) With(labelValue string) *Counter {
+	return cv.family.get(labelValue, func() valuer { return &Counter{} }).(*Counter)
+}
+
+// GaugeVec is a gauge family partitioned by one label
+type GaugeVec struct{ family *metric }
+
+// GaugeVec registers (or returns) a gauge family with one label
+func (m *Metrics) GaugeVec(name, help, label string) *GaugeVec {
+	return &GaugeVec{family: m.register(name, help, "gauge", label)}
+}
+
+// With returns the gauge for a label value
+func (gv *GaugeVec) With(labelValue string) *Gauge {
+	return gv.family.get(labelValue, func() valuer { return &Gauge{} }).(*Gauge)
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn on every scrape
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	m.register(name, help, "gauge", "").fn = fn
+}
+
+// Write writes every metric in the Prometheus text exposition format
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		m.mu.Lock()
+		family := m.metrics[name]
+		m.mu.Unlock()
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		if family.fn != nil {
+			fmt.Fprintf(w, "%s %s\n", family.name, strconv.FormatFloat(family.fn(), 'g', -1, 64))
+			continue
+		}
+
+		family.mu.Lock()
+		labelValues := make([]string, 0, len(family.values))
+		for labelValue := range family.values {
+			labelValues = append(labelValues, labelValue)
+		}
+		sort.Strings(labelValues)
+		for _, labelValue := range labelValues {
+			value := family.values[labelValue].Value()
+			if family.label == "" {
+				fmt.Fprintf(w, "%s %d\n", family.name, value)
+			} else {
+				fmt.Fprintf(w, "%s{%s=%q} %d\n", family.name, family.label, labelValue, value)
+			}
+		}
+		family.mu.Unlock()
+	}
+}
+
+// handleMetrics serves the registry in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
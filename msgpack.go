@@ -0,0 +1,266 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : MessagePack decoding into Go values, with a size budget against oversized input
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxMsgpackDepth bounds the nesting of arrays and maps
+const maxMsgpackDepth = 100
+
+var errMsgpackTooLarge = errors.New("msgpack value exceeds the size limit")
+
+// msgpackExt is an extension value, e.g. the Fluentd EventTime (type 0)
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// msgpackDecoder reads MessagePack values from a stream. Integers decode to int64
+// (uint64 above its range), floats to float64, str to string, bin to []byte, maps
+// to map[string]interface{} with non-string keys formatted, and arrays to
+// []interface{}. budget caps the bytes a value may allocate.
+type msgpackDecoder struct {
+	r      msgpackReader
+	budget int64
+	limit  int64
+}
+
+// msgpackReader is what a msgpackDecoder reads from, e.g. a *bufio.Reader or *bytes.Reader
+type msgpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// newMsgpackDecoder creates a decoder whose values may each hold up to limit bytes;
+// r is read one value at a time
+func newMsgpackDecoder(r msgpackReader, limit int64) *msgpackDecoder {
+	return &msgpackDecoder{r: r, limit: limit}
+}
+
+// Decode reads the next value; io.EOF means the stream ended between values
+func (d *msgpackDecoder) Decode() (interface{}, error) {
+	d.budget = d.limit
+	return d.value(0)
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack value nested too deeply")
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		if depth > 0 && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.mapOf(int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.arrayOf(int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		data, err := d.bytes(int(b & 0x1f))
+		return string(data), err
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		data, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 0xcb:
+		data, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		data, err := d.fixed(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		n := uintBE(data)
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		data, err := d.fixed(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(uintBE(data)<<shift) >> shift, nil // sign-extend
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.bytes(n)
+		return string(data), err
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	}
+
+	return nil, fmt.Errorf("invalid msgpack type byte 0x%02x", b)
+}
+
+// length reads a big-endian length of size bytes
+func (d *msgpackDecoder) length(size int) (int, error) {
+	data, err := d.fixed(size)
+	if err != nil {
+		return 0, err
+	}
+	n := uintBE(data)
+	if int64(n) < 0 || int64(n) > d.budget {
+		return 0, errMsgpackTooLarge
+	}
+	return int(n), nil
+}
+
+// fixed reads size bytes that are part of a type's encoding
+func (d *msgpackDecoder) fixed(size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf, nil
+}
+
+// bytes reads n bytes of payload, charging them to the budget
+func (d *msgpackDecoder) bytes(n int) ([]byte, error) {
+	if d.budget -= int64(n); d.budget < 0 {
+		return nil, errMsgpackTooLarge
+	}
+	return d.fixed(n)
+}
+
+func (d *msgpackDecoder) ext(n int) (interface{}, error) {
+	typ, err := d.r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	data, err := d.bytes(n)
+	return msgpackExt{Type: int8(typ), Data: data}, err
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) (interface{}, error) {
+	// Every element takes at least a byte, so n is charged up front
+	if d.budget -= int64(n); d.budget < 0 {
+		return nil, errMsgpackTooLarge
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		var err error
+		if array[i], err = d.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) mapOf(n, depth int) (interface{}, error) {
+	if d.budget -= 2 * int64(n); d.budget < 0 {
+		return nil, errMsgpackTooLarge
+	}
+	object := make(map[string]interface{}, n)
+	for range n {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case string:
+			object[k] = value
+		case []byte:
+			object[string(k)] = value
+		default:
+			object[fmt.Sprint(k)] = value
+		}
+	}
+	return object, nil
+}
+
+// uintBE decodes a big-endian unsigned integer of up to 8 bytes
+func uintBE(data []byte) uint64 {
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendMsgpackString appends s encoded as a msgpack str
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n < 1<<8:
+		buf = append(buf, 0xd9, byte(n))
+	case n < 1<<16:
+		buf = append(buf, 0xda, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, s...)
+}
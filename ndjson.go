@@ -0,0 +1,53 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Streaming of query results as newline-delimited JSON (Accept: application/x-ndjson)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ndjsonContentType is the media type of newline-delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many streamed logs are written between flushes
+const ndjsonFlushEvery = 100
+
+// acceptsNDJSON reports whether the client asked for newline-delimited JSON
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == ndjsonContentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamNDJSON writes one JSON log, as apply presents it, per line as it walks the
+// results, flushing every ndjsonFlushEvery logs so clients can process them as they arrive
+func streamNDJSON(w http.ResponseWriter, results Results, nextToken string, apply func(Log) interface{}) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	if nextToken != "" {
+		w.Header().Set("X-Next-Token", nextToken)
+	}
+
+	rc := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	written := 0
+	results.Each(func(log Log) bool {
+		if err := encoder.Encode(apply(log)); err != nil {
+			return false // the client went away
+		}
+		if written++; written%ndjsonFlushEvery == 0 {
+			rc.Flush()
+		}
+		return true
+	})
+}
@@ -0,0 +1,95 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Negated filters ("level!" keys and the "not" block) excluding the logs they match
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// negationSuffix marks a filter key as negated, so "level!": "debug" excludes
+// debug logs and reads level!=debug in a GET /query URL
+const negationSuffix = "!"
+
+// noneOfFilter excludes the logs meeting the filter key with any of values
+type noneOfFilter struct{ anyOfFilter }
+
+func (n noneOfFilter) eval(log Log) bool { return !n.anyOfFilter.eval(log) }
+
+// positions cannot narrow anything down: the logs left are all but a few
+func (n noneOfFilter) positions(sh *shard) ([]int, bool) { return nil, false }
+
+// excluded returns the positions of the logs the filter rules out with certainty,
+// from the field index; nil for fields it does not cover
+func (n noneOfFilter) excluded(sh *shard) []int {
+	if !slices.Contains(indexedFields, n.key) {
+		return nil
+	}
+	var positions []int
+	for _, value := range n.values {
+		positions = union(positions, sh.index[n.key][value])
+	}
+	return positions
+}
+
+// exclusions gathers the excluded positions of the negated filters ANDed into an
+// expression, which candidates can subtract from its index lookups
+func exclusions(expr lqlNode, sh *shard) []int {
+	switch n := expr.(type) {
+	case lqlAnd:
+		return union(exclusions(n.left, sh), exclusions(n.right, sh))
+	case noneOfFilter:
+		return n.excluded(sh)
+	}
+	return nil
+}
+
+// addNegated records a negated filter of a query: a value or an array of them
+func (req *QueryRequest) addNegated(key string, raw json.RawMessage) error {
+	if slices.Contains(scalarFilterKeys, key) {
+		return errors.New("cannot be negated")
+	}
+	var values []string
+	if len(raw) > 0 && raw[0] == '[' {
+		var err error
+		if values, err = parseFilterValues(key, raw); err != nil {
+			return err
+		}
+	} else {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		values = []string{value}
+	}
+
+	if req.Not == nil {
+		req.Not = make(map[string][]string)
+	}
+	req.Not[key] = append(req.Not[key], values...)
+	return nil
+}
+
+// parseNot records the filters of a "not" block, an object of filter keys
+func (req *QueryRequest) parseNot(raw json.RawMessage) error {
+	var block map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return errors.New("expected an object of filters")
+	}
+	for key, value := range block {
+		if strings.HasSuffix(key, negationSuffix) || key == "not" {
+			return fmt.Errorf("%s cannot be nested", key)
+		}
+		if err := req.addNegated(key, value); err != nil {
+			return fmt.Errorf("%s %v", key, err)
+		}
+	}
+	return nil
+}
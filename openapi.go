@@ -0,0 +1,31 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : OpenAPI 3 description of the HTTP API, served at /openapi.json for client generators
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is api/openapi.json, the endpoints, schemas and error responses of
+// the API; the cluster-internal endpoints are left out. The client package
+// follows it.
+//
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the OpenAPI document; it needs no API key, like /healthz
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(openAPISpec)
+}
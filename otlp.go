@@ -0,0 +1,472 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : OpenTelemetry OTLP logs receiver over HTTP (/v1/logs, protobuf or JSON) and gRPC
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpGRPCMethod is the full gRPC method of the OTLP logs service
+const otlpGRPCMethod = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+var (
+	otlpReceived = metrics.Counter("logingestor_otlp_log_records_total", "OTLP log records received")
+	otlpRejected = metrics.Counter("logingestor_otlp_rejected_total", "OTLP log records rejected by validation")
+)
+
+// otlpResourceKeys are the resource attributes used as resourceId, in order of preference
+var otlpResourceKeys = []string{"resourceId", "service.instance.id", "host.name", "service.name"}
+
+// otlpSeverityLevels maps the OTLP SeverityNumber ranges (1-4 TRACE, 5-8 DEBUG,
+// 9-12 INFO, 13-16 WARN, 17-20 ERROR, 21-24 FATAL) to log levels
+var otlpSeverityLevels = []string{"debug", "debug", "info", "warn", "error", "fatal"}
+
+// otlpRecord is a LogRecord with the attributes of its resource, before mapping to a Log
+type otlpRecord struct {
+	timeUnixNano     uint64
+	observedUnixNano uint64
+	severityNumber   int
+	severityText     string
+	body             string
+	traceID          []byte
+	spanID           []byte
+	attributes       map[string]string
+	resource         map[string]string
+}
+
+// toLog maps a record onto the log schema: severity to level, body to message,
+// a resource attribute to resourceId and the trace context to traceId/spanId;
+// "commit" and "parentResourceId" log attributes fill the matching fields and
+// the other log attributes become metadata keys
+func (rec otlpRecord) toLog() Log {
+	log := Log{
+		Message:  rec.body,
+		TraceID:  hex.EncodeToString(rec.traceID),
+		SpanID:   hex.EncodeToString(rec.spanID),
+		Commit:   rec.attributes["commit"],
+		Metadata: Metadata{ParentResourceID: rec.attributes["parentResourceId"]},
+	}
+	for key, value := range rec.attributes {
+		if key != "commit" && key != "parentResourceId" {
+			if log.Metadata.Fields == nil {
+				log.Metadata.Fields = make(map[string]any)
+			}
+			log.Metadata.Fields[key] = value
+		}
+	}
+
+	switch {
+	case rec.severityNumber >= 1 && rec.severityNumber <= 24:
+		log.Level = otlpSeverityLevels[(rec.severityNumber-1)/4]
+	case rec.severityText != "":
+		log.Level = strings.ToLower(rec.severityText)
+		switch log.Level {
+		case "trace":
+			log.Level = "debug"
+		case "warning":
+			log.Level = "warn"
+		case "information":
+			log.Level = "info"
+		}
+	default:
+		log.Level = "info"
+	}
+
+	for _, key := range otlpResourceKeys {
+		if value := rec.resource[key]; value != "" {
+			log.ResourceID = value
+			break
+		}
+	}
+
+	switch {
+	case rec.timeUnixNano != 0:
+		log.Timestamp = time.Unix(0, int64(rec.timeUnixNano)).UTC()
+	case rec.observedUnixNano != 0:
+		log.Timestamp = time.Unix(0, int64(rec.observedUnixNano)).UTC()
+	default:
+		log.Timestamp = time.Now().UTC()
+	}
+
+	return log
+}
+
+// decodeOTLPProto decodes an ExportLogsServiceRequest in the protobuf encoding
+func decodeOTLPProto(b []byte) ([]otlpRecord, error) {
+	var records []otlpRecord
+	err := parseProto(b, func(f protoField) error {
+		if f.Num != 1 { // resource_logs
+			return nil
+		}
+
+		resource := make(map[string]string)
+		var scopeLogs [][]byte
+		err := parseProto(f.Bytes, func(rl protoField) error {
+			switch rl.Num {
+			case 1: // resource
+				return parseProto(rl.Bytes, func(res protoField) error {
+					if res.Num == 1 {
+						return decodeOTLPKeyValue(res.Bytes, resource)
+					}
+					return nil
+				})
+			case 2: // scope_logs, decoded once the resource is known
+				scopeLogs = append(scopeLogs, rl.Bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, sl := range scopeLogs {
+			err := parseProto(sl, func(f protoField) error {
+				if f.Num != 2 { // log_records
+					return nil
+				}
+				rec, err := decodeOTLPLogRecord(f.Bytes)
+				rec.resource = resource
+				records = append(records, rec)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return records, err
+}
+
+// decodeOTLPLogRecord decodes a LogRecord message
+func decodeOTLPLogRecord(b []byte) (otlpRecord, error) {
+	rec := otlpRecord{attributes: make(map[string]string)}
+	err := parseProto(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			rec.timeUnixNano = f.Fixed
+		case 11:
+			rec.observedUnixNano = f.Fixed
+		case 2:
+			rec.severityNumber = int(f.Varint)
+		case 3:
+			rec.severityText = f.String()
+		case 5:
+			var err error
+			rec.body, err = decodeOTLPAnyValue(f.Bytes)
+			return err
+		case 6:
+			return decodeOTLPKeyValue(f.Bytes, rec.attributes)
+		case 9:
+			rec.traceID = f.Bytes
+		case 10:
+			rec.spanID = f.Bytes
+		}
+		return nil
+	})
+
+	return rec, err
+}
+
+// decodeOTLPKeyValue decodes a KeyValue message into attrs
+func decodeOTLPKeyValue(b []byte, attrs map[string]string) error {
+	var key, value string
+	err := parseProto(b, func(f protoField) error {
+		var err error
+		switch f.Num {
+		case 1:
+			key = f.String()
+		case 2:
+			value, err = decodeOTLPAnyValue(f.Bytes)
+		}
+		return err
+	})
+	attrs[key] = value
+
+	return err
+}
+
+// decodeOTLPAnyValue decodes an AnyValue as text; arrays and maps become JSON
+func decodeOTLPAnyValue(b []byte) (string, error) {
+	var value string
+	err := parseProto(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			value = f.String()
+		case 2:
+			value = strconv.FormatBool(f.Varint != 0)
+		case 3:
+			value = strconv.FormatInt(f.Int64(), 10)
+		case 4:
+			value = strconv.FormatFloat(f.Double(), 'g', -1, 64)
+		case 5: // ArrayValue{repeated AnyValue values = 1}
+			var values []string
+			err := parseProto(f.Bytes, func(e protoField) error {
+				v, err := decodeOTLPAnyValue(e.Bytes)
+				values = append(values, v)
+				return err
+			})
+			data, _ := json.Marshal(values)
+			value = string(data)
+			return err
+		case 6: // KeyValueList{repeated KeyValue values = 1}
+			kv := make(map[string]string)
+			err := parseProto(f.Bytes, func(e protoField) error { return decodeOTLPKeyValue(e.Bytes, kv) })
+			data, _ := json.Marshal(kv)
+			value = string(data)
+			return err
+		case 7:
+			value = hex.EncodeToString(f.Bytes)
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// OTLP JSON encoding (protojson): 64-bit integers are strings, ids are hex
+type otlpJSONRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []struct {
+				TimeUnixNano         json.Number        `json:"timeUnixNano"`
+				ObservedTimeUnixNano json.Number        `json:"observedTimeUnixNano"`
+				SeverityNumber       int                `json:"severityNumber"`
+				SeverityText         string             `json:"severityText"`
+				Body                 otlpJSONAnyValue   `json:"body"`
+				Attributes           []otlpJSONKeyValue `json:"attributes"`
+				TraceID              string             `json:"traceId"`
+				SpanID               string             `json:"spanId"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue string `json:"bytesValue"` // base64
+}
+
+// text returns the value as text; arrays and maps become JSON
+func (v otlpJSONAnyValue) text() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != "":
+		return v.IntValue.String()
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.ArrayValue != nil:
+		values := make([]string, len(v.ArrayValue.Values))
+		for i, e := range v.ArrayValue.Values {
+			values[i] = e.text()
+		}
+		data, _ := json.Marshal(values)
+		return string(data)
+	case v.KvlistValue != nil:
+		data, _ := json.Marshal(otlpJSONAttributes(v.KvlistValue.Values))
+		return string(data)
+	}
+
+	return v.BytesValue
+}
+
+func otlpJSONAttributes(kvs []otlpJSONKeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.text()
+	}
+	return attrs
+}
+
+// decodeOTLPJSON decodes an ExportLogsServiceRequest in the JSON encoding
+func decodeOTLPJSON(b []byte) ([]otlpRecord, error) {
+	var req otlpJSONRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+
+	var records []otlpRecord
+	for _, rl := range req.ResourceLogs {
+		resource := otlpJSONAttributes(rl.Resource.Attributes)
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				rec := otlpRecord{
+					severityNumber: lr.SeverityNumber,
+					severityText:   lr.SeverityText,
+					body:           lr.Body.text(),
+					attributes:     otlpJSONAttributes(lr.Attributes),
+					resource:       resource,
+				}
+				var err error
+				if rec.timeUnixNano, err = parseOTLPUint(lr.TimeUnixNano); err != nil {
+					return nil, fmt.Errorf("timeUnixNano: %v", err)
+				}
+				if rec.observedUnixNano, err = parseOTLPUint(lr.ObservedTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("observedTimeUnixNano: %v", err)
+				}
+				if rec.traceID, err = hex.DecodeString(lr.TraceID); err != nil {
+					return nil, fmt.Errorf("traceId: %v", err)
+				}
+				if rec.spanID, err = hex.DecodeString(lr.SpanID); err != nil {
+					return nil, fmt.Errorf("spanId: %v", err)
+				}
+				records = append(records, rec)
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func parseOTLPUint(n json.Number) (uint64, error) {
+	if n == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(n.String(), 10, 64)
+}
+
+// ingestOTLP validates and stores decoded records, returning how many were rejected
+// and the first rejection reason; rejected records are dead-lettered as mapped logs
+func (s *Server) ingestOTLP(r *http.Request, records []otlpRecord) (rejected int, reason string, err error) {
+	otlpReceived.Add(int64(len(records)))
+
+	var valid []Log
+	for _, rec := range records {
+		log := rec.toLog()
+		if err := s.validator.Validate(&log); err != nil {
+			if rejected == 0 {
+				reason = err.Error()
+			}
+			payload, _ := json.Marshal(log)
+			s.rejectRequest(r, "otlp", rejectionReason(err), payload)
+			rejected++
+			continue
+		}
+		valid = append(valid, log)
+	}
+	otlpRejected.Add(int64(rejected))
+
+	logs, err := s.admit(r.Context(), "otlp", valid)
+	if err == nil {
+		_, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	return rejected, reason, err
+}
+
+// handleOTLP receives an OTLP/HTTP ExportLogsServiceRequest
+func (s *Server) handleOTLP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-protobuf" && mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, "Content-Type must be application/x-protobuf or application/json", nil)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+
+	var records []otlpRecord
+	if mediaType == "application/json" {
+		records, err = decodeOTLPJSON(body)
+	} else {
+		records, err = decodeOTLPProto(body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Error decoding OTLP request", err.Error())
+		return
+	}
+
+	rejected, reason, err := s.ingestOTLP(r, records)
+	if err != nil {
+		writeStoreError(w, err, "Error storing logs")
+		return
+	}
+
+	// ExportLogsServiceResponse, with partial_success only when something was rejected
+	w.Header().Set("Content-Type", mediaType)
+	if mediaType == "application/json" {
+		response := map[string]interface{}{}
+		if rejected > 0 {
+			response["partialSuccess"] = map[string]interface{}{"rejectedLogRecords": strconv.Itoa(rejected), "errorMessage": reason}
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	w.Write(encodeOTLPResponse(rejected, reason))
+}
+
+// encodeOTLPResponse returns an ExportLogsServiceResponse in the protobuf encoding
+func encodeOTLPResponse(rejected int, reason string) []byte {
+	var e protoEncoder
+	if rejected > 0 {
+		e.message(1, func(m *protoEncoder) {
+			m.int64(1, int64(rejected))
+			m.string(2, reason)
+		})
+	}
+
+	return e.buf
+}
+
+// grpcOTLPExport handles the OTLP/gRPC LogsService/Export method
+func (s *Server) grpcOTLPExport(r *http.Request) ([]byte, error) {
+	message, err := s.readGRPCUnary(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := decodeOTLPProto(message)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "decoding ExportLogsServiceRequest: %v", err)
+	}
+
+	rejected, reason, err := s.ingestOTLP(r, records)
+	var qerr *QuotaError
+	if errors.As(err, &qerr) {
+		return nil, grpcErrorf(grpcResourceExhausted, "%s", qerr.Error())
+	}
+	if err != nil {
+		return nil, grpcErrorf(grpcInternal, "Error storing logs")
+	}
+
+	return encodeOTLPResponse(rejected, reason), nil
+}
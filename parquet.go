@@ -0,0 +1,413 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Minimal Parquet writer for /export: flat columns, PLAIN pages, GZIP compression
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize is how many logs are buffered per row group
+const parquetRowGroupSize = 10000
+
+// Parquet physical and converted types, repetitions, encodings and codecs used
+// here, as numbered by parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetJSON            = 19
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+	parquetGzip  = 2
+
+	parquetDataPage = 0
+)
+
+// parquetColumn is one column of an export, read from every log
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32 // -1 for none
+	optional  bool
+}
+
+// newParquetColumn returns the column written for an export field: timestamp as
+// microseconds, metadata as JSON, custom fields by their type (optional, as logs
+// may lack them) and the other fields as required strings
+func newParquetColumn(field string) parquetColumn {
+	switch field {
+	case "timestamp":
+		return parquetColumn{name: field, kind: parquetInt64, converted: parquetTimestampMicros}
+	case "metadata":
+		return parquetColumn{name: field, kind: parquetByteArray, converted: parquetJSON}
+	}
+	if custom := customField(field); custom != nil {
+		switch custom.Type {
+		case FieldTypeNumber:
+			return parquetColumn{name: field, kind: parquetDouble, converted: -1, optional: true}
+		case FieldTypeBool:
+			return parquetColumn{name: field, kind: parquetBoolean, converted: -1, optional: true}
+		}
+		return parquetColumn{name: field, kind: parquetByteArray, converted: parquetUTF8, optional: true}
+	}
+	return parquetColumn{name: field, kind: parquetByteArray, converted: parquetUTF8}
+}
+
+// parquetChunk is the footer metadata of a written column chunk
+type parquetChunk struct {
+	offset       int64
+	numValues    int64
+	uncompressed int64
+	compressed   int64
+}
+
+// parquetRowGroup is the footer metadata of a written row group
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+// ParquetWriter writes logs as a Parquet file, one row group per
+// parquetRowGroupSize logs; the file is complete once Close returns
+type ParquetWriter struct {
+	w         io.Writer
+	columns   []parquetColumn
+	rows      []Log
+	offset    int64
+	numRows   int64
+	rowGroups []parquetRowGroup
+}
+
+// NewParquetWriter returns a writer of the given export fields to w
+func NewParquetWriter(w io.Writer, fields []string) *ParquetWriter {
+	pw := &ParquetWriter{w: w}
+	for _, field := range fields {
+		pw.columns = append(pw.columns, newParquetColumn(field))
+	}
+	return pw
+}
+
+// Write adds a log, writing a row group once enough are buffered
+func (pw *ParquetWriter) Write(log Log) error {
+	pw.rows = append(pw.rows, log)
+	if len(pw.rows) < parquetRowGroupSize {
+		return nil
+	}
+	return pw.flush()
+}
+
+// Close writes the buffered logs and the file footer
+func (pw *ParquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	footer := pw.footer()
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], uint32(len(footer)))
+	footer = append(footer, trailer[:]...)
+	footer = append(footer, parquetMagic...)
+	return pw.write(footer)
+}
+
+// write writes data to the file, starting it with the magic first
+func (pw *ParquetWriter) write(data []byte) error {
+	if pw.offset == 0 {
+		if _, err := io.WriteString(pw.w, parquetMagic); err != nil {
+			return err
+		}
+		pw.offset = int64(len(parquetMagic))
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// flush writes the buffered logs as a row group, one page per column
+func (pw *ParquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{numRows: int64(len(pw.rows))}
+	for _, column := range pw.columns {
+		page := column.encode(pw.rows)
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(page)
+		gz.Close()
+
+		t := thriftStruct{buf: new(bytes.Buffer)}
+		t.i32(1, parquetDataPage)
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(compressed.Len()))
+		t.structField(5, func(t *thriftStruct) {
+			t.i32(1, int32(len(pw.rows)))
+			t.i32(2, parquetPlain)
+			t.i32(3, parquetRLE)
+			t.i32(4, parquetRLE)
+		})
+		t.end()
+
+		if err := pw.write(t.buf.Bytes()); err != nil {
+			return err
+		}
+		chunk := parquetChunk{
+			offset:       pw.offset - int64(t.buf.Len()),
+			numValues:    int64(len(pw.rows)),
+			uncompressed: int64(t.buf.Len() + len(page)),
+			compressed:   int64(t.buf.Len() + compressed.Len()),
+		}
+		if err := pw.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.numRows += group.numRows
+	pw.rows = pw.rows[:0]
+	return nil
+}
+
+// encode returns the uncompressed data page of the column over logs: the
+// definition levels of an optional column, then its PLAIN-encoded values
+func (c parquetColumn) encode(logs []Log) []byte {
+	var page bytes.Buffer
+	var values bytes.Buffer
+	var levels []bool
+	var bits []bool
+	for _, log := range logs {
+		switch c.name {
+		case "timestamp":
+			binary.Write(&values, binary.LittleEndian, log.Timestamp.UnixMicro())
+			continue
+		case "metadata":
+			data, _ := json.Marshal(log.Metadata)
+			writeByteArray(&values, string(data))
+			continue
+		}
+		if !c.optional {
+			writeByteArray(&values, fieldValue(log, c.name))
+			continue
+		}
+
+		value, ok := log.Custom[c.name]
+		levels = append(levels, ok)
+		if !ok {
+			continue
+		}
+		switch c.kind {
+		case parquetDouble:
+			number, _ := value.(json.Number).Float64()
+			binary.Write(&values, binary.LittleEndian, math.Float64bits(number))
+		case parquetBoolean:
+			bits = append(bits, value.(bool))
+		default:
+			writeByteArray(&values, formatValue(value))
+		}
+	}
+
+	if c.optional {
+		encoded := encodeLevels(levels)
+		binary.Write(&page, binary.LittleEndian, uint32(len(encoded)))
+		page.Write(encoded)
+	}
+	if c.kind == parquetBoolean {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, bit := range bits {
+			if bit {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	}
+	page.Write(values.Bytes())
+	return page.Bytes()
+}
+
+// writeByteArray writes a PLAIN BYTE_ARRAY value: its length, then its bytes
+func writeByteArray(buf *bytes.Buffer, value string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(value)))
+	buf.WriteString(value)
+}
+
+// encodeLevels encodes definition levels of bit width 1 as RLE runs
+func encodeLevels(levels []bool) []byte {
+	var buf []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		if levels[i] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		i = j
+	}
+	return buf
+}
+
+// footer returns the FileMetaData of the file
+func (pw *ParquetWriter) footer() []byte {
+	t := thriftStruct{buf: new(bytes.Buffer)}
+	t.i32(1, 1)
+	t.structList(2, len(pw.columns)+1, func(i int, t *thriftStruct) {
+		if i == 0 {
+			t.str(4, "schema")
+			t.i32(5, int32(len(pw.columns)))
+			return
+		}
+		column := pw.columns[i-1]
+		repetition := int32(parquetRequired)
+		if column.optional {
+			repetition = parquetOptional
+		}
+		t.i32(1, column.kind)
+		t.i32(3, repetition)
+		t.str(4, column.name)
+		if column.converted >= 0 {
+			t.i32(6, column.converted)
+		}
+	})
+	t.i64(3, pw.numRows)
+	t.structList(4, len(pw.rowGroups), func(i int, t *thriftStruct) {
+		group := pw.rowGroups[i]
+		var size int64
+		t.structList(1, len(group.chunks), func(j int, t *thriftStruct) {
+			chunk, column := group.chunks[j], pw.columns[j]
+			size += chunk.uncompressed
+			t.i64(2, chunk.offset)
+			t.structField(3, func(t *thriftStruct) {
+				t.i32(1, column.kind)
+				t.i32List(2, []int32{parquetPlain, parquetRLE})
+				t.strList(3, []string{column.name})
+				t.i32(4, parquetGzip)
+				t.i64(5, chunk.numValues)
+				t.i64(6, chunk.uncompressed)
+				t.i64(7, chunk.compressed)
+				t.i64(9, chunk.offset)
+			})
+		})
+		t.i64(2, size)
+		t.i64(3, group.numRows)
+	})
+	t.str(6, "logingestor")
+	t.end()
+	return t.buf.Bytes()
+}
+
+// Thrift compact protocol types
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+// thriftStruct writes one struct in the Thrift compact protocol, which Parquet
+// metadata is encoded in; fields must be written in increasing id order
+type thriftStruct struct {
+	buf  *bytes.Buffer
+	last int16
+}
+
+func (t *thriftStruct) header(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftStruct) varint(v int64) {
+	t.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (t *thriftStruct) listHeader(id int16, kind byte, n int) {
+	t.header(id, thriftTypeList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | kind)
+		return
+	}
+	t.buf.WriteByte(0xf0 | kind)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (t *thriftStruct) i32(id int16, v int32) {
+	t.header(id, thriftTypeI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftStruct) i64(id int16, v int64) {
+	t.header(id, thriftTypeI64)
+	t.varint(v)
+}
+
+func (t *thriftStruct) str(id int16, v string) {
+	t.header(id, thriftTypeBinary)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(v))))
+	t.buf.WriteString(v)
+}
+
+func (t *thriftStruct) i32List(id int16, values []int32) {
+	t.listHeader(id, thriftTypeI32, len(values))
+	for _, v := range values {
+		t.varint(int64(v))
+	}
+}
+
+func (t *thriftStruct) strList(id int16, values []string) {
+	t.listHeader(id, thriftTypeBinary, len(values))
+	for _, v := range values {
+		t.buf.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		t.buf.WriteString(v)
+	}
+}
+
+func (t *thriftStruct) structField(id int16, fn func(t *thriftStruct)) {
+	t.header(id, thriftTypeStruct)
+	inner := thriftStruct{buf: t.buf}
+	fn(&inner)
+	inner.end()
+}
+
+func (t *thriftStruct) structList(id int16, n int, fn func(i int, t *thriftStruct)) {
+	t.listHeader(id, thriftTypeStruct, n)
+	for i := range n {
+		inner := thriftStruct{buf: t.buf}
+		fn(i, &inner)
+		inner.end()
+	}
+}
+
+// end writes the stop field closing the struct
+func (t *thriftStruct) end() {
+	t.buf.WriteByte(0)
+}
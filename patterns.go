@@ -0,0 +1,467 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Drain-style mining of log message templates (/patterns) and queries by pattern id
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// patternWildcard stands for the variable parts of a template
+const patternWildcard = "<*>"
+
+// Shape of the parse tree: after the token count, messages are routed by their
+// first patternDepth tokens, a node holding at most patternMaxChildren tokens
+// before the others share its wildcard child
+const (
+	patternDepth       = 2
+	patternMaxChildren = 100
+)
+
+// patternSaveInterval is how often changed patterns are written to patterns.json
+const patternSaveInterval = time.Minute
+
+var (
+	patternsMined      = metrics.Gauge("logingestor_patterns", "Message patterns mined across tenants")
+	patternUnmatched   = metrics.Counter("logingestor_pattern_unmatched_logs_total", "Logs fitting no pattern once pattern-max-entries was reached")
+	patternSaveFailure = metrics.Counter("logingestor_pattern_save_failures_total", "Failed writes of patterns.json")
+)
+
+// Pattern is a message template mined from the ingested logs, where <*> stands
+// for the tokens that vary between the messages it groups
+type Pattern struct {
+	ID        string    `json:"id"`
+	Template  string    `json:"template"`
+	Count     int       `json:"count"` // logs that fit it since it was mined
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// patternCluster is a pattern with its template split into tokens
+type patternCluster struct {
+	Pattern
+	tokens []string
+}
+
+// patternNode is a node of the parse tree: inner nodes route by token, leaves
+// hold the clusters of the messages routed to them
+type patternNode struct {
+	children map[string]*patternNode
+	clusters []*patternCluster
+}
+
+// PatternMiner clusters the messages of a tenant's logs into templates as they
+// are ingested, following Drain: messages of equal token count and equal first
+// tokens are compared to the templates of their leaf, joining the most similar
+// one when at least similarity of their tokens are equal
+type PatternMiner struct {
+	similarity float64
+	maxEntries int
+	path       string // patterns.json; empty keeps them in memory only
+
+	mu       sync.Mutex
+	root     map[int]*patternNode // by token count
+	patterns map[string]*patternCluster
+	nextID   int
+	dirty    bool // changed since last saved
+}
+
+// patternFile is the content of patterns.json
+type patternFile struct {
+	NextID   int       `json:"next_id"`
+	Patterns []Pattern `json:"patterns"`
+}
+
+// OpenPatternMiner creates the miner of a tenant, loading the patterns saved in
+// dir; an empty dir keeps them in memory
+func OpenPatternMiner(cfg Config, dir string) (*PatternMiner, error) {
+	pm := &PatternMiner{
+		similarity: cfg.PatternSimilarity,
+		maxEntries: cfg.PatternMaxEntries,
+		root:       make(map[int]*patternNode),
+		patterns:   make(map[string]*patternCluster),
+		nextID:     1,
+	}
+	if dir == "" {
+		return pm, nil
+	}
+
+	pm.path = filepath.Join(dir, "patterns.json")
+	data, err := os.ReadFile(pm.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pm, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file patternFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", pm.path, err)
+	}
+	pm.nextID = max(file.NextID, 1)
+	for _, pattern := range file.Patterns {
+		cluster := &patternCluster{Pattern: pattern, tokens: strings.Fields(pattern.Template)}
+		leaf := pm.leaf(cluster.tokens)
+		leaf.clusters = append(leaf.clusters, cluster)
+		pm.patterns[pattern.ID] = cluster
+	}
+	patternsMined.Add(int64(len(pm.patterns)))
+	return pm, nil
+}
+
+// maskToken replaces the digits of a token, with whatever lies between them,
+// by a wildcard: 5000ms becomes <*>ms and 10.0.0.1:5432 <*>
+func maskToken(token string) string {
+	first := strings.IndexFunc(token, unicode.IsDigit)
+	if first < 0 {
+		return token
+	}
+	last := strings.LastIndexFunc(token, unicode.IsDigit)
+	return token[:first] + patternWildcard + token[last+1:]
+}
+
+// tokenize splits a message into its masked tokens
+func tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		tokens[i] = maskToken(token)
+	}
+	return tokens
+}
+
+// leaf returns the leaf of the parse tree tokens are routed to, creating it
+func (pm *PatternMiner) leaf(tokens []string) *patternNode {
+	node := pm.root[len(tokens)]
+	if node == nil {
+		node = &patternNode{}
+		pm.root[len(tokens)] = node
+	}
+	for _, token := range tokens[:min(patternDepth, len(tokens))] {
+		if strings.Contains(token, patternWildcard) {
+			token = patternWildcard
+		}
+		if node.children == nil {
+			node.children = make(map[string]*patternNode)
+		}
+		child := node.children[token]
+		if child == nil {
+			if len(node.children) >= patternMaxChildren {
+				token = patternWildcard
+				child = node.children[token]
+			}
+			if child == nil {
+				child = &patternNode{}
+				node.children[token] = child
+			}
+		}
+		node = child
+	}
+	return node
+}
+
+// similar returns the share of the tokens of a message equal to those of a
+// template of the same length, and the number of wildcards of the template
+func similar(template, tokens []string) (float64, int) {
+	equal, wildcards := 0, 0
+	for i, token := range template {
+		if token == patternWildcard {
+			wildcards++
+		}
+		if token == tokens[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), wildcards
+}
+
+// add mines the messages of ingested logs
+func (pm *PatternMiner) add(logs []Log) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for _, log := range logs {
+		tokens := tokenize(log.Message)
+		if len(tokens) == 0 {
+			continue
+		}
+		pm.match(tokens, log.IngestedAt)
+	}
+}
+
+// match adds a message to the most similar template of its leaf, generalizing
+// the template where they differ, or to a new pattern; pm.mu is held
+func (pm *PatternMiner) match(tokens []string, now time.Time) {
+	leaf := pm.leaf(tokens)
+	var best *patternCluster
+	bestSim, bestWildcards := -1.0, 0
+	for _, cluster := range leaf.clusters {
+		sim, wildcards := similar(cluster.tokens, tokens)
+		if sim > bestSim || (sim == bestSim && wildcards > bestWildcards) {
+			best, bestSim, bestWildcards = cluster, sim, wildcards
+		}
+	}
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+
+	if best == nil || bestSim < pm.similarity {
+		if len(pm.patterns) >= pm.maxEntries {
+			patternUnmatched.Inc()
+			return
+		}
+		best = &patternCluster{tokens: tokens}
+		best.ID = "p" + strconv.Itoa(pm.nextID)
+		best.Template = strings.Join(tokens, " ")
+		best.FirstSeen = now
+		pm.nextID++
+		pm.patterns[best.ID] = best
+		leaf.clusters = append(leaf.clusters, best)
+		patternsMined.Add(1)
+	} else {
+		changed := false
+		for i, token := range best.tokens {
+			if token != tokens[i] && token != patternWildcard {
+				best.tokens[i] = patternWildcard
+				changed = true
+			}
+		}
+		if changed {
+			best.Template = strings.Join(best.tokens, " ")
+		}
+	}
+	best.Count++
+	best.LastSeen = now
+	pm.dirty = true
+}
+
+// template returns the tokens of the template of a pattern
+func (pm *PatternMiner) template(id string) ([]string, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	cluster, ok := pm.patterns[id]
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(cluster.tokens), true
+}
+
+// List returns the patterns whose template contains search, most frequent first,
+// those fitting fewer than minCount logs left out, up to limit
+func (pm *PatternMiner) List(search string, minCount, limit int) []Pattern {
+	pm.mu.Lock()
+	patterns := []Pattern{}
+	for _, cluster := range pm.patterns {
+		if cluster.Count >= minCount && strings.Contains(cluster.Template, search) {
+			patterns = append(patterns, cluster.Pattern)
+		}
+	}
+	pm.mu.Unlock()
+
+	slices.SortFunc(patterns, func(a, b Pattern) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(len(a.ID), len(b.ID)), strings.Compare(a.ID, b.ID))
+	})
+	if len(patterns) > limit {
+		patterns = patterns[:limit]
+	}
+	return patterns
+}
+
+// purge drops the patterns whose template contains id, which is lower case, and
+// saves the others at once; it returns how many were dropped
+func (pm *PatternMiner) purge(id string) int {
+	pm.mu.Lock()
+	dropped := 0
+	for key, cluster := range pm.patterns {
+		if !strings.Contains(strings.ToLower(cluster.Template), id) {
+			continue
+		}
+		leaf := pm.leaf(cluster.tokens)
+		leaf.clusters = slices.DeleteFunc(leaf.clusters, func(c *patternCluster) bool { return c == cluster })
+		delete(pm.patterns, key)
+		dropped++
+	}
+	if dropped > 0 {
+		pm.dirty = true
+		patternsMined.Add(int64(-dropped))
+	}
+	pm.mu.Unlock()
+
+	if dropped > 0 && pm.path != "" {
+		pm.save()
+	}
+	return dropped
+}
+
+// Run writes the patterns to patterns.json every interval when they changed,
+// and a last time once ctx is done
+func (pm *PatternMiner) Run(ctx context.Context, interval time.Duration) {
+	if pm.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			pm.save()
+			return
+		case <-ticker.C:
+			pm.save()
+		}
+	}
+}
+
+// save writes the patterns when they changed, through a temporary file so a
+// crash leaves the previous ones
+func (pm *PatternMiner) save() {
+	pm.mu.Lock()
+	if !pm.dirty {
+		pm.mu.Unlock()
+		return
+	}
+	file := patternFile{NextID: pm.nextID, Patterns: make([]Pattern, 0, len(pm.patterns))}
+	for _, cluster := range pm.patterns {
+		file.Patterns = append(file.Patterns, cluster.Pattern)
+	}
+	pm.dirty = false
+	pm.mu.Unlock()
+
+	slices.SortFunc(file.Patterns, func(a, b Pattern) int { return a.FirstSeen.Compare(b.FirstSeen) })
+	err := func() error {
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		tmp := pm.path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, pm.path)
+	}()
+	if err != nil {
+		patternSaveFailure.Inc()
+		logger.Error("saving patterns failed", "path", pm.path, "error", err)
+		pm.mu.Lock()
+		pm.dirty = true
+		pm.mu.Unlock()
+	}
+}
+
+// patternFilter matches the logs whose message fits a template: as many tokens,
+// each equal once masked or under a wildcard
+type patternFilter struct {
+	tokens []string
+}
+
+func (n patternFilter) eval(log Log) bool {
+	tokens := strings.Fields(log.Message)
+	if len(tokens) != len(n.tokens) {
+		return false
+	}
+	for i, token := range n.tokens {
+		if token != patternWildcard && token != maskToken(tokens[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// positions looks up the longest literal part of the template in the text index
+func (n patternFilter) positions(sh *shard) ([]int, bool) {
+	var literal string
+	for _, token := range n.tokens {
+		for _, part := range strings.Split(token, patternWildcard) {
+			if len(part) > len(literal) {
+				literal = part
+			}
+		}
+	}
+	return sh.messages.lookup(literal)
+}
+
+// applyPattern resolves the pattern of a query to its current template, which
+// joins the query expression
+func (s *Server) applyPattern(ctx context.Context, req *QueryRequest) error {
+	if req.Pattern == "" {
+		return nil
+	}
+	miner := s.tenant(ctx).storage.patterns
+	switch {
+	case miner == nil:
+		return errors.New("Invalid pattern: pattern mining is disabled (pattern-max-entries is 0)")
+	case s.cluster != nil:
+		return errors.New("Invalid pattern: each cluster node mines patterns of its own, which cannot filter a cluster query")
+	}
+	tokens, ok := miner.template(req.Pattern)
+	if !ok {
+		return fmt.Errorf("Invalid pattern: no pattern %q", req.Pattern)
+	}
+
+	var node lqlNode = patternFilter{tokens: tokens}
+	if req.Options.Expr != nil {
+		node = lqlAnd{left: req.Options.Expr, right: node}
+	}
+	req.Options.Expr = node
+	return nil
+}
+
+// PatternList is the body of GET /patterns
+type PatternList struct {
+	Patterns []Pattern `json:"patterns"`
+}
+
+// handlePatterns lists the patterns mined from the request tenant's logs, most
+// frequent first, narrowed by the search and min_count URL parameters, up to
+// limit (default 100)
+func (s *Server) handlePatterns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	miner := s.tenant(r.Context()).storage.patterns
+	if miner == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Pattern mining is disabled (pattern-max-entries is 0)", nil)
+		return
+	}
+	if accessScope(r.Context()).restricted() {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "Patterns are mined from every resource and level: this API key is limited to some of them", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultValuesLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > s.cfg.MaxPageSize {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+	minCount := 0
+	if v := query.Get("min_count"); v != "" {
+		var err error
+		if minCount, err = strconv.Atoi(v); err != nil || minCount < 0 {
+			writeValidationError(w, errors.New("min_count must be a non-negative integer"))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, PatternList{Patterns: miner.List(query.Get("search"), minCount, limit)})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatternsRestrictedKey(t *testing.T) {
+	dir := t.TempDir()
+	keys := `[{"key":"writer","scopes":["read","write"]},{"key":"errors-only","scopes":["read"],"levels":["error"]}]`
+	cfg := defaultConfig()
+	cfg.DataDir = dir
+	cfg.APIKeysFile = filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(cfg.APIKeysFile, []byte(keys), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := OpenTenants(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenants.Close()
+	s, err := NewServer(cfg, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	log := `{"level":"info","message":"user alice logged in","resourceId":"auth-1","timestamp":"2026-01-01T00:00:00Z","traceId":"t","spanId":"s","commit":"c","metadata":{"parentResourceId":"p"}}`
+	if rec := do(http.MethodPost, "/ingest", "writer", log); rec.Code != http.StatusOK {
+		t.Fatalf("ingest: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(http.MethodGet, "/patterns", "writer", "")
+	var list PatternList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); rec.Code != http.StatusOK || err != nil || len(list.Patterns) != 1 {
+		t.Fatalf("unrestricted key: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodGet, "/patterns", "errors-only", "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("restricted key: got %d %s, want 403", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "logged in") {
+		t.Fatalf("restricted key saw a template: %s", rec.Body)
+	}
+}
@@ -0,0 +1,290 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Ingest pipelines dropping, renaming and enriching logs with processors before storage
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var pipelineDropped = metrics.CounterVec("logingestor_pipeline_dropped_total", "Logs dropped by a processor of an ingest pipeline", "pipeline")
+
+// PipelinesFile is the content of the pipelines-file
+type PipelinesFile struct {
+	Pipelines []PipelineConfig `json:"pipelines"`
+}
+
+// PipelineConfig is a pipeline of the pipelines-file: its processors run in order
+// over the logs of its tenant and resources
+type PipelineConfig struct {
+	Name       string            `json:"name"`
+	Tenant     string            `json:"tenant"`      // empty runs over every tenant
+	ResourceID string            `json:"resource_id"` // glob such as "checkout-*"; empty matches every resource
+	Processors []ProcessorConfig `json:"processors"`
+}
+
+// ProcessorConfig configures one processor: exactly one of its fields is set
+type ProcessorConfig struct {
+	Drop   map[string]json.RawMessage `json:"drop"`   // filters, min_level and "q" of the logs dropped
+	Rename *RenameConfig              `json:"rename"` // moves a metadata key
+	Tags   map[string]any             `json:"tags"`   // metadata paths and values added to every log
+	Lookup *LookupConfig              `json:"lookup"` // metadata added from the CSV row of a field's value
+}
+
+// RenameConfig moves the metadata value at path From to path To
+type RenameConfig struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// LookupConfig enriches logs from a CSV file with a header row: the row whose first
+// column holds the value of Field adds its other columns as metadata keys named by
+// the header, e.g. resourceId,team,owner
+type LookupConfig struct {
+	File  string `json:"file"`
+	Field string `json:"field"` // a log field as filters name it, e.g. resourceId or metadata.service
+}
+
+// processor changes a log in place, returning false to drop it
+type processor interface {
+	process(log *Log) bool
+}
+
+// pipeline is a loaded pipeline
+type pipeline struct {
+	name       string
+	tenant     string
+	resourceID string
+	processors []processor
+}
+
+// Pipelines run the processors of the pipelines-file over logs before they are stored
+type Pipelines struct {
+	pipelines []*pipeline
+}
+
+// LoadPipelines reads the pipelines-file, YAML or with a .json extension JSON, and
+// loads the lookup files it names
+func LoadPipelines(cfg Config, tenants *Tenants, levels *LevelRegistry) (*Pipelines, error) {
+	data, err := os.ReadFile(cfg.PipelinesFile)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(cfg.PipelinesFile)); ext != ".json" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.PipelinesFile, err)
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var file PipelinesFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.PipelinesFile, err)
+	}
+
+	ps := &Pipelines{}
+	names := make(map[string]bool)
+	for _, pc := range file.Pipelines {
+		if pc.Name == "" {
+			return nil, errors.New("pipeline without a name")
+		}
+		if names[pc.Name] {
+			return nil, fmt.Errorf("pipeline %q: duplicate name", pc.Name)
+		}
+		names[pc.Name] = true
+
+		p, err := loadPipeline(cfg, tenants, levels, pc)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", pc.Name, err)
+		}
+		ps.pipelines = append(ps.pipelines, p)
+	}
+
+	return ps, nil
+}
+
+// loadPipeline checks a pipeline and builds its processors
+func loadPipeline(cfg Config, tenants *Tenants, levels *LevelRegistry, pc PipelineConfig) (*pipeline, error) {
+	if _, ok := tenants.byID[pc.Tenant]; pc.Tenant != "" && !ok {
+		return nil, fmt.Errorf("unknown tenant %q", pc.Tenant)
+	}
+	if _, err := path.Match(pc.ResourceID, ""); err != nil {
+		return nil, fmt.Errorf("invalid resource_id pattern %q", pc.ResourceID)
+	}
+	if len(pc.Processors) == 0 {
+		return nil, errors.New("no processors")
+	}
+
+	p := &pipeline{name: pc.Name, tenant: pc.Tenant, resourceID: pc.ResourceID}
+	for i, config := range pc.Processors {
+		proc, err := newProcessor(cfg, levels, config)
+		if err != nil {
+			return nil, fmt.Errorf("processor %d: %v", i+1, err)
+		}
+		p.processors = append(p.processors, proc)
+	}
+	return p, nil
+}
+
+// newProcessor builds the processor of a configuration
+func newProcessor(cfg Config, levels *LevelRegistry, config ProcessorConfig) (processor, error) {
+	var procs []processor
+	if config.Drop != nil {
+		req, err := buildRuleQuery(config.Drop, cfg.MaxPageSize, levels)
+		if err != nil {
+			return nil, fmt.Errorf("drop: %v", err)
+		}
+		procs = append(procs, dropProcessor{req: req})
+	}
+	if config.Rename != nil {
+		if config.Rename.From == "" || config.Rename.To == "" {
+			return nil, errors.New("rename: from and to are required")
+		}
+		procs = append(procs, renameProcessor(*config.Rename))
+	}
+	if config.Tags != nil {
+		for key, value := range config.Tags {
+			switch value.(type) {
+			case string, json.Number, bool:
+			default:
+				return nil, fmt.Errorf("tags: %s must be a string, number or boolean", key)
+			}
+		}
+		procs = append(procs, tagsProcessor(config.Tags))
+	}
+	if config.Lookup != nil {
+		lookup, err := loadLookup(*config.Lookup)
+		if err != nil {
+			return nil, fmt.Errorf("lookup: %v", err)
+		}
+		procs = append(procs, lookup)
+	}
+
+	if len(procs) != 1 {
+		return nil, errors.New("expected exactly one of drop, rename, tags and lookup")
+	}
+	return procs[0], nil
+}
+
+// process runs the pipelines matching each log of a tenant over it, in order,
+// returning the logs left
+func (ps *Pipelines) process(tenant string, logs []Log) []Log {
+	if ps == nil {
+		return logs
+	}
+	kept := logs[:0:0]
+	for _, log := range logs {
+		if ps.processLog(tenant, &log) {
+			kept = append(kept, log)
+		}
+	}
+	return kept
+}
+
+func (ps *Pipelines) processLog(tenant string, log *Log) bool {
+	for _, p := range ps.pipelines {
+		if p.tenant != "" && p.tenant != tenant {
+			continue
+		}
+		if ok, _ := path.Match(p.resourceID, log.ResourceID); p.resourceID != "" && !ok {
+			continue
+		}
+		for _, proc := range p.processors {
+			if !proc.process(log) {
+				pipelineDropped.With(p.name).Inc()
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dropProcessor drops the logs matching its query
+type dropProcessor struct {
+	req QueryRequest
+}
+
+func (d dropProcessor) process(log *Log) bool {
+	return !matchesQuery(*log, d.req.Filters, d.req.Options.Expr)
+}
+
+// renameProcessor moves a metadata value, replacing any at the new path
+type renameProcessor RenameConfig
+
+func (r renameProcessor) process(log *Log) bool {
+	if value, ok := log.Metadata.value(r.From); ok {
+		log.Metadata.delete(r.From)
+		log.Metadata.set(r.To, value)
+	}
+	return true
+}
+
+// tagsProcessor adds static metadata, leaving the keys a log already has
+type tagsProcessor map[string]any
+
+func (t tagsProcessor) process(log *Log) bool {
+	for key, value := range t {
+		if _, ok := log.Metadata.value(key); !ok {
+			log.Metadata.set(key, value)
+		}
+	}
+	return true
+}
+
+// lookupProcessor adds the metadata of the CSV row matching a field of each log,
+// leaving the keys a log already has
+type lookupProcessor struct {
+	field   string
+	columns []string
+	rows    map[string][]string
+}
+
+// loadLookup reads the CSV file of a lookup
+func loadLookup(config LookupConfig) (*lookupProcessor, error) {
+	if config.File == "" || config.Field == "" {
+		return nil, errors.New("file and field are required")
+	}
+	f, err := os.Open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", config.File, err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("%s: expected a header row with a key column and at least one more", config.File)
+	}
+
+	l := &lookupProcessor{field: config.Field, columns: records[0][1:], rows: make(map[string][]string, len(records)-1)}
+	for _, record := range records[1:] {
+		l.rows[record[0]] = record[1:]
+	}
+	return l, nil
+}
+
+func (l *lookupProcessor) process(log *Log) bool {
+	row, ok := l.rows[fieldValue(*log, l.field)]
+	if !ok {
+		return true
+	}
+	for i, column := range l.columns {
+		if _, ok := log.Metadata.value(column); !ok && row[i] != "" {
+			log.Metadata.set(column, row[i])
+		}
+	}
+	return true
+}
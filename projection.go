@@ -0,0 +1,109 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Field projection of query results ("fields"), marshaling only the requested fields
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// projectableFields lists the log fields a projection may name
+var projectableFields = []string{"level", "message", "resourceId", "timestamp", "traceId", "spanId", "commit", "metadata"}
+
+// Projection lists the fields written for each log of a query response, in
+// order; a nil Projection writes whole logs
+type Projection []string
+
+// parseProjection reads "fields", either an array of names or a comma-separated string
+func parseProjection(raw json.RawMessage) (Projection, error) {
+	var fields []string
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		var list string
+		if json.Unmarshal(raw, &list) != nil {
+			return nil, fmt.Errorf("must be an array of field names or a comma-separated string")
+		}
+		fields = splitList(list)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("must name at least one of %s", strings.Join(projectableFields, ", "))
+	}
+
+	var projection Projection
+	for _, field := range fields {
+		if !slices.Contains(projectableFields, field) && customField(field) == nil {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(projectableFields, ", "))
+		}
+		if !slices.Contains(projection, field) {
+			projection = append(projection, field)
+		}
+	}
+
+	return projection, nil
+}
+
+// apply returns what is marshaled for a log: the log itself, or its projection
+func (p Projection) apply(log Log) interface{} {
+	if p == nil {
+		return log
+	}
+	return projectedLog{log: log, fields: p}
+}
+
+// project returns what is marshaled for a list of logs
+func (p Projection) project(logs []Log) interface{} {
+	if p == nil {
+		return logs
+	}
+
+	projected := make([]projectedLog, len(logs))
+	for i, log := range logs {
+		projected[i] = projectedLog{log: log, fields: p}
+	}
+	return projected
+}
+
+// projectedLog marshals only the projected fields of a log
+type projectedLog struct {
+	log    Log
+	fields Projection
+}
+
+func (pl projectedLog) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range pl.fields {
+		var value interface{}
+		switch field {
+		case "timestamp":
+			value = pl.log.Timestamp
+		case "metadata":
+			value = pl.log.Metadata
+		default:
+			if customField(field) != nil {
+				value = pl.log.Custom[field]
+				break
+			}
+			value = fieldValue(pl.log, field)
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:", field)
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,185 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Compact protocol buffers batches (LogBatch in proto/logingestor.proto) for /ingest/batch
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// protoBatchContentTypes are the Content-Types of a LogBatch body
+var protoBatchContentTypes = []string{"application/x-protobuf", "application/protobuf"}
+
+// isProtoBatch reports whether an /ingest/batch body is a LogBatch
+func isProtoBatch(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == protoBatchContentTypes[0] || mediaType == protoBatchContentTypes[1]
+}
+
+// protoBatch is a decoded LogBatch: the string table, the time the offsets of
+// the entries count from, and the entries still encoded
+type protoBatch struct {
+	strings []string
+	base    time.Time
+	based   bool // base_time was sent, otherwise offsets count from the Unix epoch
+	entries [][]byte
+}
+
+// decodeProtoBatch decodes the envelope of a LogBatch; its entries are read by
+// entry, once the string table is known, as it may follow them on the wire
+func decodeProtoBatch(body []byte) (*protoBatch, error) {
+	batch := &protoBatch{base: time.Unix(0, 0).UTC()}
+	err := parseProto(body, func(f protoField) error {
+		if f.WireType != wireBytes {
+			return nil
+		}
+		var err error
+		switch f.Num {
+		case 1:
+			batch.strings = append(batch.strings, f.String())
+		case 2:
+			batch.base, err = parseProtoTimestamp(f.Bytes)
+			batch.based = true
+		case 3:
+			batch.entries = append(batch.entries, f.Bytes)
+		}
+		return err
+	})
+	return batch, err
+}
+
+// ref resolves a string table reference: 0 is the empty string, i the entry i-1
+func (batch *protoBatch) ref(f protoField) (string, error) {
+	if f.WireType != wireVarint {
+		return "", fmt.Errorf("field %d must be a string table index", f.Num)
+	}
+	if f.Varint == 0 {
+		return "", nil
+	}
+	if f.Varint > uint64(len(batch.strings)) {
+		return "", fmt.Errorf("string index %d out of range (the table has %d)", f.Varint, len(batch.strings))
+	}
+	return batch.strings[f.Varint-1], nil
+}
+
+// entry decodes the CompactLog i into a log entry, with the fields and types a
+// JSON one would have, so that Validator.Validate sees the same log
+func (batch *protoBatch) entry(i int) (Log, error) {
+	var log Log
+	var custom map[string]interface{}
+	var offset int64
+	err := parseProto(batch.entries[i], func(f protoField) error {
+		var err error
+		switch f.Num {
+		case 1:
+			log.Level, err = batch.ref(f)
+		case 2:
+			log.Message = f.String()
+		case 3:
+			log.ResourceID, err = batch.ref(f)
+		case 4:
+			offset = protoZigzag(f.Varint)
+		case 5:
+			log.TraceID = f.String()
+		case 6:
+			log.SpanID = f.String()
+		case 7:
+			log.Commit, err = batch.ref(f)
+		case 8:
+			log.Metadata.ParentResourceID, err = batch.ref(f)
+		case 9, 10:
+			var key string
+			var value interface{}
+			if key, value, err = batch.field(f.Bytes); err != nil {
+				return err
+			}
+			if f.Num == 9 {
+				if log.Metadata.Fields == nil {
+					log.Metadata.Fields = make(map[string]any)
+				}
+				log.Metadata.Fields[key] = value
+			} else {
+				if custom == nil {
+					custom = make(map[string]interface{})
+				}
+				custom[key] = value
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return Log{}, err
+	}
+
+	// Without base_time, an entry without an offset has no timestamp
+	if batch.based || offset != 0 {
+		log.Timestamp = batch.base.Add(time.Duration(offset))
+	}
+	log.Custom = docCustom(custom)
+	return log, nil
+}
+
+// field decodes a Field message to its name and value, numbers as json.Number
+func (batch *protoBatch) field(b []byte) (key string, value interface{}, err error) {
+	err = parseProto(b, func(f protoField) error {
+		var err error
+		switch f.Num {
+		case 1:
+			key, err = batch.ref(f)
+		case 2:
+			value = f.String()
+		case 3:
+			value = json.Number(strconv.FormatInt(protoZigzag(f.Varint), 10))
+		case 4:
+			value = json.Number(strconv.FormatFloat(f.Double(), 'g', -1, 64))
+		case 5:
+			value = f.Varint != 0
+		case 6:
+			value, err = batch.ref(f)
+		}
+		return err
+	})
+	if err == nil && key == "" {
+		err = fmt.Errorf("field without a key")
+	}
+	return key, value, err
+}
+
+// protoZigzag decodes a sint64 varint
+func protoZigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// ingestProtoBatch stores a LogBatch, as handleIngestBatch a JSON array; rejected
+// entries are dead-lettered as JSON
+func (s *Server) ingestProtoBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	batch, err := decodeProtoBatch(body)
+	if err != nil {
+		s.rejectRequest(r, "ingest/batch", rejectionReason(err), body)
+		writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Error decoding LogBatch", err.Error())
+		return
+	}
+
+	var valid []Log
+	response := BatchResponse{Results: make([]BatchResult, 0, len(batch.entries))}
+	for i := range batch.entries {
+		log, err := batch.entry(i)
+		if err != nil {
+			s.rejectRequest(r, "ingest/batch", rejectionReason(err), nil)
+			response.reject(i, "Error decoding LogBatch: "+err.Error(), nil)
+			continue
+		}
+		if log, ok := s.batchEntry(r, &response, i, log, nil); ok {
+			valid = append(valid, log)
+		}
+	}
+	s.storeBatch(w, r, valid, response)
+}
@@ -0,0 +1,189 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Minimal protocol buffers wire format encoder and decoder used by the binary APIs
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Protocol buffers wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("proto: truncated message")
+
+// protoEncoder appends fields in the protocol buffers wire format. Following
+// proto3, fields holding their zero value are omitted.
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// uint64 writes a varint field
+func (e *protoEncoder) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// int64 writes an int64/int32 field (two's complement varint)
+func (e *protoEncoder) int64(field int, v int64) {
+	e.uint64(field, uint64(v))
+}
+
+// bool writes a bool field
+func (e *protoEncoder) bool(field int, v bool) {
+	if v {
+		e.uint64(field, 1)
+	}
+}
+
+// fixed64 writes a fixed64 field
+func (e *protoEncoder) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+// double writes a double field
+func (e *protoEncoder) double(field int, v float64) {
+	e.fixed64(field, math.Float64bits(v))
+}
+
+// string writes a string field
+func (e *protoEncoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// bytes writes a bytes field
+func (e *protoEncoder) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// message writes an embedded message built by fn; it is always written, even when empty
+func (e *protoEncoder) message(field int, fn func(*protoEncoder)) {
+	var inner protoEncoder
+	fn(&inner)
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(inner.buf)))
+	e.buf = append(e.buf, inner.buf...)
+}
+
+// timestamp writes a google.protobuf.Timestamp field
+func (e *protoEncoder) timestamp(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	e.message(field, func(m *protoEncoder) {
+		m.int64(1, t.Unix())
+		m.int64(2, int64(t.Nanosecond()))
+	})
+}
+
+// protoField is one decoded field; which value is set depends on the wire type
+type protoField struct {
+	Num      int
+	WireType int
+	Varint   uint64
+	Fixed    uint64
+	Bytes    []byte
+}
+
+// String returns a length-delimited field as a string
+func (f protoField) String() string { return string(f.Bytes) }
+
+// Int64 returns a varint field as a signed integer
+func (f protoField) Int64() int64 { return int64(f.Varint) }
+
+// Double returns a fixed64 field as a float64
+func (f protoField) Double() float64 { return math.Float64frombits(f.Fixed) }
+
+// parseProto calls fn for every field of an encoded message, in wire order
+func parseProto(b []byte, fn func(protoField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		b = b[n:]
+
+		f := protoField{Num: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case wireVarint:
+			f.Varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errProtoTruncated
+			}
+			f.Fixed, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errProtoTruncated
+			}
+			f.Fixed, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errProtoTruncated
+			}
+			f.Bytes, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d", f.WireType)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseProtoTimestamp decodes a google.protobuf.Timestamp message
+func parseProtoTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := parseProto(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			seconds = f.Int64()
+		case 2:
+			nanos = f.Int64()
+		}
+		return nil
+	})
+
+	return time.Unix(seconds, nanos).UTC(), err
+}
@@ -0,0 +1,360 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Right-to-erasure purges of every log holding an identifier, with signed deletion reports
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// minPurgeIdentifier is the fewest characters an identifier needs, so that a
+// purge cannot match most logs by accident
+const minPurgeIdentifier = 3
+
+var purgedLogs = metrics.Counter("logingestor_purged_logs_total", "Logs purged through /admin/purge")
+
+// Purger is implemented by a Storage that can rewrite its files without some logs
+type Purger interface {
+	// Purge rewrites the stored files holding deleted logs without them
+	Purge(ctx context.Context, deleted func(Log) bool) ([]PurgedFile, error)
+}
+
+// PurgedFile is a file a purge rewrote: a WAL segment, the file storage or a
+// cold tier window
+type PurgedFile struct {
+	Tier    string `json:"tier"`
+	File    string `json:"file"`
+	Removed int    `json:"removed"` // logs it no longer holds
+}
+
+// PurgeReport records a purge. It holds the SHA-256 of the identifier, not the
+// identifier itself, and is signed with purge-signing-key.
+type PurgeReport struct {
+	ID          string                  `json:"id"`
+	Tenant      string                  `json:"tenant"`
+	Node        string                  `json:"node,omitempty"`
+	Subject     string                  `json:"subject_sha256"`
+	From        time.Time               `json:"timestamp_from,omitzero"`
+	To          time.Time               `json:"timestamp_to,omitzero"`
+	DryRun      bool                    `json:"dry_run"`
+	RequestedBy string                  `json:"requested_by,omitempty"`
+	StartedAt   time.Time               `json:"started_at"`
+	FinishedAt  time.Time               `json:"finished_at"`
+	Matched     int                     `json:"matched"`                 // logs holding the identifier
+	Files       []PurgedFile            `json:"files,omitempty"`         // rewritten without them
+	Patterns    int                     `json:"patterns,omitempty"`      // message templates dropped
+	Summaries   int                     `json:"summaries,omitempty"`     // rollup summaries of resources holding it, dropped
+	Exemplars   int                     `json:"exemplars,omitempty"`     // rollup exemplars dropped
+	DeadLetters int                     `json:"dead_letters,omitempty"`  // dead letters dropped
+	Audit       int                     `json:"audit_entries,omitempty"` // earlier audit entries redacted
+	Nodes       map[string]*PurgeReport `json:"nodes,omitempty"`         // the report of each cluster node
+	Signature   string                  `json:"signature"`               // hex HMAC-SHA256 of the report without it
+}
+
+// digest returns the HMAC-SHA256 of the report without its signature
+func (report PurgeReport) digest(key string) []byte {
+	report.Signature = ""
+	data, _ := json.Marshal(report)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// sign sets the signature of the report
+func (report *PurgeReport) sign(key string) {
+	report.Signature = hex.EncodeToString(report.digest(key))
+}
+
+// verify reports whether the report is unchanged since it was signed with key
+func (report *PurgeReport) verify(key string) bool {
+	signature, err := hex.DecodeString(report.Signature)
+	return err == nil && hmac.Equal(signature, report.digest(key))
+}
+
+// subjectHash returns the hex SHA-256 of an identifier in lower case, as reports
+// and the audit log hold it
+func subjectHash(identifier string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(identifier))))
+	return hex.EncodeToString(sum[:])
+}
+
+// containsIdentifier reports whether the message or a field value of log,
+// metadata and custom fields included, contains id, which is lower case
+func containsIdentifier(log Log, id string) bool {
+	for _, field := range []string{log.Message, log.ResourceID, log.TraceID, log.SpanID, log.Commit, log.Metadata.ParentResourceID} {
+		if strings.Contains(strings.ToLower(field), id) {
+			return true
+		}
+	}
+	return valueContains(log.Metadata.Fields, id) || valueContains(log.Custom, id)
+}
+
+// valueContains reports whether a decoded JSON value holds a string or number
+// containing id, at any depth
+func valueContains(value any, id string) bool {
+	switch v := value.(type) {
+	case string:
+		return strings.Contains(strings.ToLower(v), id)
+	case float64:
+		return strings.Contains(strconv.FormatFloat(v, 'f', -1, 64), id)
+	case json.Number:
+		return strings.Contains(v.String(), id)
+	case map[string]any:
+		for _, field := range v {
+			if valueContains(field, id) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if valueContains(item, id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Purge tombstones the logs between from and to (zero for unbounded) holding
+// identifier, ignoring case, then rewrites the stored files and cold windows
+// holding deleted logs without them, and drops the pattern templates and rollup
+// exemplars holding it. Nothing is purged when more than maxLogs match.
+func (ls *LogStorage) Purge(ctx context.Context, identifier string, from, to time.Time, dryRun bool, maxLogs int) (PurgeReport, error) {
+	report := PurgeReport{Subject: subjectHash(identifier), From: from, To: to, DryRun: dryRun, StartedAt: time.Now().UTC()}
+	filters := make(map[string]string)
+	if !from.IsZero() {
+		filters["timestamp_from"] = from.Format(time.RFC3339Nano)
+	}
+	if !to.IsZero() {
+		filters["timestamp_to"] = to.Format(time.RFC3339Nano)
+	}
+	id := strings.ToLower(strings.TrimSpace(identifier))
+	matched, err := ls.collect(ctx, filters, nil, func(log Log) bool { return containsIdentifier(log, id) }, maxLogs)
+	if err != nil {
+		return report, err
+	}
+	report.Matched = len(matched)
+
+	if !dryRun && len(matched) > 0 {
+		if err := ls.remove(matched, filters); err != nil {
+			return report, err
+		}
+		if purger, ok := ls.store.(Purger); ok {
+			files, err := purger.Purge(ctx, ls.deleted.has)
+			report.Files = append(report.Files, files...)
+			if err != nil {
+				return report, err
+			}
+		}
+		files, err := ls.purgeCold(ctx, from, to)
+		report.Files = append(report.Files, files...)
+		if err != nil {
+			return report, err
+		}
+		purgedLogs.Add(int64(len(matched)))
+	}
+	if !dryRun {
+		if ls.patterns != nil {
+			report.Patterns = ls.patterns.purge(id)
+		}
+		if ls.rollups != nil {
+			if report.Summaries, report.Exemplars, err = ls.rollups.purge(id, from, to); err != nil {
+				return report, err
+			}
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// PurgeRequest is the body of POST /admin/purge
+type PurgeRequest struct {
+	Identifier string    `json:"identifier"`
+	From       time.Time `json:"timestamp_from"`
+	To         time.Time `json:"timestamp_to"`
+	DryRun     bool      `json:"dry_run"`
+}
+
+// handlePurge erases every log holding the identifier of the body, within its
+// optional time range, and answers with the signed report of the purge; with
+// "dry_run": true it only counts them. In cluster mode every node purges its
+// copies, so every node must be up.
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.cfg.PurgeSigningKey == "" {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "Purges are disabled: set purge-signing-key to sign their reports", nil)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var req PurgeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(req.Identifier)) < minPurgeIdentifier {
+		writeValidationError(w, fmt.Errorf("Invalid identifier: must be at least %d characters", minPurgeIdentifier))
+		return
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && req.To.Before(req.From) {
+		writeValidationError(w, errors.New("timestamp_to must not be before timestamp_from"))
+		return
+	}
+	// The audit log must not keep the identifier the purge erases
+	auditQuery(r.Context(), map[string]json.RawMessage{
+		"subject_sha256": json.RawMessage(strconv.Quote(subjectHash(req.Identifier))),
+		"dry_run":        json.RawMessage(strconv.FormatBool(req.DryRun)),
+	})
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var report PurgeReport
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		report, err = s.clusterPurge(ctx, r, body, req)
+	} else {
+		report, err = s.tenant(r.Context()).storage.Purge(ctx, req.Identifier, req.From, req.To, req.DryRun, s.cfg.DeleteMaxLogs)
+		if err == nil {
+			err = s.purgeRecords(r.Context(), req, &report)
+		}
+		if s.cluster != nil {
+			report.Node = s.cluster.self.ID
+		}
+	}
+	var tooMany *errTooManyMatches
+	switch {
+	case errors.As(err, &tooMany):
+		writeValidationError(w, err)
+		return
+	case err != nil:
+		writeClusterError(w, err, PartialResponse{})
+		return
+	}
+
+	s.signPurge(r.Context(), &report)
+	if !req.DryRun {
+		logger.Warn("logs purged", "tenant", report.Tenant, "key", report.RequestedBy, "report", report.ID, "logs", report.Matched, "files", len(report.Files))
+	}
+	auditResults(r.Context(), report.Matched)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// clusterPurge runs a purge on every node and gathers their reports
+func (s *Server) clusterPurge(ctx context.Context, r *http.Request, body []byte, req PurgeRequest) (PurgeReport, error) {
+	reports := make([]*PurgeReport, len(s.cluster.nodes))
+	merged := PurgeReport{Subject: subjectHash(req.Identifier), From: req.From, To: req.To, DryRun: req.DryRun, StartedAt: time.Now().UTC()}
+	err := s.cluster.eachNode(func(i int, node *clusterNode) error {
+		report := new(PurgeReport)
+		reports[i] = report
+		if node == s.cluster.self {
+			var err error
+			*report, err = s.tenant(r.Context()).storage.Purge(ctx, req.Identifier, req.From, req.To, req.DryRun, s.cfg.DeleteMaxLogs)
+			if err == nil {
+				err = s.purgeRecords(r.Context(), req, report)
+			}
+			report.Node = node.ID
+			s.signPurge(r.Context(), report)
+			return err
+		}
+
+		resp, err := s.cluster.peerQuery(ctx, node, "/admin/purge", r, body, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+			return peerError(node, err)
+		}
+		return nil
+	})
+
+	merged.Nodes = make(map[string]*PurgeReport, len(reports))
+	for i, report := range reports {
+		merged.Nodes[s.cluster.nodes[i].ID] = report
+		merged.Matched += report.Matched
+		merged.Patterns += report.Patterns
+		merged.Summaries += report.Summaries
+		merged.Exemplars += report.Exemplars
+		merged.DeadLetters += report.DeadLetters
+		merged.Audit += report.Audit
+	}
+	merged.FinishedAt = time.Now().UTC()
+	return merged, err
+}
+
+// purgeRecords drops the tenant's dead letters holding the identifier of a purge
+// and redacts its earlier audit entries, whatever the time range of the purge
+func (s *Server) purgeRecords(ctx context.Context, req PurgeRequest, report *PurgeReport) error {
+	if req.DryRun {
+		return nil
+	}
+	tenant, id := s.tenant(ctx).ID, strings.ToLower(strings.TrimSpace(req.Identifier))
+	var err error
+	if s.deadLetters != nil {
+		if report.DeadLetters, err = s.deadLetters.Purge(tenant, id); err != nil {
+			return err
+		}
+	}
+	if s.audit != nil {
+		report.Audit, err = s.audit.Redact(tenant, id)
+	}
+	return err
+}
+
+// signPurge completes a report with its id, tenant and requester, then signs it
+func (s *Server) signPurge(ctx context.Context, report *PurgeReport) {
+	report.ID = newPurgeID()
+	report.Tenant = s.tenant(ctx).ID
+	report.RequestedBy = principalName(ctx)
+	report.sign(s.cfg.PurgeSigningKey)
+}
+
+// handleVerifyPurge answers a purge report with whether its signature holds
+func (s *Server) handleVerifyPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.cfg.PurgeSigningKey == "" {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "Purges are disabled: set purge-signing-key to sign their reports", nil)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var report PurgeReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"valid": report.verify(s.cfg.PurgeSigningKey)})
+}
+
+// newPurgeID returns a random id for a purge report
+func newPurgeID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
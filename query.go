@@ -0,0 +1,381 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Parsing of /query request bodies into filters and result options such as pagination
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var queriesStopped = metrics.CounterVec("logingestor_queries_stopped_total", "Queries stopped before completing, by query-timeout or by the client going away", "reason")
+
+// QueryOptions controls which page of the matching logs is returned
+type QueryOptions struct {
+	Offset int
+	Limit  int // zero means no limit
+	Sort   []SortKey
+	Scope  AccessScope    // logs outside the caller's scope are never returned
+	Expr   lqlNode        // parsed "q" expression, nil when absent
+	Serves func(Log) bool // in cluster mode, the logs this node answers for; nil is every log
+}
+
+// allows reports whether a log matching the query may be returned
+func (o QueryOptions) allows(log Log) bool {
+	return o.Scope.allows(log) && (o.Serves == nil || o.Serves(log))
+}
+
+// QueryRequest is a decoded /query body: the filters plus the result options.
+// The body stays a flat JSON object; option keys are reserved and every other
+// key is a filter.
+type QueryRequest struct {
+	Filters    map[string]string
+	AnyOf      map[string][]string // filters given several values, matched by Options.Expr
+	Not        map[string][]string // negated filters, excluding the logs matching any value
+	Options    QueryOptions
+	Paginated  bool
+	CountOnly  bool            // only the number of matching logs is returned
+	Fields     Projection      // fields written for each log; nil writes whole logs
+	MinLevel   string          // least severe level returned, resolved by LevelRegistry.apply
+	Highlight  *Highlighter    // marks the message matches of each log; nil without "highlight"
+	Context    *ContextOptions // logs around each match; nil without "context"
+	CollapseBy string          // field whose logs of equal value are returned once, with their count
+	Pattern    string          // id of the mined pattern messages must fit, resolved by Server.applyPattern
+
+	contexts []LogContext // the context of each log of the page once addContext fetched it
+	counts   []int        // the size of the group of each log of the page once collapsed
+}
+
+// CountResponse is the /query response body for count_only requests
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// QueryResponse is the /query response body for paginated requests
+type QueryResponse struct {
+	Logs      interface{} `json:"logs"` // []Log, or their projection when "fields" is set
+	NextToken string      `json:"next_token,omitempty"`
+}
+
+// filterFields are the log fields a filter key may name besides metadata paths
+// and custom fields; each of them but the timestamp keys takes the regex suffix
+var filterFields = []string{"level", "message", "resourceId", "timestamp", "timestamp_from", "timestamp_to", "traceId", "spanId", "commit", "metadata.parentResourceId"}
+
+// UnknownFilterKeysError lists the filter keys of a query that name no field, in order
+type UnknownFilterKeysError []string
+
+func (e UnknownFilterKeysError) Error() string {
+	return fmt.Sprintf("Unknown filter keys: %s (set lenient to true to ignore them)", strings.Join(e, ", "))
+}
+
+// isFilterKey reports whether a filter key names a field logs can be matched on
+func isFilterKey(key string) bool {
+	field, regex := regexField(key)
+	if !regex {
+		field = key
+	} else if strings.HasPrefix(field, "timestamp") {
+		return false
+	}
+	return slices.Contains(filterFields, field) || isMetadataPath(field) || customField(field) != nil
+}
+
+// validateFilterKeys rejects the filter keys, single or multi-value, that name
+// no field, which would otherwise be ignored and match every log
+func validateFilterKeys(filters map[string]string, sets ...map[string][]string) error {
+	var unknown UnknownFilterKeysError
+	for key := range filters {
+		if !isFilterKey(key) && key != messageModeKey {
+			unknown = append(unknown, key)
+		}
+	}
+	for _, set := range sets {
+		for key := range set {
+			if !isFilterKey(key) {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+	if unknown == nil {
+		return nil
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// buildQuery parses the fields of a query request and validates its filters;
+// unknown filter keys are rejected unless "lenient" is true
+func buildQuery(fields map[string]json.RawMessage, maxPageSize int) (QueryRequest, error) {
+	req, err := parseQueryRequest(fields, maxPageSize)
+	if err != nil {
+		return QueryRequest{}, err
+	}
+
+	var lenient bool
+	if raw, ok := fields["lenient"]; ok {
+		if err := json.Unmarshal(raw, &lenient); err != nil {
+			return QueryRequest{}, fmt.Errorf("Invalid lenient: %v", err)
+		}
+	}
+	if !lenient {
+		if err := validateFilterKeys(req.Filters, req.AnyOf, req.Not); err != nil {
+			return QueryRequest{}, err
+		}
+	}
+
+	if err := validateTimeFilters(req.Filters); err != nil {
+		return QueryRequest{}, err
+	}
+
+	if err := validateRegexFilters(req.Filters); err != nil {
+		return QueryRequest{}, err
+	}
+
+	if err := validateAnyOf(req.AnyOf); err != nil {
+		return QueryRequest{}, err
+	}
+
+	if err := validateAnyOf(req.Not); err != nil {
+		return QueryRequest{}, err
+	}
+
+	if err := validateMessageMode(req.Filters, req.AnyOf, req.Not); err != nil {
+		return QueryRequest{}, err
+	}
+
+	return req, nil
+}
+
+// buildRuleQuery parses the query of an alerting rule or output sink: filters,
+// min_level and "q" only, without time bounds since logs are matched as they come
+func buildRuleQuery(fields map[string]json.RawMessage, maxPageSize int, levels *LevelRegistry) (QueryRequest, error) {
+	req, err := buildQuery(fields, maxPageSize)
+	if err != nil {
+		return QueryRequest{}, err
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.CountOnly || req.Fields != nil || req.Highlight != nil || req.Context != nil || req.CollapseBy != "" || req.Pattern != "" {
+		return QueryRequest{}, errors.New("query may only hold filters, min_level and q")
+	}
+	for _, key := range []string{"timestamp", "timestamp_from", "timestamp_to"} {
+		if _, ok := req.Filters[key]; ok {
+			return QueryRequest{}, fmt.Errorf("query may not filter on %s", key)
+		}
+	}
+	if err := levels.apply(&req); err != nil {
+		return QueryRequest{}, err
+	}
+
+	return req, nil
+}
+
+// urlIntegerKeys and urlBooleanKeys are the /query keys a URL query gives as
+// numbers and booleans rather than strings
+var (
+	urlIntegerKeys = []string{"limit", "offset", "context"}
+	urlBooleanKeys = []string{"count_only", "lenient"}
+)
+
+// urlQueryFields turns the parameters of a GET /query, or of /tail, into the
+// fields of a /query body: "fields" is a comma-separated list, "from" and "to"
+// stand for timestamp_from and timestamp_to, and a filter repeated is multi-value
+func urlQueryFields(params url.Values) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage, len(params))
+	for key, values := range params {
+		value := values[0]
+		switch key {
+		case "from":
+			key = "timestamp_from"
+		case "to":
+			key = "timestamp_to"
+		}
+		if _, ok := fields[key]; ok {
+			return nil, fmt.Errorf("Invalid %s: given twice", key)
+		}
+		if len(values) > 1 && isFilterKey(strings.TrimSuffix(key, negationSuffix)) && !slices.Contains(scalarFilterKeys, key) {
+			fields[key], _ = json.Marshal(values)
+			continue
+		}
+
+		var v interface{} = value
+		switch {
+		case slices.Contains(urlIntegerKeys, key):
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: expected an integer", key)
+			}
+			v = n
+		case slices.Contains(urlBooleanKeys, key):
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: expected true or false", key)
+			}
+			v = b
+		case key == "fields":
+			v = splitList(value)
+		}
+		fields[key], _ = json.Marshal(v)
+	}
+	return fields, nil
+}
+
+// parseQueryRequest splits the fields of a /query body into filters and options,
+// capping the page size at maxPageSize
+func parseQueryRequest(fields map[string]json.RawMessage, maxPageSize int) (QueryRequest, error) {
+	req := QueryRequest{
+		Filters: make(map[string]string, len(fields)),
+		Options: QueryOptions{Limit: maxPageSize},
+	}
+	var highlight string
+	var contextOpts ContextOptions
+	for key, raw := range fields {
+		var err error
+		switch key {
+		case "highlight":
+			err = json.Unmarshal(raw, &highlight)
+		case "context":
+			err = json.Unmarshal(raw, &contextOpts.Logs)
+			if err == nil && (contextOpts.Logs <= 0 || contextOpts.Logs > maxContextLogs) {
+				err = fmt.Errorf("must be between 1 and %d", maxContextLogs)
+			}
+		case "context_by":
+			err = json.Unmarshal(raw, &contextOpts.By)
+			if err == nil && !slices.Contains(contextFields, contextOpts.By) {
+				err = fmt.Errorf("must be one of %s", strings.Join(contextFields, ", "))
+			}
+		case "limit":
+			req.Paginated = true
+			err = json.Unmarshal(raw, &req.Options.Limit)
+			if err == nil && (req.Options.Limit <= 0 || req.Options.Limit > maxPageSize) {
+				err = fmt.Errorf("must be between 1 and %d", maxPageSize)
+			}
+		case "offset":
+			req.Paginated = true
+			err = json.Unmarshal(raw, &req.Options.Offset)
+			if err == nil && req.Options.Offset < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "page_token":
+			req.Paginated = true
+			var token string
+			if err = json.Unmarshal(raw, &token); err == nil {
+				req.Options.Offset, err = decodePageToken(token)
+			}
+		case "fields":
+			req.Fields, err = parseProjection(raw)
+		case "min_level":
+			err = json.Unmarshal(raw, &req.MinLevel)
+			if err == nil && req.MinLevel == "" {
+				err = errors.New("must not be empty")
+			}
+		case "count_only":
+			err = json.Unmarshal(raw, &req.CountOnly)
+		case "collapse_by":
+			if err = json.Unmarshal(raw, &req.CollapseBy); err == nil {
+				err = parseCollapseBy(req.CollapseBy)
+			}
+		case "lenient": // read by buildQuery
+		case "pattern":
+			err = json.Unmarshal(raw, &req.Pattern)
+			if err == nil && req.Pattern == "" {
+				err = errors.New("must not be empty")
+			}
+		case "q":
+			var query string
+			if err = json.Unmarshal(raw, &query); err == nil {
+				req.Options.Expr, err = parseLQL(query)
+			}
+		case "sort":
+			var value string
+			if err = json.Unmarshal(raw, &value); err == nil {
+				req.Options.Sort, err = parseSort(value)
+			}
+		case "not":
+			err = req.parseNot(raw)
+		default:
+			if negated, ok := strings.CutSuffix(key, negationSuffix); ok {
+				err = req.addNegated(negated, raw)
+				break
+			}
+			if len(raw) > 0 && raw[0] == '[' {
+				var values []string
+				if values, err = parseFilterValues(key, raw); len(values) == 1 {
+					req.Filters[key] = values[0]
+				} else if err == nil {
+					if req.AnyOf == nil {
+						req.AnyOf = make(map[string][]string)
+					}
+					req.AnyOf[key] = values
+				}
+				break
+			}
+			var value string
+			err = json.Unmarshal(raw, &value)
+			req.Filters[key] = value
+		}
+
+		if err != nil {
+			return QueryRequest{}, fmt.Errorf("Invalid %s: %v", key, err)
+		}
+	}
+
+	_, hasOffset := fields["offset"]
+	_, hasToken := fields["page_token"]
+	if hasOffset && hasToken {
+		return QueryRequest{}, errors.New("Invalid page_token: cannot be combined with offset")
+	}
+	if req.CountOnly && (req.Paginated || len(req.Options.Sort) > 0) {
+		return QueryRequest{}, errors.New("Invalid count_only: cannot be combined with limit, offset, page_token or sort")
+	}
+	if req.CountOnly && req.CollapseBy != "" {
+		return QueryRequest{}, errors.New("Invalid collapse_by: cannot be combined with count_only")
+	}
+	if _, ok := fields["context"]; ok {
+		if req.CountOnly {
+			return QueryRequest{}, errors.New("Invalid context: cannot be combined with count_only")
+		}
+		if contextOpts.By == "" {
+			contextOpts.By = "resourceId"
+		}
+		req.Context = &contextOpts
+	} else if _, ok := fields["context_by"]; ok {
+		return QueryRequest{}, errors.New("Invalid context_by: requires context")
+	}
+	req.Options.Expr = setFilterExpr(req.Options.Expr, req.AnyOf, req.Filters[messageModeKey], false)
+	req.Options.Expr = setFilterExpr(req.Options.Expr, req.Not, req.Filters[messageModeKey], true)
+	if _, ok := fields["highlight"]; ok {
+		var err error
+		if req.Highlight, err = newHighlighter(highlight, req.Filters, req.Options.Expr); err != nil {
+			return QueryRequest{}, fmt.Errorf("Invalid highlight: %v", err)
+		}
+	}
+
+	return req, nil
+}
+
+// encodePageToken returns the opaque token for the page starting at offset
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodePageToken returns the offset encoded in a page token
+func decodePageToken(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.New("malformed page token")
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "offset:"))
+	if err != nil || offset < 0 || !strings.HasPrefix(string(raw), "offset:") {
+		return 0, errors.New("malformed page token")
+	}
+
+	return offset, nil
+}
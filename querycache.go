@@ -0,0 +1,243 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : LRU cache of query results, invalidated by the shards that change under them
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	queryCacheHits    = metrics.Counter("logingestor_query_cache_hits_total", "Queries answered from the query cache")
+	queryCacheMisses  = metrics.Counter("logingestor_query_cache_misses_total", "Cacheable queries that had to scan the shards")
+	queryCacheEntries = metrics.Gauge("logingestor_query_cache_entries", "Query results held by the query caches")
+)
+
+// queryCache holds the results of the last queries of a tenant, least recently
+// used evicted first. An entry is valid while no shard overlapping its time range
+// changed since it was computed: ingests stamp their shard with a sequence
+// number (see LogStorage.touch), which the entry's must not be older than.
+// Deletions drop every entry and retention the entries reaching back to the
+// evicted logs.
+type queryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cachedQuery
+	lru     *list.List               // most recently used first
+
+	// Results computed before these changes are not added
+	clearedSeq     uint64
+	expiredSeq     uint64
+	expiredThrough time.Time // newest timestamp retention evicted
+}
+
+// cachedQuery is the result of a query, a page of logs or a count
+type cachedQuery struct {
+	key      string
+	from, to time.Time // time bounds of the filters, zero for open
+	seq      uint64    // LogStorage.changes when the query started
+	logs     []Log
+	more     bool
+	count    int
+}
+
+func newQueryCache(maxEntries int) *queryCache {
+	return &queryCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the entry of key, marking it used
+func (qc *queryCache) get(key string) (*cachedQuery, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	elem, ok := qc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	qc.lru.MoveToFront(elem)
+	return elem.Value.(*cachedQuery), true
+}
+
+// add stores an entry, unless a deletion or eviction happened since it started
+func (qc *queryCache) add(entry *cachedQuery) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if entry.seq < qc.clearedSeq || (entry.seq < qc.expiredSeq && !entry.from.After(qc.expiredThrough)) {
+		return
+	}
+	if elem, ok := qc.entries[entry.key]; ok {
+		qc.lru.Remove(elem)
+		queryCacheEntries.Add(-1)
+	}
+	qc.entries[entry.key] = qc.lru.PushFront(entry)
+	queryCacheEntries.Add(1)
+	for qc.lru.Len() > qc.maxEntries {
+		qc.removeLocked(qc.lru.Back())
+	}
+}
+
+// remove drops the entry of key unless it was replaced meanwhile
+func (qc *queryCache) remove(entry *cachedQuery) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if elem, ok := qc.entries[entry.key]; ok && elem.Value == entry {
+		qc.removeLocked(elem)
+	}
+}
+
+func (qc *queryCache) removeLocked(elem *list.Element) {
+	qc.lru.Remove(elem)
+	delete(qc.entries, elem.Value.(*cachedQuery).key)
+	queryCacheEntries.Add(-1)
+}
+
+// clear drops every entry after a change to any window, such as a deletion;
+// seq is a sequence number taken once the change is done
+func (qc *queryCache) clear(seq uint64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	queryCacheEntries.Add(-int64(qc.lru.Len()))
+	qc.entries = make(map[string]*list.Element)
+	qc.lru.Init()
+	qc.clearedSeq = seq
+}
+
+// expire drops the entries whose time range reaches back to through, the newest
+// timestamp retention evicted
+func (qc *queryCache) expire(through time.Time, seq uint64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	for elem := qc.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if !elem.Value.(*cachedQuery).from.After(through) {
+			qc.removeLocked(elem)
+		}
+		elem = next
+	}
+	qc.expiredSeq = seq
+	if through.After(qc.expiredThrough) {
+		qc.expiredThrough = through
+	}
+}
+
+// queryCacheKey returns the key of the results of a /query: its fields, which
+// json.Marshal writes with sorted keys and compacted values, and the caller's
+// scope. Queries whose results depend on more, mined patterns or the nodes a
+// cluster node answers for, have no key and are not cached.
+func queryCacheKey(ctx context.Context, fields map[string]json.RawMessage, req QueryRequest) string {
+	if req.Pattern != "" || req.Options.Serves != nil {
+		return ""
+	}
+	query, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	scope, _ := json.Marshal(accessScope(ctx))
+	return string(query) + "\x00" + string(scope) + "\x00" + strconv.FormatBool(req.CountOnly)
+}
+
+// EnableQueryCache makes CachedQuery and CachedCount keep the results of the
+// last maxEntries queries
+func (ls *LogStorage) EnableQueryCache(maxEntries int) {
+	ls.cache = newQueryCache(maxEntries)
+}
+
+// touch stamps a shard as changed, after logs were added to it
+func (ls *LogStorage) touch(sh *shard) {
+	if ls.cache != nil {
+		sh.changed.Store(ls.changes.Add(1))
+	}
+}
+
+// invalidateCache drops every cached result, after logs were removed
+func (ls *LogStorage) invalidateCache() {
+	if ls.cache != nil {
+		ls.cache.clear(ls.changes.Add(1))
+	}
+}
+
+// expireCache drops the cached results reaching back to through, after retention
+// evicted the logs up to it
+func (ls *LogStorage) expireCache(through time.Time) {
+	if ls.cache != nil {
+		ls.cache.expire(through, ls.changes.Add(1))
+	}
+}
+
+// cached returns the entry of key when no shard within its time range changed
+// since it was computed
+func (ls *LogStorage) cached(key string) (*cachedQuery, bool) {
+	entry, ok := ls.cache.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	stale := false
+	for _, sh := range ls.shards {
+		stale = stale || (sh.overlaps(entry.from, entry.to) && sh.changed.Load() > entry.seq)
+	}
+	if ls.cold != nil {
+		// Shards moved to the cold tier since keep their stamp there
+		for _, seg := range ls.cold.segments {
+			stale = stale || ((&shard{start: seg.footer.Start, end: seg.footer.End}).overlaps(entry.from, entry.to) && seg.changed > entry.seq)
+		}
+	}
+	if stale {
+		ls.cache.remove(entry)
+		return nil, false
+	}
+	return entry, true
+}
+
+// CachedQuery is Query answered from the query cache when the same query, by
+// key, ran since the shards it reads last changed. Without a cache or a key it
+// is Query.
+func (ls *LogStorage) CachedQuery(ctx context.Context, key string, filters map[string]string, opts QueryOptions) ([]Log, bool, error) {
+	if ls.cache == nil || key == "" {
+		return ls.Query(ctx, filters, opts)
+	}
+	if entry, ok := ls.cached(key); ok {
+		queryCacheHits.Inc()
+		return slices.Clone(entry.logs), entry.more, nil
+	}
+
+	queryCacheMisses.Inc()
+	seq := ls.changes.Load()
+	logs, more, err := ls.Query(ctx, filters, opts)
+	if err == nil {
+		from, to := timeBounds(filters)
+		ls.cache.add(&cachedQuery{key: key, from: from, to: to, seq: seq, logs: slices.Clone(logs), more: more})
+	}
+	return logs, more, err
+}
+
+// CachedCount is Count answered from the query cache, as CachedQuery
+func (ls *LogStorage) CachedCount(ctx context.Context, key string, filters map[string]string, opts QueryOptions) (int, error) {
+	if ls.cache == nil || key == "" {
+		return ls.Count(ctx, filters, opts)
+	}
+	if entry, ok := ls.cached(key); ok {
+		queryCacheHits.Inc()
+		return entry.count, nil
+	}
+
+	queryCacheMisses.Inc()
+	seq := ls.changes.Load()
+	count, err := ls.Count(ctx, filters, opts)
+	if err == nil {
+		from, to := timeBounds(filters)
+		ls.cache.add(&cachedQuery{key: key, from: from, to: to, seq: seq, count: count})
+	}
+	return count, err
+}
@@ -0,0 +1,367 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Daily quotas of logs and bytes per tenant or resource, rejecting or sampling what exceeds them
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaSaveInterval is how often changed usage is written to quotas.json
+const quotaSaveInterval = time.Minute
+
+// Actions of a quota on the logs exceeding it
+const (
+	QuotaReject = "reject"
+	QuotaSample = "sample"
+)
+
+var (
+	quotaExceeded    = metrics.CounterVec("logingestor_quota_exceeded_total", "Tenants and resources that reached a daily quota", "quota")
+	quotaRejected    = metrics.CounterVec("logingestor_quota_rejected_logs_total", "Logs rejected for exceeding a daily quota", "quota")
+	quotaSampledOut  = metrics.CounterVec("logingestor_quota_sampled_out_total", "Logs dropped by the sampling of a daily quota they exceed", "quota")
+	quotaSaveFailure = metrics.Counter("logingestor_quota_save_failures_total", "Failed writes of quotas.json")
+)
+
+// Quota is an entry of the quotas-file: the logs and bytes a tenant, or each of
+// its resources, may ingest per UTC day
+type Quota struct {
+	Name        string  `json:"name"`
+	Tenant      string  `json:"tenant"`        // empty applies to every tenant, each on its own
+	ResourceID  string  `json:"resource_id"`   // glob such as "checkout-*"; set, each matching resource has its own usage
+	LogsPerDay  int64   `json:"logs_per_day"`  // 0 is unlimited
+	BytesPerDay int64   `json:"bytes_per_day"` // approximate size of the logs, 0 is unlimited
+	Action      string  `json:"action"`        // reject (default) or sample the logs over the quota
+	SampleRate  float64 `json:"sample_rate"`   // fraction of the logs over the quota sample keeps
+}
+
+// QuotaUsage is what a tenant or resource ingested today against a quota
+type QuotaUsage struct {
+	Quota      string `json:"quota"`
+	Tenant     string `json:"tenant"`
+	ResourceID string `json:"resourceId,omitempty"` // empty for a quota of the whole tenant
+	Logs       int64  `json:"logs"`
+	Bytes      int64  `json:"bytes"`
+	Dropped    int64  `json:"dropped"` // logs rejected or sampled out today
+	Exceeded   bool   `json:"exceeded"`
+}
+
+// quotaKey identifies the usage of a quota by a tenant or one of its resources
+type quotaKey struct {
+	quota, tenant, resource string
+}
+
+// Quotas holds the quotas of the quotas-file and today's usage of each, written
+// to quotas.json in the data directory so restarts keep counting
+type Quotas struct {
+	quotas []Quota
+	path   string // empty keeps the usage in memory only
+
+	mu    sync.Mutex
+	day   string // the UTC day of the usage, 2006-01-02
+	usage map[quotaKey]*QuotaUsage
+	dirty bool // changed since last saved
+}
+
+// quotaFile is the content of quotas.json
+type quotaFile struct {
+	Day   string       `json:"day"`
+	Usage []QuotaUsage `json:"usage"`
+}
+
+// QuotaError is returned when a quota rejected every log of a request
+type QuotaError struct {
+	Quota   string
+	ResetAt time.Time // the next UTC midnight
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Daily quota %q exceeded, it resets at %s", e.Quota, e.ResetAt.Format(time.RFC3339))
+}
+
+// LoadQuotas reads and checks the quotas of the quotas-file, and the usage of
+// today saved in the data directory
+func LoadQuotas(cfg Config, tenants *Tenants) (*Quotas, error) {
+	data, err := os.ReadFile(cfg.QuotasFile)
+	if err != nil {
+		return nil, err
+	}
+	var quotas []Quota
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.QuotasFile, err)
+	}
+
+	names := make(map[string]bool)
+	for i, quota := range quotas {
+		if quota.Name == "" {
+			return nil, errors.New("quota without a name")
+		}
+		if names[quota.Name] {
+			return nil, fmt.Errorf("quota %q: duplicate name", quota.Name)
+		}
+		names[quota.Name] = true
+
+		if _, ok := tenants.byID[quota.Tenant]; quota.Tenant != "" && !ok {
+			return nil, fmt.Errorf("quota %q: unknown tenant %q", quota.Name, quota.Tenant)
+		}
+		if _, err := path.Match(quota.ResourceID, ""); err != nil {
+			return nil, fmt.Errorf("quota %q: invalid resource_id pattern %q", quota.Name, quota.ResourceID)
+		}
+		if quota.LogsPerDay < 0 || quota.BytesPerDay < 0 {
+			return nil, fmt.Errorf("quota %q: logs_per_day and bytes_per_day must not be negative", quota.Name)
+		}
+		if quota.LogsPerDay == 0 && quota.BytesPerDay == 0 {
+			return nil, fmt.Errorf("quota %q: needs logs_per_day or bytes_per_day", quota.Name)
+		}
+		switch quota.Action {
+		case "":
+			quotas[i].Action = QuotaReject
+		case QuotaReject:
+		case QuotaSample:
+			if quota.SampleRate < 0 || quota.SampleRate > 1 {
+				return nil, fmt.Errorf("quota %q: sample_rate must be between 0 and 1", quota.Name)
+			}
+		default:
+			return nil, fmt.Errorf("quota %q: action must be %s or %s", quota.Name, QuotaReject, QuotaSample)
+		}
+	}
+
+	q := &Quotas{quotas: quotas, usage: make(map[quotaKey]*QuotaUsage)}
+	if cfg.DataDir == "" {
+		return q, nil
+	}
+	q.path = filepath.Join(cfg.DataDir, "quotas.json")
+	data, err = os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file quotaFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", q.path, err)
+	}
+	q.day = file.Day
+	for i, usage := range file.Usage {
+		if names[usage.Quota] {
+			q.usage[quotaKey{usage.Quota, usage.Tenant, usage.ResourceID}] = &file.Usage[i]
+		}
+	}
+	return q, nil
+}
+
+// matches reports whether a quota applies to a log of tenant
+func (quota *Quota) matches(tenant string, log Log) bool {
+	if quota.Tenant != "" && quota.Tenant != tenant {
+		return false
+	}
+	ok, _ := path.Match(quota.ResourceID, log.ResourceID)
+	return quota.ResourceID == "" || ok
+}
+
+// usageOf returns the usage of a quota by the tenant or resource of a log; q.mu
+// is held
+func (q *Quotas) usageOf(quota *Quota, tenant string, log Log) *QuotaUsage {
+	key := quotaKey{quota: quota.Name, tenant: tenant}
+	if quota.ResourceID != "" {
+		key.resource = log.ResourceID
+	}
+	usage, ok := q.usage[key]
+	if !ok {
+		usage = &QuotaUsage{Quota: key.quota, Tenant: key.tenant, ResourceID: key.resource}
+		q.usage[key] = usage
+	}
+	return usage
+}
+
+// admit returns the logs of tenant within their quotas, or sampled past them,
+// counting them against every quota they match. The first exhausted quota of a
+// log decides its fate; a QuotaError is returned when reject quotas dropped
+// every log.
+func (q *Quotas) admit(tenant string, logs []Log, now time.Time) ([]Log, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if day := now.UTC().Format(time.DateOnly); day != q.day {
+		q.day = day
+		clear(q.usage)
+	}
+
+	kept := logs[:0:0]
+	var rejectedBy string
+	for _, log := range logs {
+		size := logSize(log)
+		var over *Quota
+		for i := range q.quotas {
+			quota := &q.quotas[i]
+			if !quota.matches(tenant, log) {
+				continue
+			}
+			usage := q.usageOf(quota, tenant, log)
+			if (quota.LogsPerDay > 0 && usage.Logs+1 > quota.LogsPerDay) || (quota.BytesPerDay > 0 && usage.Bytes+size > quota.BytesPerDay) {
+				if !usage.Exceeded {
+					usage.Exceeded = true
+					quotaExceeded.With(quota.Name).Inc()
+					logger.Warn("daily quota exceeded", "quota", quota.Name, "tenant", tenant, "resourceId", usage.ResourceID, "action", quota.Action)
+				}
+				over = quota
+				break
+			}
+		}
+
+		if over != nil && (over.Action == QuotaReject || !sampled(log, over.SampleRate)) {
+			q.usageOf(over, tenant, log).Dropped++
+			q.dirty = true
+			if over.Action == QuotaReject {
+				quotaRejected.With(over.Name).Inc()
+				rejectedBy = over.Name
+			} else {
+				quotaSampledOut.With(over.Name).Inc()
+			}
+			continue
+		}
+		for i := range q.quotas {
+			if quota := &q.quotas[i]; quota.matches(tenant, log) {
+				usage := q.usageOf(quota, tenant, log)
+				usage.Logs++
+				usage.Bytes += size
+			}
+		}
+		q.dirty = true
+		kept = append(kept, log)
+	}
+
+	if len(kept) == 0 && rejectedBy != "" {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return nil, &QuotaError{Quota: rejectedBy, ResetAt: midnight}
+	}
+	return kept, nil
+}
+
+// List returns today's usage by tenant, by quota then resource
+func (q *Quotas) List(tenant string, now time.Time) []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usages := []QuotaUsage{}
+	if q.day != now.UTC().Format(time.DateOnly) {
+		return usages
+	}
+	for _, usage := range q.usage {
+		if usage.Tenant == tenant {
+			usages = append(usages, *usage)
+		}
+	}
+	slices.SortFunc(usages, func(a, b QuotaUsage) int {
+		return cmp.Or(strings.Compare(a.Quota, b.Quota), strings.Compare(a.ResourceID, b.ResourceID))
+	})
+	return usages
+}
+
+// Run writes the usage to quotas.json every interval when it changed, and a
+// last time once ctx is done
+func (q *Quotas) Run(ctx context.Context, interval time.Duration) {
+	if q.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			q.save()
+			return
+		case <-ticker.C:
+			q.save()
+		}
+	}
+}
+
+// save writes the usage when it changed, through a temporary file so a crash
+// leaves the previous one
+func (q *Quotas) save() {
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return
+	}
+	file := quotaFile{Day: q.day, Usage: make([]QuotaUsage, 0, len(q.usage))}
+	for _, usage := range q.usage {
+		file.Usage = append(file.Usage, *usage)
+	}
+	q.dirty = false
+	q.mu.Unlock()
+
+	err := func() error {
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		tmp := q.path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, q.path)
+	}()
+	if err != nil {
+		quotaSaveFailure.Inc()
+		logger.Error("saving quota usage failed", "path", q.path, "error", err)
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+}
+
+// writeQuotaError answers a request whose logs all exceeded a reject quota
+func writeQuotaError(w http.ResponseWriter, err *QuotaError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(err.ResetAt).Seconds()))))
+	writeError(w, http.StatusTooManyRequests, ErrCodeQuotaExceeded, err.Error(), nil)
+}
+
+// QuotaList is the body of GET /admin/quotas
+type QuotaList struct {
+	Day    string       `json:"day"`
+	Quotas []Quota      `json:"quotas"`
+	Usage  []QuotaUsage `json:"usage"`
+}
+
+// handleQuotas lists the quotas applying to the request's tenant and what it and
+// its resources ingested against them today
+func (s *Server) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.quotas == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "No quotas are configured (quotas-file is empty)", nil)
+		return
+	}
+
+	tenant := s.tenant(r.Context()).ID
+	now := time.Now()
+	list := QuotaList{Day: now.UTC().Format(time.DateOnly), Quotas: []Quota{}, Usage: s.quotas.List(tenant, now)}
+	for _, quota := range s.quotas.quotas {
+		if quota.Tenant == "" || quota.Tenant == tenant {
+			list.Quotas = append(list.Quotas, quota)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
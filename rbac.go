@@ -0,0 +1,118 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Role-based access control restricting which logs an API key may query
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// AccessScope limits the logs visible to an API key; empty lists allow everything
+type AccessScope struct {
+	ResourcePrefixes []string `json:"resource_prefixes"`
+	Levels           []string `json:"levels"`
+}
+
+// restricted reports whether the scope limits anything
+func (a AccessScope) restricted() bool {
+	return len(a.ResourcePrefixes) > 0 || len(a.Levels) > 0
+}
+
+// allows reports whether a log is inside the scope
+func (a AccessScope) allows(log Log) bool {
+	if len(a.Levels) > 0 && !slices.Contains(a.Levels, log.Level) {
+		return false
+	}
+	if len(a.ResourcePrefixes) > 0 && !a.allowsResource(log.ResourceID) {
+		return false
+	}
+
+	return true
+}
+
+func (a AccessScope) allowsResource(resourceID string) bool {
+	for _, prefix := range a.ResourcePrefixes {
+		if strings.HasPrefix(resourceID, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply merges the scope into a query: explicit level or resourceId filters
+// outside the scope are rejected, a single allowed level becomes a filter, and
+// the query options enforce the rest on every matching log
+func (a AccessScope) apply(req *QueryRequest) error {
+	if !a.restricted() {
+		return nil
+	}
+
+	levels, resources := req.AnyOf["level"], req.AnyOf["resourceId"]
+	if level, ok := req.Filters["level"]; ok {
+		levels = []string{level}
+	}
+	if resourceID, ok := req.Filters["resourceId"]; ok {
+		resources = []string{resourceID}
+	}
+	for _, level := range levels {
+		if len(a.Levels) > 0 && !slices.Contains(a.Levels, level) {
+			return fmt.Errorf("API key may not query level %q", level)
+		}
+	}
+	for _, resourceID := range resources {
+		if len(a.ResourcePrefixes) > 0 && !a.allowsResource(resourceID) {
+			return fmt.Errorf("API key may not query resourceId %q", resourceID)
+		}
+	}
+
+	if levels == nil && len(a.Levels) == 1 {
+		req.Filters["level"] = a.Levels[0]
+	}
+	req.Options.Scope = a
+
+	return nil
+}
+
+// principalKey is the request context key of the authenticated principal
+type principalKey struct{}
+
+// withPrincipal returns ctx carrying the authenticated principal
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalName returns the name of the API key of ctx, empty without one
+func principalName(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey{}).(*principal); ok && p != nil {
+		return p.Name
+	}
+
+	return ""
+}
+
+// hasScope reports whether the request's principal holds scope; without
+// authentication every request does
+func (s *Server) hasScope(ctx context.Context, scope string) bool {
+	if !s.auth.enabled() {
+		return true
+	}
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	return ok && p != nil && slices.Contains(p.Scopes, scope)
+}
+
+// accessScope returns the access scope of the request's principal; requests
+// without authentication are unrestricted
+func accessScope(ctx context.Context) AccessScope {
+	if p, ok := ctx.Value(principalKey{}).(*principal); ok && p != nil {
+		return p.AccessScope
+	}
+
+	return AccessScope{}
+}
@@ -0,0 +1,171 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Redaction of emails, card numbers and custom patterns from logs before they are stored
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+)
+
+var redactionMatches = metrics.CounterVec("logingestor_redaction_matches_total", "Matches of each redaction pattern in ingested logs and rejected payloads, redacted or, in dry-run mode, only counted", "pattern")
+
+// RedactPattern is an entry of the redact-patterns-file
+type RedactPattern struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`     // regular expression
+	Replacement string `json:"replacement"` // default [REDACTED:<name>]
+}
+
+// redactPattern is a compiled pattern; check, when set, rejects matches that
+// only look like the data, such as digits failing the Luhn check
+type redactPattern struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	check       func(match string) bool
+}
+
+// builtinRedactPatterns are the patterns named by the redact setting
+var builtinRedactPatterns = map[string]redactPattern{
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	"credit_card": {
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		check: luhnValid,
+	},
+}
+
+// Redactor scrubs the message and metadata strings of logs before they are stored.
+// In dry-run mode matches are only counted.
+type Redactor struct {
+	patterns []redactPattern
+	dryRun   bool
+}
+
+// NewRedactor compiles the built-in patterns named by the redact setting and those
+// of the redact-patterns-file; it returns nil when there are none
+func NewRedactor(cfg Config) (*Redactor, error) {
+	r := &Redactor{dryRun: cfg.RedactDryRun}
+	for _, name := range cfg.Redact {
+		pattern, ok := builtinRedactPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("redact: unknown pattern %q, expected email or credit_card", name)
+		}
+		pattern.name = name
+		r.add(pattern)
+	}
+
+	if cfg.RedactPatternsFile != "" {
+		data, err := os.ReadFile(cfg.RedactPatternsFile)
+		if err != nil {
+			return nil, err
+		}
+		var patterns []RedactPattern
+		if err := json.Unmarshal(data, &patterns); err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.RedactPatternsFile, err)
+		}
+		for _, pattern := range patterns {
+			if pattern.Name == "" {
+				return nil, errors.New("redact pattern without a name")
+			}
+			if slices.ContainsFunc(r.patterns, func(p redactPattern) bool { return p.name == pattern.Name }) {
+				return nil, fmt.Errorf("redact pattern %q: duplicate name", pattern.Name)
+			}
+			re, err := regexp.Compile(pattern.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redact pattern %q: %v", pattern.Name, err)
+			}
+			r.add(redactPattern{name: pattern.Name, re: re, replacement: pattern.Replacement})
+		}
+	}
+
+	if len(r.patterns) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func (r *Redactor) add(pattern redactPattern) {
+	if pattern.replacement == "" {
+		pattern.replacement = "[REDACTED:" + pattern.name + "]"
+	}
+	r.patterns = append(r.patterns, pattern)
+}
+
+// Redact scrubs the message of a log and every string in its metadata
+func (r *Redactor) Redact(log *Log) {
+	if r == nil {
+		return
+	}
+	log.Message = r.redact(log.Message)
+	log.Metadata.ParentResourceID = r.redact(log.Metadata.ParentResourceID)
+	for key, value := range log.Metadata.Fields {
+		log.Metadata.Fields[key] = r.redactValue(value)
+	}
+}
+
+// redactValue scrubs the strings of a decoded JSON value, in place for objects
+// and arrays
+func (r *Redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.redact(v)
+	case map[string]any:
+		for key, item := range v {
+			v[key] = r.redactValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = r.redactValue(item)
+		}
+	}
+	return value
+}
+
+// redact replaces the matches of every pattern in s, counting them
+func (r *Redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, pattern := range r.patterns {
+		s = pattern.re.ReplaceAllStringFunc(s, func(match string) string {
+			if pattern.check != nil && !pattern.check(match) {
+				return match
+			}
+			redactionMatches.With(pattern.name).Inc()
+			if r.dryRun {
+				return match
+			}
+			return pattern.replacement
+		})
+	}
+	return s
+}
+
+// luhnValid reports whether the digits of a card number candidate pass the Luhn check
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if double {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
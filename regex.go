@@ -0,0 +1,88 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Regular expression filters with a cache of compiled patterns shared across queries
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// regexSuffix marks a filter key as a regular expression on the field before it,
+// e.g. "message_regex" or "resourceId_regex"
+const regexSuffix = "_regex"
+
+// maxCachedPatterns bounds the number of compiled patterns kept in memory
+const maxCachedPatterns = 1024
+
+// regexCache keeps compiled patterns so repeated queries skip recompilation
+type regexCache struct {
+	patterns map[string]*regexp.Regexp
+	mu       sync.RWMutex
+}
+
+var compiledPatterns = &regexCache{patterns: make(map[string]*regexp.Regexp)}
+
+// compile returns the compiled pattern, compiling and caching it on first use
+func (rc *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	rc.mu.RLock()
+	re, ok := rc.patterns[pattern]
+	rc.mu.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if len(rc.patterns) >= maxCachedPatterns {
+		rc.patterns = make(map[string]*regexp.Regexp)
+	}
+	rc.patterns[pattern] = re
+
+	return re, nil
+}
+
+// regexField returns the field a regex filter key applies to
+func regexField(key string) (string, bool) {
+	if !strings.HasSuffix(key, regexSuffix) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(key, regexSuffix), true
+}
+
+// validateRegexFilters precompiles every regex filter, reporting the first invalid pattern
+func validateRegexFilters(filters map[string]string) error {
+	for key, pattern := range filters {
+		if _, ok := regexField(key); !ok {
+			continue
+		}
+
+		if _, err := compiledPatterns.compile(pattern); err != nil {
+			return fmt.Errorf("Invalid %s: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// matchesRegex reports whether the field value matches the pattern
+func matchesRegex(value, pattern string) bool {
+	re, err := compiledPatterns.compile(pattern)
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(value)
+}
@@ -0,0 +1,462 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Cluster replication: copies of every log on several nodes, failover and catch-up
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Values of the cluster-replication setting
+const (
+	ReplicationSync  = "sync"
+	ReplicationAsync = "async"
+)
+
+const (
+	replicaQueueSize   = 1000 // batches waiting for asynchronous replication, per peer
+	catchUpQueueSize   = 16   // catch-up requests waiting to be served
+	catchUpBatchSize   = 1000 // logs stored at once while catching up
+	clusterLogsPath    = "/cluster/logs"
+	clusterCatchUpPath = "/cluster/catch-up"
+)
+
+var errNodeDown = errors.New("node is down")
+
+var (
+	clusterNodesDown      = metrics.Gauge("logingestor_cluster_nodes_down", "Other cluster nodes currently considered down")
+	clusterReplicaDropped = metrics.CounterVec("logingestor_cluster_replica_dropped_total", "Asynchronous log copies not sent, left to catch-up", "node")
+	clusterCaughtUp       = metrics.Counter("logingestor_cluster_caught_up_logs_total", "Logs copied from other nodes while catching up")
+)
+
+// replicaBatch is a batch of logs waiting to be copied to a peer
+type replicaBatch struct {
+	tenant *Tenant
+	logs   []Log
+}
+
+// markDown records that node failed, unless it already is down
+func (c *Cluster) markDown(node *clusterNode, err error) {
+	if node == c.self {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.down[node]; !ok {
+		c.down[node] = time.Now()
+		clusterNodesDown.Set(int64(len(c.down)))
+		logger.Warn("cluster node down", "node", node.ID, "error", err)
+	}
+}
+
+// isDown reports whether node is considered down
+func (c *Cluster) isDown(node *clusterNode) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.down[node]
+	return ok
+}
+
+// downNodes returns the sorted ids of the nodes considered down
+func (c *Cluster) downNodes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for node := range c.down {
+		ids = append(ids, node.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// serves returns the QueryOptions.Serves of a query treating the down nodes as
+// down: each log is answered for by its first live replica, so it is returned
+// once. Logs this node is no replica of, stored under an earlier cluster-nodes,
+// are always answered for. It is nil without replication.
+func (c *Cluster) serves(down []string) func(Log) bool {
+	if c.replicas == 1 {
+		return nil
+	}
+	return func(log Log) bool {
+		replicas := c.ring.replicas(fieldValue(log, c.shardKey), c.replicas)
+		if !slices.Contains(replicas, c.self) {
+			return true
+		}
+		for _, node := range replicas {
+			if node == c.self || !slices.Contains(down, node.ID) {
+				return node == c.self
+			}
+		}
+		return true
+	}
+}
+
+// uncovered returns a node whose keys have no live replica left when the down
+// nodes are down, nil if every key still has one
+func (c *Cluster) uncovered(down []string) *clusterNode {
+	if len(down) == 0 {
+		return nil
+	}
+	for i := range c.ring.points {
+		replicas := c.ring.replicasAt(i, c.replicas)
+		if !slices.ContainsFunc(replicas, func(node *clusterNode) bool { return !slices.Contains(down, node.ID) }) {
+			return replicas[0]
+		}
+	}
+	return nil
+}
+
+// fanOut runs a query attempt over the nodes, passing it the nodes to skip as
+// down. With replicas, a node failing during the attempt is marked down and the
+// attempt is run once more without it.
+func (c *Cluster) fanOut(attempt func(down []string) error) error {
+	for retried := false; ; retried = true {
+		var down []string
+		if c.replicas > 1 {
+			down = c.downNodes()
+		}
+		if node := c.uncovered(down); node != nil {
+			return &nodeError{node: node, err: errNodeDown}
+		}
+
+		err := attempt(down)
+		var nerr *nodeError
+		if retried || c.replicas == 1 || !errors.As(err, &nerr) || !c.isDown(nerr.node) {
+			return err
+		}
+	}
+}
+
+// replicateLater queues logs to be copied to node in the background. When the
+// queue is full they are dropped and node is marked down, so it catches up once
+// it is seen up again.
+func (c *Cluster) replicateLater(node *clusterNode, tenant *Tenant, logs []Log) {
+	select {
+	case c.pending[node] <- replicaBatch{tenant: tenant, logs: logs}:
+	default:
+		clusterReplicaDropped.With(node.ID).Add(int64(len(logs)))
+		c.markDown(node, errors.New("replication queue is full"))
+	}
+}
+
+// Run sends the asynchronous copies, catches up with the other nodes at start
+// and when asked to, and probes the other nodes every probe interval until ctx is
+// cancelled. catchUp copies the logs missed since a time; a zero time is since
+// shortly before the newest stored log.
+func (c *Cluster) Run(ctx context.Context, catchUp func(ctx context.Context, since time.Time)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for node, queue := range c.pending {
+		wg.Go(func() { c.replicate(ctx, node, queue) })
+	}
+
+	if c.replicas > 1 {
+		catchUp(ctx, time.Time{})
+	}
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.probe(ctx)
+		case since := <-c.catchUps:
+			catchUp(ctx, since)
+		}
+	}
+}
+
+// replicate sends the batches queued for node until ctx is cancelled; a failed
+// batch marks node down, which makes it catch up once it is up again
+func (c *Cluster) replicate(ctx context.Context, node *clusterNode, queue chan replicaBatch) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case batch := <-queue:
+			if err := c.sendLogs(ctx, node, batch.tenant, batch.logs); err != nil && ctx.Err() == nil {
+				clusterReplicaDropped.With(node.ID).Add(int64(len(batch.logs)))
+				c.markDown(node, err)
+			}
+		}
+	}
+}
+
+// probe checks whether every other node is up. A node seen up again after being
+// down is asked to catch up with the logs it missed while it was.
+func (c *Cluster) probe(ctx context.Context) {
+	c.eachNodeExcept([]string{c.self.ID}, func(_ int, node *clusterNode) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.URL+"/healthz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			c.markDown(node, err)
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.markDown(node, errors.New("health check answered "+resp.Status))
+			return nil
+		}
+
+		c.mu.Lock()
+		since, wasDown := c.down[node]
+		delete(c.down, node)
+		clusterNodesDown.Set(int64(len(c.down)))
+		c.mu.Unlock()
+		if !wasDown || c.replicas == 1 {
+			return nil
+		}
+		logger.Info("cluster node up", "node", node.ID, "down_since", since)
+		if err := c.requestCatchUp(ctx, node, since.Add(-c.overlap)); err != nil && ctx.Err() == nil {
+			// Keep it down since the original time, so the request is made again
+			c.mu.Lock()
+			if _, ok := c.down[node]; !ok {
+				c.down[node] = since
+			}
+			clusterNodesDown.Set(int64(len(c.down)))
+			c.mu.Unlock()
+			logger.Warn("cluster catch-up request failed", "node", node.ID, "error", err)
+		}
+		return nil
+	})
+}
+
+// authorize adds the headers of a request this node makes on its own behalf
+func (c *Cluster) authorize(req *http.Request) {
+	req.Header.Set(clusterForwardedHeader, c.self.ID)
+	req.Header.Set(clusterKeyHeader, c.apiKey)
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+}
+
+// requestCatchUp asks node to copy the logs it misses since a time from its peers
+func (c *Cluster) requestCatchUp(ctx context.Context, node *clusterNode, since time.Time) error {
+	query := url.Values{"since": {since.UTC().Format(time.RFC3339)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL+clusterCatchUpPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return peerError(node, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return peerError(node, errors.New("catch-up answered "+resp.Status))
+	}
+	return nil
+}
+
+// catchUp copies the logs this node replicates and misses from every peer, for
+// every tenant; a zero since starts cluster-catch-up-overlap before the newest
+// log the tenant holds, or at the oldest log when it holds none
+func (s *Server) catchUp(ctx context.Context, since time.Time) {
+	for _, tenant := range s.tenants.All() {
+		from := since
+		if from.IsZero() {
+			newest, _, err := tenant.storage.Query(ctx, nil, QueryOptions{Limit: 1, Sort: []SortKey{{Field: "timestamp", Desc: true}}})
+			if err != nil {
+				logger.Error("cluster catch-up failed", "tenant", tenant.ID, "error", err)
+				continue
+			}
+			if len(newest) > 0 {
+				from = newest[0].Timestamp.Add(-s.cluster.overlap)
+			}
+		}
+
+		start := time.Now()
+		n, err := s.catchUpTenant(ctx, tenant, from)
+		if err != nil {
+			logger.Warn("cluster catch-up incomplete", "tenant", tenant.ID, "logs", n, "error", err)
+			continue
+		}
+		logger.Info("cluster caught up", "tenant", tenant.ID, "since", from, "logs", n, "duration", time.Since(start))
+	}
+}
+
+// catchUpTenant stores the logs of a tenant from since on that its peers hold,
+// this node replicates and it does not hold yet, returning how many it stored
+func (s *Server) catchUpTenant(ctx context.Context, tenant *Tenant, since time.Time) (int, error) {
+	filters := map[string]string{}
+	if !since.IsZero() {
+		filters["timestamp_from"] = since.UTC().Format(time.RFC3339)
+	}
+	seen := make(map[string]bool)
+	results, err := tenant.storage.Select(ctx, filters, QueryOptions{})
+	if err != nil {
+		return 0, err
+	}
+	results.Each(func(log Log) bool {
+		seen[logKey(log)] = true
+		return true
+	})
+
+	stored := 0
+	var errs []error
+	for _, node := range s.cluster.nodes {
+		if node == s.cluster.self {
+			continue
+		}
+		n, err := s.cluster.copyFrom(ctx, node, tenant, since, seen)
+		stored += n
+		errs = append(errs, err)
+	}
+	return stored, errors.Join(errs...)
+}
+
+// copyFrom stores the logs node holds from since on that this node replicates
+// and has not seen, adding them to seen
+func (c *Cluster) copyFrom(ctx context.Context, node *clusterNode, tenant *Tenant, since time.Time, seen map[string]bool) (int, error) {
+	query := url.Values{"node": {c.self.ID}}
+	if !since.IsZero() {
+		query.Set("since", since.UTC().Format(time.RFC3339))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.URL+clusterLogsPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	c.authorize(req)
+	req.Header.Set(tenantHeader, tenant.ID)
+
+	// The copy may take longer than cluster-timeout, so only ctx bounds it
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, peerError(node, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, peerError(node, errors.New("catch-up answered "+resp.Status))
+	}
+
+	stored := 0
+	var batch []Log
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := tenant.storage.IngestBatch(batch); err != nil {
+			return err
+		}
+		stored += len(batch)
+		clusterCaughtUp.Add(int64(len(batch)))
+		batch = batch[:0]
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var log Log
+		if err := decoder.Decode(&log); err == io.EOF {
+			break
+		} else if err != nil {
+			ferr := flush()
+			return stored, errors.Join(peerError(node, err), ferr)
+		}
+		if key := logKey(log); !seen[key] {
+			seen[key] = true
+			if batch = append(batch, log); len(batch) == catchUpBatchSize {
+				if err := flush(); err != nil {
+					return stored, err
+				}
+			}
+		}
+	}
+	return stored, flush()
+}
+
+// handleClusterLogs streams as NDJSON the logs of the request's tenant that the
+// node named by the "node" parameter replicates, from "since" on when given.
+// Only other nodes and admin keys may call it.
+func (s *Server) handleClusterLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.cluster == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Cluster mode is off", nil)
+		return
+	}
+	if !isForwarded(r.Context()) && !s.hasScope(r.Context(), ScopeAdmin) {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "Only cluster nodes and keys with the admin scope may read the replicated logs", nil)
+		return
+	}
+	node := s.cluster.node(r.URL.Query().Get("node"))
+	if node == nil {
+		writeValidationError(w, errors.New("Invalid node: expected the id of a cluster node"))
+		return
+	}
+	filters := map[string]string{}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if _, err := time.Parse(time.RFC3339, since); err != nil {
+			writeValidationError(w, errors.New("Invalid since: expected RFC3339 time"))
+			return
+		}
+		filters["timestamp_from"] = since
+	}
+
+	shardKey, n := s.cluster.shardKey, s.cluster.replicas
+	replicated := func(log Log) bool {
+		return slices.Contains(s.cluster.ring.replicas(fieldValue(log, shardKey), n), node)
+	}
+	results, err := s.tenant(r.Context()).storage.Select(r.Context(), filters, QueryOptions{Serves: replicated, Scope: accessScope(r.Context())})
+	if err != nil {
+		writeQueryError(w, err, PartialResponse{})
+		return
+	}
+	auditResults(r.Context(), results.Len())
+	logAttrs(r.Context(), slog.String("node", node.ID), slog.Int("logs", results.Len()))
+	streamNDJSON(w, results, "", Projection(nil).apply)
+}
+
+// handleClusterCatchUp queues a catch-up of the logs this node missed since the
+// "since" parameter, which a peer asks for once it sees this node up again.
+// Only other nodes and admin keys may call it, and since is moved up to
+// cluster-catch-up-overlap before this node started: the catch-up it runs on
+// start covers the logs before.
+func (s *Server) handleClusterCatchUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if s.cluster == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Cluster mode is off", nil)
+		return
+	}
+	if !isForwarded(r.Context()) && !s.hasScope(r.Context(), ScopeAdmin) {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "Only cluster nodes and keys with the admin scope may request a catch-up", nil)
+		return
+	}
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		writeValidationError(w, errors.New("Invalid since: expected RFC3339 time"))
+		return
+	}
+	if earliest := s.started.Add(-s.cluster.overlap); since.Before(earliest) {
+		since = earliest
+	}
+
+	select {
+	case s.cluster.catchUps <- since:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "Too many catch-ups waiting, retry later", nil)
+	}
+}
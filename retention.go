@@ -0,0 +1,246 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Retention policy that periodically evicts the oldest logs from memory and storage
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionPolicy bounds how much log data is kept; a zero field is unlimited
+type RetentionPolicy struct {
+	MaxAge     time.Duration
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// enabled reports whether the policy limits anything
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxEntries > 0 || p.MaxBytes > 0
+}
+
+// Expirer is implemented by a Storage that can discard old logs
+type Expirer interface {
+	// ExpireBefore discards stored data holding only logs older than cutoff
+	ExpireBefore(cutoff time.Time) error
+}
+
+var (
+	evictedLogs  = metrics.Counter("logingestor_retention_evicted_logs_total", "Logs evicted by the retention policy")
+	evictedBytes = metrics.Counter("logingestor_retention_evicted_bytes_total", "Approximate bytes of logs evicted by the retention policy")
+	retentionRun = metrics.Counter("logingestor_retention_runs_total", "Retention passes executed")
+)
+
+// logSize approximates the memory held by a log entry
+func logSize(log Log) int64 {
+	return int64(len(log.Level) + len(log.Message) + len(log.ResourceID) + len(log.TraceID) +
+		len(log.SpanID) + len(log.Commit) + log.Metadata.size() + customSize(log.Custom) + 64)
+}
+
+// Expire evicts the oldest logs, by timestamp, until the policy holds and returns
+// the number evicted. Shards that only hold evicted logs are dropped whole; the
+// shard holding the oldest survivors is rebuilt without the evicted entries.
+// Storage then drops whatever only holds evicted logs. Cold tier windows, being
+// the oldest, go first and only whole, so while one is kept the limits may be
+// exceeded by less than a window. With rollups, the evicted logs are summarized
+// first.
+func (ls *LogStorage) Expire(policy RetentionPolicy, now time.Time) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.rollups != nil {
+		if err := ls.rollups.expire(now); err != nil {
+			return 0, err
+		}
+	}
+
+	var cutoff time.Time
+	if policy.MaxAge > 0 {
+		cutoff = now.Add(-policy.MaxAge)
+	}
+
+	remaining, bytes := 0, int64(0)
+	for _, sh := range ls.shards {
+		sh.mu.RLock()
+		remaining, bytes = remaining+len(sh.logs), bytes+sh.bytes
+		sh.mu.RUnlock()
+	}
+	if ls.cold != nil {
+		for _, seg := range ls.cold.segments {
+			remaining, bytes = remaining+seg.footer.Count, bytes+seg.footer.Bytes
+		}
+	}
+	overLimit := func() bool {
+		return (policy.MaxEntries > 0 && remaining > policy.MaxEntries) || (policy.MaxBytes > 0 && bytes > policy.MaxBytes)
+	}
+
+	evict, dropped := 0, 0
+	var newestEvicted time.Time
+	coldKept := false
+	if ls.cold != nil {
+		for _, seg := range ls.cold.segments {
+			count, size := seg.footer.Count, seg.footer.Bytes
+			if seg.footer.End.After(cutoff) && (policy.MaxEntries == 0 || remaining-count < policy.MaxEntries) &&
+				(policy.MaxBytes == 0 || bytes-size < policy.MaxBytes) {
+				coldKept = true
+				break
+			}
+			if ls.rollups != nil {
+				logs, err := seg.read(nil)
+				if err != nil {
+					return evict, err
+				}
+				ls.rollups.add(ls.deleted.drop(logs)...)
+			}
+			if err := seg.retire(); err != nil {
+				return evict, err
+			}
+			evict, remaining, bytes = evict+count, remaining-count, bytes-size
+			evictedBytes.Add(size)
+			newestEvicted = seg.footer.End.Add(-time.Nanosecond)
+			dropped++
+		}
+		ls.cold.segments = ls.cold.segments[dropped:]
+		ls.cold.updateGauges()
+		dropped = 0
+	}
+
+	// Windows go oldest first, with all their stripes
+	for dropped < len(ls.shards) && !coldKept { // older logs survive in the cold tier
+		stripes := ls.windowShards(ls.shards[dropped].start)
+		count, size := 0, int64(0)
+		for _, sh := range stripes {
+			sh.mu.Lock()
+			count, size = count+len(sh.logs), size+sh.bytes
+		}
+
+		// Whole window older than the cutoff, or still over the limits without it
+		if !stripes[0].end.After(cutoff) || (policy.MaxEntries > 0 && remaining-count >= policy.MaxEntries) ||
+			(policy.MaxBytes > 0 && bytes-size >= policy.MaxBytes) {
+			evict, remaining, bytes = evict+count, remaining-count, bytes-size
+			evictedBytes.Add(size)
+			for _, sh := range stripes {
+				if n := len(sh.byTime); n > 0 && sh.logs[sh.byTime[n-1]].Timestamp.After(newestEvicted) {
+					newestEvicted = sh.logs[sh.byTime[n-1]].Timestamp
+				}
+				if ls.rollups != nil {
+					ls.rollups.add(sh.logs...)
+				}
+				sh.mu.Unlock()
+			}
+			dropped += len(stripes)
+			continue
+		}
+
+		// The oldest logs of the window go first, whatever their stripe
+		evicted := make([]int, len(stripes))
+		for {
+			next := -1
+			for i, sh := range stripes {
+				if evicted[i] < len(sh.byTime) && (next < 0 ||
+					sh.logs[sh.byTime[evicted[i]]].Timestamp.Before(stripes[next].logs[stripes[next].byTime[evicted[next]]].Timestamp)) {
+					next = i
+				}
+			}
+			if next < 0 {
+				break
+			}
+			log := stripes[next].logs[stripes[next].byTime[evicted[next]]]
+			if !log.Timestamp.Before(cutoff) && !overLimit() {
+				break
+			}
+			if ls.rollups != nil {
+				ls.rollups.add(log)
+			}
+			evicted[next]++
+			remaining--
+			bytes -= logSize(log)
+			newestEvicted = log.Timestamp
+		}
+		empty := true
+		for i, sh := range stripes {
+			if n := evicted[i]; n > 0 {
+				gone := make(map[int]bool, n)
+				for _, pos := range sh.byTime[:n] {
+					gone[pos] = true
+				}
+				kept := make([]Log, 0, len(sh.logs)-n)
+				for pos, log := range sh.logs {
+					if !gone[pos] {
+						kept = append(kept, log)
+					}
+				}
+				before := sh.bytes
+				sh.rebuildLocked(kept)
+				evictedBytes.Add(before - sh.bytes)
+				evict += n
+			}
+			empty = empty && len(sh.logs) == 0
+			sh.mu.Unlock()
+		}
+		if !empty {
+			break
+		}
+		dropped += len(stripes)
+	}
+	ls.shards = ls.shards[dropped:]
+	if evict == 0 {
+		return 0, nil
+	}
+	evictedLogs.Add(int64(evict))
+	ls.expireCache(newestEvicted)
+	// Stored logs are only dropped once their summaries are safe
+	if ls.rollups != nil {
+		if err := ls.rollups.flush(); err != nil {
+			return evict, err
+		}
+	}
+
+	// Everything left is at least as new as the oldest survivor, so storage
+	// holding only older timestamps is fully evicted
+	storageCutoff := newestEvicted.Add(time.Nanosecond)
+	if len(ls.shards) > 0 {
+		var oldest time.Time
+		for _, sh := range ls.windowShards(ls.shards[0].start) {
+			sh.mu.RLock()
+			if len(sh.byTime) > 0 && (oldest.IsZero() || sh.logs[sh.byTime[0]].Timestamp.Before(oldest)) {
+				oldest = sh.logs[sh.byTime[0]].Timestamp
+			}
+			sh.mu.RUnlock()
+		}
+		if !oldest.IsZero() {
+			storageCutoff = oldest
+		}
+	}
+
+	if expirer, ok := ls.store.(Expirer); ok {
+		if err := expirer.ExpireBefore(storageCutoff); err != nil {
+			return evict, err
+		}
+	}
+
+	return evict, nil
+}
+
+// RunRetention applies the policy every interval until ctx is cancelled
+func (ls *LogStorage) RunRetention(ctx context.Context, policy RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			retentionRun.Inc()
+			if n, err := ls.Expire(policy, now); err != nil {
+				logger.Error("retention failed", "error", err)
+			} else if n > 0 {
+				logger.Info("retention evicted logs", "logs", n)
+			}
+		}
+	}
+}
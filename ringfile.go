@@ -0,0 +1,118 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Bounded NDJSON file behind the stores keeping their newest entries (audit, dead letters)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// ringFile is the NDJSON file of a store holding its newest entries in memory,
+// up to max. Lines are only appended, and the file is rewritten with the entries
+// held once it has grown to twice that many. A nil ringFile writes nothing.
+type ringFile[T any] struct {
+	path  string
+	name  string // in log messages, e.g. "audit"
+	max   int
+	file  *os.File
+	lines int
+}
+
+// openRingFile passes the entries in path to load, oldest first, skipping lines
+// that do not decode; the store then calls rewrite with the entries it keeps
+func openRingFile[T any](path, name string, maxEntries int, load func(T)) (*ringFile[T], error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, maxWALRecordSize)
+	for scanner.Scan() {
+		var entry T
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // a torn last line after a crash
+		}
+		load(entry)
+	}
+
+	return &ringFile[T]{path: path, name: name, max: maxEntries}, nil
+}
+
+// write appends a line, or rewrites the file with entries once it is full.
+// Errors are logged, so that a full disk does not fail the requests recorded.
+func (f *ringFile[T]) write(entry T, entries []T) {
+	if f == nil || f.file == nil {
+		return
+	}
+
+	if f.lines >= 2*f.max {
+		if err := f.rewrite(entries); err != nil {
+			logger.Error("rewriting the "+f.name+" file failed", "error", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = f.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		logger.Error("writing the "+f.name+" file failed", "error", err)
+		return
+	}
+	f.lines++
+}
+
+// rewrite replaces the file with entries, through a temporary file
+func (f *ringFile[T]) rewrite(entries []T) error {
+	if f == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.file = file
+	f.lines = len(entries)
+
+	return nil
+}
+
+// close closes the file
+func (f *ringFile[T]) close() error {
+	if f == nil || f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
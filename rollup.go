@@ -0,0 +1,512 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Rollups replacing logs older than rollup-after with per-minute summaries (GET /rollups)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rollupInterval is the time span of a summary
+const rollupInterval = time.Minute
+
+// rollupDayFormat names the file of each day of summaries
+const rollupDayFormat = "2006-01-02"
+
+// clusterRollupsPath is where cluster nodes ask each other for their summaries
+const clusterRollupsPath = "/cluster/rollups"
+
+var (
+	rolledUpLogs  = metrics.Counter("logingestor_rollup_logs_total", "Logs replaced by per-minute summaries")
+	rollupEntries = metrics.Gauge("logingestor_rollup_summaries", "Per-minute summaries held by the rollups")
+)
+
+// Summary is what a rollup keeps of the logs of one resource at one level
+// during the minute starting at Time: their number and a random sample of them
+type Summary struct {
+	Time       time.Time `json:"time"`
+	ResourceID string    `json:"resourceId"`
+	Level      string    `json:"level"`
+	Count      int       `json:"count"`
+	Exemplars  []Log     `json:"exemplars,omitempty"`
+}
+
+// rollupKey identifies the summaries of a minute
+type rollupKey struct {
+	resource, level string
+}
+
+// rollupMinutes holds summaries by minute start, in Unix nanoseconds, and key
+type rollupMinutes map[int64]map[rollupKey]*Summary
+
+// rollups are the summaries of a tenant's logs evicted for being older than
+// rollup-after, kept in memory and, with a data-dir, in one NDJSON file per day
+// under rollups/ that is only appended to. A file may hold several lines for a
+// minute and key, from logs rolled up by different retention passes, which add up.
+type rollups struct {
+	dir       string // empty keeps them in memory only
+	exemplars int
+	maxAge    time.Duration // summaries older are dropped; 0 keeps them
+
+	mu      sync.Mutex
+	serves  func(Log) bool // in cluster mode, the logs this node summarizes
+	minutes rollupMinutes
+	pending rollupMinutes // summaries of the current retention pass, not written yet
+	entries int           // summaries in minutes, as counted by the gauge
+}
+
+// openRollups loads the summaries saved in dir; an empty dir keeps them in memory
+func openRollups(dir string, exemplars int, maxAge time.Duration) (*rollups, error) {
+	r := &rollups{exemplars: exemplars, maxAge: maxAge, minutes: make(rollupMinutes), pending: make(rollupMinutes)}
+	if dir == "" {
+		return r, nil
+	}
+
+	r.dir = filepath.Join(dir, "rollups")
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, maxWALRecordSize)
+		for scanner.Scan() {
+			var summary Summary
+			if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+				continue // a torn last line after a crash
+			}
+			r.merge(r.minutes, &summary)
+		}
+	}
+	r.updateGauge()
+	return r, nil
+}
+
+// merge adds a summary to those of its minute and key; r.mu is held unless the
+// rollups are being opened
+func (r *rollups) merge(minutes rollupMinutes, summary *Summary) {
+	start := summary.Time.UnixNano()
+	if minutes[start] == nil {
+		minutes[start] = make(map[rollupKey]*Summary)
+	}
+	key := rollupKey{summary.ResourceID, summary.Level}
+	existing := minutes[start][key]
+	if existing == nil {
+		minutes[start][key] = summary
+		return
+	}
+	existing.Count += summary.Count
+	room := max(r.exemplars-len(existing.Exemplars), 0)
+	existing.Exemplars = append(existing.Exemplars, summary.Exemplars[:min(room, len(summary.Exemplars))]...)
+}
+
+// setServes makes the rollups only summarize the logs serves is true for
+func (r *rollups) setServes(serves func(Log) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.serves = serves
+}
+
+// add summarizes evicted logs into the pending summaries, keeping a uniform
+// sample of each minute's logs as its exemplars
+func (r *rollups) add(logs ...Log) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, log := range logs {
+		if r.serves != nil && !r.serves(log) {
+			continue
+		}
+		start := log.Timestamp.Truncate(rollupInterval)
+		if r.pending[start.UnixNano()] == nil {
+			r.pending[start.UnixNano()] = make(map[rollupKey]*Summary)
+		}
+		key := rollupKey{log.ResourceID, log.Level}
+		summary := r.pending[start.UnixNano()][key]
+		if summary == nil {
+			summary = &Summary{Time: start.UTC(), ResourceID: log.ResourceID, Level: log.Level}
+			r.pending[start.UnixNano()][key] = summary
+		}
+		summary.Count++
+		switch n := summary.Count; {
+		case len(summary.Exemplars) < r.exemplars:
+			summary.Exemplars = append(summary.Exemplars, log)
+		case r.exemplars > 0:
+			if i := rand.IntN(n); i < r.exemplars {
+				summary.Exemplars[i] = log
+			}
+		}
+	}
+}
+
+// flush writes the pending summaries to the file of their day, durably, then
+// makes them visible. Summaries failing to be written stay pending for the
+// next pass.
+func (r *rollups) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	if r.dir != "" {
+		days := make(map[string]*bytes.Buffer)
+		for _, summaries := range r.pending {
+			for _, summary := range summaries {
+				day := summary.Time.Format(rollupDayFormat)
+				if days[day] == nil {
+					days[day] = new(bytes.Buffer)
+				}
+				line, err := json.Marshal(summary)
+				if err != nil {
+					return err
+				}
+				days[day].Write(append(line, '\n'))
+			}
+		}
+		for day, buf := range days {
+			if err := appendFileSync(filepath.Join(r.dir, day+".ndjson"), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	n := 0
+	for _, summaries := range r.pending {
+		for _, summary := range summaries {
+			n += summary.Count
+			r.merge(r.minutes, summary)
+		}
+	}
+	r.pending = make(rollupMinutes)
+	rolledUpLogs.Add(int64(n))
+	r.updateGauge()
+	return nil
+}
+
+// appendFileSync appends data to the file at path, creating it if needed, and
+// syncs it to disk
+func appendFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// expire drops the summaries older than the max age at now, and the files of
+// the days holding nothing newer
+func (r *rollups) expire(now time.Time) error {
+	if r.maxAge == 0 {
+		return nil
+	}
+	cutoff := now.Add(-r.maxAge)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for start := range r.minutes {
+		if time.Unix(0, start).Before(cutoff.Truncate(rollupInterval)) {
+			delete(r.minutes, start)
+		}
+	}
+	r.updateGauge()
+	if r.dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.ndjson"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		day, err := time.Parse(rollupDayFormat, strings.TrimSuffix(filepath.Base(path), ".ndjson"))
+		if err == nil && !day.AddDate(0, 0, 1).After(cutoff) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// purge drops the exemplars between from and to (zero for unbounded) holding
+// id, which is lower case, and the summaries of resources whose id holds it,
+// then rewrites the files of the days they were in. It returns how many
+// summaries and exemplars were dropped.
+func (r *rollups) purge(id string, from, to time.Time) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summaries, exemplars := 0, 0
+	days := make(map[string]bool)
+	for _, minutes := range []rollupMinutes{r.minutes, r.pending} {
+		for start, byKey := range minutes {
+			t := time.Unix(0, start).UTC()
+			if (!from.IsZero() && t.Before(from.Truncate(rollupInterval))) || (!to.IsZero() && t.After(to)) {
+				continue
+			}
+			for key, summary := range byKey {
+				if strings.Contains(strings.ToLower(summary.ResourceID), id) {
+					delete(byKey, key)
+					summaries++
+					days[t.Format(rollupDayFormat)] = true
+					continue
+				}
+				n := len(summary.Exemplars)
+				summary.Exemplars = slices.DeleteFunc(summary.Exemplars, func(log Log) bool {
+					return (from.IsZero() || !log.Timestamp.Before(from)) && (to.IsZero() || !log.Timestamp.After(to)) && containsIdentifier(log, id)
+				})
+				if len(summary.Exemplars) < n {
+					exemplars += n - len(summary.Exemplars)
+					days[t.Format(rollupDayFormat)] = true
+				}
+			}
+			if len(byKey) == 0 {
+				delete(minutes, start)
+			}
+		}
+	}
+	r.updateGauge()
+	if r.dir == "" {
+		return summaries, exemplars, nil
+	}
+
+	for day := range days {
+		var buf bytes.Buffer
+		for _, byKey := range r.minutes {
+			for _, summary := range byKey {
+				if summary.Time.Format(rollupDayFormat) != day {
+					continue
+				}
+				line, err := json.Marshal(summary)
+				if err != nil {
+					return summaries, exemplars, err
+				}
+				buf.Write(append(line, '\n'))
+			}
+		}
+		path := filepath.Join(r.dir, day+".ndjson")
+		if err := os.WriteFile(path+".tmp", buf.Bytes(), 0644); err != nil {
+			return summaries, exemplars, err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return summaries, exemplars, err
+		}
+	}
+	return summaries, exemplars, nil
+}
+
+// updateGauge adds the change in the number of summaries to the gauge of every
+// tenant's; r.mu is held
+func (r *rollups) updateGauge() {
+	n := 0
+	for _, summaries := range r.minutes {
+		n += len(summaries)
+	}
+	rollupEntries.Add(int64(n - r.entries))
+	r.entries = n
+}
+
+// RollupFilter selects summaries; zero fields select everything
+type RollupFilter struct {
+	From, To   time.Time
+	ResourceID string
+	Level      string
+	Scope      AccessScope
+}
+
+func (f RollupFilter) matches(summary *Summary) bool {
+	return (f.From.IsZero() || !summary.Time.Before(f.From.Truncate(rollupInterval))) &&
+		(f.To.IsZero() || !summary.Time.After(f.To)) &&
+		(f.ResourceID == "" || summary.ResourceID == f.ResourceID) && (f.Level == "" || summary.Level == f.Level) &&
+		(len(f.Scope.Levels) == 0 || slices.Contains(f.Scope.Levels, summary.Level)) &&
+		(len(f.Scope.ResourcePrefixes) == 0 || f.Scope.allowsResource(summary.ResourceID))
+}
+
+// compareSummaries orders summaries by time, then resource and level
+func compareSummaries(a, b Summary) int {
+	return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(a.ResourceID, b.ResourceID), cmp.Compare(a.Level, b.Level))
+}
+
+// List returns the summaries the filter selects, oldest first and then by
+// resource and level, from offset up to limit, reporting whether more follow
+func (r *rollups) List(filter RollupFilter, offset, limit int) ([]Summary, bool) {
+	r.mu.Lock()
+	var matches []Summary
+	for _, summaries := range r.minutes {
+		for _, summary := range summaries {
+			if filter.matches(summary) {
+				s := *summary
+				s.Exemplars = slices.Clone(s.Exemplars)
+				matches = append(matches, s)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(matches, compareSummaries)
+	start := min(offset, len(matches))
+	end := min(start+limit, len(matches))
+	return matches[start:end], end < len(matches)
+}
+
+// RollupList is the body of GET /rollups
+type RollupList struct {
+	Summaries []Summary `json:"summaries"`
+	More      bool      `json:"more"`
+}
+
+// handleRollups lists the summaries of the request's tenant between the from and
+// to URL parameters, narrowed by resourceId and level, from offset up to limit
+// (default 100); exemplars=false leaves out the sample logs
+func (s *Server) handleRollups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !(r.Method == http.MethodPost && isForwarded(r.Context())) {
+		writeMethodNotAllowed(w)
+		return
+	}
+	tenant := s.tenant(r.Context())
+	if tenant.storage.rollups == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Rollups are disabled (rollup-after is 0 or not below the retention)", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := RollupFilter{ResourceID: query.Get("resourceId"), Level: query.Get("level"), Scope: accessScope(r.Context())}
+	var err error
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				writeValidationError(w, fmt.Errorf("%s must be an RFC 3339 timestamp", name))
+				return
+			}
+		}
+	}
+	limit, offset := defaultValuesLimit, 0
+	if v := query.Get("limit"); v != "" {
+		// Peers are asked for every summary up to the page the client wants
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || (limit > s.cfg.MaxPageSize && !isForwarded(r.Context())) {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeValidationError(w, errors.New("offset must be a non-negative integer"))
+			return
+		}
+	}
+	exemplars := true
+	if v := query.Get("exemplars"); v != "" {
+		if exemplars, err = strconv.ParseBool(v); err != nil {
+			writeValidationError(w, errors.New("exemplars must be true or false"))
+			return
+		}
+	}
+
+	var list RollupList
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		ctx, cancel := s.queryContext(r.Context())
+		defer cancel()
+		if list, err = s.clusterRollups(ctx, r, tenant, filter, offset, limit); err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+	} else {
+		list.Summaries, list.More = tenant.storage.rollups.List(filter, offset, limit)
+	}
+	if !exemplars {
+		for i := range list.Summaries {
+			list.Summaries[i].Exemplars = nil
+		}
+	}
+	if list.Summaries == nil {
+		list.Summaries = []Summary{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// clusterRollups merges the summaries of every node. As every node orders them
+// alike, the first offset+limit of each hold every summary of the page.
+func (s *Server) clusterRollups(ctx context.Context, r *http.Request, tenant *Tenant, filter RollupFilter, offset, limit int) (RollupList, error) {
+	query := r.URL.Query()
+	query.Set("offset", "0")
+	query.Set("limit", strconv.Itoa(offset+limit+1))
+	path := clusterRollupsPath + "?" + query.Encode()
+
+	lists := make([]RollupList, len(s.cluster.nodes))
+	err := s.cluster.eachNode(func(i int, node *clusterNode) error {
+		if node == s.cluster.self {
+			lists[i].Summaries, lists[i].More = tenant.storage.rollups.List(filter, 0, offset+limit+1)
+			return nil
+		}
+
+		resp, err := s.cluster.peerQuery(ctx, node, path, r, nil, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&lists[i]); err != nil {
+			return peerError(node, err)
+		}
+		return nil
+	})
+
+	// Merged summaries past the last of the shortest list that was cut may miss
+	// a node's part
+	merger := &rollups{exemplars: tenant.storage.rollups.exemplars}
+	minutes := make(rollupMinutes)
+	var bound *Summary
+	for _, list := range lists {
+		for _, summary := range list.Summaries {
+			merger.merge(minutes, &summary)
+		}
+		if list.More && len(list.Summaries) > 0 {
+			if last := list.Summaries[len(list.Summaries)-1]; bound == nil || compareSummaries(last, *bound) < 0 {
+				bound = &last
+			}
+		}
+	}
+	var merged []Summary
+	for _, summaries := range minutes {
+		for _, summary := range summaries {
+			if bound == nil || compareSummaries(*summary, *bound) <= 0 {
+				merged = append(merged, *summary)
+			}
+		}
+	}
+	slices.SortFunc(merged, compareSummaries)
+
+	start := min(offset, len(merged))
+	end := min(start+limit, len(merged))
+	return RollupList{Summaries: merged[start:end], More: end < len(merged) || bound != nil}, err
+}
@@ -0,0 +1,144 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Minimal S3 client uploading objects with AWS Signature Version 4
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// s3Client uploads objects to a bucket of S3 or an S3-compatible store, using
+// path-style URLs so custom endpoints such as MinIO work too
+type s3Client struct {
+	endpoint     string // scheme://host[:port]
+	bucket       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Client creates a client for bucket; empty credentials are taken from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables, and an
+// empty endpoint is the AWS endpoint of the region
+func newS3Client(endpoint, bucket, region, accessKey, secretKey string, timeout time.Duration) (*s3Client, error) {
+	if bucket == "" || region == "" {
+		return nil, fmt.Errorf("bucket and region are required")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	c := &s3Client{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: timeout},
+	}
+	if c.accessKey == "" {
+		c.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		c.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		c.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, fmt.Errorf("no S3 credentials: set access_key_id and secret_access_key or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	return c, nil
+}
+
+// PutObject uploads body as the object key
+func (c *s3Client) PutObject(key, contentType string, body []byte) error {
+	path := "/" + c.bucket + "/" + s3EscapePath(key)
+	req, err := http.NewRequest(http.MethodPut, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req, path, body, time.Now().UTC())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &httpStatusError{status: resp.StatusCode, message: fmt.Sprintf("S3 PUT %s returned %s: %s", key, resp.Status, bytes.TrimSpace(message))}
+	}
+
+	return nil
+}
+
+// sign adds the Signature Version 4 headers for a request with an unquoted
+// path and no query string
+func (c *s3Client) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+		signed = append(signed, "x-amz-security-token")
+		canonicalHeaders += "x-amz-security-token:" + c.sessionToken + "\n"
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, path, "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	for _, part := range []string{c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath percent-encodes an object key as SigV4 expects, keeping slashes
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,355 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Saved queries, named /query bodies shared within a tenant and run by name
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// savedQueryName is what a saved query may be called, so it fits in a URL path
+var savedQueryName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// SavedQuery is a named /query body of a tenant
+type SavedQuery struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Query       map[string]json.RawMessage `json:"query"`             // filters, options, sort and fields, as sent to /query
+	Since       string                     `json:"since,omitempty"`   // e.g. "1h": each run starts that long before now
+	Private     bool                       `json:"private,omitempty"` // only its owner and admin keys see and run it
+	Owner       string                     `json:"owner,omitempty"`   // name of the API key that saved it
+	Tenant      string                     `json:"tenant"`
+	Created     time.Time                  `json:"created"`
+	Updated     time.Time                  `json:"updated"`
+}
+
+// SavedQueryList is the body of GET /queries
+type SavedQueryList struct {
+	Queries []SavedQuery `json:"queries"`
+}
+
+// SavedQueries holds the saved queries of every tenant, written to saved-queries.json
+// in the data directory on every change
+type SavedQueries struct {
+	path string // empty keeps them in memory only
+
+	mu      sync.Mutex
+	queries map[string]map[string]SavedQuery // by tenant, then name
+}
+
+// OpenSavedQueries loads the saved queries of the data directory
+func OpenSavedQueries(cfg Config) (*SavedQueries, error) {
+	sq := &SavedQueries{queries: make(map[string]map[string]SavedQuery)}
+	if cfg.DataDir == "" {
+		return sq, nil
+	}
+
+	sq.path = filepath.Join(cfg.DataDir, "saved-queries.json")
+	data, err := os.ReadFile(sq.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sq, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var queries []SavedQuery
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("%s: %v", sq.path, err)
+	}
+	for _, query := range queries {
+		if sq.queries[query.Tenant] == nil {
+			sq.queries[query.Tenant] = make(map[string]SavedQuery)
+		}
+		sq.queries[query.Tenant][query.Name] = query
+	}
+	return sq, nil
+}
+
+// List returns the queries of a tenant that see accepts, by name
+func (sq *SavedQueries) List(tenant string, see func(SavedQuery) bool) []SavedQuery {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	queries := []SavedQuery{}
+	for _, query := range sq.queries[tenant] {
+		if see(query) {
+			queries = append(queries, query)
+		}
+	}
+	slices.SortFunc(queries, func(a, b SavedQuery) int { return cmp.Compare(a.Name, b.Name) })
+	return queries
+}
+
+// Get returns a query of a tenant
+func (sq *SavedQueries) Get(tenant, name string) (SavedQuery, bool) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	query, ok := sq.queries[tenant][name]
+	return query, ok
+}
+
+// Put stores a query, replacing the one of its name when replace is set; it fails
+// with errSavedQueryExists or errSavedQueryMissing when the name is taken or free
+func (sq *SavedQueries) Put(query SavedQuery, replace bool) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	_, exists := sq.queries[query.Tenant][query.Name]
+	switch {
+	case exists && !replace:
+		return errSavedQueryExists
+	case !exists && replace:
+		return errSavedQueryMissing
+	}
+	if sq.queries[query.Tenant] == nil {
+		sq.queries[query.Tenant] = make(map[string]SavedQuery)
+	}
+	sq.queries[query.Tenant][query.Name] = query
+	return sq.saveLocked()
+}
+
+// Delete removes a query
+func (sq *SavedQueries) Delete(tenant, name string) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	delete(sq.queries[tenant], name)
+	return sq.saveLocked()
+}
+
+var (
+	errSavedQueryExists  = errors.New("a saved query of this name exists")
+	errSavedQueryMissing = errors.New("no saved query of this name")
+)
+
+// saveLocked writes every query to the file, through a temporary file so a crash
+// leaves the previous one
+func (sq *SavedQueries) saveLocked() error {
+	if sq.path == "" {
+		return nil
+	}
+	var queries []SavedQuery
+	for _, tenant := range sq.queries {
+		for _, query := range tenant {
+			queries = append(queries, query)
+		}
+	}
+	slices.SortFunc(queries, func(a, b SavedQuery) int {
+		return cmp.Or(cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.Name, b.Name))
+	})
+	data, err := json.Marshal(queries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sq.path), 0755); err != nil {
+		return err
+	}
+	tmp := sq.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sq.path)
+}
+
+// fields returns the /query body of a run at now: the saved query with its since
+// window, under the overrides of the run request
+func (q SavedQuery) fields(overrides map[string]json.RawMessage, now time.Time) map[string]json.RawMessage {
+	fields := maps.Clone(q.Query)
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+	if since, err := parseDuration(q.Since); q.Since != "" && err == nil {
+		from, _ := json.Marshal(now.Add(-since).UTC().Format(time.RFC3339))
+		fields["timestamp_from"] = from
+	}
+	maps.Copy(fields, overrides)
+	return fields
+}
+
+// mayChange reports whether the caller of ctx may replace or delete a query: its
+// owner and admin keys may
+func mayChange(ctx context.Context, q SavedQuery) bool {
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	if !ok || p == nil {
+		return true
+	}
+	return q.Owner == p.Name || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// maySee reports whether the caller of ctx sees a query: private ones are only
+// seen by those who may change them
+func maySee(ctx context.Context, q SavedQuery) bool {
+	return !q.Private || mayChange(ctx, q)
+}
+
+// handleSavedQueries lists the saved queries of the tenant or, with ?name=,
+// returns one (GET), saves a new one (POST), replaces one (PUT) or deletes one
+// (DELETE ?name=)
+func (s *Server) handleSavedQueries(w http.ResponseWriter, r *http.Request) {
+	tenant, name := s.tenant(r.Context()).ID, r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+		if name == "" {
+			writeJSON(w, http.StatusOK, SavedQueryList{Queries: s.savedQueries.List(tenant, func(q SavedQuery) bool { return maySee(r.Context(), q) })})
+			return
+		}
+		if saved, ok := s.savedQuery(w, r, name); ok {
+			writeJSON(w, http.StatusOK, saved)
+		}
+	case http.MethodPost:
+		query, ok := s.readSavedQuery(w, r)
+		if !ok {
+			return
+		}
+		query.Tenant, query.Owner = tenant, principalName(r.Context())
+		query.Created = time.Now().UTC()
+		query.Updated = query.Created
+		s.putSavedQuery(w, query, false, http.StatusCreated)
+	case http.MethodPut:
+		query, ok := s.readSavedQuery(w, r)
+		if !ok {
+			return
+		}
+		saved, ok := s.savedQuery(w, r, query.Name)
+		if !ok {
+			return
+		}
+		if !mayChange(r.Context(), saved) {
+			writeError(w, http.StatusForbidden, ErrCodeForbidden, "Only the owner of a saved query or an admin key may change it", nil)
+			return
+		}
+		query.Tenant, query.Owner, query.Created = tenant, saved.Owner, saved.Created
+		query.Updated = time.Now().UTC()
+		s.putSavedQuery(w, query, true, http.StatusOK)
+	case http.MethodDelete:
+		saved, ok := s.savedQuery(w, r, name)
+		if !ok {
+			return
+		}
+		if !mayChange(r.Context(), saved) {
+			writeError(w, http.StatusForbidden, ErrCodeForbidden, "Only the owner of a saved query or an admin key may delete it", nil)
+			return
+		}
+		if err := s.savedQueries.Delete(tenant, name); err != nil {
+			logger.Error("saving queries failed", "error", err)
+			writeInternalError(w, "Error saving queries")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// savedQuery returns the named query of the tenant the caller sees; otherwise
+// the 404 response has already been written
+func (s *Server) savedQuery(w http.ResponseWriter, r *http.Request, name string) (SavedQuery, bool) {
+	saved, ok := s.savedQueries.Get(s.tenant(r.Context()).ID, name)
+	if ok = ok && maySee(r.Context(), saved); !ok {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Saved query %q not found", name), nil)
+	}
+	return saved, ok
+}
+
+// handleRunSavedQuery runs a saved query like /query; a JSON body of /query keys,
+// such as limit or page_token, overrides the saved ones
+func (s *Server) handleRunSavedQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	name := r.PathValue("name")
+	saved, ok := s.savedQuery(w, r, name)
+	if !ok {
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var overrides map[string]json.RawMessage
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &overrides); err != nil {
+			writeMalformedJSON(w, err)
+			return
+		}
+	}
+
+	logAttrs(r.Context(), slog.String("saved_query", name))
+	s.runQuery(w, r, saved.fields(overrides, time.Now()))
+}
+
+// readSavedQuery decodes and checks the saved query of a request body; on failure
+// the error response has already been written
+func (s *Server) readSavedQuery(w http.ResponseWriter, r *http.Request) (SavedQuery, bool) {
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return SavedQuery{}, false
+	}
+	var query SavedQuery
+	if err := json.Unmarshal(body, &query); err != nil {
+		writeMalformedJSON(w, err)
+		return SavedQuery{}, false
+	}
+	if err := s.checkSavedQuery(query); err != nil {
+		writeValidationError(w, err)
+		return SavedQuery{}, false
+	}
+	return query, true
+}
+
+// checkSavedQuery validates the name, window and query of a saved query
+func (s *Server) checkSavedQuery(query SavedQuery) error {
+	if !savedQueryName.MatchString(query.Name) {
+		return errors.New("Invalid name: must be 1 to 128 letters, digits, '.', '_' or '-'")
+	}
+	if query.Since != "" {
+		since, err := parseDuration(query.Since)
+		if err != nil || since <= 0 {
+			return fmt.Errorf("Invalid since: %q is not a positive duration", query.Since)
+		}
+		if _, ok := query.Query["timestamp_from"]; ok {
+			return errors.New("Invalid since: cannot be combined with timestamp_from")
+		}
+	}
+	req, err := buildQuery(query.fields(nil, time.Now()), s.cfg.MaxPageSize)
+	if err != nil {
+		return err
+	}
+	return s.validator.Levels.apply(&req)
+}
+
+// putSavedQuery stores a query and writes it back with status
+func (s *Server) putSavedQuery(w http.ResponseWriter, query SavedQuery, replace bool, status int) {
+	err := s.savedQueries.Put(query, replace)
+	switch {
+	case errors.Is(err, errSavedQueryExists):
+		writeError(w, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Saved query %q already exists", query.Name), nil)
+	case errors.Is(err, errSavedQueryMissing):
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Saved query %q not found", query.Name), nil)
+	case err != nil:
+		logger.Error("saving queries failed", "error", err)
+		writeInternalError(w, "Error saving queries")
+	default:
+		writeJSON(w, status, query)
+	}
+}
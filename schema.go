@@ -0,0 +1,243 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Custom top-level log fields declared by the operator, with their types and indexes
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Types of custom fields
+const (
+	FieldTypeString = "string"
+	FieldTypeNumber = "number"
+	FieldTypeBool   = "bool"
+)
+
+// CustomField is a top-level log field declared with the custom-fields setting
+type CustomField struct {
+	Name    string
+	Type    string
+	Indexed bool
+}
+
+// customFields are the declared custom fields, set once at startup by
+// declareCustomFields before any log is read
+var customFields []CustomField
+
+// customFieldName is what a custom field may be called: it must not look like
+// a metadata path or a regex filter
+var customFieldName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// reservedFieldNames are the log fields and query keys a custom field cannot shadow
+var reservedFieldNames = []string{"level", "message", "resourceId", "timestamp", "traceId", "spanId", "commit", "metadata", "ingestedAt",
+	"timestamp_from", "timestamp_to", "limit", "offset", "page_token", "fields", "min_level", "count_only", "q", "sort", "format", "highlight",
+	"context", "context_by", "lenient", "message_mode", "not", "collapse_by", "count", "pattern"}
+
+// parseCustomFields parses custom-fields entries, each "name:type" or
+// "name:type:indexed"
+func parseCustomFields(entries []string) ([]CustomField, error) {
+	var fields []CustomField
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "indexed") {
+			return nil, fmt.Errorf("invalid custom field %q, expected name:type or name:type:indexed", entry)
+		}
+		field := CustomField{Name: parts[0], Type: parts[1], Indexed: len(parts) == 3}
+
+		switch {
+		case !customFieldName.MatchString(field.Name) || strings.HasSuffix(field.Name, regexSuffix):
+			return nil, fmt.Errorf("custom field %q: name must be letters, digits, _ and -, starting with a letter", field.Name)
+		case slices.Contains(reservedFieldNames, field.Name):
+			return nil, fmt.Errorf("custom field %q: name is reserved", field.Name)
+		case slices.ContainsFunc(fields, func(f CustomField) bool { return f.Name == field.Name }):
+			return nil, fmt.Errorf("custom field %q is declared twice", field.Name)
+		case field.Type != FieldTypeString && field.Type != FieldTypeNumber && field.Type != FieldTypeBool:
+			return nil, fmt.Errorf("custom field %q: type must be string, number or bool", field.Name)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// declareCustomFields makes the fields part of the log schema and indexes the
+// indexed ones
+func declareCustomFields(fields []CustomField) {
+	customFields = fields
+	for _, field := range fields {
+		if field.Indexed {
+			indexedFields = append(indexedFields, field.Name)
+		}
+	}
+}
+
+// customField returns the declared custom field called name, nil if there is none
+func customField(name string) *CustomField {
+	for i := range customFields {
+		if customFields[i].Name == name {
+			return &customFields[i]
+		}
+	}
+	return nil
+}
+
+// logJSON is a Log without its JSON methods, encoded the default way
+type logJSON Log
+
+// MarshalJSON writes the custom fields after the fixed ones
+func (l Log) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(logJSON(l))
+	if err != nil || len(l.Custom) == 0 {
+		return data, err
+	}
+
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for _, field := range customFields {
+		value, ok := l.Custom[field.Name]
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, ",%q:", field.Name)
+		buf.Write(encoded)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON reads the fixed fields and the declared custom ones, whose types
+// are checked by Validator.Validate; numbers are kept as json.Number
+func (l *Log) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*logJSON)(l)); err != nil {
+		return err
+	}
+	if len(customFields) == 0 {
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	l.Custom = nil
+	for _, field := range customFields {
+		value, ok := raw[field.Name]
+		if !ok {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		var decoded any
+		if err := decoder.Decode(&decoded); err != nil {
+			return err
+		}
+		if decoded == nil {
+			continue
+		}
+		if l.Custom == nil {
+			l.Custom = make(map[string]any)
+		}
+		l.Custom[field.Name] = decoded
+	}
+	return nil
+}
+
+// docCustom returns the declared custom fields of a decoded document, such as a
+// /_bulk document or forward record
+func docCustom(doc map[string]interface{}) map[string]any {
+	var custom map[string]any
+	for _, field := range customFields {
+		value := doc[field.Name]
+		switch v := value.(type) {
+		case nil:
+			continue
+		case float64:
+			value = json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+		case int64:
+			value = json.Number(strconv.FormatInt(v, 10))
+		case uint64:
+			value = json.Number(strconv.FormatUint(v, 10))
+		}
+		if custom == nil {
+			custom = make(map[string]any)
+		}
+		custom[field.Name] = value
+	}
+	return custom
+}
+
+// customSize approximates the bytes custom fields take in memory
+func customSize(custom map[string]any) int {
+	n := 0
+	for name, value := range custom {
+		n += len(name) + len(formatValue(value))
+	}
+	return n
+}
+
+// validateCustom checks the type of every custom field of a log
+func validateCustom(log *Log, add func(field, format string, args ...interface{})) {
+	for _, field := range customFields {
+		value, ok := log.Custom[field.Name]
+		if !ok {
+			continue
+		}
+		switch field.Type {
+		case FieldTypeString:
+			if _, ok := value.(string); !ok {
+				add(field.Name, "must be a string")
+			}
+		case FieldTypeNumber:
+			if _, ok := value.(json.Number); !ok {
+				add(field.Name, "must be a number")
+			}
+		case FieldTypeBool:
+			if _, ok := value.(bool); !ok {
+				add(field.Name, "must be true or false")
+			}
+		}
+	}
+}
+
+// compareCustom orders two values of a custom field, numbers by value and the
+// others as fieldValue strings; logs without the field come first
+func compareCustom(field *CustomField, a, b Log) int {
+	if field.Type == FieldTypeNumber {
+		x, errX := strconv.ParseFloat(formatValue(a.Custom[field.Name]), 64)
+		y, errY := strconv.ParseFloat(formatValue(b.Custom[field.Name]), 64)
+		switch {
+		case errX != nil || errY != nil:
+			return compareBool(errX == nil, errY == nil)
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(formatValue(a.Custom[field.Name]), formatValue(b.Custom[field.Name]))
+}
+
+// compareBool orders false before true
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,847 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : HTTP handlers of the log ingestor and the query interface
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves the ingest and query HTTP API over a LogStorage
+type Server struct {
+	cfg       Config
+	tenants   *Tenants
+	validator Validator
+	auth      *Authenticator
+	cors      *corsPolicy  // nil without cors-origins
+	queue     *IngestQueue // nil when ingests are stored synchronously
+
+	deadLetters  *DeadLetterStore // nil when disabled
+	audit        *AuditLog        // nil when disabled
+	alerts       *Alerter         // nil without an alert-rules-file
+	anomalies    *AnomalyDetector // nil without an anomaly-interval
+	exports      *Exporter        // nil without an export-jobs-file
+	aggregations *Aggregations    // nil without an aggregations-file
+	pipelines    *Pipelines       // nil without a pipelines-file
+	savedQueries *SavedQueries
+	policies     *IngestPolicies // nil without an ingest-policies-file
+	quotas       *Quotas         // nil without a quotas-file
+	compaction   *CompactionRunner
+	sinks        []*Sink
+	idempotency  *dedupCache // responses by Idempotency-Key; nil without dedup-window
+	cluster      *Cluster    // nil unless cluster-nodes is set
+	started      time.Time
+}
+
+// NewServer creates a Server for the given configuration and storage
+func NewServer(cfg Config, tenants *Tenants) (*Server, error) {
+	auth, err := NewAuthenticator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading API keys: %v", err)
+	}
+
+	s := &Server{cfg: cfg, tenants: tenants, validator: NewValidator(cfg), auth: auth, compaction: NewCompactionRunner(cfg, tenants),
+		cluster: NewCluster(cfg), started: time.Now()}
+	if s.validator.Redactor, err = NewRedactor(cfg); err != nil {
+		return nil, fmt.Errorf("loading redact patterns: %v", err)
+	}
+	if s.cors, err = newCORSPolicy(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.IngestQueueSize > 0 {
+		s.queue = NewIngestQueue(cfg)
+	}
+	if cfg.DedupWindow > 0 {
+		s.idempotency = newDedupCache(idempotencyKeys, nil, cfg.DedupWindow, cfg.DedupMaxKeys)
+	}
+
+	if cfg.DeadLetterMaxEntries > 0 {
+		var path string
+		if cfg.DataDir != "" {
+			if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+				return nil, err
+			}
+			path = filepath.Join(cfg.DataDir, "deadletter.ndjson")
+		}
+		s.deadLetters, err = OpenDeadLetterStore(path, cfg.DeadLetterMaxEntries)
+		if err != nil {
+			return nil, fmt.Errorf("opening dead-letter store: %v", err)
+		}
+		metrics.GaugeFunc("logingestor_dead_letters", "Rejected payloads held in the dead-letter store", func() float64 {
+			return float64(s.deadLetters.Len())
+		})
+	}
+	if cfg.AuditMaxEntries > 0 {
+		var path string
+		if cfg.DataDir != "" {
+			if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+				return nil, err
+			}
+			path = filepath.Join(cfg.DataDir, "audit.ndjson")
+		}
+		s.audit, err = OpenAuditLog(path, cfg.AuditMaxEntries)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %v", err)
+		}
+		metrics.GaugeFunc("logingestor_audit_entries", "Queries held in the audit log", func() float64 {
+			return float64(s.audit.Len())
+		})
+	}
+
+	if s.savedQueries, err = OpenSavedQueries(cfg); err != nil {
+		return nil, fmt.Errorf("opening saved queries: %v", err)
+	}
+
+	if cfg.AlertRulesFile != "" {
+		s.alerts, err = LoadAlerter(cfg, tenants, s.validator.Levels)
+		if err != nil {
+			return nil, fmt.Errorf("loading alert rules: %v", err)
+		}
+	}
+
+	if cfg.AnomalyInterval > 0 {
+		s.anomalies, err = NewAnomalyDetector(cfg, tenants, s.validator.Levels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.SinksFile != "" {
+		s.sinks, err = OpenSinks(cfg, tenants, s.validator.Levels)
+		if err != nil {
+			return nil, fmt.Errorf("loading sinks: %v", err)
+		}
+	}
+
+	if cfg.PipelinesFile != "" {
+		s.pipelines, err = LoadPipelines(cfg, tenants, s.validator.Levels)
+		if err != nil {
+			return nil, fmt.Errorf("loading pipelines: %v", err)
+		}
+	}
+
+	if cfg.IngestPoliciesFile != "" {
+		s.policies, err = LoadIngestPolicies(cfg, s.validator.Levels)
+		if err != nil {
+			return nil, fmt.Errorf("loading ingest policies: %v", err)
+		}
+	}
+
+	if cfg.QuotasFile != "" {
+		s.quotas, err = LoadQuotas(cfg, tenants)
+		if err != nil {
+			return nil, fmt.Errorf("loading quotas: %v", err)
+		}
+	}
+
+	if cfg.ExportJobsFile != "" {
+		s.exports, err = LoadExporter(cfg, tenants, s.validator.Levels)
+		if err != nil {
+			return nil, fmt.Errorf("loading export jobs: %v", err)
+		}
+		if s.cluster != nil {
+			s.exports.node, s.exports.serves = s.cluster.self.ID, s.cluster.serves(nil)
+		}
+	}
+
+	if s.cluster != nil {
+		for _, tenant := range tenants.All() {
+			if tenant.storage.rollups != nil {
+				tenant.storage.rollups.setServes(s.cluster.serves(nil))
+			}
+		}
+	}
+
+	if cfg.AggregationsFile != "" {
+		var serves func(Log) bool
+		if s.cluster != nil {
+			serves = s.cluster.serves(nil)
+		}
+		s.aggregations, err = LoadAggregations(cfg, tenants, s.validator.Levels, serves)
+		if err != nil {
+			return nil, fmt.Errorf("loading aggregations: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Close stores the logs still waiting in the ingest queue, flushes the output
+// sinks and closes the dead-letter store and audit log
+func (s *Server) Close() {
+	if s.queue != nil {
+		s.queue.Close()
+	}
+	for _, sink := range s.sinks {
+		sink.Close()
+	}
+	if s.deadLetters != nil {
+		s.deadLetters.Close()
+	}
+	if s.audit != nil {
+		s.audit.Close()
+	}
+}
+
+// store hands logs for a tenant to the ingest queue when it is enabled, otherwise
+// stores them before returning; queued reports that they were only queued. In
+// cluster mode, logs owned by other nodes are sent to them first, unless ctx is
+// of a request another node forwarded.
+func (s *Server) store(ctx context.Context, tenant *Tenant, logs []Log) (queued bool, err error) {
+	if s.cluster != nil && !isForwarded(ctx) {
+		if logs, err = s.cluster.route(ctx, tenant, logs); err != nil {
+			return false, err
+		}
+	}
+	if s.queue != nil {
+		return true, s.queue.Enqueue(tenant.storage, logs)
+	}
+
+	return false, tenant.storage.IngestBatch(logs)
+}
+
+// writeStoreError reports why store failed
+func writeStoreError(w http.ResponseWriter, err error, message string) {
+	var qerr *QuotaError
+	if errors.As(err, &qerr) {
+		writeQuotaError(w, qerr)
+		return
+	}
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "Ingest queue is full, retry later", nil)
+		return
+	}
+	var nerr *nodeError
+	if errors.As(err, &nerr) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, fmt.Sprintf("Cluster node %s could not store its logs, retry later", nerr.node.ID), nil)
+		return
+	}
+
+	writeInternalError(w, message)
+}
+
+// Handler returns the HTTP handler with every endpoint registered
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", s.requireScope(ScopeWrite, s.idempotent(s.handleIngest)))
+	mux.HandleFunc("/ingest/batch", s.requireScope(ScopeWrite, s.idempotent(s.handleIngestBatch)))
+	mux.HandleFunc("/loki/api/v1/push", s.requireScope(ScopeWrite, s.handleLokiPush))
+	mux.HandleFunc("/loki/api/v1/query_range", s.requireScope(ScopeRead, s.audited(gzipResponse(s.handleLokiQueryRange))))
+	mux.HandleFunc("/loki/api/v1/query", s.requireScope(ScopeRead, s.audited(s.handleLokiQuery)))
+	mux.HandleFunc("/loki/api/v1/labels", s.requireScope(ScopeRead, s.audited(s.handleLokiLabels)))
+	mux.HandleFunc("/loki/api/v1/label/{name}/values", s.requireScope(ScopeRead, s.audited(s.handleLokiLabels)))
+	mux.HandleFunc("/query", s.requireScope(ScopeRead, s.audited(gzipResponse(s.handleQuery))))
+	mux.HandleFunc("/query/values", s.requireScope(ScopeRead, s.audited(s.handleValues)))
+	mux.HandleFunc("/query/histogram", s.requireScope(ScopeRead, s.audited(s.handleHistogram)))
+	mux.HandleFunc("/query/bursts", s.requireScope(ScopeRead, s.audited(s.handleBursts)))
+	mux.HandleFunc("/query/top", s.requireScope(ScopeRead, s.audited(s.handleTop)))
+	mux.HandleFunc("/patterns", s.requireScope(ScopeRead, s.handlePatterns))
+	mux.HandleFunc("/queries", s.requireScope(ScopeRead, s.handleSavedQueries))
+	mux.HandleFunc("/queries/{name}/run", s.requireScope(ScopeRead, s.audited(gzipResponse(s.handleRunSavedQuery))))
+	mux.HandleFunc("/export", s.requireScope(ScopeRead, s.audited(gzipResponse(s.handleExport))))
+	mux.HandleFunc("/import", s.requireScope(ScopeWrite, s.handleImport))
+	mux.HandleFunc("/_bulk", s.requireScope(ScopeWrite, s.idempotent(s.handleBulk)))
+	// By method, as /aggregations/_bulk would match both patterns
+	mux.HandleFunc("POST /{index}/_bulk", s.requireScope(ScopeWrite, s.idempotent(s.handleBulk)))
+	mux.HandleFunc("PUT /{index}/_bulk", s.requireScope(ScopeWrite, s.idempotent(s.handleBulk)))
+	mux.HandleFunc("/{$}", s.handleRoot)
+	mux.HandleFunc("/v1/logs", s.requireScope(ScopeWrite, s.idempotent(s.handleOTLP)))
+	mux.HandleFunc("/services/collector", s.requireScopeAs(ScopeWrite, writeHECAuthError, s.idempotent(s.handleHEC)))
+	mux.HandleFunc("/services/collector/event", s.requireScopeAs(ScopeWrite, writeHECAuthError, s.idempotent(s.handleHEC)))
+	mux.HandleFunc("/services/collector/event/1.0", s.requireScopeAs(ScopeWrite, writeHECAuthError, s.idempotent(s.handleHEC)))
+	mux.HandleFunc("/services/collector/health", handleHECHealth)
+	mux.HandleFunc("/tail", s.requireScope(ScopeRead, s.audited(s.handleTail)))
+	mux.HandleFunc("/traces/{traceId}/logs", s.requireScope(ScopeRead, s.audited(gzipResponse(s.handleTrace))))
+	mux.HandleFunc("/deadletter", s.requireScope(ScopeAdmin, s.handleDeadLetters))
+	mux.HandleFunc("/deadletter/reprocess", s.requireScope(ScopeWrite, s.handleReprocess))
+	mux.HandleFunc("/alerts", s.requireScope(ScopeRead, s.handleAlerts))
+	mux.HandleFunc("/anomalies", s.requireScope(ScopeRead, s.audited(s.handleAnomalies)))
+	mux.HandleFunc("/exports", s.requireScope(ScopeRead, s.handleExportJobs))
+	mux.HandleFunc("/rollups", s.requireScope(ScopeRead, s.handleRollups))
+	mux.HandleFunc(clusterRollupsPath, s.requireScope(ScopeRead, s.handleRollups))
+	mux.HandleFunc("/aggregations", s.requireScope(ScopeRead, s.handleAggregations))
+	mux.HandleFunc("GET /aggregations/{name}", s.requireScope(ScopeRead, s.audited(s.handleAggregation)))
+	mux.HandleFunc(clusterAggregationsPath+"{name}", s.requireScope(ScopeRead, s.handleAggregation))
+	mux.HandleFunc("/admin/snapshot", s.requireScope(ScopeAdmin, s.handleSnapshot))
+	mux.HandleFunc("/admin/restore", s.requireScope(ScopeAdmin, s.handleRestore))
+	mux.HandleFunc("/admin/compaction", s.requireScope(ScopeAdmin, s.handleCompaction))
+	mux.HandleFunc("/admin/stats", s.requireScope(ScopeAdmin, s.handleStats))
+	mux.HandleFunc("/admin/audit", s.requireScope(ScopeAdmin, s.handleAudit))
+	mux.HandleFunc("/admin/quotas", s.requireScope(ScopeAdmin, s.handleQuotas))
+	mux.HandleFunc("/admin/fsck", s.requireScope(ScopeAdmin, s.handleFsck))
+	mux.HandleFunc("/logs", s.requireScope(ScopeAdmin, s.audited(s.handleDeleteLogs)))
+	mux.HandleFunc("/logs/delete", s.requireScope(ScopeAdmin, s.audited(s.handleDeleteLogs)))
+	mux.HandleFunc("/admin/purge", s.requireScope(ScopeAdmin, s.audited(s.handlePurge)))
+	mux.HandleFunc("/admin/purge/verify", s.requireScope(ScopeAdmin, s.handleVerifyPurge))
+	mux.HandleFunc(clusterLogsPath, s.requireScope(ScopeRead, s.auditedAlways(s.handleClusterLogs)))
+	mux.HandleFunc(clusterCatchUpPath, s.requireScope(ScopeWrite, s.auditedAlways(s.handleClusterCatchUp)))
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/openapi.json", handleOpenAPI)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
+	return s.markForwarded(logRequests(s.cors.handler(mux)))
+}
+
+// maxPooledBody is the largest buffer kept in bodyBuffers, so that a rare huge
+// body does not stay allocated
+const maxPooledBody = 4 << 20
+
+// bodyBuffers reuses the buffers the bodies of ingest requests are read into
+var bodyBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// readBody reads the request body, rejecting bodies larger than limit with 413 and
+// bodies the client is too slow to send within read-timeout with 408.
+// A gzip Content-Encoding is decompressed, up to max-decompressed-size.
+// On failure the error response has already been written.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.readBodyInto(&buf, w, r, limit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readPooledBody is readBody into a buffer of bodyBuffers, for the hot ingest
+// paths. The caller hands it to releaseBody once nothing refers to its bytes.
+func (s *Server) readPooledBody(w http.ResponseWriter, r *http.Request, limit int64) (*bytes.Buffer, error) {
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	if err := s.readBodyInto(buf, w, r, limit); err != nil {
+		releaseBody(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// releaseBody returns a buffer of readPooledBody to bodyBuffers
+func releaseBody(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBody {
+		return
+	}
+	buf.Reset()
+	bodyBuffers.Put(buf)
+}
+
+// readBodyInto reads the request body into buf as readBody does
+func (s *Server) readBodyInto(buf *bytes.Buffer, w http.ResponseWriter, r *http.Request, limit int64) error {
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, limit)
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		// Sized up front, the buffer is not regrown while the body is read
+		if r.ContentLength > 0 && r.ContentLength <= limit {
+			buf.Grow(int(r.ContentLength) + bytes.MinRead)
+		}
+	case "gzip":
+		zr, err := newGzipReader(reader)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid gzip request body", err.Error())
+			return err
+		}
+		defer putGzipReader(zr)
+		reader = io.LimitReader(zr, s.cfg.MaxDecompressedSize+1)
+	default:
+		err := fmt.Errorf("unsupported Content-Encoding %q", encoding)
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedEncoding, "Content-Encoding must be gzip or identity", nil)
+		return err
+	}
+
+	buf.Reset()
+	if _, err := buf.ReadFrom(reader); err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), nil)
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			w.Header().Set("Connection", "close")
+			writeError(w, http.StatusRequestTimeout, ErrCodeRequestTimeout, "Request body not received within the read timeout", nil)
+		case encoding == "gzip":
+			writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid gzip request body", err.Error())
+		default:
+			writeInternalError(w, "Error reading request body")
+		}
+		return err
+	}
+	if encoding == "gzip" && int64(buf.Len()) > s.cfg.MaxDecompressedSize {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge,
+			fmt.Sprintf("Decompressed request body exceeds %d bytes", s.cfg.MaxDecompressedSize), nil)
+		return errors.New("decompressed body too large")
+	}
+
+	return nil
+}
+
+// handleIngest stores a single log entry, sent as JSON, MessagePack or CBOR
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	buf, err := s.readPooledBody(w, r, min(s.cfg.MaxLogSize, s.cfg.MaxBodySize))
+	if err != nil {
+		return
+	}
+	defer releaseBody(buf)
+	body := buf.Bytes()
+
+	var log Log
+	if codec := ingestCodec(r); codec != "" {
+		var value interface{}
+		if value, err = decodeBinary(codec, body); err == nil {
+			log, err = binaryLog(value)
+			body, _ = json.Marshal(value) // dead-lettered as JSON
+		}
+		if err != nil {
+			s.rejectRequest(r, "ingest", rejectionReason(err), body)
+			writeMalformedBinary(w, codec, err)
+			return
+		}
+	} else if err = json.Unmarshal(body, &log); err != nil {
+		s.rejectRequest(r, "ingest", rejectionReason(err), body)
+		writeMalformedJSON(w, err)
+		return
+	}
+
+	if err := s.validator.Validate(&log); err != nil {
+		s.rejectRequest(r, "ingest", rejectionReason(err), body)
+		writeValidationError(w, err)
+		return
+	}
+
+	logs, err := s.admit(r.Context(), "ingest", []Log{log})
+	var queued bool
+	if err == nil {
+		queued, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	if err != nil {
+		writeStoreError(w, err, "Error storing log")
+		return
+	}
+	logAttrs(r.Context(), slog.Int("logs", 1))
+	if queued {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIngestBatch stores a JSON array of log entries, reporting the outcome of each.
+// The array is decoded one entry at a time from a pooled buffer, once checked
+// as a whole so that a syntax error rejects the batch, not its first entries.
+// MessagePack and CBOR arrays are decoded whole, then read entry by entry, and
+// protobuf bodies are a LogBatch.
+func (s *Server) handleIngestBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	buf, err := s.readPooledBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	defer releaseBody(buf)
+	body := buf.Bytes()
+	if codec := ingestCodec(r); codec != "" {
+		s.ingestBinaryBatch(w, r, codec, body)
+		return
+	}
+	if isProtoBatch(r) {
+		s.ingestProtoBatch(w, r, body)
+		return
+	}
+	if !json.Valid(body) {
+		var raw json.RawMessage
+		err := json.Unmarshal(body, &raw)
+		s.rejectRequest(r, "ingest/batch", rejectionReason(err), body)
+		writeMalformedJSON(w, err)
+		return
+	}
+
+	var valid []Log
+	response := BatchResponse{Results: []BatchResult{}}
+	var entry json.RawMessage // reused by every entry
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	array, err := batchStart(decoder)
+	for i := 0; err == nil && array && decoder.More(); i++ {
+		if err = decoder.Decode(&entry); err != nil {
+			break
+		}
+		var log Log
+		if err := json.Unmarshal(entry, &log); err != nil {
+			s.rejectRequest(r, "ingest/batch", rejectionReason(err), entry)
+			response.reject(i, "Error decoding JSON: "+err.Error(), nil)
+			continue
+		}
+		if log, ok := s.batchEntry(r, &response, i, log, entry); ok {
+			valid = append(valid, log)
+		}
+	}
+	if err == nil {
+		err = batchEnd(decoder, array)
+	}
+	if err != nil {
+		s.rejectRequest(r, "ingest/batch", rejectionReason(err), body)
+		writeMalformedJSON(w, err)
+		return
+	}
+	s.storeBatch(w, r, valid, response)
+}
+
+// ingestBinaryBatch stores a MessagePack or CBOR array of log entries, as
+// handleIngestBatch a JSON one
+func (s *Server) ingestBinaryBatch(w http.ResponseWriter, r *http.Request, codec string, body []byte) {
+	value, err := decodeBinary(codec, body)
+	var entries []interface{}
+	if err == nil {
+		entries, err = binaryBatch(value)
+	}
+	if err != nil {
+		s.rejectRequest(r, "ingest/batch", rejectionReason(err), body)
+		writeMalformedBinary(w, codec, err)
+		return
+	}
+
+	var valid []Log
+	response := BatchResponse{Results: []BatchResult{}}
+	for i, entry := range entries {
+		log, err := binaryLog(entry)
+		if err != nil {
+			raw, _ := json.Marshal(entry)
+			s.rejectRequest(r, "ingest/batch", rejectionReason(err), raw)
+			response.reject(i, "Error decoding "+codec+": "+err.Error(), nil)
+			continue
+		}
+		if log, ok := s.batchEntry(r, &response, i, log, nil); ok {
+			valid = append(valid, log)
+		}
+	}
+	s.storeBatch(w, r, valid, response)
+}
+
+// batchEntry validates the decoded entry i of a batch, recording its outcome in
+// response; entry is its JSON, dead-lettered if invalid, or nil for the log as
+// decoded, as entries of other encodings are
+func (s *Server) batchEntry(r *http.Request, response *BatchResponse, i int, log Log, entry []byte) (Log, bool) {
+	decoded := log
+	if err := s.validator.Validate(&log); err != nil {
+		if entry == nil {
+			entry, _ = json.Marshal(decoded)
+		}
+		s.rejectRequest(r, "ingest/batch", rejectionReason(err), entry)
+		response.reject(i, "Invalid log entry", err.(ValidationError))
+		return log, false
+	}
+	response.Results = append(response.Results, BatchResult{Index: i, Status: "ok"})
+	response.Accepted++
+	return log, true
+}
+
+// reject records entry i of a batch as rejected
+func (response *BatchResponse) reject(i int, message string, fields ValidationError) {
+	response.Results = append(response.Results, BatchResult{Index: i, Status: "rejected", Error: message, Fields: fields})
+	response.Rejected++
+}
+
+// storeBatch stores the valid entries of a batch and writes its response
+func (s *Server) storeBatch(w http.ResponseWriter, r *http.Request, valid []Log, response BatchResponse) {
+	logs, err := s.admit(r.Context(), "ingest/batch", valid)
+	var queued bool
+	if err == nil {
+		queued, err = s.store(r.Context(), s.tenant(r.Context()), logs)
+	}
+	if err != nil {
+		writeStoreError(w, err, "Error storing logs")
+		return
+	}
+	logAttrs(r.Context(), slog.Int("accepted", response.Accepted), slog.Int("rejected", response.Rejected))
+
+	result, err := json.Marshal(response)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if queued {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	w.Write(result)
+}
+
+// batchStart reads the opening bracket of a batch array, reporting false for a
+// null batch, which holds no entries
+func batchStart(decoder *json.Decoder) (bool, error) {
+	token, err := decoder.Token()
+	switch {
+	case err != nil:
+		return false, err
+	case token == nil:
+		return false, nil
+	case token != json.Delim('['):
+		return false, fmt.Errorf("json: cannot unmarshal %s into a batch: expected an array of log entries", batchToken(token))
+	}
+	return true, nil
+}
+
+// batchEnd reads the closing bracket of a batch array and checks nothing follows it
+func batchEnd(decoder *json.Decoder, array bool) error {
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+		return err
+	}
+	return nil
+}
+
+// batchToken names the JSON type of a token for an error
+func batchToken(token json.Token) string {
+	switch token.(type) {
+	case json.Delim:
+		return "object"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	}
+	return "null"
+}
+
+// handleQuery returns the logs matching the filters of a POST body or of the
+// URL parameters of a GET
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		fields, err := urlQueryFields(r.URL.Query())
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		s.runQuery(w, r, fields)
+	case http.MethodPost:
+		body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+		if err != nil {
+			return
+		}
+
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(body, &fields)
+		if err != nil {
+			writeMalformedJSON(w, err)
+			return
+		}
+		s.runQuery(w, r, fields)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// runQuery answers the fields of a /query body
+func (s *Server) runQuery(w http.ResponseWriter, r *http.Request, fields map[string]json.RawMessage) {
+	auditQuery(r.Context(), fields)
+	req, err := buildQuery(fields, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+	if s.cluster != nil {
+		if !isForwarded(r.Context()) {
+			s.clusterQuery(w, r, fields, req)
+			return
+		}
+		req.Options.Serves = s.cluster.serves(splitList(r.Header.Get(clusterDownHeader)))
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	storage := s.tenant(r.Context()).storage
+	cacheKey := queryCacheKey(r.Context(), fields, req)
+	if req.CountOnly {
+		count, err := storage.CachedCount(ctx, cacheKey, req.Filters, req.Options)
+		logAttrs(r.Context(), slog.Int("count", count))
+		auditResults(r.Context(), count)
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{Count: &count})
+			return
+		}
+		writeCount(w, count)
+		return
+	}
+
+	if req.CollapseBy != "" {
+		if acceptsNDJSON(r) && !req.Paginated {
+			req.Options.Limit = 0
+		}
+		logs, more, err := collapsedQuery(ctx, storage, &req)
+		logAttrs(r.Context(), slog.Int("results", len(logs)))
+		auditResults(r.Context(), len(logs))
+		if err == nil {
+			err = s.addContext(ctx, r, &req, logs)
+		}
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{Logs: req.project(logs)})
+			return
+		}
+		writePage(w, r, req, logs, more)
+		return
+	}
+
+	if acceptsNDJSON(r) {
+		// Streaming keeps memory flat, so only an explicit limit bounds the result
+		if !req.Paginated {
+			req.Options.Limit = 0
+		}
+		results, err := storage.Select(ctx, req.Filters, req.Options)
+		if err != nil {
+			var logs []Log
+			results.Each(func(log Log) bool {
+				logs = append(logs, log)
+				return true
+			})
+			writeQueryError(w, err, PartialResponse{Logs: req.project(logs)})
+			return
+		}
+		var nextToken string
+		if results.More {
+			nextToken = encodePageToken(req.Options.Offset + results.Len())
+		}
+		auditResults(r.Context(), results.Len())
+		if req.Context != nil {
+			var logs []Log
+			results.Each(func(log Log) bool {
+				logs = append(logs, log)
+				return true
+			})
+			if err := s.addContext(ctx, r, &req, logs); err != nil {
+				writeQueryError(w, err, PartialResponse{Logs: req.project(logs)})
+				return
+			}
+			results = resultsOf(logs)
+		}
+		streamNDJSON(w, results, nextToken, req.pageApply())
+		return
+	}
+
+	logs, more, err := storage.CachedQuery(ctx, cacheKey, req.Filters, req.Options)
+	logAttrs(r.Context(), slog.Int("results", len(logs)))
+	auditResults(r.Context(), len(logs))
+	if err == nil {
+		err = s.addContext(ctx, r, &req, logs)
+	}
+	if err != nil {
+		writeQueryError(w, err, PartialResponse{Logs: req.project(logs)})
+		return
+	}
+	writeLogs(w, req, logs, more)
+}
+
+// writeCount writes the response of a count_only query
+func writeCount(w http.ResponseWriter, count int) {
+	response, err := json.Marshal(CountResponse{Count: count})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// writeJSON writes a JSON response body with status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// writePage writes a page of logs gathered in memory, as NDJSON when the client
+// accepts it
+func writePage(w http.ResponseWriter, r *http.Request, req QueryRequest, logs []Log, more bool) {
+	if acceptsNDJSON(r) {
+		var nextToken string
+		if more {
+			nextToken = encodePageToken(req.Options.Offset + len(logs))
+		}
+		streamNDJSON(w, resultsOf(logs), nextToken, req.pageApply())
+		return
+	}
+	writeLogs(w, req, logs, more)
+}
+
+// writeLogs writes a page of query results as a JSON array, or as a QueryResponse
+// for paginated requests; more reports that matching logs follow the page
+func writeLogs(w http.ResponseWriter, req QueryRequest, logs []Log, more bool) {
+	var nextToken string
+	if more {
+		nextToken = encodePageToken(req.Options.Offset + len(logs))
+	}
+
+	var response []byte
+	var err error
+	if req.Paginated {
+		response, err = json.Marshal(QueryResponse{Logs: req.project(logs), NextToken: nextToken})
+	} else {
+		if more {
+			w.Header().Set("X-Next-Token", nextToken)
+		}
+		response, err = json.Marshal(req.project(logs))
+	}
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// queryContext bounds a query of the request with ctx by query-timeout
+func (s *Server) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.QueryTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.cfg.QueryTimeout)
+}
@@ -0,0 +1,208 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Time-window shards of the LogStorage, each holding its logs with their own lock and indexes
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shard holds the logs of a stripe whose timestamps fall in [start, end)
+type shard struct {
+	start, end time.Time
+	stripe     int // of the stripes of its window, see LogStorage.stripeOf
+
+	mu       sync.RWMutex
+	logs     []Log
+	index    fieldIndex
+	messages textIndex
+	byTime   timeIndex
+	bytes    int64
+
+	changed   atomic.Uint64 // stamp of the last change for the query cache, see LogStorage.touch
+	compacted bool          // rebuilt by compaction and unchanged since
+	detached  bool          // moved to the cold tier; appends go to a new shard
+	cold      bool          // placeholder for a cold tier window, see LogStorage.openShard
+}
+
+// newShard creates an empty shard for the window starting at start
+func newShard(start time.Time, window time.Duration) *shard {
+	return &shard{start: start, end: start.Add(window), index: newFieldIndex(), messages: newTextIndex()}
+}
+
+// compare orders the shard against the stripe of the window starting at start,
+// by start then stripe
+func (sh *shard) compare(start time.Time, stripe int) int {
+	if c := sh.start.Compare(start); c != 0 {
+		return c
+	}
+	return cmp.Compare(sh.stripe, stripe)
+}
+
+// overlaps reports whether the shard may hold logs within [from, to]; a zero bound is open
+func (sh *shard) overlaps(from, to time.Time) bool {
+	return (from.IsZero() || sh.end.After(from)) && (to.IsZero() || !sh.start.After(to))
+}
+
+// appendLocked adds log entries to the shard and its indexes, returning how many
+// are older than a log the shard already held; the caller holds the write lock
+func (sh *shard) appendLocked(logs ...Log) int {
+	sh.compacted = false
+	first := len(sh.logs)
+	for _, log := range logs {
+		pos := len(sh.logs)
+		sh.logs = append(sh.logs, log)
+		sh.index.add(log, pos)
+		sh.messages.add(log.Message, pos)
+		sh.bytes += logSize(log)
+	}
+	return sh.byTime.insertFrom(sh.logs, first)
+}
+
+// rebuildLocked replaces the logs of the shard and rebuilds every index from them;
+// the caller holds the write lock
+func (sh *shard) rebuildLocked(logs []Log) {
+	sh.logs = nil
+	sh.index = newFieldIndex()
+	sh.messages = newTextIndex()
+	sh.byTime = nil
+	sh.bytes = 0
+	sh.appendLocked(logs...)
+}
+
+// queryCheckEvery is how many logs a scan looks at between checks of its context
+const queryCheckEvery = 1024
+
+// scan calls fn with the position of every log matching the filters and the
+// optional LQL expression, in ingestion order, until fn returns false or ctx is
+// done; the caller holds the read lock
+func (sh *shard) scan(ctx context.Context, filters map[string]string, expr lqlNode, fn func(pos int) bool) bool {
+	if positions, ok := sh.candidates(filters, expr); ok {
+		for i, pos := range positions {
+			if i%queryCheckEvery == 0 && ctx.Err() != nil {
+				return false
+			}
+			if matchesQuery(sh.logs[pos], filters, expr) && !fn(pos) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for pos, log := range sh.logs {
+		if pos%queryCheckEvery == 0 && ctx.Err() != nil {
+			return false
+		}
+		if matchesQuery(log, filters, expr) && !fn(pos) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scanByTime is scan in timestamp order. Index candidates are few enough to sort
+// directly; otherwise the timestamp index is walked over the filtered time range,
+// so paginated queries stop early instead of sorting every match.
+func (sh *shard) scanByTime(ctx context.Context, filters map[string]string, expr lqlNode, desc bool, fn func(pos int) bool) bool {
+	var ordered []int
+	if positions, ok := sh.candidates(filters, expr); ok {
+		ordered = append(ordered, positions...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return sh.logs[ordered[i]].Timestamp.Before(sh.logs[ordered[j]].Timestamp)
+		})
+	} else {
+		from, to := timeBounds(filters)
+		ordered = sh.byTime.between(sh.logs, from, to)
+	}
+
+	for i := range ordered {
+		if i%queryCheckEvery == 0 && ctx.Err() != nil {
+			return false
+		}
+		pos := ordered[i]
+		if desc {
+			pos = ordered[len(ordered)-1-i]
+		}
+		if matchesQuery(sh.logs[pos], filters, expr) && !fn(pos) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// candidates narrows the filters and expression down to the positions the indexes
+// allow. ok is false when nothing can be answered from an index.
+func (sh *shard) candidates(filters map[string]string, expr lqlNode) (positions []int, ok bool) {
+	positions, ok = sh.index.lookup(filters)
+
+	if message, present := filters["message"]; present && filters[messageModeKey] != MessageFuzzy {
+		if matches, indexed := sh.messages.lookup(message); indexed {
+			if ok {
+				positions = intersect(positions, matches)
+			} else {
+				positions, ok = matches, true
+			}
+		}
+	}
+
+	if expr != nil {
+		if matches, indexed := expr.positions(sh); indexed {
+			if ok {
+				positions = intersect(positions, matches)
+			} else {
+				positions, ok = matches, true
+			}
+		}
+		if ok {
+			positions = subtract(positions, exclusions(expr, sh))
+		}
+	}
+
+	return positions, ok
+}
+
+// count returns how many logs match the filters and are allowed by opts. When
+// the indexes are exact for the filters (equality on indexed fields only, or time
+// bounds only) the count comes from them without looking at any log.
+func (sh *shard) count(ctx context.Context, filters map[string]string, opts QueryOptions) int {
+	if opts.Expr == nil && !opts.Scope.restricted() && opts.Serves == nil {
+		indexed, timed := 0, 0
+		for key := range filters {
+			switch {
+			case slices.Contains(indexedFields, key):
+				indexed++
+			case key == "timestamp" || key == "timestamp_from" || key == "timestamp_to":
+				timed++
+			}
+		}
+		switch {
+		case indexed > 0 && indexed == len(filters):
+			positions, _ := sh.index.lookup(filters)
+			return len(positions)
+		case timed == len(filters):
+			from, to := timeBounds(filters)
+			return len(sh.byTime.between(sh.logs, from, to))
+		}
+	}
+
+	n := 0
+	sh.scan(ctx, filters, opts.Expr, func(pos int) bool {
+		if opts.allows(sh.logs[pos]) {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
@@ -0,0 +1,457 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Output sinks forwarding ingested logs to webhooks, other ingestors, Elasticsearch or S3
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow policies: what Enqueue does when a sink's queue is full
+const (
+	SinkDrop  = "drop"  // discard the log and count it
+	SinkBlock = "block" // hold the ingest until there is room, pushing back on clients
+)
+
+// Backoff bounds between retries of failed deliveries
+const (
+	sinkMinBackoff = 500 * time.Millisecond
+	sinkMaxBackoff = 30 * time.Second
+)
+
+// sinkRequestTimeout bounds each delivery request
+const sinkRequestTimeout = 30 * time.Second
+
+var (
+	sinkTypes      = []string{"webhook", "ingestor", "elasticsearch", "s3"}
+	sinkForwarded  = metrics.CounterVec("logingestor_sink_forwarded_total", "Logs delivered by an output sink", "sink")
+	sinkDropped    = metrics.CounterVec("logingestor_sink_dropped_total", "Logs an output sink discarded: queue full, rejected by the target or pending at shutdown", "sink")
+	sinkRetries    = metrics.CounterVec("logingestor_sink_retries_total", "Output sink deliveries retried after a failure", "sink")
+	sinkQueueDepth = metrics.GaugeVec("logingestor_sink_queue_depth", "Logs waiting in an output sink queue", "sink")
+)
+
+// SinkConfig is an entry of the sinks-file
+type SinkConfig struct {
+	Name   string                     `json:"name"`
+	Type   string                     `json:"type"`   // webhook, ingestor, elasticsearch or s3
+	Tenant string                     `json:"tenant"` // tenant whose logs are forwarded, default the default tenant
+	Query  map[string]json.RawMessage `json:"query"`  // optional filters, min_level and "q"; empty forwards every log
+
+	URL     string            `json:"url"`     // webhook, ingestor and elasticsearch
+	Headers map[string]string `json:"headers"` // sent with every request, e.g. Authorization
+
+	APIKey       string `json:"api_key"`       // ingestor: API key of the target
+	TargetTenant string `json:"target_tenant"` // ingestor: tenant of the target, sent as X-Tenant-ID
+	Index        string `json:"index"`         // elasticsearch: index, default logs
+
+	Bucket          string `json:"bucket"` // s3: one object per batch
+	Region          string `json:"region"`
+	Prefix          string `json:"prefix"`
+	Endpoint        string `json:"endpoint"` // empty for AWS
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+
+	BatchSize     int    `json:"batch_size"`     // default 500
+	FlushInterval string `json:"flush_interval"` // default 1s
+	QueueSize     int    `json:"queue_size"`     // default 10000
+	Overflow      string `json:"overflow"`       // drop (default) or block
+}
+
+// httpStatusError is a delivery answered with an unsuccessful status
+type httpStatusError struct {
+	status  int
+	message string
+}
+
+func (e *httpStatusError) Error() string { return e.message }
+
+// permanent reports whether retrying cannot help: client errors other than
+// timeouts and rate limiting
+func (e *httpStatusError) permanent() bool {
+	return e.status/100 == 4 && e.status != http.StatusRequestTimeout && e.status != http.StatusTooManyRequests
+}
+
+// Sink forwards the ingested logs of a tenant matching its query to a target.
+// Logs wait in a bounded queue and are sent in batches by one worker, which
+// retries failed batches with exponential backoff; batches rejected by the
+// target with a client error are dropped.
+type Sink struct {
+	SinkConfig
+	req     QueryRequest
+	send    func(logs []Log) error
+	client  *http.Client
+	s3      *s3Client
+	objects atomic.Int64 // s3 objects written, for unique keys
+
+	flushInterval time.Duration
+	queue         chan Log
+	closing       chan struct{} // closed by Close
+	stopped       chan struct{} // closed when the worker returned
+	closeOnce     sync.Once
+}
+
+// OpenSinks reads the sinks-file, attaches each sink to the storage of its tenant
+// and starts its worker
+func OpenSinks(cfg Config, tenants *Tenants, levels *LevelRegistry) ([]*Sink, error) {
+	data, err := os.ReadFile(cfg.SinksFile)
+	if err != nil {
+		return nil, err
+	}
+	var configs []SinkConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.SinksFile, err)
+	}
+
+	var sinks []*Sink
+	names := make(map[string]bool)
+	for _, sc := range configs {
+		if sc.Name == "" {
+			return nil, errors.New("sink without a name")
+		}
+		if names[sc.Name] {
+			return nil, fmt.Errorf("sink %q: duplicate name", sc.Name)
+		}
+		names[sc.Name] = true
+
+		sink, tenant, err := newSink(cfg, tenants, levels, sc)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %v", sc.Name, err)
+		}
+		sinks = append(sinks, sink)
+		tenant.storage.AddSink(sink)
+	}
+	for _, sink := range sinks {
+		go sink.run()
+	}
+
+	return sinks, nil
+}
+
+// newSink checks a sink configuration, fills in its defaults and returns the
+// sink with the tenant it forwards
+func newSink(cfg Config, tenants *Tenants, levels *LevelRegistry, sc SinkConfig) (*Sink, *Tenant, error) {
+	if sc.Tenant == "" {
+		sc.Tenant = DefaultTenant
+	}
+	tenant, ok := tenants.byID[sc.Tenant]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown tenant %q", sc.Tenant)
+	}
+
+	req, err := buildRuleQuery(sc.Query, cfg.MaxPageSize, levels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sc.BatchSize == 0 {
+		sc.BatchSize = 500
+	}
+	if sc.QueueSize == 0 {
+		sc.QueueSize = 10000
+	}
+	if sc.BatchSize < 0 || sc.QueueSize < 0 {
+		return nil, nil, errors.New("batch_size and queue_size must be positive")
+	}
+	if sc.FlushInterval == "" {
+		sc.FlushInterval = "1s"
+	}
+	flushInterval, err := parseDuration(sc.FlushInterval)
+	if err != nil || flushInterval <= 0 {
+		return nil, nil, fmt.Errorf("invalid flush_interval %q", sc.FlushInterval)
+	}
+	switch sc.Overflow {
+	case "":
+		sc.Overflow = SinkDrop
+	case SinkDrop, SinkBlock:
+	default:
+		return nil, nil, fmt.Errorf("overflow %q must be %s or %s", sc.Overflow, SinkDrop, SinkBlock)
+	}
+
+	sink := &Sink{
+		SinkConfig:    sc,
+		req:           req,
+		client:        &http.Client{Timeout: sinkRequestTimeout},
+		flushInterval: flushInterval,
+		queue:         make(chan Log, sc.QueueSize),
+		closing:       make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	switch sc.Type {
+	case "webhook":
+		sink.send = sink.sendWebhook
+	case "ingestor":
+		sink.send = sink.sendIngestor
+	case "elasticsearch":
+		if sink.Index == "" {
+			sink.Index = "logs"
+		}
+		sink.send = sink.sendElasticsearch
+	case "s3":
+		sink.s3, err = newS3Client(sc.Endpoint, sc.Bucket, sc.Region, sc.AccessKeyID, sc.SecretAccessKey, sinkRequestTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		sink.send = sink.sendS3
+	default:
+		return nil, nil, fmt.Errorf("unknown type %q, expected one of %s", sc.Type, strings.Join(sinkTypes, ", "))
+	}
+	if sc.Type != "s3" && sc.URL == "" {
+		return nil, nil, fmt.Errorf("%s sink without a url", sc.Type)
+	}
+
+	return sink, tenant, nil
+}
+
+// AddSink makes the storage forward every log it ingests to sink; sinks are
+// added before ingestion starts
+func (ls *LogStorage) AddSink(sink *Sink) {
+	ls.sinks = append(ls.sinks, sink)
+}
+
+// Enqueue queues the logs matching the sink's query. With the block policy it
+// waits for room, so a slow target slows down ingestion instead of losing logs.
+func (sk *Sink) Enqueue(logs []Log) {
+	for _, log := range logs {
+		if !matchesQuery(log, sk.req.Filters, sk.req.Options.Expr) {
+			continue
+		}
+		if sk.Overflow == SinkBlock {
+			select {
+			case sk.queue <- log:
+			case <-sk.closing:
+				sinkDropped.With(sk.Name).Inc()
+			}
+			continue
+		}
+		select {
+		case sk.queue <- log:
+		default:
+			sinkDropped.With(sk.Name).Inc()
+		}
+	}
+	sinkQueueDepth.With(sk.Name).Set(int64(len(sk.queue)))
+}
+
+// Close stops the sink; logs still queued get a single delivery attempt
+func (sk *Sink) Close() {
+	sk.closeOnce.Do(func() { close(sk.closing) })
+	<-sk.stopped
+}
+
+// run sends the queued logs in batches of batch_size, or whatever arrived within
+// flush_interval, until the sink is closed
+func (sk *Sink) run() {
+	defer close(sk.stopped)
+	ticker := time.NewTicker(sk.flushInterval)
+	defer ticker.Stop()
+
+	var batch []Log
+	for {
+		select {
+		case log := <-sk.queue:
+			batch = append(batch, log)
+			if len(batch) < sk.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-sk.closing:
+			sk.drain(batch)
+			return
+		}
+
+		if !sk.deliver(batch) {
+			// Closed while retrying; the batch is retried once more by drain
+			sk.drain(batch)
+			return
+		}
+		batch = nil
+		sinkQueueDepth.With(sk.Name).Set(int64(len(sk.queue)))
+	}
+}
+
+// deliver sends a batch, retrying until it is accepted, rejected for good or the
+// sink is closed; it returns false in the last case
+func (sk *Sink) deliver(batch []Log) bool {
+	backoff := sinkMinBackoff
+	for {
+		err := sk.send(batch)
+		if err == nil {
+			sinkForwarded.With(sk.Name).Add(int64(len(batch)))
+			return true
+		}
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.permanent() {
+			sinkDropped.With(sk.Name).Add(int64(len(batch)))
+			logger.Error("sink refused logs, dropping them", "sink", sk.Name, "logs", len(batch), "error", err)
+			return true
+		}
+
+		sinkRetries.With(sk.Name).Inc()
+		logger.Warn("sink send failed", "sink", sk.Name, "error", err, "retry_in", backoff)
+		select {
+		case <-sk.closing:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, sinkMaxBackoff)
+	}
+}
+
+// drain makes one delivery attempt for batch and the logs still queued at shutdown
+func (sk *Sink) drain(batch []Log) {
+	for {
+		select {
+		case log := <-sk.queue:
+			batch = append(batch, log)
+			if len(batch) < sk.BatchSize {
+				continue
+			}
+		default:
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := sk.send(batch); err != nil {
+			lost := len(batch) + len(sk.queue)
+			sinkDropped.With(sk.Name).Add(int64(lost))
+			logger.Error("sink closed with logs not forwarded", "sink", sk.Name, "logs", lost, "error", err)
+			return
+		}
+		sinkForwarded.With(sk.Name).Add(int64(len(batch)))
+		batch = nil
+	}
+}
+
+// post sends body to url with the sink's headers and returns the response body,
+// failing on a non-2xx response
+func (sk *Sink) post(url, contentType string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range sk.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := sk.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		return nil, &httpStatusError{status: resp.StatusCode, message: fmt.Sprintf("%s returned %s: %.512s", url, resp.Status, bytes.TrimSpace(response))}
+	}
+
+	return response, err
+}
+
+// sendWebhook posts the batch as a JSON array of logs
+func (sk *Sink) sendWebhook(logs []Log) error {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	_, err = sk.post(sk.URL, "application/json", body, nil)
+	return err
+}
+
+// sendIngestor posts the batch to the /ingest/batch endpoint of another instance
+func (sk *Sink) sendIngestor(logs []Log) error {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	if sk.APIKey != "" {
+		header.Set("X-API-Key", sk.APIKey)
+	}
+	if sk.TargetTenant != "" {
+		header.Set(tenantHeader, sk.TargetTenant)
+	}
+	_, err = sk.post(strings.TrimSuffix(sk.URL, "/")+"/ingest/batch", "application/json", body, header)
+	return err
+}
+
+// sendElasticsearch indexes the batch through the Elasticsearch bulk API. Documents
+// the cluster refuses are logged but not retried, like client errors.
+func (sk *Sink) sendElasticsearch(logs []Log) error {
+	var buf bytes.Buffer
+	action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": sk.Index}})
+	for _, log := range logs {
+		doc, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(doc)
+		buf.WriteByte('\n')
+	}
+
+	response, err := sk.post(strings.TrimSuffix(sk.URL, "/")+"/_bulk", "application/x-ndjson", buf.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(response, &result) == nil && result.Errors {
+		refused := 0
+		for _, item := range result.Items {
+			for _, outcome := range item {
+				if outcome.Status/100 != 2 {
+					refused++
+				}
+			}
+		}
+		logger.Warn("elasticsearch refused documents", "sink", sk.Name, "refused", refused, "documents", len(logs))
+	}
+
+	return nil
+}
+
+// sendS3 writes the batch as a gzip-compressed NDJSON object named
+// <prefix><tenant>/<yyyy>/<mm>/<dd>/<time>-<n>.ndjson.gz
+func (sk *Sink) sendS3(logs []Log) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s%s/%s/%s-%d.ndjson.gz", sk.Prefix, sk.Tenant, now.Format("2006/01/02"), now.Format("20060102T150405.000000000Z"), sk.objects.Add(1))
+	return sk.s3.PutObject(key, "application/gzip", buf.Bytes())
+}
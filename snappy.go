@@ -0,0 +1,94 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Snappy block format decoding, as Loki push requests are compressed
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	errSnappyCorrupt  = errors.New("snappy: corrupt input")
+	errSnappyTooLarge = errors.New("snappy: decoded block exceeds the size limit")
+)
+
+// decodeSnappy decodes a snappy block (not the framed stream format) of up to
+// limit decoded bytes: the decoded length as a varint, then literals and copies
+// of earlier output
+func decodeSnappy(src []byte, limit int64) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errSnappyCorrupt
+	}
+	if n > uint64(limit) {
+		return nil, errSnappyTooLarge
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // literal, its length-1 in the tag or the 1-4 bytes after it
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				size := length - 59
+				if len(src) < size {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for i := size - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[size:]
+			}
+			length++
+			if length <= 0 || length > len(src) || uint64(len(dst)+length) > n {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1: // copy of 4-11 bytes with an 11-bit offset
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2: // copy with a 16-bit offset
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3: // copy with a 32-bit offset
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > n {
+			return nil, errSnappyCorrupt
+		}
+		// Copies may overlap their own output, e.g. a run of one byte
+		start := len(dst) - offset
+		for i := range length {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if uint64(len(dst)) != n {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,235 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Snapshot and restore of a tenant's logs as a portable gzip-compressed NDJSON file
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// A snapshot is gzip-compressed NDJSON: a snapshotHeader line, then one log per
+// line. Indexes are not part of it; they are rebuilt on restore, which keeps the
+// file portable between versions and instances.
+const (
+	snapshotFormat  = "logingestor-snapshot"
+	snapshotVersion = 1
+)
+
+// snapshotRestoreBatch is how many logs a merging restore stores at a time
+const snapshotRestoreBatch = 1000
+
+// snapshotHeader is the first line of a snapshot
+type snapshotHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Tenant  string    `json:"tenant"`
+	Created time.Time `json:"created"`
+	Logs    int       `json:"logs"`
+}
+
+// Replacer is implemented by a Storage whose whole content can be replaced
+type Replacer interface {
+	// ReplaceAll makes logs the only stored log entries
+	ReplaceAll(logs ...Log) error
+}
+
+// Replace discards every stored log and stores logs instead, rebuilding the shards
+// and their indexes. Logs ingested while a replace runs may be lost either way.
+func (ls *LogStorage) Replace(logs []Log) error {
+	if ls.store != nil {
+		replacer, ok := ls.store.(Replacer)
+		if !ok {
+			return errors.New("the storage engine does not support replacing its logs")
+		}
+		if err := replacer.ReplaceAll(logs...); err != nil {
+			return err
+		}
+	}
+
+	// The new shards are not shared until swapped in, so they need no locking yet
+	type shardKey struct {
+		start  int64
+		stripe int
+	}
+	byKey := make(map[shardKey]*shard)
+	var shards []*shard
+	for _, log := range logs {
+		start, stripe := log.Timestamp.Truncate(ls.window), ls.stripeOf(log)
+		sh, ok := byKey[shardKey{start.UnixNano(), stripe}]
+		if !ok {
+			sh = newShard(start, ls.window)
+			sh.stripe = stripe
+			byKey[shardKey{start.UnixNano(), stripe}] = sh
+			shards = append(shards, sh)
+		}
+		sh.appendLocked(log)
+	}
+	slices.SortFunc(shards, func(a, b *shard) int { return a.compare(b.start, b.stripe) })
+
+	ls.mu.Lock()
+	ls.shards = shards
+	var cold []*coldSegment
+	if ls.cold != nil {
+		cold, ls.cold.segments = ls.cold.segments, nil
+		ls.cold.updateGauges()
+	}
+	ls.mu.Unlock()
+	ls.invalidateCache()
+
+	for _, seg := range cold {
+		if err := seg.retire(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleSnapshot streams every log of the request's tenant as a snapshot file
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	tenant := s.tenant(r.Context())
+	results, err := tenant.storage.Select(r.Context(), map[string]string{}, QueryOptions{})
+	if err != nil {
+		writeQueryError(w, err, PartialResponse{})
+		return
+	}
+	created := time.Now().UTC()
+
+	// The download outlives the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logingestor-%s-%s.ndjson.gz"`, tenant.ID, created.Format("20060102T150405Z")))
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	if err := encoder.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, Tenant: tenant.ID, Created: created, Logs: results.Len()}); err != nil {
+		return
+	}
+	results.Each(func(log Log) bool {
+		return encoder.Encode(log) == nil // false once the client went away
+	})
+	gz.Close()
+}
+
+// RestoreResponse is the body of POST /admin/restore
+type RestoreResponse struct {
+	Mode     string `json:"mode"`
+	Restored int    `json:"restored"`
+	Rejected int    `json:"rejected"`
+}
+
+// readSnapshot decodes a snapshot, gzip-compressed or not, validating each log;
+// invalid logs are counted and skipped
+func (s *Server) readSnapshot(body io.Reader) (logs []Log, rejected int, err error) {
+	reader := bufio.NewReader(body)
+	if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer zr.Close()
+		reader = bufio.NewReader(zr)
+	}
+
+	limited := &io.LimitedReader{R: reader, N: s.cfg.MaxRestoreSize + 1}
+	decoder := json.NewDecoder(limited)
+
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, 0, fmt.Errorf("reading snapshot header: %v", err)
+	}
+	if header.Format != snapshotFormat {
+		return nil, 0, errors.New("not a logingestor snapshot")
+	}
+	if header.Version != snapshotVersion {
+		return nil, 0, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	logs = make([]Log, 0, header.Logs)
+	for line := 2; ; line++ {
+		var log Log
+		err := decoder.Decode(&log)
+		if err == io.EOF {
+			break
+		}
+		if limited.N <= 0 {
+			return nil, 0, fmt.Errorf("snapshot exceeds max-restore-size (%d bytes)", s.cfg.MaxRestoreSize)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if s.validator.Validate(&log) != nil {
+			rejected++
+			continue
+		}
+		logs = append(logs, log)
+	}
+
+	return logs, rejected, nil
+}
+
+// handleRestore loads a snapshot into the request's tenant. mode=replace (the
+// default) discards the logs held before; mode=merge adds the snapshot's logs.
+// The snapshot is decoded completely before anything is changed.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "replace"
+	}
+	if mode != "replace" && mode != "merge" {
+		writeValidationError(w, fmt.Errorf("mode %q must be replace or merge", mode))
+		return
+	}
+
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+	logs, rejected, err := s.readSnapshot(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeMalformedBody, "Invalid snapshot", err.Error())
+		return
+	}
+
+	tenant := s.tenant(r.Context())
+	if mode == "replace" {
+		err = tenant.storage.Replace(logs)
+	} else {
+		for batch := range slices.Chunk(logs, snapshotRestoreBatch) {
+			if err = tenant.storage.IngestBatch(batch); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		writeInternalError(w, "Error restoring snapshot: "+err.Error())
+		return
+	}
+
+	response, err := json.Marshal(RestoreResponse{Mode: mode, Restored: len(logs), Rejected: rejected})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,93 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Ordering of query results by timestamp and the other log fields
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortKey orders query results by one field
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// sortableFields lists the fields accepted by the sort option
+var sortableFields = map[string]bool{
+	"timestamp":                 true,
+	"level":                     true,
+	"message":                   true,
+	"resourceId":                true,
+	"traceId":                   true,
+	"spanId":                    true,
+	"commit":                    true,
+	"metadata.parentResourceId": true,
+	"ingestedAt":                true,
+}
+
+// parseSort parses a sort option such as "timestamp:desc,level:asc"; the
+// direction defaults to ascending
+func parseSort(value string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, part := range strings.Split(value, ",") {
+		field, dir, _ := strings.Cut(strings.TrimSpace(part), ":")
+		if !sortableFields[field] && !isMetadataPath(field) && customField(field) == nil {
+			return nil, fmt.Errorf("unknown sort field %q", field)
+		}
+
+		key := SortKey{Field: field}
+		switch dir {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("unknown sort direction %q", dir)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// compareLogs orders two log entries by a single sort key
+func compareLogs(a, b Log, key SortKey) int {
+	var c int
+	if key.Field == "timestamp" {
+		c = a.Timestamp.Compare(b.Timestamp)
+	} else if key.Field == "ingestedAt" {
+		c = a.IngestedAt.Compare(b.IngestedAt)
+	} else if field := customField(key.Field); field != nil {
+		c = compareCustom(field, a, b)
+	} else {
+		c = strings.Compare(fieldValue(a, key.Field), fieldValue(b, key.Field))
+	}
+
+	if key.Desc {
+		return -c
+	}
+	return c
+}
+
+// sortLogs orders log entries by the sort keys, keeping ingestion order for ties
+func sortLogs(logs []Log, keys []SortKey) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		for _, key := range keys {
+			if c := compareLogs(logs[i], logs[j], key); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// isTimestampSort reports whether the keys order by timestamp alone, which the
+// timestamp index can serve without sorting the matches
+func isTimestampSort(keys []SortKey) bool {
+	return len(keys) == 1 && keys[0].Field == "timestamp"
+}
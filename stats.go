@@ -0,0 +1,229 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : /admin/stats: the state of a tenant's storage, indexes and queues as JSON
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// StatsReporter is implemented by a Storage that can describe its files
+type StatsReporter interface {
+	StorageStats() (StorageStats, error)
+}
+
+// StorageStats describes the files of a Storage
+type StorageStats struct {
+	Engine   string `json:"engine"`
+	Segments int    `json:"segments"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// IndexStats sizes the in-memory indexes of the hot tier
+type IndexStats struct {
+	Values   map[string]int `json:"values"` // distinct values per indexed field
+	Postings int            `json:"postings"`
+	Terms    int            `json:"terms"` // distinct words of the message index
+}
+
+// QueueStats is the fill of a bounded queue
+type QueueStats struct {
+	Depth int `json:"depth"`
+	Size  int `json:"size"`
+}
+
+// MemoryStats is the memory use of the whole process, shared by every tenant
+type MemoryStats struct {
+	HeapAlloc  uint64 `json:"heap_alloc_bytes"`
+	HeapInuse  uint64 `json:"heap_inuse_bytes"`
+	Sys        uint64 `json:"sys_bytes"`
+	NumGC      uint32 `json:"num_gc"`
+	Goroutines int    `json:"goroutines"`
+}
+
+// Stats is the response body of /admin/stats
+type Stats struct {
+	Tenant       string         `json:"tenant"`
+	Logs         int            `json:"logs"`
+	HotLogs      int            `json:"hot_logs"`
+	ColdLogs     int            `json:"cold_logs"`
+	Levels       map[string]int `json:"levels"`
+	Oldest       time.Time      `json:"oldest,omitzero"`
+	Newest       time.Time      `json:"newest,omitzero"`
+	HotBytes     int64          `json:"hot_bytes"` // approximate size of the logs in memory
+	Shards       int            `json:"shards"`
+	ColdSegments int            `json:"cold_segments"`
+	ColdBytes    int64          `json:"cold_bytes"`
+	Storage      *StorageStats  `json:"storage,omitempty"`
+	Index        IndexStats     `json:"index"`
+
+	IngestQueue *QueueStats           `json:"ingest_queue,omitempty"`
+	Sinks       map[string]QueueStats `json:"sinks,omitempty"`
+	DeadLetters *int                  `json:"dead_letters,omitempty"`
+	DedupKeys   *int                  `json:"dedup_keys,omitempty"`
+	Tails       int                   `json:"tails"`
+	Memory      MemoryStats           `json:"memory"`
+}
+
+// Stats returns the counts, time range and index sizes of the stored logs; level
+// counts of cold files written before they were recorded are missing
+func (ls *LogStorage) Stats() (Stats, error) {
+	stats := Stats{Levels: make(map[string]int), Index: IndexStats{Values: make(map[string]int)}}
+	widen := func(oldest, newest time.Time) {
+		if stats.Oldest.IsZero() || oldest.Before(stats.Oldest) {
+			stats.Oldest = oldest
+		}
+		if newest.After(stats.Newest) {
+			stats.Newest = newest
+		}
+	}
+
+	ls.mu.RLock()
+	shards := slices.Clone(ls.shards)
+	var segments []*coldSegment
+	if ls.cold != nil {
+		segments = slices.Clone(ls.cold.segments)
+	}
+	ls.mu.RUnlock()
+
+	for _, sh := range shards {
+		sh.mu.RLock()
+		stats.Shards++
+		stats.HotLogs += len(sh.logs)
+		stats.HotBytes += sh.bytes
+		if len(sh.byTime) > 0 {
+			widen(sh.logs[sh.byTime[0]].Timestamp, sh.logs[sh.byTime[len(sh.byTime)-1]].Timestamp)
+		}
+		for level, postings := range sh.index["level"] {
+			stats.Levels[level] += len(postings)
+		}
+		for field, values := range sh.index {
+			stats.Index.Values[field] += len(values)
+			for _, postings := range values {
+				stats.Index.Postings += len(postings)
+			}
+		}
+		stats.Index.Terms += len(sh.messages)
+		sh.mu.RUnlock()
+	}
+
+	for _, seg := range segments {
+		stats.ColdSegments++
+		stats.ColdLogs += seg.footer.Count
+		for level, n := range seg.footer.Levels {
+			stats.Levels[level] += n
+		}
+		for _, block := range seg.footer.Blocks {
+			widen(block.Oldest, block.Newest)
+		}
+		if info, err := os.Stat(seg.path); err == nil {
+			stats.ColdBytes += info.Size()
+		}
+	}
+	stats.Logs = stats.HotLogs + stats.ColdLogs
+	stats.Oldest, stats.Newest = stats.Oldest.UTC(), stats.Newest.UTC()
+
+	if reporter, ok := ls.store.(StatsReporter); ok {
+		storage, err := reporter.StorageStats()
+		if err != nil {
+			return stats, err
+		}
+		stats.Storage = &storage
+	}
+
+	ls.tail.mu.Lock()
+	stats.Tails = len(ls.tail.subs)
+	ls.tail.mu.Unlock()
+	if ls.dedup != nil {
+		ls.dedup.mu.Lock()
+		keys := len(ls.dedup.entries)
+		ls.dedup.mu.Unlock()
+		stats.DedupKeys = &keys
+	}
+
+	return stats, nil
+}
+
+// StorageStats counts the WAL segments and their bytes
+func (w *WAL) StorageStats() (StorageStats, error) {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return StorageStats{}, err
+	}
+
+	stats := StorageStats{Engine: "wal", Segments: len(segments)}
+	for _, n := range segments {
+		if info, err := os.Stat(w.segmentPath(n)); err == nil {
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// StorageStats reports the size of the NDJSON file
+func (fs *FileStorage) StorageStats() (StorageStats, error) {
+	info, err := os.Stat(fs.path)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	return StorageStats{Engine: "file", Segments: 1, Bytes: info.Size()}, nil
+}
+
+// memoryStats reads the memory use of the process
+func memoryStats() MemoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return MemoryStats{HeapAlloc: m.HeapAlloc, HeapInuse: m.HeapInuse, Sys: m.Sys, NumGC: m.NumGC, Goroutines: runtime.NumGoroutine()}
+}
+
+// handleStats reports the stats of the request's tenant along with the queues
+// feeding and draining it and the memory use of the process
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	tenant := s.tenant(r.Context())
+
+	stats, err := tenant.storage.Stats()
+	if err != nil {
+		writeInternalError(w, "Error reading storage stats")
+		return
+	}
+	stats.Tenant = tenant.ID
+	stats.Memory = memoryStats()
+
+	if s.queue != nil {
+		// The queue is shared by every tenant
+		stats.IngestQueue = &QueueStats{Depth: int(s.queue.Depth()), Size: s.queue.Size()}
+	}
+	for _, sink := range s.sinks {
+		if sink.Tenant == tenant.ID {
+			if stats.Sinks == nil {
+				stats.Sinks = make(map[string]QueueStats)
+			}
+			stats.Sinks[sink.Name] = QueueStats{Depth: len(sink.queue), Size: cap(sink.queue)}
+		}
+	}
+	if s.deadLetters != nil {
+		n := s.deadLetters.Len()
+		stats.DeadLetters = &n
+	}
+
+	response, err := json.Marshal(stats)
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
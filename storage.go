@@ -0,0 +1,182 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Pluggable persistence layer so ingested logs survive a restart of the log ingestor
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage durably persists log entries for a LogStorage
+type Storage interface {
+	// Append writes the log entries durably before returning
+	Append(logs ...Log) error
+	// Load replays every stored log entry in ingestion order
+	Load(fn func(Log)) error
+	// Close releases the underlying resources
+	Close() error
+}
+
+// FileStorage is an append-only file of newline-delimited JSON log entries
+type FileStorage struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileStorage opens (or creates) the append-only log file at path
+func NewFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{path: path, file: file}, nil
+}
+
+// Append writes the log entries to the end of the file and syncs it to disk
+func (fs *FileStorage) Append(logs ...Log) error {
+	var buf bytes.Buffer
+	for _, log := range logs {
+		line, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, err := fs.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	return fs.file.Sync()
+}
+
+// Load reads the file from the start and calls fn for every log entry.
+// A truncated final line, left behind by a crash mid-write, is ignored.
+func (fs *FileStorage) Load(fn func(Log)) error {
+	file, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var log Log
+		if err := json.Unmarshal(line, &log); err != nil {
+			return fmt.Errorf("%s:%d: %v", fs.path, lineNo, err)
+		}
+		fn(log)
+	}
+}
+
+// ReplaceAll writes logs to a new file that then takes the place of the current one
+func (fs *FileStorage) ReplaceAll(logs ...Log) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.replaceLocked(logs)
+}
+
+// Purge rewrites the file without the deleted logs, when it holds any
+func (fs *FileStorage) Purge(ctx context.Context, deleted func(Log) bool) ([]PurgedFile, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var kept []Log
+	removed := 0
+	if err := fs.Load(func(log Log) {
+		if deleted(log) {
+			removed++
+		} else {
+			kept = append(kept, log)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, nil
+	}
+	if err := fs.replaceLocked(kept); err != nil {
+		return nil, err
+	}
+	return []PurgedFile{{Tier: "file", File: filepath.Base(fs.path), Removed: removed}}, nil
+}
+
+// replaceLocked writes logs to a new file that then takes the place of the
+// current one; the caller holds fs.mu
+func (fs *FileStorage) replaceLocked(logs []Log) error {
+	tmp := fs.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, log := range logs {
+		if err = encoder.Encode(log); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fs.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fs.file.Close()
+	fs.file, err = os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Close syncs and closes the underlying file
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.file.Sync(); err != nil {
+		fs.file.Close()
+		return err
+	}
+
+	return fs.file.Close()
+}
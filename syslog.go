@@ -0,0 +1,335 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Syslog (RFC 5424) listener on UDP and TCP feeding the LogStorage
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSyslogMessage bounds a single syslog message, matching the largest UDP datagram
+const maxSyslogMessage = 64 << 10
+
+var (
+	syslogMessages = metrics.Counter("logingestor_syslog_messages_total", "Syslog messages received")
+	syslogRejected = metrics.Counter("logingestor_syslog_rejected_total", "Syslog messages that could not be parsed or stored")
+)
+
+// syslogLevels maps syslog severities 0-7 to log levels
+var syslogLevels = [8]string{"fatal", "fatal", "fatal", "error", "warn", "info", "info", "debug"}
+
+// SyslogMessage is a parsed RFC 5424 message
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string // SD-ID -> param -> value
+	Message        string
+}
+
+// parseSyslog parses an RFC 5424 message:
+// <PRI>VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parseSyslog(line string) (SyslogMessage, error) {
+	var msg SyslogMessage
+
+	if !strings.HasPrefix(line, "<") {
+		return msg, errors.New("missing PRI")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return msg, errors.New("malformed PRI")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return msg, errors.New("malformed PRI")
+	}
+	msg.Facility, msg.Severity = pri/8, pri%8
+
+	rest := line[end+1:]
+	fields := make([]string, 6)
+	for i := range fields {
+		var ok bool
+		fields[i], rest, ok = strings.Cut(rest, " ")
+		if !ok && i < len(fields)-1 {
+			return msg, errors.New("truncated header")
+		}
+	}
+	if fields[0] != "1" {
+		return msg, fmt.Errorf("unsupported syslog version %q", fields[0])
+	}
+
+	if fields[1] != "-" {
+		msg.Timestamp, err = time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return msg, fmt.Errorf("malformed timestamp %q", fields[1])
+		}
+	}
+	msg.Hostname = nilValue(fields[2])
+	msg.AppName = nilValue(fields[3])
+	msg.ProcID = nilValue(fields[4])
+	msg.MsgID = nilValue(fields[5])
+
+	msg.StructuredData, rest, err = parseStructuredData(rest)
+	if err != nil {
+		return msg, err
+	}
+
+	rest = strings.TrimPrefix(rest, " ")
+	msg.Message = strings.TrimRight(strings.TrimPrefix(rest, "\ufeff"), "\r\n")
+
+	return msg, nil
+}
+
+// nilValue maps the RFC 5424 NILVALUE "-" to an empty string
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parseStructuredData parses the STRUCTURED-DATA part, e.g. [id@1 key="value"][id2 k="v"]
+// or "-", and returns the unparsed remainder
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, s[1:], nil
+	}
+
+	data := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		params := make(map[string]string)
+		data[s[:idEnd]] = params
+		s = s[idEnd:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, "=\"")
+			if eq < 0 {
+				return nil, "", errors.New("malformed structured data parameter")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			// PARAM-VALUE escapes '"', '\' and ']' with a backslash
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					s, closed = s[i+1:], true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, "", errors.New("unterminated structured data value")
+			}
+			params[name] = value.String()
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errors.New("unterminated structured data element")
+		}
+		s = s[1:]
+	}
+
+	return data, s, nil
+}
+
+// toLog maps a syslog message onto the log schema: severity to level, hostname
+// (or the sender address) to resourceId, and the well-known structured data
+// parameters traceId, spanId, commit and parentResourceId to their fields
+func (msg SyslogMessage) toLog(sender string) Log {
+	log := Log{
+		Level:      syslogLevels[msg.Severity],
+		Message:    msg.Message,
+		ResourceID: msg.Hostname,
+		Timestamp:  msg.Timestamp,
+	}
+	if log.ResourceID == "" {
+		log.ResourceID = sender
+	}
+	if log.Timestamp.IsZero() {
+		log.Timestamp = time.Now().UTC()
+	}
+
+	for _, params := range msg.StructuredData {
+		for name, value := range params {
+			switch name {
+			case "traceId":
+				log.TraceID = value
+			case "spanId":
+				log.SpanID = value
+			case "commit":
+				log.Commit = value
+			case "parentResourceId":
+				log.Metadata.ParentResourceID = value
+			}
+		}
+	}
+
+	return log
+}
+
+// SyslogListener receives syslog messages on UDP and TCP
+type SyslogListener struct {
+	udp       net.PacketConn
+	tcp       net.Listener
+	ingest    func(logs []Log) error
+	validator Validator
+	reject    rejectFunc
+}
+
+// ListenSyslog binds the UDP and TCP sockets for addr, e.g. ":514"; valid
+// messages are passed to ingest
+func ListenSyslog(addr string, ingest func(logs []Log) error, validator Validator, reject rejectFunc) (*SyslogListener, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	return &SyslogListener{udp: udp, tcp: tcp, ingest: ingest, validator: validator, reject: reject}, nil
+}
+
+// Serve receives messages until ctx is cancelled and open connections are drained
+func (sl *SyslogListener) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		sl.udp.Close()
+		sl.tcp.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Go(func() { sl.serveUDP(sl.udp) })
+	wg.Go(func() { sl.serveTCP(ctx, sl.tcp) })
+	wg.Wait()
+}
+
+// serveUDP handles one message per datagram
+func (sl *SyslogListener) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		host, _, _ := net.SplitHostPort(addr.String())
+		sl.handle(string(buf[:n]), host)
+	}
+}
+
+// serveTCP accepts connections until the listener is closed; open connections
+// are closed when ctx is cancelled
+func (sl *SyslogListener) serveTCP(ctx context.Context, listener net.Listener) {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conns.Go(func() {
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			sl.serveConn(conn)
+		})
+	}
+}
+
+// serveConn reads messages framed by octet counting ("<len> <msg>") or, per
+// RFC 6587, terminated by a newline
+func (sl *SyslogListener) serveConn(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	reader := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		next, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var frame string
+		if next[0] >= '1' && next[0] <= '9' {
+			prefix, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(prefix))
+			if err != nil || length > maxSyslogMessage {
+				syslogRejected.Inc()
+				return
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			frame = string(buf)
+		} else {
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return
+			}
+			frame = line
+		}
+
+		sl.handle(frame, host)
+	}
+}
+
+// handle parses, validates and stores one message
+func (sl *SyslogListener) handle(frame, sender string) {
+	frame = strings.TrimRight(frame, "\r\n\x00")
+	if frame == "" {
+		return
+	}
+	syslogMessages.Inc()
+
+	msg, err := parseSyslog(frame)
+	if err != nil {
+		syslogRejected.Inc()
+		sl.reject("syslog", sender, err.Error(), []byte(frame))
+		return
+	}
+
+	log := msg.toLog(sender)
+	if err := sl.validator.Validate(&log); err != nil {
+		syslogRejected.Inc()
+		sl.reject("syslog", sender, rejectionReason(err), []byte(frame))
+		return
+	}
+	if err := sl.ingest([]Log{log}); err != nil {
+		syslogRejected.Inc()
+		logger.Error("storing syslog log failed", "error", err)
+	}
+}
@@ -0,0 +1,219 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Live tail of newly ingested logs streamed over Server-Sent Events at /tail
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tailHeartbeat is how often an idle tail sends a comment to keep proxies from closing it
+const tailHeartbeat = 15 * time.Second
+
+// Slow consumer policies: what happens when a subscriber's buffer is full
+const (
+	TailDisconnect = "disconnect" // end the stream so the client can reconnect
+	TailDrop       = "drop"       // skip the log and keep streaming
+)
+
+var (
+	tailSubscribers = metrics.Gauge("logingestor_tail_subscribers", "Open /tail streams")
+	tailDropped     = metrics.Counter("logingestor_tail_dropped_total", "Logs not delivered to a /tail stream because its buffer was full")
+	tailSlowClosed  = metrics.Counter("logingestor_tail_slow_disconnects_total", "/tail streams closed because the client did not keep up")
+)
+
+// Subscription receives the newly ingested logs accepted by its match function
+type Subscription struct {
+	logs  chan Log
+	done  chan struct{} // closed when the subscription ends
+	err   error         // why it ended, set before done is closed
+	match func(Log) bool
+	drop  bool // TailDrop policy
+	once  sync.Once
+}
+
+// end closes the subscription with a reason; only the first call has an effect
+func (sub *Subscription) end(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+// tailHub fans ingested logs out to the subscriptions
+type tailHub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+var (
+	errSlowConsumer = errors.New("client too slow, tail closed")
+	errTailShutdown = errors.New("server shutting down")
+)
+
+// Subscribe starts delivering newly ingested logs accepted by match, buffering up
+// to buffer logs; policy decides what happens when the buffer is full
+func (ls *LogStorage) Subscribe(match func(Log) bool, buffer int, policy string) *Subscription {
+	sub := &Subscription{
+		logs:  make(chan Log, buffer),
+		done:  make(chan struct{}),
+		match: match,
+		drop:  policy == TailDrop,
+	}
+
+	ls.tail.mu.Lock()
+	defer ls.tail.mu.Unlock()
+	if ls.tail.subs == nil {
+		ls.tail.subs = make(map[*Subscription]struct{})
+	}
+	ls.tail.subs[sub] = struct{}{}
+	tailSubscribers.Add(1)
+
+	return sub
+}
+
+// Unsubscribe stops delivery to sub
+func (ls *LogStorage) Unsubscribe(sub *Subscription) {
+	ls.tail.mu.Lock()
+	defer ls.tail.mu.Unlock()
+
+	if _, ok := ls.tail.subs[sub]; ok {
+		delete(ls.tail.subs, sub)
+		tailSubscribers.Add(-1)
+	}
+	sub.end(nil)
+}
+
+// EndSubscriptions ends every subscription, e.g. when the server shuts down
+func (ls *LogStorage) EndSubscriptions() {
+	ls.tail.mu.Lock()
+	defer ls.tail.mu.Unlock()
+
+	for sub := range ls.tail.subs {
+		sub.end(errTailShutdown)
+	}
+}
+
+// publish offers newly ingested logs to every subscription without blocking
+func (ls *LogStorage) publish(logs []Log) {
+	ls.tail.mu.Lock()
+	defer ls.tail.mu.Unlock()
+
+	for sub := range ls.tail.subs {
+		for _, log := range logs {
+			if !sub.match(log) {
+				continue
+			}
+			select {
+			case sub.logs <- log:
+			case <-sub.done:
+			default:
+				tailDropped.Inc()
+				if !sub.drop {
+					tailSlowClosed.Inc()
+					sub.end(errSlowConsumer)
+				}
+			}
+		}
+	}
+}
+
+// handleTail streams newly ingested logs matching the filters of the URL query
+// (the /query filter keys and "q") as Server-Sent Events
+func (s *Server) handleTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	fields, err := urlQueryFields(r.URL.Query())
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	req, err := buildQuery(fields, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.Context != nil || req.CollapseBy != "" {
+		writeError(w, http.StatusBadRequest, ErrCodeValidation, "limit, offset, page_token, sort, context and collapse_by do not apply to /tail", nil)
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	rc.SetWriteDeadline(time.Time{})
+
+	storage := s.tenant(r.Context()).storage
+	sub := storage.Subscribe(func(log Log) bool {
+		return req.Options.Scope.allows(log) && matchesQuery(log, req.Filters, req.Options.Expr)
+	}, s.cfg.TailBuffer, s.cfg.TailSlowConsumer)
+	defer storage.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	sent := 0 // logs streamed, for the audit log
+	defer func() { auditResults(r.Context(), sent) }()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			if sub.err != nil {
+				fmt.Fprintf(w, "event: close\ndata: %s\n\n", sub.err)
+				rc.Flush()
+			}
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case log := <-sub.logs:
+			data, err := json.Marshal(log)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			sent++
+			// Send everything already buffered before flushing
+			for drained := false; !drained; {
+				select {
+				case log := <-sub.logs:
+					if data, err := json.Marshal(log); err == nil {
+						fmt.Fprintf(w, "data: %s\n\n", data)
+						sent++
+					}
+				default:
+					drained = true
+				}
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,211 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Multi-tenancy: one isolated LogStorage and retention policy per tenant
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultTenant receives the requests naming no tenant, as well as syslog and Kafka logs
+const DefaultTenant = "default"
+
+// tenantHeader names the tenant of requests whose API key is not bound to one
+const tenantHeader = "X-Tenant-ID"
+
+// tenantIDPattern restricts tenant ids to names that are safe as directory names
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Tenant is an isolated partition of the logs with its own storage and retention
+type Tenant struct {
+	ID      string
+	storage *LogStorage
+	policy  RetentionPolicy
+}
+
+// Tenants holds every configured tenant; the set is fixed at startup
+type Tenants struct {
+	byID map[string]*Tenant
+	def  *Tenant
+}
+
+// parseTenant splits a tenants entry, "id" or "id:retention"
+func parseTenant(entry string) (id, retention string) {
+	id, retention, _ = strings.Cut(entry, ":")
+	return id, retention
+}
+
+// OpenTenants opens the storage of the default tenant, under data-dir as before,
+// and of each configured tenant under data-dir/tenants/<id>
+func OpenTenants(cfg Config) (*Tenants, error) {
+	base := RetentionPolicy{MaxAge: cfg.Retention, MaxEntries: cfg.RetentionMaxEntries, MaxBytes: cfg.RetentionMaxBytes}
+
+	t := &Tenants{byID: make(map[string]*Tenant)}
+	def, err := openTenant(cfg, DefaultTenant, cfg.DataDir, base)
+	if err != nil {
+		return nil, err
+	}
+	t.def = def
+	t.byID[DefaultTenant] = def
+
+	for _, entry := range cfg.Tenants {
+		id, retention := parseTenant(entry)
+		policy := base
+		if retention != "" {
+			policy.MaxAge, _ = parseDuration(retention) // checked by Config.Validate
+		}
+
+		dir := cfg.DataDir
+		if dir != "" {
+			dir = filepath.Join(cfg.DataDir, "tenants", id)
+		}
+		tenant, err := openTenant(cfg, id, dir, policy)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("tenant %s: %v", id, err)
+		}
+		t.byID[id] = tenant
+	}
+
+	return t, nil
+}
+
+// openTenant opens the storage of a tenant in dir; an empty dir keeps it in memory
+func openTenant(cfg Config, id, dir string, policy RetentionPolicy) (*Tenant, error) {
+	// With rollups the policy evicts logs past rollup-after into summaries, which
+	// the retention expires; a tenant retention not above it leaves them off
+	summaryAge := policy.MaxAge
+	rollup := cfg.RollupAfter > 0 && (summaryAge == 0 || cfg.RollupAfter < summaryAge)
+	if rollup {
+		policy.MaxAge = cfg.RollupAfter
+	}
+
+	tenant := &Tenant{ID: id, storage: NewLogStorage(cfg.ShardWindow, cfg.ShardStripes), policy: policy}
+	if dir != "" {
+		cfg.DataDir = dir
+		store, err := openStorage(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("opening storage: %v", err)
+		}
+		var cold *coldTier
+		if cfg.HotWindow > 0 {
+			if cold, err = openColdTier(id, filepath.Join(dir, "cold"), cfg.HotWindow); err != nil {
+				return nil, fmt.Errorf("opening the cold tier: %v", err)
+			}
+		}
+		deleted, err := openTombstones(dir)
+		if err != nil {
+			return nil, fmt.Errorf("loading tombstones: %v", err)
+		}
+		tenant.storage, err = OpenLogStorage(store, cold, deleted, cfg.ShardWindow, policy.MaxAge, cfg.ShardStripes)
+		if err != nil {
+			return nil, fmt.Errorf("loading stored logs: %v", err)
+		}
+	}
+	if rollup {
+		var err error
+		if tenant.storage.rollups, err = openRollups(dir, cfg.RollupExemplars, summaryAge); err != nil {
+			return nil, fmt.Errorf("loading rollups: %v", err)
+		}
+	}
+	if cfg.QueryCacheSize > 0 {
+		tenant.storage.EnableQueryCache(cfg.QueryCacheSize)
+	}
+	if cfg.DedupWindow > 0 {
+		tenant.storage.EnableDedup(id, cfg.DedupWindow, cfg.DedupMaxKeys)
+	}
+	if cfg.PatternMaxEntries > 0 {
+		var err error
+		if tenant.storage.patterns, err = OpenPatternMiner(cfg, dir); err != nil {
+			return nil, fmt.Errorf("loading patterns: %v", err)
+		}
+	}
+
+	return tenant, nil
+}
+
+// Default returns the default tenant
+func (t *Tenants) Default() *Tenant { return t.def }
+
+// All returns every tenant, the default one first and the rest by id
+func (t *Tenants) All() []*Tenant {
+	all := make([]*Tenant, 0, len(t.byID))
+	for _, tenant := range t.byID {
+		if tenant != t.def {
+			all = append(all, tenant)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+
+	return append([]*Tenant{t.def}, all...)
+}
+
+// EndSubscriptions ends the live tails of every tenant
+func (t *Tenants) EndSubscriptions() {
+	for _, tenant := range t.All() {
+		tenant.storage.EndSubscriptions()
+	}
+}
+
+// Close flushes and closes the storage of every tenant, returning the first error
+func (t *Tenants) Close() error {
+	var firstErr error
+	for _, tenant := range t.byID {
+		if err := tenant.storage.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("tenant %s: %v", tenant.ID, err)
+		}
+	}
+	return firstErr
+}
+
+// resolve picks the tenant of a request: its key's own, else X-Tenant-ID (or
+// X-Scope-OrgID on the Loki API), else the default; a bound key may not name another
+func (t *Tenants) resolve(r *http.Request, p *principal) (*Tenant, *authError) {
+	id := r.Header.Get(tenantHeader)
+	if id == "" && strings.HasPrefix(r.URL.Path, "/loki/") {
+		id = r.Header.Get(lokiTenantHeader)
+	}
+	if p != nil && p.Tenant != "" {
+		if id != "" && id != p.Tenant {
+			return nil, &authError{status: http.StatusForbidden, code: ErrCodeForbidden,
+				message: fmt.Sprintf("API key is bound to tenant %q", p.Tenant)}
+		}
+		id = p.Tenant
+	}
+	if id == "" {
+		return t.def, nil
+	}
+
+	tenant, ok := t.byID[id]
+	if !ok {
+		return nil, &authError{status: http.StatusNotFound, code: ErrCodeNotFound, message: fmt.Sprintf("Unknown tenant %q", id)}
+	}
+
+	return tenant, nil
+}
+
+// tenantKey is the request context key of the resolved tenant
+type tenantKey struct{}
+
+// withTenant returns ctx carrying the tenant of the request
+func withTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenant returns the tenant of the request, the default one when not resolved
+func (s *Server) tenant(ctx context.Context) *Tenant {
+	if tenant, ok := ctx.Value(tenantKey{}).(*Tenant); ok {
+		return tenant
+	}
+
+	return s.tenants.Default()
+}
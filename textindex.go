@@ -0,0 +1,73 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Trigram inverted index over log messages for fast substring search
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// textIndex maps every trigram of the lowercased message to the sorted positions of
+// the logs containing it. Any substring of three or more bytes can be resolved by
+// intersecting the posting lists of its trigrams, so both word and partial-word
+// searches are served without scanning every message.
+type textIndex map[string][]int
+
+// newTextIndex creates an empty message index
+func newTextIndex() textIndex {
+	return make(textIndex)
+}
+
+// trigrams returns the distinct trigrams of the lowercased text
+func trigrams(text string) []string {
+	text = strings.ToLower(text)
+	if len(text) < 3 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(text)-2)
+	result := make([]string, 0, len(text)-2)
+	for i := 0; i+3 <= len(text); i++ {
+		gram := text[i : i+3]
+		if !seen[gram] {
+			seen[gram] = true
+			result = append(result, gram)
+		}
+	}
+
+	return result
+}
+
+// add records the message of the log entry stored at position pos
+func (idx textIndex) add(message string, pos int) {
+	for _, gram := range trigrams(message) {
+		idx[gram] = append(idx[gram], pos)
+	}
+}
+
+// lookup returns the positions of messages that may contain the query. The result
+// is a superset that must still be verified; ok is false when the query is too
+// short to have trigrams and a full scan is needed.
+func (idx textIndex) lookup(query string) (positions []int, ok bool) {
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	// Intersect the rarest trigrams first to keep the working set small
+	sort.Slice(grams, func(i, j int) bool { return len(idx[grams[i]]) < len(idx[grams[j]]) })
+
+	positions = idx[grams[0]]
+	for _, gram := range grams[1:] {
+		if len(positions) == 0 {
+			break
+		}
+		positions = intersect(positions, idx[gram])
+	}
+
+	return positions, true
+}
@@ -0,0 +1,680 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Hot/cold tiering: past time windows move from memory to compressed block files on disk
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// coldExt is the file extension of cold tier files
+const coldExt = ".cold"
+
+// coldMagic starts and ends every cold file
+const coldMagic = "LGCOLD1\n"
+
+// coldBlockLogs is how many logs a block of a cold file holds; blocks are
+// read one at a time
+const coldBlockLogs = 4096
+
+var (
+	coldSegments = metrics.GaugeVec("logingestor_cold_segments", "Time windows held in the cold tier", "tenant")
+	coldLogs     = metrics.GaugeVec("logingestor_cold_logs", "Logs held in the cold tier", "tenant")
+	coldFlushed  = metrics.Counter("logingestor_cold_flushed_logs_total", "Logs moved from memory to the cold tier")
+)
+
+// coldBlock locates one compressed block of a cold file
+type coldBlock struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	Count  int       `json:"count"`
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+	CRC    uint32    `json:"crc,omitempty"` // CRC-32C of the compressed block
+}
+
+// errCorruptBlock is wrapped by the errors of cold blocks that cannot be decoded
+var errCorruptBlock = errors.New("corrupt block")
+
+// coldFooter describes a cold file; it is written after the blocks, so the
+// file is read without decompressing any block until a query needs it
+type coldFooter struct {
+	Encoding string                  `json:"encoding,omitempty"`
+	Checksum bool                    `json:"checksum,omitempty"` // blocks carry a CRC
+	Columns  int                     `json:"columns,omitempty"`  // per columnar block; legacyColumns when absent
+	Start    time.Time               `json:"start"`
+	End      time.Time               `json:"end"`
+	Count    int                     `json:"count"`
+	Bytes    int64                   `json:"bytes"`
+	Blocks   []coldBlock             `json:"blocks"`
+	Levels   map[string]int          `json:"levels,omitempty"`
+	Blooms   map[string]*bloomFilter `json:"blooms,omitempty"`
+}
+
+// coldSegment is the immutable cold file of one time window. Rewriting a window
+// creates a new segment; the old one is retired, its file deleted at once and
+// closed when the last reader is done with it.
+type coldSegment struct {
+	path    string
+	gen     int64
+	file    *os.File
+	footer  coldFooter
+	changed uint64 // latest change stamp of the shards flushed into it, see LogStorage.touch; guarded by LogStorage.mu
+
+	mu      sync.Mutex
+	refs    int
+	retired bool
+	corrupt map[int64]bool // offsets of the corrupt blocks reported
+}
+
+// acquire keeps the file open until release
+func (seg *coldSegment) acquire() {
+	seg.mu.Lock()
+	seg.refs++
+	seg.mu.Unlock()
+}
+
+func (seg *coldSegment) release() {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	if seg.refs--; seg.refs == 0 && seg.retired {
+		seg.file.Close()
+	}
+}
+
+// retire deletes the file; it is closed once no reader holds it
+func (seg *coldSegment) retire() error {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	seg.retired = true
+	if seg.refs == 0 {
+		seg.file.Close()
+	}
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// read returns the logs of the file that may match filters: nothing when its bloom
+// filters rule out a traceId, spanId or resourceId filter, else the blocks whose time
+// range misses the filters' are skipped, and columnar blocks also drop the logs
+// their equality filters rule out. Nil filters read every log. Corrupt blocks
+// are skipped and reported once.
+func (seg *coldSegment) read(filters map[string]string) ([]Log, error) {
+	if bloomExcludes(seg.footer.Blooms, filters) {
+		bloomSkipped.Inc()
+		return nil, nil
+	}
+	from, to := timeBounds(filters)
+	var logs []Log
+	for _, block := range seg.footer.Blocks {
+		if (!from.IsZero() && block.Newest.Before(from)) || (!to.IsZero() && block.Oldest.After(to)) {
+			continue
+		}
+		decoded, err := seg.readBlock(block, filters)
+		if errors.Is(err, errCorruptBlock) {
+			seg.mu.Lock()
+			reported := seg.corrupt[block.Offset]
+			if seg.corrupt == nil {
+				seg.corrupt = make(map[int64]bool)
+			}
+			seg.corrupt[block.Offset] = true
+			seg.mu.Unlock()
+			if !reported {
+				reportCorruption(Corruption{Tier: "cold", File: seg.path, Offset: block.Offset, Error: err.Error()})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, decoded...)
+	}
+
+	return logs, nil
+}
+
+// readBlock reads and decodes one block of the file; a block cut short, failing
+// its checksum or not decoding returns an error wrapping errCorruptBlock
+func (seg *coldSegment) readBlock(block coldBlock, filters map[string]string) ([]Log, error) {
+	data := make([]byte, block.Length)
+	if _, err := seg.file.ReadAt(data, block.Offset); errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: cut short", errCorruptBlock)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", seg.path, err)
+	}
+	if seg.footer.Checksum && crc32.Checksum(data, crcTable) != block.CRC {
+		return nil, fmt.Errorf("%w: checksum mismatch", errCorruptBlock)
+	}
+
+	var logs []Log
+	var err error
+	if seg.footer.Encoding == coldEncodingColumnar {
+		columns := seg.footer.Columns
+		if columns == 0 {
+			columns = legacyColumns
+		}
+		logs, err = decodeColumnarBlock(data, columns, filters)
+	} else {
+		logs, err = decodeColdBlock(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptBlock, err)
+	}
+	return logs, nil
+}
+
+// fsck reads every block of the file, checking that it decodes to as many logs
+// as the footer counts
+func (seg *coldSegment) fsck(report *FsckReport) {
+	report.Files++
+	for _, block := range seg.footer.Blocks {
+		report.Blocks++
+		logs, err := seg.readBlock(block, nil)
+		if err == nil && len(logs) != block.Count {
+			err = fmt.Errorf("%w: %d logs, the footer counts %d", errCorruptBlock, len(logs), block.Count)
+		}
+		if err != nil {
+			report.add(Corruption{Tier: "cold", File: seg.path, Offset: block.Offset, Error: err.Error()})
+			continue
+		}
+		report.Records += len(logs)
+	}
+}
+
+// decodeColdBlock decodes a gzip NDJSON block, the encoding of files written
+// before blocks became columnar
+func decodeColdBlock(data []byte) ([]Log, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var logs []Log
+	decoder := json.NewDecoder(gz)
+	for {
+		var log Log
+		if err := decoder.Decode(&log); err == io.EOF {
+			return logs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+}
+
+// coldTier is the on-disk tier of a LogStorage: the time windows that ended
+// more than hotWindow ago, one file each
+type coldTier struct {
+	dir       string
+	hotWindow time.Duration
+	gen       atomic.Int64
+	files     *Gauge // number of windows
+	logs      *Gauge
+
+	// Guarded by LogStorage.mu
+	segments []*coldSegment // ordered by start
+
+	// keys of each window's logs, built while the WAL is replayed on startup
+	loadKeys map[int64]map[string]bool
+}
+
+// openColdTier opens the cold files in dir, keeping the newest generation of
+// each window and deleting leftovers of interrupted rewrites
+func openColdTier(tenant, dir string, hotWindow time.Duration) (*coldTier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &coldTier{dir: dir, hotWindow: hotWindow, files: coldSegments.With(tenant), logs: coldLogs.With(tenant), loadKeys: make(map[int64]map[string]bool)}
+	newest := make(map[int64]*coldSegment)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, coldExt+".tmp") {
+			os.Remove(path)
+			continue
+		}
+		start, gen, ok := parseColdName(name)
+		if !ok {
+			continue
+		}
+		if gen > ct.gen.Load() {
+			ct.gen.Store(gen)
+		}
+		if prev, ok := newest[start]; ok {
+			if prev.gen > gen {
+				os.Remove(path)
+				continue
+			}
+			prev.retire()
+		}
+		seg, err := openColdSegment(path)
+		if err != nil {
+			ct.close()
+			return nil, err
+		}
+		seg.gen = gen
+		newest[start] = seg
+	}
+	for _, seg := range newest {
+		ct.segments = append(ct.segments, seg)
+	}
+	sort.Slice(ct.segments, func(i, j int) bool { return ct.segments[i].footer.Start.Before(ct.segments[j].footer.Start) })
+	ct.updateGauges()
+
+	return ct, nil
+}
+
+// parseColdName splits a cold file name, <window start in unix nanoseconds>-<generation>.cold
+func parseColdName(name string) (start, gen int64, ok bool) {
+	base, found := strings.CutSuffix(name, coldExt)
+	if !found {
+		return 0, 0, false
+	}
+	startPart, genPart, found := strings.Cut(base, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(startPart, 10, 64)
+	gen, err2 := strconv.ParseInt(genPart, 10, 64)
+	return start, gen, err1 == nil && err2 == nil
+}
+
+// openColdSegment opens a cold file and reads its footer
+func openColdSegment(path string) (*coldSegment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	footer, err := readColdFooter(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return &coldSegment{path: path, file: file, footer: footer}, nil
+}
+
+// readColdFooter reads the footer at the end of a cold file: the footer JSON,
+// its length as a uint32 and coldMagic
+func readColdFooter(file *os.File) (coldFooter, error) {
+	var footer coldFooter
+	info, err := file.Stat()
+	if err != nil {
+		return footer, err
+	}
+	trailer := make([]byte, 4+len(coldMagic))
+	if info.Size() < int64(2*len(coldMagic)+4) {
+		return footer, errors.New("not a cold file")
+	}
+	if _, err := file.ReadAt(trailer, info.Size()-int64(len(trailer))); err != nil {
+		return footer, err
+	}
+	if string(trailer[4:]) != coldMagic {
+		return footer, errors.New("not a cold file")
+	}
+	length := int64(binary.BigEndian.Uint32(trailer[:4]))
+	if length > info.Size()-int64(len(trailer)+len(coldMagic)) {
+		return footer, errors.New("corrupt footer length")
+	}
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, info.Size()-int64(len(trailer))-length); err != nil {
+		return footer, err
+	}
+	err = json.Unmarshal(data, &footer)
+	return footer, err
+}
+
+// write stores logs as a new generation of the window [start, end)
+func (ct *coldTier) write(start, end time.Time, logs []Log) (*coldSegment, error) {
+	gen := ct.gen.Add(1)
+	path := filepath.Join(ct.dir, fmt.Sprintf("%d-%d%s", start.UnixNano(), gen, coldExt))
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp) // a no-op once renamed
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, 64<<10)
+	writer.WriteString(coldMagic)
+	offset := int64(len(coldMagic))
+	footer := coldFooter{Encoding: coldEncodingColumnar, Checksum: true, Columns: numColumns, Start: start, End: end,
+		Blooms: newBloomFilters(logs), Levels: make(map[string]int)}
+	for i := 0; i < len(logs); i += coldBlockLogs {
+		blockLogs := logs[i:min(i+coldBlockLogs, len(logs))]
+		data, err := encodeColumnarBlock(blockLogs)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		block := coldBlock{Offset: offset, Length: int64(len(data)), Count: len(blockLogs), Oldest: blockLogs[0].Timestamp, Newest: blockLogs[0].Timestamp,
+			CRC: crc32.Checksum(data, crcTable)}
+		for _, log := range blockLogs {
+			if log.Timestamp.Before(block.Oldest) {
+				block.Oldest = log.Timestamp
+			}
+			if log.Timestamp.After(block.Newest) {
+				block.Newest = log.Timestamp
+			}
+			footer.Bytes += logSize(log)
+			footer.Levels[log.Level]++
+		}
+		footer.Blocks = append(footer.Blocks, block)
+		footer.Count += len(blockLogs)
+		offset += int64(len(data))
+	}
+
+	meta, err := json.Marshal(footer)
+	if err != nil {
+		return nil, err
+	}
+	writer.Write(meta)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(meta)))
+	writer.Write(length[:])
+	writer.WriteString(coldMagic)
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	seg, err := openColdSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	seg.gen = gen
+	return seg, nil
+}
+
+// segmentAt returns the segment of the window starting at start, nil if none;
+// the caller holds LogStorage.mu
+func (ct *coldTier) segmentAt(start time.Time) *coldSegment {
+	i := sort.Search(len(ct.segments), func(i int) bool { return !ct.segments[i].footer.Start.Before(start) })
+	if i < len(ct.segments) && ct.segments[i].footer.Start.Equal(start) {
+		return ct.segments[i]
+	}
+	return nil
+}
+
+// holds reports, while the WAL is replayed on startup, whether log already
+// belongs to the cold tier; the WAL keeps flushed logs until compaction
+func (ct *coldTier) holds(log Log, window time.Duration) bool {
+	start := log.Timestamp.Truncate(window)
+	seg := ct.segmentAt(start)
+	if seg == nil {
+		return false
+	}
+	keys, ok := ct.loadKeys[start.UnixNano()]
+	if !ok {
+		keys = make(map[string]bool)
+		if logs, err := seg.read(nil); err == nil {
+			for _, cold := range logs {
+				keys[logKey(cold)] = true
+			}
+		}
+		ct.loadKeys[start.UnixNano()] = keys
+	}
+
+	return keys[logKey(log)]
+}
+
+// updateGauges exports the size of the tier; the caller holds LogStorage.mu
+func (ct *coldTier) updateGauges() {
+	n := 0
+	for _, seg := range ct.segments {
+		n += seg.footer.Count
+	}
+	ct.files.Set(int64(len(ct.segments)))
+	ct.logs.Set(int64(n))
+}
+
+// close closes every cold file
+func (ct *coldTier) close() {
+	for _, seg := range ct.segments {
+		seg.file.Close()
+	}
+}
+
+// openShard returns sh, or for a cold window's placeholder a private shard holding
+// the window's logs that may match filters, including late logs of the window
+// still in memory
+func (ls *LogStorage) openShard(sh *shard, filters map[string]string) (*shard, error) {
+	if !sh.cold {
+		return sh, nil
+	}
+
+	ls.mu.RLock()
+	seg := ls.cold.segmentAt(sh.start)
+	late := slices.Clone(ls.windowShards(sh.start))
+	if seg != nil {
+		seg.acquire()
+	}
+	ls.mu.RUnlock()
+
+	opened := newShard(sh.start, ls.window)
+	if seg != nil {
+		logs, err := seg.read(filters)
+		seg.release()
+		if err != nil {
+			return nil, err
+		}
+		opened.appendLocked(ls.deleted.drop(logs)...)
+	}
+	for _, sh := range late {
+		sh.mu.RLock()
+		opened.appendLocked(sh.logs...)
+		sh.mu.RUnlock()
+	}
+
+	return opened, nil
+}
+
+// flushCold moves every shard whose window ended more than hot-window before now
+// to the cold tier, the stripes of a window into one cold file merged with the
+// window's file if it has one, and returns the number of logs moved. A window
+// that changes while it is written is left for the next pass.
+func (ls *LogStorage) flushCold(now time.Time) (int, error) {
+	cutoff := now.Add(-ls.cold.hotWindow)
+	ls.mu.RLock()
+	var due [][]*shard
+	for i := 0; i < len(ls.shards) && !ls.shards[i].end.After(cutoff); {
+		stripes := slices.Clone(ls.windowShards(ls.shards[i].start))
+		due = append(due, stripes)
+		i += len(stripes)
+	}
+	ls.mu.RUnlock()
+
+	moved := 0
+	for _, stripes := range due {
+		sh := stripes[0]
+		var logs []Log
+		counts := make([]int, len(stripes))
+		for i, stripe := range stripes {
+			stripe.mu.RLock()
+			logs = append(logs, stripe.logs...)
+			counts[i] = len(stripe.logs)
+			stripe.mu.RUnlock()
+		}
+		n := len(logs)
+
+		ls.mu.RLock()
+		prev := ls.cold.segmentAt(sh.start)
+		if prev != nil {
+			prev.acquire()
+		}
+		ls.mu.RUnlock()
+		if prev != nil {
+			old, err := prev.read(nil)
+			prev.release()
+			if err != nil {
+				return moved, err
+			}
+			logs = append(ls.deleted.drop(old), logs...)
+		}
+
+		seg, err := ls.cold.write(sh.start, sh.end, logs)
+		if err != nil {
+			return moved, err
+		}
+
+		ls.mu.Lock()
+		changed := prev != ls.cold.segmentAt(sh.start)
+		for i, stripe := range stripes {
+			stripe.mu.Lock()
+			changed = changed || len(stripe.logs) != counts[i]
+		}
+		if !changed {
+			if prev != nil {
+				seg.changed = prev.changed
+			}
+			for _, stripe := range stripes {
+				seg.changed = max(seg.changed, stripe.changed.Load())
+				stripe.detached = true
+				if i, ok := ls.findShard(stripe.start, stripe.stripe); ok && ls.shards[i] == stripe {
+					ls.shards = slices.Delete(ls.shards, i, i+1)
+				}
+			}
+			if prev != nil {
+				i := slices.Index(ls.cold.segments, prev)
+				ls.cold.segments[i] = seg
+			} else {
+				i := sort.Search(len(ls.cold.segments), func(i int) bool { return ls.cold.segments[i].footer.Start.After(sh.start) })
+				ls.cold.segments = slices.Insert(ls.cold.segments, i, seg)
+			}
+			ls.cold.updateGauges()
+		}
+		for _, stripe := range stripes {
+			stripe.mu.Unlock()
+		}
+		ls.mu.Unlock()
+
+		if changed {
+			seg.retire()
+			continue
+		}
+		if prev != nil {
+			if err := prev.retire(); err != nil {
+				return moved, err
+			}
+		}
+		moved += n
+	}
+	coldFlushed.Add(int64(moved))
+
+	return moved, nil
+}
+
+// purgeCold rewrites the cold files of the windows overlapping [from, to] that
+// hold deleted logs without them. A window flushCold rewrites meanwhile keeps its
+// new file, which leaves the deleted logs out already.
+func (ls *LogStorage) purgeCold(ctx context.Context, from, to time.Time) ([]PurgedFile, error) {
+	if ls.cold == nil {
+		return nil, nil
+	}
+	ls.mu.RLock()
+	var segments []*coldSegment
+	for _, seg := range ls.cold.segments {
+		if (&shard{start: seg.footer.Start, end: seg.footer.End}).overlaps(from, to) {
+			seg.acquire()
+			segments = append(segments, seg)
+		}
+	}
+	ls.mu.RUnlock()
+	defer func() {
+		for _, seg := range segments {
+			seg.release()
+		}
+	}()
+
+	var files []PurgedFile
+	for _, seg := range segments {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+		logs, err := seg.read(nil)
+		if err != nil {
+			return files, err
+		}
+		kept := ls.deleted.drop(logs)
+		if len(kept) == len(logs) {
+			continue
+		}
+		next, err := ls.cold.write(seg.footer.Start, seg.footer.End, kept)
+		if err != nil {
+			return files, err
+		}
+
+		ls.mu.Lock()
+		swapped := ls.cold.segmentAt(seg.footer.Start) == seg
+		if swapped {
+			next.changed = seg.changed
+			ls.cold.segments[slices.Index(ls.cold.segments, seg)] = next
+			ls.cold.updateGauges()
+		}
+		ls.mu.Unlock()
+		if !swapped {
+			next.retire()
+			continue
+		}
+		if err := seg.retire(); err != nil {
+			return files, err
+		}
+		files = append(files, PurgedFile{Tier: "cold", File: filepath.Base(seg.path), Removed: len(logs) - len(kept)})
+	}
+
+	return files, nil
+}
+
+// RunTiering moves past windows to the cold tier every interval until ctx is cancelled
+func (ls *LogStorage) RunTiering(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := ls.flushCold(now); err != nil {
+				logger.Error("tiering failed", "error", err)
+			} else if n > 0 {
+				logger.Info("tiering moved logs to the cold tier", "logs", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Timestamp-ordered index used for time-sorted results and time-range narrowing
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// timeIndex holds log positions ordered by timestamp, ties kept in ingestion order
+type timeIndex []int
+
+// insert adds the log stored at pos, keeping the index ordered. Logs usually
+// arrive in time order, so the common case is a plain append.
+func (idx *timeIndex) insert(logs []Log, pos int) {
+	ts := logs[pos].Timestamp
+	n := len(*idx)
+	if n == 0 || !logs[(*idx)[n-1]].Timestamp.After(ts) {
+		*idx = append(*idx, pos)
+		return
+	}
+
+	i := sort.Search(n, func(i int) bool { return logs[(*idx)[i]].Timestamp.After(ts) })
+	*idx = append(*idx, 0)
+	copy((*idx)[i+1:], (*idx)[i:])
+	(*idx)[i] = pos
+}
+
+// insertFrom adds the logs stored from pos on, returning how many are older than
+// a log already indexed. Out-of-order logs are sorted and merged into the index
+// in one pass, so a backfill costs O(n + k log k) rather than a shift per log.
+func (idx *timeIndex) insertFrom(logs []Log, pos int) int {
+	if pos == len(logs)-1 {
+		late := len(*idx) > 0 && logs[(*idx)[len(*idx)-1]].Timestamp.After(logs[pos].Timestamp)
+		idx.insert(logs, pos)
+		if late {
+			return 1
+		}
+		return 0
+	}
+
+	added := make([]int, 0, len(logs)-pos)
+	for p := pos; p < len(logs); p++ {
+		added = append(added, p)
+	}
+	sort.SliceStable(added, func(i, j int) bool { return logs[added[i]].Timestamp.Before(logs[added[j]].Timestamp) })
+
+	old := *idx
+	late := 0
+	if len(old) > 0 {
+		newest := logs[old[len(old)-1]].Timestamp
+		for _, p := range added {
+			if logs[p].Timestamp.Before(newest) {
+				late++
+			}
+		}
+	}
+	if late == 0 {
+		*idx = append(old, added...)
+		return 0
+	}
+
+	merged := make(timeIndex, 0, len(old)+len(added))
+	i, j := 0, 0
+	for i < len(old) && j < len(added) {
+		// Ties keep ingestion order: indexed logs come first
+		if logs[added[j]].Timestamp.Before(logs[old[i]].Timestamp) {
+			merged = append(merged, added[j])
+			j++
+		} else {
+			merged = append(merged, old[i])
+			i++
+		}
+	}
+	merged = append(append(merged, old[i:]...), added[j:]...)
+	*idx = merged
+	return late
+}
+
+// timeBounds returns the inclusive time range implied by the timestamp filters;
+// a zero bound is open
+func timeBounds(filters map[string]string) (from, to time.Time) {
+	if value, ok := filters["timestamp"]; ok {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			from, to = t, t.Add(24*time.Hour)
+		}
+	}
+	if value, ok := filters["timestamp_from"]; ok {
+		if t, err := time.Parse(time.RFC3339, value); err == nil && t.After(from) {
+			from = t
+		}
+	}
+	if value, ok := filters["timestamp_to"]; ok {
+		if t, err := time.Parse(time.RFC3339, value); err == nil && (to.IsZero() || t.Before(to)) {
+			to = t
+		}
+	}
+
+	return from, to
+}
+
+// between returns the part of the index whose timestamps fall within [from, to]
+func (idx timeIndex) between(logs []Log, from, to time.Time) timeIndex {
+	lo, hi := 0, len(idx)
+	if !from.IsZero() {
+		lo = sort.Search(len(idx), func(i int) bool { return !logs[idx[i]].Timestamp.Before(from) })
+	}
+	if !to.IsZero() {
+		hi = sort.Search(len(idx), func(i int) bool { return logs[idx[i]].Timestamp.After(to) })
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	return idx[lo:hi]
+}
@@ -0,0 +1,133 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : HTTPS with certificates reloaded on rotation and optional client certificate (mTLS) checks
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tls-client-auth values
+const (
+	TLSClientAuthOff    = "off"
+	TLSClientAuthIngest = "ingest" // write requests need a trusted client certificate
+	TLSClientAuthAll    = "all"    // every connection needs one
+)
+
+// tlsReloadCheck is how often handshakes look for rotated certificate files
+const tlsReloadCheck = 10 * time.Second
+
+var tlsReloads = metrics.CounterVec("logingestor_tls_reloads_total", "Reloads of rotated TLS certificate files", "result")
+
+// tlsReloader serves the certificate and client CAs of the tls-* settings and
+// reloads them when a file's modification time changes. A reload that fails,
+// e.g. on a half-written file, keeps the previous files in use.
+type tlsReloader struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes [3]time.Time
+	checked  time.Time
+}
+
+// newTLSReloader loads the files of cfg, failing if any is unusable
+func newTLSReloader(cfg Config) (*tlsReloader, error) {
+	tr := &tlsReloader{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile, caFile: cfg.TLSClientCAFile}
+	switch cfg.TLSClientAuth {
+	case TLSClientAuthIngest:
+		tr.clientAuth = tls.VerifyClientCertIfGiven
+	case TLSClientAuthAll:
+		tr.clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if err := tr.load(tr.fileModTimes()); err != nil {
+		return nil, err
+	}
+	tr.checked = time.Now()
+
+	return tr, nil
+}
+
+// config returns the TLS config of a server speaking protocols, e.g. h2 and http/1.1
+func (tr *tlsReloader) config(protocols ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: protocols,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCA := tr.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   protocols,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   tr.clientAuth,
+				ClientCAs:    clientCA,
+			}, nil
+		},
+	}
+}
+
+// current returns the certificate and client CAs, reloading rotated files
+// at most every tlsReloadCheck
+func (tr *tlsReloader) current() (*tls.Certificate, *x509.CertPool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if now := time.Now(); now.Sub(tr.checked) >= tlsReloadCheck {
+		tr.checked = now
+		if modTimes := tr.fileModTimes(); modTimes != tr.modTimes {
+			if err := tr.load(modTimes); err != nil {
+				tlsReloads.With("error").Inc()
+				logger.Error("reloading TLS certificates failed, keeping the previous ones", "error", err)
+			} else {
+				tlsReloads.With("ok").Inc()
+				logger.Info("reloaded TLS certificates")
+			}
+		}
+	}
+
+	return tr.cert, tr.clientCA
+}
+
+// load reads the files; modTimes are recorded on success only, so a failed
+// reload is retried on the next check
+func (tr *tlsReloader) load(modTimes [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(tr.certFile, tr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %v", tr.certFile, err)
+	}
+
+	var clientCA *x509.CertPool
+	if tr.caFile != "" {
+		pem, err := os.ReadFile(tr.caFile)
+		if err != nil {
+			return err
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates", tr.caFile)
+		}
+	}
+
+	tr.cert, tr.clientCA, tr.modTimes = &cert, clientCA, modTimes
+	return nil
+}
+
+func (tr *tlsReloader) fileModTimes() [3]time.Time {
+	var modTimes [3]time.Time
+	for i, file := range []string{tr.certFile, tr.keyFile, tr.caFile} {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
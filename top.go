@@ -0,0 +1,211 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Most frequent values of a field among the logs of a query (/query/top)
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultTopValues is the number of values ranked when a request gives no n
+const defaultTopValues = 10
+
+// maxTopValues bounds the n of a request
+const maxTopValues = 1000
+
+// TopResponse is the body of /query/top: the values of field held by the most
+// matching logs, most frequent first, out of total matching logs
+type TopResponse struct {
+	Field  string       `json:"field"`
+	Total  int          `json:"total"`
+	Values []FieldValue `json:"values"`
+}
+
+// topCounts holds the number of matching logs of every value of a field; the
+// empty value counts the logs without it
+type topCounts map[string]int
+
+// Top counts the logs matching the filters and opts by their value of field,
+// exactly, in one pass over the matches. When ctx or a cold tier read ends the
+// scan early, the counts so far come with the error.
+func (ls *LogStorage) Top(ctx context.Context, filters map[string]string, opts QueryOptions, field string) (topCounts, error) {
+	counts := make(topCounts)
+	from, to := timeBounds(filters)
+	for _, sh := range ls.shardsBetween(from, to) {
+		sh, err := ls.openShard(sh, filters)
+		if err != nil {
+			return counts, err
+		}
+		sh.mu.RLock()
+		sh.scan(ctx, filters, opts.Expr, func(pos int) bool {
+			if log := sh.logs[pos]; opts.allows(log) {
+				counts[fieldValue(log, field)]++
+			}
+			return true
+		})
+		sh.mu.RUnlock()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return counts, ctx.Err()
+}
+
+// response ranks the n most frequent non-empty values, ties in value order;
+// every value when n is zero
+func (tc topCounts) response(field string, n int) TopResponse {
+	response := TopResponse{Field: field, Values: []FieldValue{}}
+	for value, count := range tc {
+		response.Total += count
+		if value != "" {
+			response.Values = append(response.Values, FieldValue{Value: value, Count: count})
+		}
+	}
+	slices.SortFunc(response.Values, func(a, b FieldValue) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Value, b.Value))
+	})
+	if n > 0 && len(response.Values) > n {
+		response.Values = response.Values[:n]
+	}
+	return response
+}
+
+// handleTop answers a /query body (filters, min_level and "q") with the n
+// (default 10) values of "field" held by the most matching logs, such as the
+// resourceIds logging the most errors over a time range
+func (s *Server) handleTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	body, err := s.readBody(w, r, s.cfg.MaxBodySize)
+	if err != nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeMalformedJSON(w, err)
+		return
+	}
+	auditQuery(r.Context(), fields)
+
+	var field string
+	if err := json.Unmarshal(fields["field"], &field); err != nil || !isCollapseField(field) {
+		writeValidationError(w, errors.New("Invalid field: must name a log field such as resourceId, message or a metadata path"))
+		return
+	}
+	n := defaultTopValues
+	if raw, ok := fields["n"]; ok {
+		if err := json.Unmarshal(raw, &n); err != nil || n <= 0 || n > maxTopValues {
+			writeValidationError(w, fmt.Errorf("Invalid n: must be between 1 and %d", maxTopValues))
+			return
+		}
+	}
+	query := make(map[string]json.RawMessage, len(fields))
+	for key, raw := range fields {
+		if key != "field" && key != "n" {
+			query[key] = raw
+		}
+	}
+
+	req, err := buildQuery(query, s.cfg.MaxPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Paginated || len(req.Options.Sort) > 0 || req.CountOnly || req.Fields != nil || req.Highlight != nil || req.Context != nil || req.CollapseBy != "" {
+		writeValidationError(w, errors.New("limit, offset, page_token, sort, count_only, fields, highlight, context and collapse_by do not apply to /query/top"))
+		return
+	}
+	if err := s.validator.Levels.apply(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := s.applyPattern(r.Context(), &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	var counts topCounts
+	if s.cluster != nil && !isForwarded(r.Context()) {
+		counts, err = s.clusterTop(ctx, r, body, req, field)
+		if err != nil {
+			writeClusterError(w, err, PartialResponse{})
+			return
+		}
+	} else {
+		opts := req.Options
+		if s.cluster != nil {
+			opts.Serves = s.cluster.serves(splitList(r.Header.Get(clusterDownHeader)))
+			n = 0 // the node merging the counts ranks them
+		}
+		counts, err = s.tenant(r.Context()).storage.Top(ctx, req.Filters, opts, field)
+		if err != nil {
+			writeQueryError(w, err, PartialResponse{})
+			return
+		}
+	}
+
+	response := counts.response(field, n)
+	auditResults(r.Context(), response.Total)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// clusterTop adds up the counts of every node, which answer the request's own
+// body with all of their values so the merged ranking is exact
+func (s *Server) clusterTop(ctx context.Context, r *http.Request, body []byte, req QueryRequest, field string) (topCounts, error) {
+	var nodeCounts []topCounts
+	err := s.cluster.fanOut(func(down []string) error {
+		nodeCounts = make([]topCounts, len(s.cluster.nodes))
+		return s.cluster.eachNodeExcept(down, func(i int, node *clusterNode) (err error) {
+			if node == s.cluster.self {
+				opts := req.Options
+				opts.Serves = s.cluster.serves(down)
+				nodeCounts[i], err = s.tenant(r.Context()).storage.Top(ctx, req.Filters, opts, field)
+				return err
+			}
+
+			resp, err := s.cluster.peerQuery(ctx, node, "/query/top", r, body, "application/json", down)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			var top TopResponse
+			if err := json.NewDecoder(resp.Body).Decode(&top); err != nil {
+				return peerError(node, err)
+			}
+			nodeCounts[i] = topCounts{"": top.Total}
+			for _, value := range top.Values {
+				nodeCounts[i][value.Value] += value.Count
+				nodeCounts[i][""] -= value.Count
+			}
+			return nil
+		})
+	})
+
+	counts := make(topCounts)
+	for _, nc := range nodeCounts {
+		for value, n := range nc {
+			counts[value] += n
+		}
+	}
+	return counts, err
+}
@@ -0,0 +1,79 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Trace view returning the logs of one traceId in time order, grouped by spanId
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// TraceSpan holds the logs of one span, in timestamp order
+type TraceSpan struct {
+	SpanID string `json:"spanId"`
+	Logs   []Log  `json:"logs"`
+}
+
+// TraceResponse is the body of /traces/{traceId}/logs. Spans are ordered by their
+// first log; Truncated is set when the trace has more than max-page-size logs.
+type TraceResponse struct {
+	TraceID   string      `json:"traceId"`
+	Count     int         `json:"count"`
+	Spans     []TraceSpan `json:"spans"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// groupBySpan splits time-ordered logs into spans ordered by their first log
+func groupBySpan(logs []Log) []TraceSpan {
+	spans := []TraceSpan{}
+	bySpan := make(map[string]int)
+	for _, log := range logs {
+		i, ok := bySpan[log.SpanID]
+		if !ok {
+			i = len(spans)
+			bySpan[log.SpanID] = i
+			spans = append(spans, TraceSpan{SpanID: log.SpanID})
+		}
+		spans[i].Logs = append(spans[i].Logs, log)
+	}
+
+	return spans
+}
+
+// handleTrace returns every log of the traceId in the path, resolved through the traceId index
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	traceID := r.PathValue("traceId")
+	req := QueryRequest{
+		Filters: map[string]string{"traceId": traceID},
+		Options: QueryOptions{Limit: s.cfg.MaxPageSize, Sort: []SortKey{{Field: "timestamp"}}},
+	}
+	if err := accessScope(r.Context()).apply(&req); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, err.Error(), nil)
+		return
+	}
+
+	ctx, cancel := s.queryContext(r.Context())
+	defer cancel()
+	logs, more, err := s.tenant(r.Context()).storage.Query(ctx, req.Filters, req.Options)
+	if err != nil {
+		writeQueryError(w, err, PartialResponse{Logs: logs})
+		return
+	}
+	auditResults(r.Context(), len(logs))
+	response, err := json.Marshal(TraceResponse{TraceID: traceID, Count: len(logs), Spans: groupBySpan(logs), Truncated: more})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,126 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : UDP listener for fire-and-forget clients sending one JSON log per datagram
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+)
+
+// maxUDPDatagram is the largest UDP payload
+const maxUDPDatagram = 64 << 10
+
+// maxUDPBatch bounds the logs stored at once from the queue
+const maxUDPBatch = 500
+
+// udpReadBuffer is the socket receive buffer asked for, so that bursts wait in
+// the kernel rather than being dropped there while the reader copies datagrams
+const udpReadBuffer = 4 << 20
+
+var (
+	udpDatagrams = metrics.Counter("logingestor_udp_datagrams_total", "UDP datagrams received")
+	udpDropped   = metrics.CounterVec("logingestor_udp_dropped_total", "UDP datagrams dropped, by reason: queue_full, invalid (not a valid JSON log) or store", "reason")
+)
+
+// udpDatagram is a received datagram waiting in the queue
+type udpDatagram struct {
+	payload []byte
+	sender  string
+}
+
+// UDPListener receives one JSON log per datagram. Datagrams are queued for a
+// single writer, which stores what is waiting in batches; when the queue is full
+// they are dropped and counted, as UDP clients expect no answer.
+type UDPListener struct {
+	conn      net.PacketConn
+	queue     chan udpDatagram
+	ingest    func(logs []Log) error
+	validator Validator
+	reject    rejectFunc
+}
+
+// ListenUDP binds the UDP socket for addr, e.g. ":5170", queueing up to
+// queueSize datagrams; valid logs are passed to ingest
+func ListenUDP(addr string, queueSize int, ingest func(logs []Log) error, validator Validator, reject rejectFunc) (*UDPListener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udp, ok := conn.(*net.UDPConn); ok {
+		udp.SetReadBuffer(udpReadBuffer) // the kernel may cap it
+	}
+
+	ul := &UDPListener{conn: conn, queue: make(chan udpDatagram, queueSize), ingest: ingest, validator: validator, reject: reject}
+	metrics.GaugeFunc("logingestor_udp_queue_depth", "UDP datagrams waiting to be stored", func() float64 { return float64(len(ul.queue)) })
+	return ul, nil
+}
+
+// Serve receives datagrams until ctx is cancelled, then stores those queued
+func (ul *UDPListener) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		ul.conn.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ul.write()
+	}()
+
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, addr, err := ul.conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		udpDatagrams.Inc()
+		host, _, _ := net.SplitHostPort(addr.String())
+		select {
+		case ul.queue <- udpDatagram{payload: append([]byte(nil), buf[:n]...), sender: host}:
+		default:
+			udpDropped.With("queue_full").Inc()
+		}
+	}
+	close(ul.queue)
+	<-done
+}
+
+// write decodes, validates and stores the queued datagrams until the queue is closed
+func (ul *UDPListener) write() {
+	logs := make([]Log, 0, maxUDPBatch)
+	for datagram := range ul.queue {
+		logs = append(logs[:0], ul.decode(datagram)...)
+		for len(logs) < maxUDPBatch && len(ul.queue) > 0 {
+			logs = append(logs, ul.decode(<-ul.queue)...)
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		if err := ul.ingest(logs); err != nil {
+			udpDropped.With("store").Add(int64(len(logs)))
+			logger.Error("storing UDP logs failed", "error", err, "logs", len(logs))
+		}
+	}
+}
+
+// decode returns the log of a datagram, none if it is not a valid log
+func (ul *UDPListener) decode(datagram udpDatagram) []Log {
+	var log Log
+	if err := json.Unmarshal(datagram.payload, &log); err != nil {
+		udpDropped.With("invalid").Inc()
+		ul.reject("udp", datagram.sender, err.Error(), datagram.payload)
+		return nil
+	}
+	if err := ul.validator.Validate(&log); err != nil {
+		udpDropped.With("invalid").Inc()
+		ul.reject("udp", datagram.sender, rejectionReason(err), datagram.payload)
+		return nil
+	}
+	return []Log{log}
+}
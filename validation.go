@@ -0,0 +1,113 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Schema enforcement for ingested log entries with field-level validation errors
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Clock skew policies: what happens to a log timestamped more than
+// max-future-skew ahead of the server clock
+const (
+	SkewAccept = "accept" // stored as is, only counted
+	SkewClamp  = "clamp"  // stored at the server time, the original kept in metadata.clientTimestamp
+	SkewReject = "reject" // rejected with a validation error
+)
+
+var clockSkewed = metrics.CounterVec("logingestor_clock_skew_logs_total", "Logs timestamped more than max-future-skew ahead of the server clock, by clock-skew-policy", "policy")
+
+// FieldError describes why one field of a log entry is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a log entry
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	messages := make([]string, len(ve))
+	for i, fe := range ve {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Validator enforces the log entry schema at ingest and redacts valid logs
+type Validator struct {
+	Levels           *LevelRegistry
+	MaxMessageLength int
+	MaxFutureSkew    time.Duration
+	SkewPolicy       string
+	Redactor         *Redactor // nil redacts nothing
+}
+
+// NewValidator creates a Validator from the server configuration
+func NewValidator(cfg Config) Validator {
+	return Validator{Levels: NewLevelRegistry(cfg.Levels, cfg.LevelAliases), MaxMessageLength: cfg.MaxMessageLength,
+		MaxFutureSkew: cfg.MaxFutureSkew, SkewPolicy: cfg.ClockSkewPolicy}
+}
+
+// Validate normalizes the level of the log entry, then returns a ValidationError
+// listing every problem with it, or nil once it is redacted
+func (v Validator) Validate(log *Log) error {
+	var errs ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	log.Level = v.Levels.Normalize(log.Level)
+	switch {
+	case log.Level == "":
+		add("level", "is required")
+	case !v.Levels.Known(log.Level):
+		add("level", "must be one of %s", strings.Join(v.Levels.Levels(), ", "))
+	}
+
+	switch {
+	case log.Message == "":
+		add("message", "is required")
+	case v.MaxMessageLength > 0 && len(log.Message) > v.MaxMessageLength:
+		add("message", "exceeds %d bytes", v.MaxMessageLength)
+	}
+
+	if log.ResourceID == "" {
+		add("resourceId", "is required")
+	}
+
+	switch {
+	case log.Timestamp.IsZero():
+		add("timestamp", "is required")
+	case log.Timestamp.Before(time.Unix(0, 0)):
+		add("timestamp", "is before 1970-01-01")
+	default:
+		if now := time.Now(); log.Timestamp.After(now.Add(v.MaxFutureSkew)) {
+			clockSkewed.With(v.SkewPolicy).Inc()
+			switch v.SkewPolicy {
+			case SkewReject:
+				add("timestamp", "is more than %s in the future", v.MaxFutureSkew)
+			case SkewClamp:
+				if log.Metadata.Fields == nil {
+					log.Metadata.Fields = make(map[string]any)
+				}
+				log.Metadata.Fields["clientTimestamp"] = log.Timestamp.Format(time.RFC3339Nano)
+				log.Timestamp = now.UTC()
+			}
+		}
+	}
+
+	validateCustom(log, add)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	v.Redactor.Redact(log)
+	return nil
+}
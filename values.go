@@ -0,0 +1,111 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Distinct values of an indexed field (/query/values) for filter autocomplete
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultValuesLimit is the number of values returned when no limit is given
+const defaultValuesLimit = 100
+
+// FieldValue is one distinct value of a field with the number of logs holding it
+type FieldValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ValuesResponse is the body of /query/values
+type ValuesResponse struct {
+	Field  string       `json:"field"`
+	Values []FieldValue `json:"values"`
+	More   bool         `json:"more,omitempty"`
+}
+
+// Values returns the distinct non-empty values of an indexed field starting with
+// prefix, sorted, with their counts. They come from the field index, which ingest
+// keeps up to date; only logs inside scope are counted.
+func (ls *LogStorage) Values(field, prefix string, limit int, scope AccessScope) (values []FieldValue, more bool) {
+	counts := make(map[string]int)
+	ls.mu.RLock()
+	shards := slices.Clone(ls.shards)
+	ls.mu.RUnlock()
+
+	for _, sh := range shards {
+		sh.mu.RLock()
+		for value, postings := range sh.index[field] {
+			if value == "" || !strings.HasPrefix(value, prefix) {
+				continue
+			}
+			if !scope.restricted() {
+				counts[value] += len(postings)
+				continue
+			}
+			for _, pos := range postings {
+				if scope.allows(sh.logs[pos]) {
+					counts[value]++
+				}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	for value, count := range counts {
+		if count > 0 {
+			values = append(values, FieldValue{Value: value, Count: count})
+		}
+	}
+	slices.SortFunc(values, func(a, b FieldValue) int { return strings.Compare(a.Value, b.Value) })
+	if limit > 0 && len(values) > limit {
+		return values[:limit], true
+	}
+
+	return values, false
+}
+
+// handleValues lists the distinct values of the field URL parameter (one of the
+// indexed fields), optionally those starting with prefix, up to limit
+func (s *Server) handleValues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	query := r.URL.Query()
+	field := query.Get("field")
+	if !slices.Contains(indexedFields, field) {
+		writeValidationError(w, fmt.Errorf("field must be one of %s", strings.Join(indexedFields, ", ")))
+		return
+	}
+	limit := defaultValuesLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > s.cfg.MaxPageSize {
+			writeValidationError(w, fmt.Errorf("limit must be between 1 and %d", s.cfg.MaxPageSize))
+			return
+		}
+	}
+
+	values, more := s.tenant(r.Context()).storage.Values(field, query.Get("prefix"), limit, accessScope(r.Context()))
+	if values == nil {
+		values = []FieldValue{}
+	}
+	auditResults(r.Context(), len(values))
+	response, err := json.Marshal(ValuesResponse{Field: field, Values: values, More: more})
+	if err != nil {
+		writeInternalError(w, "Error encoding JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
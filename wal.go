@@ -0,0 +1,836 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Write-ahead log of segment files with batched fsync and crash recovery on replay
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walSegmentExt is the file extension of WAL segment files
+const walSegmentExt = ".wal"
+
+// walCompactExt is appended to the segment name of a compaction's output until it is complete
+const walCompactExt = ".compact"
+
+// walCompactionManifest records the segments a compaction replaces while it swaps them
+const walCompactionManifest = "compaction.json"
+
+// walCompactionMinAge keeps compaction off freshly sealed segments, whose logs may
+// not have reached memory yet
+const walCompactionMinAge = time.Minute
+
+// maxWALRecordSize bounds the length read from a record header, so a corrupt
+// header cannot trigger a huge allocation
+const maxWALRecordSize = 64 << 20
+
+// walChecksumFlag marks, in the length header of a record, one whose payload is
+// preceded by its CRC-32C; records written before checksums lack it
+const walChecksumFlag = 1 << 31
+
+// errWALClosed is returned by Append after Close
+var errWALClosed = errors.New("wal: closed")
+
+// errWALCorrupt is returned by replaySegment when a record header is damaged, so
+// that the records after it cannot be found
+var errWALCorrupt = errors.New("corrupt record header")
+
+// WAL is a Storage that appends length-prefixed, checksummed JSON records to
+// numbered segment files. Appends return once fsynced, those within one sync
+// interval sharing the fsync (group commit).
+type WAL struct {
+	dir          string
+	segmentSize  int64
+	syncInterval time.Duration
+
+	// maint serializes the operations that delete or rewrite segments:
+	// retention, compaction and ReplaceAll
+	maint sync.Mutex
+
+	mu      sync.Mutex
+	synced  *sync.Cond
+	file    *os.File
+	writer  *bufio.Writer
+	segment int
+	newest  map[int]time.Time // newest log timestamp held by each segment
+	oldest  map[int]time.Time // oldest log timestamp held by each segment
+	size    int64
+	written uint64 // number of appends buffered so far
+	flushed uint64 // number of appends fsynced so far
+	syncErr error
+	closed  bool
+	done    chan struct{}
+}
+
+// OpenWAL opens the WAL in dir, creating it if needed. A zero syncInterval fsyncs
+// every append on its own.
+func OpenWAL(dir string, segmentSize int64, syncInterval time.Duration) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, segmentSize: segmentSize, syncInterval: syncInterval, segment: 1, newest: make(map[int]time.Time), oldest: make(map[int]time.Time), done: make(chan struct{})}
+	w.synced = sync.NewCond(&w.mu)
+	if err := w.recoverCompaction(); err != nil {
+		return nil, fmt.Errorf("recovering an interrupted compaction: %v", err)
+	}
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+	if err := w.openSegment(); err != nil {
+		return nil, err
+	}
+
+	if syncInterval > 0 {
+		go w.syncLoop()
+	}
+
+	return w, nil
+}
+
+// walSegments returns the numbers of the segment files in dir in ascending order
+func walSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, walSegmentExt)); err == nil {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+// segmentPath returns the file name of segment n
+func (w *WAL) segmentPath(n int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d%s", n, walSegmentExt))
+}
+
+// openSegment opens the current segment for appending
+func (w *WAL) openSegment() error {
+	file, err := os.OpenFile(w.segmentPath(w.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file, w.writer, w.size = file, bufio.NewWriterSize(file, 64<<10), info.Size()
+	return nil
+}
+
+// Append writes the log entries as one record each and returns once they are on disk
+func (w *WAL) Append(logs ...Log) error {
+	records := make([][]byte, len(logs))
+	for i, log := range logs {
+		payload, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		records[i] = payload
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+
+	for _, log := range logs {
+		w.recordTimestampLocked(w.segment, log.Timestamp)
+	}
+
+	for _, payload := range records {
+		n, err := writeWALRecord(w.writer, payload)
+		if err != nil {
+			return err
+		}
+		w.size += n
+	}
+	w.written++
+	ticket := w.written
+
+	if w.syncInterval == 0 || w.size >= w.segmentSize {
+		if err := w.syncLocked(); err != nil {
+			return err
+		}
+	}
+	if w.size >= w.segmentSize {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	for w.flushed < ticket && w.syncErr == nil {
+		w.synced.Wait()
+	}
+
+	return w.syncErr
+}
+
+// writeWALRecord writes a record: its length with walChecksumFlag, the CRC-32C of
+// the payload, then the payload; it returns the bytes written
+func writeWALRecord(writer *bufio.Writer, payload []byte) (int64, error) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload))|walChecksumFlag)
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	if _, err := writer.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(payload); err != nil {
+		return 0, err
+	}
+	return int64(len(header) + len(payload)), nil
+}
+
+// Healthy returns the fsync error that stopped the WAL accepting appends, if any
+func (w *WAL) Healthy() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("WAL is closed")
+	}
+	return w.syncErr
+}
+
+// recordTimestampLocked widens the timestamp range known for segment; the caller holds w.mu
+func (w *WAL) recordTimestampLocked(segment int, t time.Time) {
+	if t.After(w.newest[segment]) {
+		w.newest[segment] = t
+	}
+	if oldest, ok := w.oldest[segment]; !ok || t.Before(oldest) {
+		w.oldest[segment] = t
+	}
+}
+
+// syncLocked flushes the buffer and fsyncs the current segment, waking the
+// appends it covers; the caller holds w.mu
+func (w *WAL) syncLocked() error {
+	if w.flushed == w.written {
+		return w.syncErr
+	}
+
+	err := w.writer.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		w.syncErr = err
+	} else {
+		w.flushed = w.written
+	}
+	w.synced.Broadcast()
+
+	return err
+}
+
+// rotateLocked closes the full segment and starts the next one; the caller holds w.mu
+func (w *WAL) rotateLocked() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	w.segment++
+	return w.openSegment()
+}
+
+// syncLoop fsyncs pending appends every sync interval until Close
+func (w *WAL) syncLoop() {
+	ticker := time.NewTicker(w.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if !w.closed {
+				w.syncLocked()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Load replays every record of every segment in order. A torn record at the end
+// of the last segment, left by a crash mid-write, is truncated away. Corrupt
+// records are skipped and reported, as is the rest of a segment after a damaged
+// record header; appends then go to a new segment rather than after the damage.
+func (w *WAL) Load(fn func(Log)) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range segments {
+		segment := n
+		last := i == len(segments)-1
+		valid, err := w.replaySegment(w.segmentPath(n), func(log Log, _ []byte) {
+			w.mu.Lock()
+			w.recordTimestampLocked(segment, log.Timestamp)
+			w.mu.Unlock()
+			fn(log)
+		}, reportCorruption)
+		switch {
+		case err == nil:
+		case last && errors.Is(err, io.ErrUnexpectedEOF):
+			logger.Warn("truncating torn WAL record", "segment", w.segmentPath(n), "offset", valid)
+			if err := w.truncate(valid); err != nil {
+				return err
+			}
+		case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errWALCorrupt):
+			reportCorruption(Corruption{Tier: "wal", File: w.segmentPath(n), Offset: valid, Error: err.Error()})
+			if last {
+				w.mu.Lock()
+				err := w.rotateLocked()
+				w.mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaySegment calls fn for every record of a segment, decoded and as stored,
+// and corrupt, unless nil, for every record whose checksum or JSON is bad, which
+// is skipped. It returns the offset just past the last complete record.
+func (w *WAL) replaySegment(path string, fn func(log Log, payload []byte), corrupt func(Corruption)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64<<10)
+	var offset int64
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+
+		length := binary.BigEndian.Uint32(header[:])
+		checksummed := length&walChecksumFlag != 0
+		length &^= walChecksumFlag
+		if length > maxWALRecordSize {
+			return offset, fmt.Errorf("%w: record length %d exceeds %d", errWALCorrupt, length, maxWALRecordSize)
+		}
+		size := int64(len(header)) + int64(length)
+		var sum [4]byte
+		if checksummed {
+			size += int64(len(sum))
+			if _, err := io.ReadFull(reader, sum[:]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return offset, err
+			}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return offset, err
+		}
+
+		var log Log
+		var err error
+		if checksummed && crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(sum[:]) {
+			err = errors.New("checksum mismatch")
+		} else {
+			err = json.Unmarshal(payload, &log)
+		}
+		if err != nil {
+			if corrupt != nil {
+				corrupt(Corruption{Tier: "wal", File: path, Offset: offset, Error: err.Error()})
+			}
+		} else {
+			fn(log, payload)
+		}
+		offset += size
+	}
+}
+
+// truncate cuts the current segment back to size
+func (w *WAL) truncate(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	w.size = size
+
+	return nil
+}
+
+// ExpireBefore deletes every sealed segment whose logs are all older than cutoff;
+// the segment being appended to is always kept
+func (w *WAL) ExpireBefore(cutoff time.Time) error {
+	w.maint.Lock()
+	defer w.maint.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for segment, newest := range w.newest {
+		if segment == w.segment || !newest.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(w.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(w.newest, segment)
+		delete(w.oldest, segment)
+	}
+
+	return nil
+}
+
+// walCompaction is the manifest of a compaction: output replaces the segments of replaces
+type walCompaction struct {
+	Output   int   `json:"output"`
+	Replaces []int `json:"replaces"`
+}
+
+// Compact rewrites runs of sealed segments that are small or hold logs older than
+// cutoff, merging each run into one segment without those logs and the deleted
+// ones. The merged records are written to a temporary file first and only swapped
+// in, under the number of the run's last segment, once a manifest of the swap is
+// on disk, so that OpenWAL can finish or undo a compaction a crash interrupted.
+func (w *WAL) Compact(cutoff time.Time, deleted func(Log) bool, run *compactionRun) error {
+	w.maint.Lock()
+	defer w.maint.Unlock()
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errWALClosed
+	}
+	current := w.segment
+	oldest := maps.Clone(w.oldest)
+	w.mu.Unlock()
+
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	// Runs of consecutive candidates, each merging into at most one segment's size
+	var plan [][]int
+	var group []int
+	var groupSize int64
+	flush := func() {
+		if len(group) > 1 || (len(group) == 1 && oldest[group[0]].Before(cutoff)) {
+			plan = append(plan, group)
+		}
+		group, groupSize = nil, 0
+	}
+	for _, n := range segments {
+		if n >= current {
+			break
+		}
+		info, err := os.Stat(w.segmentPath(n))
+		if err != nil {
+			return err
+		}
+		expired := oldest[n].Before(cutoff)
+		if time.Since(info.ModTime()) < walCompactionMinAge || !(expired || info.Size() < w.segmentSize/2) {
+			flush()
+			continue
+		}
+		if len(group) > 0 && groupSize+info.Size() > w.segmentSize {
+			flush()
+		}
+		group, groupSize = append(group, n), groupSize+info.Size()
+	}
+	flush()
+
+	total := 0
+	for _, group := range plan {
+		total += len(group)
+	}
+	run.update(func(status *CompactionStatus) { status.SegmentsTotal += total })
+
+	for _, group := range plan {
+		if err := w.compactGroup(group, cutoff, deleted, run); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactGroup merges the consecutive segments of group into the last one,
+// without the logs older than cutoff and the deleted ones
+func (w *WAL) compactGroup(group []int, cutoff time.Time, deleted func(Log) bool, run *compactionRun) error {
+	output := group[len(group)-1]
+	tmp := w.segmentPath(output) + walCompactExt
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // a no-op once renamed
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, 64<<10)
+	var newest, oldest time.Time
+	kept := 0
+	for _, n := range group {
+		var written, dropped int64
+		var writeErr error
+		read, err := w.replaySegment(w.segmentPath(n), func(log Log, payload []byte) {
+			if writeErr != nil {
+				return
+			}
+			if log.Timestamp.Before(cutoff) || deleted(log) {
+				dropped++
+				return
+			}
+			var size int64
+			size, writeErr = writeWALRecord(writer, payload)
+			written += size
+			if kept == 0 || log.Timestamp.Before(oldest) {
+				oldest = log.Timestamp
+			}
+			if log.Timestamp.After(newest) {
+				newest = log.Timestamp
+			}
+			kept++
+		}, func(c Corruption) {
+			// A corrupt record cannot be kept, only reported
+			reportCorruption(c)
+			dropped++
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			return fmt.Errorf("compacting %s: %v", w.segmentPath(n), err)
+		}
+		if err := run.segmentDone(read, written, dropped); err != nil {
+			return err
+		}
+	}
+
+	if kept == 0 {
+		// Nothing survives, so the segments can simply go in any order
+		file.Close()
+		return w.removeSegments(group)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	manifest, err := json.Marshal(walCompaction{Output: output, Replaces: group})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(w.dir, walCompactionManifest), manifest); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.segmentPath(output)); err != nil {
+		return err
+	}
+	if err := w.removeSegments(group[:len(group)-1]); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.newest[output], w.oldest[output] = newest, oldest
+	w.mu.Unlock()
+
+	return os.Remove(filepath.Join(w.dir, walCompactionManifest))
+}
+
+// Purge seals the current segment, then rewrites every segment holding deleted
+// logs without them, one at a time as compaction does
+func (w *WAL) Purge(ctx context.Context, deleted func(Log) bool) ([]PurgedFile, error) {
+	w.maint.Lock()
+	defer w.maint.Unlock()
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil, errWALClosed
+	}
+	err := w.syncLocked()
+	if err == nil && w.size > 0 {
+		err = w.rotateLocked()
+	}
+	current := w.segment
+	w.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	run := &compactionRun{ctx: ctx, start: time.Now(), mu: new(sync.Mutex), status: new(CompactionStatus)}
+	var files []PurgedFile
+	for _, n := range segments {
+		if n >= current {
+			break
+		}
+		removed := 0
+		if _, err := w.replaySegment(w.segmentPath(n), func(log Log, _ []byte) {
+			if deleted(log) {
+				removed++
+			}
+		}, nil); err != nil {
+			return files, err
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := w.compactGroup([]int{n}, time.Time{}, deleted, run); err != nil {
+			return files, err
+		}
+		files = append(files, PurgedFile{Tier: "wal", File: filepath.Base(w.segmentPath(n)), Removed: removed})
+	}
+
+	return files, nil
+}
+
+// Fsck reads every segment, verifying the checksum and JSON of each record. A
+// torn record at the end of the current segment, still being written, is no
+// corruption.
+func (w *WAL) Fsck(ctx context.Context, report *FsckReport) error {
+	w.maint.Lock()
+	defer w.maint.Unlock()
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errWALClosed
+	}
+	current := w.segment
+	w.syncLocked()
+	w.mu.Unlock()
+
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Files++
+		valid, err := w.replaySegment(w.segmentPath(n), func(Log, []byte) { report.Records++ }, report.add)
+		switch {
+		case err == nil, n == current && errors.Is(err, io.ErrUnexpectedEOF):
+		case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errWALCorrupt):
+			report.add(Corruption{Tier: "wal", File: w.segmentPath(n), Offset: valid, Error: err.Error()})
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeSegments deletes segments and forgets their timestamps
+func (w *WAL) removeSegments(segments []int) error {
+	for _, n := range segments {
+		if err := os.Remove(w.segmentPath(n)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.mu.Lock()
+		delete(w.newest, n)
+		delete(w.oldest, n)
+		w.mu.Unlock()
+	}
+
+	return nil
+}
+
+// recoverCompaction completes a compaction that renamed its output before a crash
+// and discards one that did not, along with any half-written output
+func (w *WAL) recoverCompaction() error {
+	path := filepath.Join(w.dir, walCompactionManifest)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var manifest walCompaction
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if _, err := os.Stat(w.segmentPath(manifest.Output) + walCompactExt); os.IsNotExist(err) {
+			for _, n := range manifest.Replaces {
+				if n == manifest.Output {
+					continue
+				}
+				if err := os.Remove(w.segmentPath(n)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+
+	partial, err := filepath.Glob(filepath.Join(w.dir, "*"+walSegmentExt+walCompactExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range partial {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeFileSync writes data to path through a fsynced temporary file, then fsyncs the directory
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// ReplaceAll makes logs the whole content of the WAL. They are written to fresh
+// segments before the old ones are deleted, so a crash in between replays both
+// rather than neither.
+func (w *WAL) ReplaceAll(logs ...Log) error {
+	w.maint.Lock()
+	defer w.maint.Unlock()
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errWALClosed
+	}
+	old, err := walSegments(w.dir)
+	if err == nil {
+		err = w.syncLocked()
+	}
+	if err == nil {
+		err = w.file.Close()
+	}
+	if err == nil {
+		w.segment++
+		w.newest, w.oldest = make(map[int]time.Time), make(map[int]time.Time)
+		err = w.openSegment()
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(logs) > 0 {
+		if err := w.Append(logs...); err != nil {
+			return err
+		}
+	}
+
+	for _, segment := range old {
+		if err := os.Remove(w.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close fsyncs pending appends and closes the current segment
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	if err := w.syncLocked(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
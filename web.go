@@ -0,0 +1,46 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : Embedded single-page web UI for searching and tailing logs from a browser
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+//go:embed ui/index.html
+var uiFiles embed.FS
+
+// uiPage is the UI; it calls /query, /query/values and /tail with the API key typed into it
+var uiPage = template.Must(template.ParseFS(uiFiles, "ui/index.html"))
+
+// handleRoot serves the web UI to browsers and the Elasticsearch version check to everyone else
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.handleUI(w, r)
+		return
+	}
+
+	s.handleESInfo(w, r)
+}
+
+// handleUI renders the web UI with the configured levels for its level pickers
+func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
+	var page bytes.Buffer
+	if err := uiPage.Execute(&page, struct{ Levels []string }{s.validator.Levels.Levels()}); err != nil {
+		writeInternalError(w, "Error rendering the UI")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Write(page.Bytes())
+}
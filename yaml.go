@@ -0,0 +1,361 @@
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+///// Author   : Latharani M K
+///// Purpose  : The subset of YAML used by pipeline files: nested mappings, sequences and flow collections
+///// Language : Golang
+//////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document without its indentation and comment
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlParser parses block mappings and sequences by indentation, with scalar and
+// flow ([a, b] and {a: b}) values on one line. Anchors, tags and multi-line
+// strings are not supported.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// yamlToJSON parses a YAML document into the JSON it stands for: mappings become
+// objects, plain scalars numbers, booleans or null when they read as one
+func yamlToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(stripYAMLComment(raw))
+		if text == "" || text == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if strings.HasPrefix(raw[indent:], "\t") {
+			return nil, fmt.Errorf("line %d: tabs may not indent YAML", number)
+		}
+		p.lines = append(p.lines, yamlLine{number: number, indent: indent, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var value any
+	if len(p.lines) > 0 {
+		var err error
+		if value, err = p.node(p.lines[0].indent); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.lines) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+		}
+	}
+	return json.Marshal(value)
+}
+
+// node parses the mapping, sequence or scalar starting at the current line
+func (p *yamlParser) node(indent int) (any, error) {
+	line := p.lines[p.pos]
+	if isYAMLSequenceItem(line.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	value, err := parseYAMLValue(line.text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", line.number, err)
+	}
+	return value, nil
+}
+
+// sequence parses the "- item" lines at indent
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text) {
+		line := &p.lines[p.pos]
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if rest == "" {
+			p.pos++
+			item, err := p.child(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// The item continues on this line: parse it as if indented past the dash
+		line.indent += len(line.text) - len(rest)
+		line.text = rest
+		item, err := p.node(line.indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// mapping parses the "key: value" lines at indent
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	object := make(map[string]any)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isYAMLSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		if _, ok := object[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+
+		if rest == "" {
+			// A sequence may sit at the indentation of its key
+			var value any
+			var err error
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text) {
+				value, err = p.sequence(indent)
+			} else {
+				value, err = p.child(indent)
+			}
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+			continue
+		}
+		value, err := parseYAMLValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line.number, err)
+		}
+		object[key] = value
+	}
+	return object, nil
+}
+
+// child parses the node indented past indent on the next line, null if there is none
+func (p *yamlParser) child(indent int) (any, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+		return nil, nil
+	}
+	return p.node(p.lines[p.pos].indent)
+}
+
+// yamlKey formats a scalar parsed as a mapping key
+func yamlKey(key any) string {
+	if key == nil {
+		return "null"
+	}
+	return fmt.Sprint(key)
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" at the first colon outside quotes that ends the
+// line or is followed by a space
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key, err := parseYAMLScalar(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return yamlKey(key), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment drops a "#" comment that starts the line or follows a space,
+// outside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseYAMLValue parses a value on one line: a flow collection or a scalar
+func parseYAMLValue(text string) (any, error) {
+	if text[0] != '[' && text[0] != '{' {
+		return parseYAMLScalar(text)
+	}
+	f := &yamlFlow{text: text}
+	value, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	if f.skipSpace(); f.pos < len(f.text) {
+		return nil, fmt.Errorf("unexpected %q after flow collection", f.text[f.pos:])
+	}
+	return value, nil
+}
+
+// parseYAMLScalar parses a quoted string, or a plain scalar: null, ~, true,
+// false and numbers read as such, anything else as a string
+func parseYAMLScalar(text string) (any, error) {
+	switch {
+	case text == "":
+		return nil, nil
+	case text[0] == '"':
+		return strconv.Unquote(text)
+	case text[0] == '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+
+	switch text {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if _, err := strconv.ParseFloat(text, 64); err == nil && json.Valid([]byte(text)) {
+		return json.Number(text), nil
+	}
+	return text, nil
+}
+
+// yamlFlow parses a flow collection such as [a, "b c"] or {level: debug}
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (any, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, fmt.Errorf("unterminated flow collection %s", f.text)
+	}
+	switch f.text[f.pos] {
+	case '[':
+		f.pos++
+		items := []any{}
+		for {
+			if f.skipSpace(); f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				return items, nil
+			}
+			item, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		object := make(map[string]any)
+		for {
+			if f.skipSpace(); f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				return object, nil
+			}
+			key, err := f.scalar(":")
+			if err != nil {
+				return nil, err
+			}
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, fmt.Errorf("expected key: value in %s", f.text)
+			}
+			f.pos++
+			value, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			object[yamlKey(key)] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.scalar(",]}")
+}
+
+// separator consumes the comma between items, leaving the closing bracket
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	switch {
+	case f.pos < len(f.text) && f.text[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.text) && f.text[f.pos] == closing:
+		return nil
+	}
+	return fmt.Errorf("expected , or %c in %s", closing, f.text)
+}
+
+// scalar parses a quoted or plain scalar, a plain one ending before any of stop
+func (f *yamlFlow) scalar(stop string) (any, error) {
+	f.skipSpace()
+	start := f.pos
+	if f.pos < len(f.text) && (f.text[f.pos] == '"' || f.text[f.pos] == '\'') {
+		quote := f.text[f.pos]
+		for f.pos++; f.pos < len(f.text); f.pos++ {
+			if f.text[f.pos] == '\\' && quote == '"' {
+				f.pos++
+			} else if f.text[f.pos] == quote {
+				if quote == '\'' && f.pos+1 < len(f.text) && f.text[f.pos+1] == '\'' {
+					f.pos++
+					continue
+				}
+				f.pos++
+				return parseYAMLScalar(f.text[start:f.pos])
+			}
+		}
+		return nil, fmt.Errorf("unterminated string in %s", f.text)
+	}
+	for f.pos < len(f.text) && !strings.ContainsRune(stop, rune(f.text[f.pos])) {
+		f.pos++
+	}
+	return parseYAMLScalar(strings.TrimSpace(f.text[start:f.pos]))
+}